@@ -1,83 +1,272 @@
 package main
 
 import (
+	"archive/zip"
 	"fmt"
-    "io/ioutil"
-    "encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"bytes"
 	"math"
 	"strconv"
+	"strings"
+	"unicode/utf16"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 )
 
+//print what the loader is doing.  This is turned off when the output has to be clean
+var debug = true
+
 //========================
-// Buffer class
+// Buffer class.  A Buffer reads a class file from an io.ReaderAt, so the file doesn't have
+// to be read into memory first.  An *os.File, a *bytes.Reader and a memory mapped file are
+// all ReaderAts.  Every read is checked against the end of the buffer, and a sub buffer can
+// be made for the body of an attribute, so its decoder can't read past attribute_length
+
+//the bytes behind a buffer and its sub buffers.  Reads from a file are done a chunk at a time
+type byteSource struct {
+	r io.ReaderAt
+	size int
+	//the chunk that was read last, and where it starts
+	window []byte
+	wstart int
+}
+
+const bufferChunk = 64*1024
+
 type Buffer struct {
-	data []byte
+	src *byteSource
+	//pos is the offset in the whole class file, even in a sub buffer, so error offsets are right
 	pos int
+	end int
+	//the attribute a sub buffer holds, for error messages
+	name string
+	//err holds the first read error.  Once it is set every read returns zero,
+	//so the loaders only need to check it once in a while instead of after every read
+	err error
+}
+
+//read size bytes from r
+func NewBuffer(r io.ReaderAt, size int64) *Buffer {
+	return &Buffer {
+		src: &byteSource{r: r, size: int(size)},
+		pos: 0,
+		end: int(size),
+	}
 }
 
-func NewBuffer(fname string) *Buffer {
-   	body, err := ioutil.ReadFile(fname)
-    if err != nil {
-        fmt.Printf("unable to read file: %v", err)
-    }
-    buf := &Buffer {
-    	data: body,
-    	pos: 0,
-    }
-    return buf;
+//wrap bytes that are already in memory.  They are all in the window, so nothing is copied
+func NewBufferFromBytes(body []byte) *Buffer {
+	buf := NewBuffer(bytes.NewReader(body), int64(len(body)))
+	buf.src.window = body
+	return buf
+}
+
+//a buffer for the next n bytes, which are the body of the named attribute.  This buffer
+//skips over them, whether or not the sub buffer reads them all
+func (buf *Buffer) sub(n int, name string) *Buffer {
+	if !buf.need(n) {
+		return &Buffer{src: buf.src, pos: buf.pos, end: buf.pos, name: name, err: buf.err}
+	}
+	s := &Buffer{src: buf.src, pos: buf.pos, end: buf.pos+n, name: name}
+	buf.pos = buf.pos + n
+	return s
+}
+
+//the number of bytes that haven't been read
+func (buf *Buffer) remaining() int {
+	return buf.end - buf.pos
+}
+
+//check that there are n more bytes to read.  If not, remember the error
+func (buf *Buffer) need(n int) bool {
+	if buf.err != nil {
+		return false
+	}
+	if n < 0 || n > buf.end - buf.pos {
+		if buf.name != "" {
+			buf.err = newParseError(ErrBadLength, buf.pos, "the "+buf.name+" attribute needs "+strconv.Itoa(n)+" more bytes, only "+strconv.Itoa(buf.end-buf.pos)+" are left")
+		} else {
+			buf.err = newParseError(ErrTruncated, buf.pos, "need "+strconv.Itoa(n)+" bytes, only "+strconv.Itoa(buf.end-buf.pos)+" left")
+		}
+		return false
+	}
+	return true
+}
+
+//the next n bytes.  These may be shared with the window, so they mustn't be changed or kept
+func (buf *Buffer) next(n int) []byte {
+	if !buf.need(n) {
+		return nil
+	}
+	src := buf.src
+	if buf.pos < src.wstart || buf.pos+n > src.wstart+len(src.window) {
+		size := bufferChunk
+		if size < n {
+			size = n
+		}
+		if size > src.size - buf.pos {
+			size = src.size - buf.pos
+		}
+		w := make([]byte, size)
+		got, err := src.r.ReadAt(w, int64(buf.pos))
+		if got < n {
+			if err == nil || err == io.EOF {
+				err = ErrTruncated
+			}
+			buf.err = newParseError(err, buf.pos, "read "+strconv.Itoa(got)+" of "+strconv.Itoa(n)+" bytes")
+			return nil
+		}
+		src.window = w[:got]
+		src.wstart = buf.pos
+	}
+	i := buf.pos - src.wstart
+	buf.pos = buf.pos + n
+	return src.window[i:i+n]
+}
+
+//read n bytes into a new slice, or nil if they aren't there
+func (buf *Buffer) readBytes(n int) []byte {
+	if !buf.need(n) {
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, buf.next(n))
+	return b
 }
 
 func (buf *Buffer) readByte() byte {
-	b := buf.data[buf.pos]
-	buf.pos = buf.pos + 1
-	return b;
+	b := buf.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0];
 }
 
 func (buf *Buffer) readUShort() uint16 {
-	var v uint16
-	v |= uint16(buf.data[buf.pos]) << 8
-	v |= uint16(buf.data[buf.pos+1]) 
-	buf.pos = buf.pos + 2
-	return v
+	b := buf.next(2)
+	if b == nil {
+		return 0
+	}
+	return getU2(b)
 }
 
-//little-endian format
 func (buf *Buffer) readUInt() uint32 {
-	var value uint32
-	value |= uint32(buf.data[buf.pos]) << 24
-	value |= uint32(buf.data[buf.pos+1]) << 16
-	value |= uint32(buf.data[buf.pos+2]) << 8
-	value |= uint32(buf.data[buf.pos+3])  
-	buf.pos = buf.pos + 4
-	return value
+	b := buf.next(4)
+	if b == nil {
+		return 0
+	}
+	return getU4(b)
+}
+
+func (buf *Buffer) readULong() uint64 {
+	b := buf.next(8)
+	if b == nil {
+		return 0
+	}
+	return getU8(b)
 }
 
 //read 4 bytes from the buffer, returning a slice
 func (buf *Buffer) read4Bytes() []byte {
-    bytes := make([]byte,4)
-    bytes[0] = buf.data[buf.pos]
-    bytes[1] = buf.data[buf.pos+1]
-    bytes[2] = buf.data[buf.pos+2]
-    bytes[3] = buf.data[buf.pos+3]
-    buf.pos = buf.pos + 4
-    return bytes
+	bytes := make([]byte, 4)
+	copy(bytes, buf.next(4))
+	return bytes
 }
 
-//static helper function, related
-//returns uint32 in little-endian format
+//returns the 4 bytes as a uint32.  Class files are big-endian
 func (buf *Buffer) toUint32(bytes []byte) uint32 {
 	if (len(bytes)!=4) {
 		fmt.Println("ERR: length of bytes is " + strconv.Itoa(len(bytes)));
 		return 0;
-	} else {
-		var value uint32
-		value |= uint32(bytes[0]) << 24
-		value |= uint32(bytes[0]) << 16
-		value |= uint32(bytes[0]) << 8
-		value |= uint32(bytes[0])  
-		return value
 	}
+	return getU4(bytes)
+}
+
+//big-endian helpers, for a u2, u4 or u8 at the start of b
+func getU2(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func getU4(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func getU8(b []byte) uint64 {
+	return uint64(getU4(b))<<32 | uint64(getU4(b[4:]))
+}
+
+//========================
+// Parse errors
+
+//these are the kinds of ParseError.  Use errors.Is to tell them apart
+var (
+	ErrTruncated = errors.New("truncated class file")
+	ErrBadMagic = errors.New("bad magic number")
+	ErrBadIndex = errors.New("bad constant pool index")
+	ErrWrongTag = errors.New("wrong constant pool tag")
+	ErrUnknownTag = errors.New("unknown constant pool tag")
+	ErrBadRefKind = errors.New("bad method handle reference kind")
+	ErrBadLength = errors.New("attribute length doesn't match its contents")
+	ErrBadUtf8 = errors.New("bad modified UTF-8")
+	ErrBadFlags = errors.New("illegal access flags")
+)
+
+//ParseError says what went wrong and where.  Offset is the byte offset in the class file
+type ParseError struct {
+	Err error;
+	Offset int;
+	Detail string;
+}
+
+func newParseError(e error, off int, detail string) *ParseError {
+	return &ParseError {
+		Err: e,
+		Offset: off,
+		Detail: detail,
+	}
+}
+
+func (e *ParseError) Error() string {
+	s := e.Err.Error() + " at offset " + strconv.Itoa(e.Offset)
+	if e.Detail != "" {
+		s = s + ": " + e.Detail
+	}
+	return s
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+//========================
+// ClassWriter.  This is the opposite of Buffer.  It collects the bytes of a class file
+// in big-endian order
+type ClassWriter struct {
+	data []byte;
+}
+
+func NewClassWriter() *ClassWriter {
+	return &ClassWriter{}
+}
+
+func (w *ClassWriter) writeByte(b uint8) {
+	w.data = append(w.data, b)
+}
+
+func (w *ClassWriter) writeUShort(v uint16) {
+	w.data = append(w.data, byte(v>>8), byte(v))
+}
+
+func (w *ClassWriter) writeUInt(v uint32) {
+	w.data = append(w.data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *ClassWriter) writeBytes(b []byte) {
+	w.data = append(w.data, b...)
 }
 
 //========================
@@ -109,21 +298,367 @@ func NewClassFile() *ClassFile {
 	};
 }
 
-func (cf *ClassFile) load(buf *Buffer) {
+//Parse reads a complete class file from r.  If the class file is bad, this returns
+//a *ParseError with the offset where it went wrong instead of crashing
+func Parse(r io.Reader) (*ClassFile, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cf := NewClassFile();
+	err = cf.load(NewBufferFromBytes(body));
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+//ParseFile opens the named class file and parses it.  The file is read as it is parsed,
+//instead of all at once
+func ParseFile(fname string) (*ClassFile, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	cf := NewClassFile();
+	err = cf.load(NewBuffer(f, st.Size()));
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+//========================
+// ClassPath.  This finds class files by their binary name, like com/acme/Foo,
+// in a list of directories and .jar or .zip files, in the same way as java -cp
+
+var ErrClassNotFound = errors.New("class not found")
+
+//one directory or archive on the class path.  read returns ErrClassNotFound if the
+//file isn't there
+type ClassPathEntry interface {
+	read(fname string) ([]byte, error)
+	//the names of all the classes, like java/lang/String
+	list() ([]string, error)
+	close()
+}
+
+type ClassPath struct {
+	entries []ClassPathEntry;
+}
+
+//path is a list separated by os.PathListSeparator, like "lib/a.jar:classes".
+//An empty element means the current directory, and so does an empty path
+func NewClassPath(path string) (*ClassPath, error) {
+	cp := &ClassPath{}
+	if path == "" {
+		path = "."
+	}
+	seen := make(map[string]bool)
+	for _, p := range filepath.SplitList(path) {
+		if p == "" {
+			p = "."
+		}
+		err := cp.add(p, seen, false)
+		if err != nil {
+			cp.Close()
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+//add a directory or an archive, and then the archives in the Class-Path of its manifest, which are
+//relative to it.  Like java, an entry that is already there is skipped, and so is an archive from a
+//Class-Path that can't be opened
+func (cp *ClassPath) add(p string, seen map[string]bool, fromManifest bool) error {
+	p = filepath.Clean(p)
+	if seen[p] {
+		return nil
+	}
+	seen[p] = true
+	lp := strings.ToLower(p)
+	if !strings.HasSuffix(lp, ".jar") && !strings.HasSuffix(lp, ".zip") {
+		cp.entries = append(cp.entries, &DirEntry{dir: p})
+		return nil
+	}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		if fromManifest {
+			return nil
+		}
+		return err
+	}
+	jar := &JarEntry{path: p, zr: zr}
+	cp.entries = append(cp.entries, jar)
+	body, err := jar.read("META-INF/MANIFEST.MF")
+	if err == ErrClassNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, u := range strings.Fields(manifestAttribute(body, "Class-Path")) {
+		err = cp.add(filepath.Join(filepath.Dir(p), filepath.FromSlash(u)), seen, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cp *ClassPath) Close() {
+	for _, e := range cp.entries {
+		e.close()
+	}
+}
+
+//read the bytes of a class.  The name can use dots or slashes, and may end in .class
+func (cp *ClassPath) readClass(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".class")
+	name = strings.ReplaceAll(name, ".", "/") + ".class"
+	for _, e := range cp.entries {
+		body, err := e.read(name)
+		if err == ErrClassNotFound {
+			continue
+		}
+		return body, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+}
+
+//find a class and load it
+func (cp *ClassPath) LoadClass(name string) (*ClassFile, error) {
+	body, err := cp.readClass(name)
+	if err != nil {
+		return nil, err
+	}
+	cf := NewClassFile();
+	err = cf.load(NewBufferFromBytes(body));
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+//return the Main-Class from the manifest of the first jar that has one, or ""
+func (cp *ClassPath) MainClass() (string, error) {
+	for _, e := range cp.entries {
+		jar, ok := e.(*JarEntry)
+		if !ok {
+			continue
+		}
+		body, err := jar.read("META-INF/MANIFEST.MF")
+		if err == ErrClassNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		main := manifestAttribute(body, "Main-Class")
+		if main != "" {
+			return main, nil
+		}
+	}
+	return "", nil
+}
+
+//look up an attribute in the main section of a manifest.  Long values are
+//split over several lines, where each extra line starts with a space
+func manifestAttribute(body []byte, key string) string {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == "" {
+			//the main section ends at the first blank line
+			break
+		}
+		k, v, ok := strings.Cut(lines[i], ":")
+		if !ok || !strings.EqualFold(k, key) {
+			continue
+		}
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], " ") {
+			i++
+			v = v + lines[i][1:]
+		}
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+//-----------------------
+type DirEntry struct {
+	dir string;
+}
+
+func (d *DirEntry) read(fname string) ([]byte, error) {
+	body, err := os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(fname)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrClassNotFound
+	}
+	return body, err
+}
+
+func (d *DirEntry) list() ([]string, error) {
+	names := []string{}
+	err := filepath.WalkDir(d.dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.IsDir() && isClassFile(filepath.ToSlash(p)) {
+			rel, err := filepath.Rel(d.dir, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, strings.TrimSuffix(filepath.ToSlash(rel), ".class"))
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (d *DirEntry) close() {}
+
+//-----------------------
+type JarEntry struct {
+	path string;
+	zr *zip.ReadCloser;
+}
+
+func (j *JarEntry) read(fname string) ([]byte, error) {
+	f, err := j.zr.Open(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrClassNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (j *JarEntry) list() ([]string, error) {
+	names := []string{}
+	for _, f := range j.zr.File {
+		//classes for other java versions are under META-INF/versions
+		if isClassFile(f.Name) && !strings.HasPrefix(f.Name, "META-INF/") {
+			names = append(names, strings.TrimSuffix(f.Name, ".class"))
+		}
+	}
+	return names, nil
+}
+
+func (j *JarEntry) close() {
+	j.zr.Close()
+}
+
+//is this file on the class path a class.  module-info.class describes a module, not a class
+func isClassFile(fname string) bool {
+	return strings.HasSuffix(fname, ".class") && path.Base(fname) != "module-info.class"
+}
+
+//load the class named on the command line.  args are the arguments after the program name:
+//	[-cp path] name args...
+//where name is a .class file, a .jar with a Main-Class, or a class on the class path,
+//which defaults to $CLASSPATH or the current directory.  This returns the class, the name
+//to show in messages and the arguments that are left
+func loadFromArgs(args []string) (*ClassFile, string, []string, error) {
+	path := os.Getenv("CLASSPATH")
+	if len(args) > 1 && (args[0] == "-cp" || args[0] == "-classpath") {
+		path = args[1]
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return nil, "", nil, errors.New("missing class name")
+	}
+	name := args[0]
+	args = args[1:]
+	if strings.HasSuffix(name, ".class") {
+		_, err := os.Stat(name)
+		if err == nil {
+			cf, err := ParseFile(name)
+			return cf, name, args, err
+		}
+	}
+	lname := strings.ToLower(name)
+	if strings.HasSuffix(lname, ".jar") || strings.HasSuffix(lname, ".zip") {
+		//java -jar ignores the class path
+		path = name
+	}
+	cp, err := NewClassPath(path)
+	if err != nil {
+		return nil, name, args, err
+	}
+	defer cp.Close()
+	if path == name {
+		main, err := cp.MainClass()
+		if err != nil {
+			return nil, name, args, err
+		}
+		if main == "" {
+			return nil, name, args, errors.New("no Main-Class in manifest")
+		}
+		name = main
+	}
+	cf, err := cp.LoadClass(name)
+	return cf, name, args, err
+}
+
+
+func (cf *ClassFile) load(buf *Buffer) error {
 	cf.magic = buf.readUInt();
+	if buf.err != nil {
+		return buf.err
+	}
+	if cf.magic != 0xCAFEBABE {
+		return newParseError(ErrBadMagic, 0, fmt.Sprintf("0x%08X", cf.magic))
+	}
 	cf.minor_version = buf.readUShort();
 	cf.major_version = buf.readUShort();
 	
 	//pool
 	pcount := buf.readUShort();
-	fmt.Println("pool count is "+ strconv.Itoa(int(pcount)) );
+	if buf.err != nil {
+		return buf.err
+	}
+	if debug {
+		fmt.Println("pool count is "+ strconv.Itoa(int(pcount)) );
+	}
 	cf.pool = NewConstantPool(pcount);
-	cf.pool.load(buf);
-	cf.pool.improve();
+	cf.pool.major_version = cf.major_version;
+	err := cf.pool.load(buf);
+	if err != nil {
+		return err
+	}
+	err = cf.pool.improve();
+	if err != nil {
+		return err
+	}
 
+	//the offsets of the access flags, for error messages
+	offs := []int{buf.pos}
 	cf.access_flags = buf.readUShort();
+	off := buf.pos
 	cf.this_class = buf.readUShort();
 	cf.super_class = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	_, err = cf.pool.entryOfType(cf.this_class, CONSTANT_Class, off)
+	if err != nil {
+		return err
+	}
+	//only java/lang/Object has no superclass
+	if cf.super_class != 0 {
+		_, err = cf.pool.entryOfType(cf.super_class, CONSTANT_Class, off+2)
+		if err != nil {
+			return err
+		}
+	}
 
 	//interfaces
 	cf.interfaces_count = buf.readUShort();
@@ -131,7 +666,15 @@ func (cf *ClassFile) load(buf *Buffer) {
 	for i :=uint16(0); i<cf.interfaces_count; i++ {
 	    //The constant_pool entry at each value of interfaces[i] 
 	    //must be a CONSTANT_Class_info structure
+	    off = buf.pos
 	    cf.interfaces[i]=buf.readUShort();
+	    if buf.err != nil {
+	    	return buf.err
+	    }
+	    _, err = cf.pool.entryOfType(cf.interfaces[i], CONSTANT_Class, off)
+	    if err != nil {
+	    	return err
+	    }
     }
 	
 	//fields
@@ -139,7 +682,11 @@ func (cf *ClassFile) load(buf *Buffer) {
 	cf.fields = make([]*MemberInfo,cf.fields_count);
 	for i :=uint16(0); i<cf.fields_count; i++ {	
 		cf.fields[i] = NewMemberInfo(cf.pool);
-		cf.fields[i].load(buf);
+		offs = append(offs, buf.pos)
+		err = cf.fields[i].load(buf);
+		if err != nil {
+			return err
+		}
 	}
 	
 	//methods
@@ -147,14 +694,79 @@ func (cf *ClassFile) load(buf *Buffer) {
 	cf.methods = make([]*MemberInfo,cf.methods_count);
 	for i :=uint16(0); i<cf.methods_count; i++ {	
 		cf.methods[i] = NewMemberInfo(cf.pool);
-		cf.methods[i].load(buf);
+		offs = append(offs, buf.pos)
+		err = cf.methods[i].load(buf);
+		if err != nil {
+			return err
+		}
+	}
+	err = cf.checkFlags(offs);
+	if err != nil {
+		return err
 	}
 
 	//load attributes
 	n := buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
 	at := NewAttributeTable(cf.pool, n);
-	at.load(buf);
+	err = at.load(buf);
+	if err != nil {
+		return err
+	}
 	cf.attribute_table = at;
+	//the class file ends with its attributes
+	if buf.remaining() != 0 {
+		return newParseError(ErrBadLength, buf.pos, strconv.Itoa(buf.remaining())+" bytes after the attributes of the class")
+	}
+	return nil
+}
+
+//WriteTo writes the class file in the binary format.  A class that was loaded and
+//not changed is written back byte for byte
+func (cf *ClassFile) WriteTo(out io.Writer) (int64, error) {
+	body, err := cf.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := out.Write(body)
+	return int64(n), err
+}
+
+//Bytes returns the class file in the binary format
+func (cf *ClassFile) Bytes() ([]byte, error) {
+	w := NewClassWriter();
+	w.writeUInt(cf.magic);
+	w.writeUShort(cf.minor_version);
+	w.writeUShort(cf.major_version);
+	cf.pool.write(w);
+	w.writeUShort(cf.access_flags);
+	w.writeUShort(cf.this_class);
+	w.writeUShort(cf.super_class);
+	w.writeUShort(uint16(len(cf.interfaces)));
+	for _, i := range cf.interfaces {
+		w.writeUShort(i);
+	}
+	w.writeUShort(uint16(len(cf.fields)));
+	for _, f := range cf.fields {
+		err := f.write(w);
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.writeUShort(uint16(len(cf.methods)));
+	for _, m := range cf.methods {
+		err := m.write(w);
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := cf.attribute_table.write(w);
+	if err != nil {
+		return nil, err
+	}
+	return w.data, nil
 }
 
 func (cf *ClassFile) dump_fields() {
@@ -162,6 +774,9 @@ func (cf *ClassFile) dump_fields() {
 	for i:= uint16(0); i<cf.fields_count; i++ {
 		f := cf.fields[i]
 		fmt.Println("    "+f.name()+" ("+f.sig()+")");
+		for _, a := range f.attribute_table.annotations() {
+			fmt.Println("        "+a.format(cf.pool));
+		}
 	}
 }
 
@@ -170,6 +785,13 @@ func (cf *ClassFile) dump_methods() {
 	for i:= uint16(0); i<cf.methods_count; i++ {
 		m := cf.methods[i]
 		fmt.Println("    "+m.name()+" "+m.sig()+"");
+		for _, a := range m.attribute_table.annotations() {
+			fmt.Println("        "+a.format(cf.pool));
+		}
+		dv := m.annotationDefault()
+		if dv != nil {
+			fmt.Println("        default "+dv.format(cf.pool));
+		}
 	}
 }
 
@@ -194,6 +816,14 @@ const (
 	ACC_ANNOTATION =          0x2000;
 	ACC_ENUM =                0x4000;
 	ACC_MODULE        		= 0x8000;
+	//these share values with the flags above, but are used in other places
+	ACC_BRIDGE =              0x0040;
+	ACC_VARARGS =             0x0080;
+	ACC_STRICT =              0x0800;
+	ACC_OPEN =                0x0020;
+	ACC_TRANSITIVE =          0x0020;
+	ACC_STATIC_PHASE =        0x0040;
+	ACC_MANDATED =            0x8000;
 )
 
 // constant tags
@@ -217,94 +847,441 @@ const (
 	CONSTANT_Package = 				  20;
 )
 
-//==============================================
-//ConstantPool class
-type ConstantPool struct {
-    constant_pool_count  uint16;
-    //cp_info[constant_pool_count-1] constant_pool;
-    //The constant_pool table is indexed from 1 to constant_pool_count-1
-    constant_pool []CP_Info;	
+//==============================
+// Access flags.  The same bit means different things in different places, for example 0x0020
+// is ACC_SUPER on a class and ACC_SYNCHRONIZED on a method, so each place has its own type
+
+type ClassFlags uint16
+type FieldFlags uint16
+type MethodFlags uint16
+type InnerClassFlags uint16
+
+//the module_flags of a module and the flags of its exports and opens
+type ModuleFlags uint16
+type RequiresFlags uint16
+//used to turn access flags into java modifiers, in the order java writes them
+type flagName struct {
+	flag uint16;
+	name string;
 }
 
-//rant: interfaces suck in Golang.  Use them very sparingly
-//Research: "X does not implement Y (... method has a pointer receiver)"
-//This compile-time error arises when you try to assign or pass (or convert) a concrete type to 
-//an interface type; and the type itself does not implement the interface, only a pointer to the type.
-type CP_Info interface {
-    ctype() uint8;
-    dump();
+var classFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_ABSTRACT, "abstract"}, {ACC_FINAL, "final"},
 }
-// this should be part of the interface, but instead it is just a convention
-//	load(b *Buffer);
 
+var fieldFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_PRIVATE, "private"}, {ACC_PROTECTED, "protected"},
+	{ACC_STATIC, "static"}, {ACC_FINAL, "final"}, {ACC_VOLATILE, "volatile"}, {ACC_TRANSIENT, "transient"},
+}
 
+var methodFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_PRIVATE, "private"}, {ACC_PROTECTED, "protected"},
+	{ACC_ABSTRACT, "abstract"}, {ACC_STATIC, "static"}, {ACC_FINAL, "final"},
+	{ACC_SYNCHRONIZED, "synchronized"}, {ACC_NATIVE, "native"}, {ACC_STRICT, "strictfp"},
+}
 
-//create an empty ConstantPool
-func NewConstantPool(count uint16) *ConstantPool {
-	p := make([]CP_Info,count)
-	cp := &ConstantPool{
-		constant_pool_count: count,
-		constant_pool:  p,
+var innerClassFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_PRIVATE, "private"}, {ACC_PROTECTED, "protected"},
+	{ACC_ABSTRACT, "abstract"}, {ACC_STATIC, "static"}, {ACC_FINAL, "final"},
+}
+
+var moduleFlagNames = []flagName {
+	{ACC_OPEN, "open"},
+}
+
+var requiresFlagNames = []flagName {
+	{ACC_TRANSITIVE, "transitive"}, {ACC_STATIC_PHASE, "static"},
+}
+//return the modifiers for the flags, with a trailing space if there are any
+func modifiers(flags uint16, names []flagName) string {
+	s := ""
+	for _, fn := range names {
+		if flags & fn.flag != 0 {
+			s = s + fn.name + " "
+		}
 	}
-	return cp;
+	return s
 }
 
-//to make this easier to use, this is the size of the constant pool.
-//entry 0 is empty and the entries from 1..size()-1 are used
-func (p *ConstantPool) size() int {
-	return int(p.constant_pool_count)
+//the modifiers as java writes them, like "public static final"
+func (f ClassFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), classFlagNames))
 }
 
-//return the tag of the entry
-func (p *ConstantPool) tag(n int) int {
-	return int(p.constant_pool[n].ctype());
+func (f FieldFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), fieldFlagNames))
 }
 
-//return the closest value to a "name" or string
-//	for entry 0 this will be empty ("")
-//	for utf8, this will the ascii value
-//	for class or string, this will the ascii value
-//  for NameAndType, this will be the name
-//	for fields, methods and interfaces, this will be the name
-//	for other values, this will be empty
-func (p *ConstantPool) getName(n int) string {
-	if n > 1 {return ""}
-	t := p.tag(n)
-	k := p.constant_pool[n];
-	switch(t) {
-		case CONSTANT_Utf8:
-			u := k.(*CONSTANT_Utf8_info);
-			return u.utf8;
-		case CONSTANT_Class, CONSTANT_String:
-			cs := k.(*CONSTANT_String_info);
-			return cs.cstr;
-		case CONSTANT_NameAndType:
-			cnat := k.(*CONSTANT_NameAndType_info);
-			return cnat.name;
-		case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+func (f MethodFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), methodFlagNames))
+}
+
+func (f InnerClassFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), innerClassFlagNames))
+}
+
+func (f ModuleFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), moduleFlagNames))
+}
+
+func (f RequiresFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), requiresFlagNames))
+}
+func (f ClassFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f FieldFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f MethodFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f InnerClassFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f ModuleFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f RequiresFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+//check the module_flags (JVMS 4.7.25).  A module can only be open, synthetic or mandated
+func (f ModuleFlags) check() string {
+	if uint16(f) &^ (ACC_OPEN|ACC_SYNTHETIC|ACC_MANDATED) != 0 {
+		return "a module can only be open, synthetic or mandated"
+	}
+	return ""
+}
+
+//check the flags of an exports or opens, which can only be synthetic or mandated
+func (f ModuleFlags) checkExports() string {
+	if uint16(f) &^ (ACC_SYNTHETIC|ACC_MANDATED) != 0 {
+		return "can only be synthetic or mandated"
+	}
+	return ""
+}
+
+//check the requires_flags.  From version 54 on, only java.base itself can require
+//java.base transitive or static
+func (f RequiresFlags) check(module string, required string, major uint16) string {
+	if uint16(f) &^ (ACC_TRANSITIVE|ACC_STATIC_PHASE|ACC_SYNTHETIC|ACC_MANDATED) != 0 {
+		return "can only be transitive, static, synthetic or mandated"
+	}
+	if major >= 54 && required == "java.base" && module != "java.base" && countFlags(uint16(f), ACC_TRANSITIVE, ACC_STATIC_PHASE) > 0 {
+		return "java.base can't be required transitive or static"
+	}
+	return ""
+}
+
+//the number of flags in the list that are set
+func countFlags(flags uint16, list ...uint16) int {
+	n := 0
+	for _, x := range list {
+		if flags & x != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (cf *ClassFile) flags() ClassFlags {
+	return ClassFlags(cf.access_flags)
+}
+
+func (m *MemberInfo) fieldFlags() FieldFlags {
+	return FieldFlags(m.access_flags)
+}
+
+func (m *MemberInfo) methodFlags() MethodFlags {
+	return MethodFlags(m.access_flags)
+}
+
+//check the class flags (JVMS 4.1).  This returns what is wrong, or "" if they are fine
+func (f ClassFlags) check(major uint16) string {
+	if f.has(ACC_MODULE) {
+		if uint16(f) != ACC_MODULE {
+			return "a module can't have any other flags"
+		}
+		return ""
+	}
+	if f.has(ACC_INTERFACE) {
+		//before version 50 the JVM adds abstract to interfaces itself
+		if major >= 50 && !f.has(ACC_ABSTRACT) {
+			return "an interface must be abstract"
+		}
+		if f.has(ACC_FINAL) || f.has(ACC_SUPER) || f.has(ACC_ENUM) {
+			return "an interface can't be final, super or an enum"
+		}
+		return ""
+	}
+	if f.has(ACC_ANNOTATION) {
+		return "an annotation must be an interface"
+	}
+	if f.has(ACC_FINAL) && f.has(ACC_ABSTRACT) {
+		return "a class can't be both abstract and final"
+	}
+	return ""
+}
+
+//check the flags of a field (JVMS 4.5).  iface is true for a field of an interface
+func (f FieldFlags) check(iface bool) string {
+	if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED) > 1 {
+		return "more than one of public, private and protected"
+	}
+	if f.has(ACC_FINAL) && f.has(ACC_VOLATILE) {
+		return "a field can't be both final and volatile"
+	}
+	if iface {
+		if !f.has(ACC_PUBLIC) || !f.has(ACC_STATIC) || !f.has(ACC_FINAL) {
+			return "a field of an interface must be public static final"
+		}
+		if uint16(f) &^ (ACC_PUBLIC|ACC_STATIC|ACC_FINAL|ACC_SYNTHETIC) != 0 {
+			return "a field of an interface can only be public static final"
+		}
+	}
+	return ""
+}
+
+//check the flags of a method (JVMS 4.6).  The rules depend on the name, on whether it is
+//in an interface and on the class file version
+func (f MethodFlags) check(name string, iface bool, major uint16) string {
+	//the static initializer can have any flags.  They are ignored
+	if name == "<clinit>" {
+		return ""
+	}
+	if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED) > 1 {
+		return "more than one of public, private and protected"
+	}
+	if iface {
+		if major < 52 {
+			if !f.has(ACC_PUBLIC) || !f.has(ACC_ABSTRACT) {
+				return "a method of an interface must be public abstract"
+			}
+		} else {
+			if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE) != 1 {
+				return "a method of an interface must be public or private"
+			}
+			if countFlags(uint16(f), ACC_PROTECTED, ACC_FINAL, ACC_SYNCHRONIZED, ACC_NATIVE) > 0 {
+				return "a method of an interface can't be protected, final, synchronized or native"
+			}
+		}
+	}
+	if f.has(ACC_ABSTRACT) {
+		if countFlags(uint16(f), ACC_PRIVATE, ACC_STATIC, ACC_FINAL, ACC_SYNCHRONIZED, ACC_NATIVE) > 0 {
+			return "an abstract method can't be private, static, final, synchronized or native"
+		}
+		//strictfp means nothing from version 61 on
+		if f.has(ACC_STRICT) && major >= 46 && major < 61 {
+			return "an abstract method can't be strictfp"
+		}
+	}
+	if name == "<init>" {
+		if uint16(f) &^ (ACC_PUBLIC|ACC_PRIVATE|ACC_PROTECTED|ACC_VARARGS|ACC_STRICT|ACC_SYNTHETIC) != 0 {
+			return "a constructor can only be public, private, protected, varargs, strictfp or synthetic"
+		}
+	}
+	return ""
+}
+
+//check the flags of an inner class (JVMS 4.7.6).  These are the flags it has in the source, so
+//the rules for a class apply.  The bits an inner class doesn't use, like ACC_SUPER, are ignored
+func (f InnerClassFlags) check(major uint16) string {
+	if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED) > 1 {
+		return "more than one of public, private and protected"
+	}
+	used := uint16(ACC_PUBLIC|ACC_PRIVATE|ACC_PROTECTED|ACC_STATIC|ACC_FINAL|ACC_INTERFACE|ACC_ABSTRACT|
+		ACC_SYNTHETIC|ACC_ANNOTATION|ACC_ENUM)
+	return ClassFlags(uint16(f) & used).check(major)
+}
+
+//check the flags of the class, the fields and the methods.  offs has the offset of the
+//access_flags of the class, then each field and then each method
+func (cf *ClassFile) checkFlags(offs []int) error {
+	bad := func(off int, what string, msg string) error {
+		return newParseError(ErrBadFlags, off, what+": "+msg)
+	}
+	cflags := cf.flags()
+	msg := cflags.check(cf.major_version)
+	if msg != "" {
+		return bad(offs[0], "class", msg)
+	}
+	iface := cflags.has(ACC_INTERFACE)
+	for i, f := range cf.fields {
+		msg = f.fieldFlags().check(iface)
+		if msg != "" {
+			return bad(offs[1+i], "field "+f.name(), msg)
+		}
+	}
+	for i, m := range cf.methods {
+		msg = m.methodFlags().check(m.name(), iface, cf.major_version)
+		if msg != "" {
+			return bad(offs[1+len(cf.fields)+i], "method "+m.name()+m.sig(), msg)
+		}
+	}
+	return nil
+}
+
+//==============================================
+//ConstantPool class
+type ConstantPool struct {
+    constant_pool_count  uint16;
+    //cp_info[constant_pool_count-1] constant_pool;
+    //The constant_pool table is indexed from 1 to constant_pool_count-1
+    constant_pool []CP_Info;	
+    //the byte offset of each entry in the class file, used for error messages
+    offsets []int;
+    //the version of the class file, which decides which attributes are recognized
+    major_version uint16;
+}
+
+//rant: interfaces suck in Golang.  Use them very sparingly
+//Research: "X does not implement Y (... method has a pointer receiver)"
+//This compile-time error arises when you try to assign or pass (or convert) a concrete type to 
+//an interface type; and the type itself does not implement the interface, only a pointer to the type.
+type CP_Info interface {
+    ctype() uint8;
+    dump();
+    write(w *ClassWriter);
+}
+// this should be part of the interface, but instead it is just a convention
+//	load(b *Buffer);
+
+
+
+//create an empty ConstantPool
+func NewConstantPool(count uint16) *ConstantPool {
+	p := make([]CP_Info,count)
+	cp := &ConstantPool{
+		constant_pool_count: count,
+		constant_pool:  p,
+		offsets: make([]int,count),
+	}
+	return cp;
+}
+
+//return entry n, or an error if n doesn't point at an entry.
+//off is the byte offset of the index, which is reported in the error
+func (p *ConstantPool) entry(n uint16, off int) (CP_Info, error) {
+	if n == 0 || n >= p.constant_pool_count || p.constant_pool[n] == nil {
+		return nil, newParseError(ErrBadIndex, off, "#"+strconv.Itoa(int(n))+" (pool count is "+strconv.Itoa(int(p.constant_pool_count))+")")
+	}
+	return p.constant_pool[n], nil
+}
+
+//same as entry, but also check that the entry has tag t
+func (p *ConstantPool) entryOfType(n uint16, t uint8, off int) (CP_Info, error) {
+	k, err := p.entry(n, off)
+	if err != nil {
+		return nil, err
+	}
+	if k.ctype() != t {
+		return nil, newParseError(ErrWrongTag, off, "#"+strconv.Itoa(int(n))+" has tag "+strconv.Itoa(int(k.ctype()))+", expecting "+strconv.Itoa(int(t)))
+	}
+	return k, nil
+}
+
+//return the string in the CONSTANT_Utf8_info at n
+func (p *ConstantPool) utf8At(n uint16, off int) (string, error) {
+	k, err := p.entryOfType(n, CONSTANT_Utf8, off)
+	if err != nil {
+		return "", err
+	}
+	return k.(*CONSTANT_Utf8_info).utf8, nil
+}
+
+//to make this easier to use, this is the size of the constant pool.
+//entry 0 is empty and the entries from 1..size()-1 are used
+func (p *ConstantPool) size() int {
+	return int(p.constant_pool_count)
+}
+
+//return the tag of the entry
+func (p *ConstantPool) tag(n int) int {
+	return int(p.constant_pool[n].ctype());
+}
+
+//return entry idx, or nil if idx doesn't point at an entry
+func (p *ConstantPool) getConstant(idx int) CP_Info {
+	if idx < 1 || idx >= p.size() {
+		return nil
+	}
+	return p.constant_pool[idx];
+}
+
+//return the closest value to a "name" or string
+//	for entry 0 this will be empty ("")
+//	for utf8, this will the ascii value
+//	for class or string, this will the ascii value
+//  for NameAndType, this will be the name
+//	for fields, methods and interfaces, this will be the name
+//	for method handles, dynamic constants and call sites, this will be the name
+//	for modules and packages, this will be the name
+//	for other values, this will be empty
+func (p *ConstantPool) getName(n int) string {
+	if n < 1 || n >= p.size() || p.constant_pool[n] == nil {return ""}
+	t := p.tag(n)
+	k := p.constant_pool[n];
+	switch(t) {
+		case CONSTANT_Utf8:
+			u := k.(*CONSTANT_Utf8_info);
+			return u.utf8;
+		case CONSTANT_Class, CONSTANT_String:
+			cs := k.(*CONSTANT_String_info);
+			return cs.cstr;
+		case CONSTANT_NameAndType:
+			cnat := k.(*CONSTANT_NameAndType_info);
+			return cnat.name;
+		case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
 			r := k.(*CONSTANT_ref_info);
 			return r.name;
+		case CONSTANT_MethodHandle:
+			mh := k.(*CONSTANT_MethodHandle_info);
+			return mh.name;
+		case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+			dy := k.(*CONSTANT_Dynamic_info);
+			return dy.name;
+		case CONSTANT_Module, CONSTANT_Package:
+			mo := k.(*CONSTANT_Module_info);
+			return mo.name;
 		default:
-			fmt.Println("DEBUG: getName() requested for type "+strconv.Itoa(t))
+			if debug {
+				fmt.Println("DEBUG: getName() requested for type "+strconv.Itoa(t))
+			}
 			return "";
 	}
 }
 
 //note that entry 0 is unused
 func (p *ConstantPool) insert(num uint16,entry CP_Info) {
-	fmt.Println("DEBUG: entering pool # "+strconv.Itoa(int(num))+" of type "+strconv.Itoa(int(entry.ctype())))
+	if debug {
+		fmt.Println("DEBUG: entering pool # "+strconv.Itoa(int(num))+" of type "+strconv.Itoa(int(entry.ctype())))
+	}
 	p.constant_pool[num] = entry
 }
 
-func (p *ConstantPool) load(buf *Buffer) {
+func (p *ConstantPool) load(buf *Buffer) error {
 	//the constant pool starts at 1. leave 0 empty
 	for i := uint16(1);i<p.constant_pool_count;i++ {
+		p.offsets[i] = buf.pos
 		//read the tag
 		t := buf.readByte();
+		if buf.err != nil {
+			return buf.err
+		}
 		switch(t) {
 			case CONSTANT_Utf8:
 				u := NewUtf8Info();
-				u.load(buf);
+				bad := u.load(buf);
+				if bad >= 0 {
+					//the bytes start after the tag and the length
+					return newParseError(ErrBadUtf8, p.offsets[i]+3+bad, "in #"+strconv.Itoa(int(i)))
+				}
 				p.insert(i,u);
 			case CONSTANT_Integer:
 				ki := NewIntegerInfo();
@@ -338,82 +1315,149 @@ func (p *ConstantPool) load(buf *Buffer) {
 				cnat := NewNameAndTypeInfo();
 				cnat.load(buf);
 				p.insert(i,cnat);				
+			case CONSTANT_MethodHandle:
+				mh := NewMethodHandleInfo();
+				mh.load(buf);
+				p.insert(i,mh);
+			case CONSTANT_MethodType:
+				mt := NewMethodTypeInfo();
+				mt.load(buf);
+				p.insert(i,mt);
+			case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+				//these 2 are identical except for the tag
+				dy := NewDynamicInfo(t);
+				dy.load(buf);
+				p.insert(i,dy);
+			case CONSTANT_Module, CONSTANT_Package:
+				mo := NewModuleInfo(t);
+				mo.load(buf);
+				p.insert(i,mo);
 			default:										
-				fmt.Println("ERR: unable to handle Constant type "+ strconv.Itoa(int(t)) + " in constant pool");								
+				return newParseError(ErrUnknownTag, p.offsets[i], "tag "+strconv.Itoa(int(t))+" at #"+strconv.Itoa(int(i)))
+		}
+		if buf.err != nil {
+			return buf.err
+		}
+	}
+	return nil
+}
+
+func (p *ConstantPool) write(w *ClassWriter) {
+	w.writeUShort(p.constant_pool_count);
+	for i := 1; i < p.size(); i++ {
+		//the slot after a long or double is empty
+		if p.constant_pool[i] != nil {
+			p.constant_pool[i].write(w);
 		}
 	}
 }
 
-func (pool *ConstantPool) improve() {
+func (pool *ConstantPool) improve() error {
 	//first pass
 	for i := uint16(1); i<pool.constant_pool_count; i++ {
 		it := pool.constant_pool[i];
-		mytag := it.ctype();
-		switch (mytag) {
+		if it == nil {
+			//this is the unused slot after a long or double
+			continue
+		}
+		off := pool.offsets[i];
+		switch (it.ctype()) {
 			case CONSTANT_Class, CONSTANT_String:
-				//the compiler is giving me errors so I need to expand this a bit
-				k1 := pool.constant_pool[i];
-				//cast the CP_Info to a string struct, which includes both classes and strings
-				ks,ok := k1.(*CONSTANT_String_info);  
-				if !ok {
-					fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(i)) + " to a CONSTANT_String_info");
+				//the CONSTANT_String_info struct holds both classes and strings
+				ks := it.(*CONSTANT_String_info);  
+				str, err := pool.utf8At(ks.name_index, off+1);
+				if err != nil {
+					return err
 				}
-				k2 := pool.constant_pool[ks.name_index];
-				uk2,ok := k2.(*CONSTANT_Utf8_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(ks.name_index)) + " to a *CONSTANT_Utf8_info");
-				}				
-				ks.cstr = uk2.utf8
+				ks.cstr = str
 			case CONSTANT_NameAndType:
-				k3 := pool.constant_pool[i];
-				cnat,ok := k3.(*CONSTANT_NameAndType_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(i)) + " to a *CONSTANT_NameAndType_info");
-				}					
-				k4 := pool.constant_pool[cnat.name_index];
-				uk4,ok := k4.(*CONSTANT_Utf8_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(i)) + " to a *CONSTANT_Utf8_info");
-				}					
-				k5 := pool.constant_pool[cnat.descriptor_index];
-				uk5,ok := k5.(*CONSTANT_Utf8_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(i)) + " to a *CONSTANT_Utf8_info");
-				}	
-				cnat.name = uk4.utf8;
-				cnat.descriptor=uk5.utf8;
+				cnat := it.(*CONSTANT_NameAndType_info);
+				name, err := pool.utf8At(cnat.name_index, off+1);
+				if err != nil {
+					return err
+				}
+				desc, err := pool.utf8At(cnat.descriptor_index, off+3);
+				if err != nil {
+					return err
+				}
+				cnat.name = name;
+				cnat.descriptor = desc;
+			case CONSTANT_MethodType:
+				mt := it.(*CONSTANT_MethodType_info);
+				desc, err := pool.utf8At(mt.descriptor_index, off+1);
+				if err != nil {
+					return err
+				}
+				mt.descriptor = desc;
+			case CONSTANT_Module, CONSTANT_Package:
+				mo := it.(*CONSTANT_Module_info);
+				name, err := pool.utf8At(mo.name_index, off+1);
+				if err != nil {
+					return err
+				}
+				mo.name = name;
 		}
 	}
 	//second pass, do field,method, interface
-	
-	//fmt.Println("DEBUG: skipping 2nd part of improve constant pool")
-	
 	for j := uint16(1); j<pool.constant_pool_count; j++ {
-		jt := pool.constant_pool[j].ctype();
-		switch (jt) {
+		it := pool.constant_pool[j];
+		if it == nil {
+			continue
+		}
+		off := pool.offsets[j];
+		switch (it.ctype()) {
 			case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
-				k6 := pool.constant_pool[j];
-				cry,ok := k6.(*CONSTANT_ref_info)
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(j)) + " to a *CONSTANT_ref_info");
-				}
-				k7 := pool.constant_pool[cry.class_index]
+				cry := it.(*CONSTANT_ref_info)
 				//get the name of the class, which is in the Constant_String_info
-				klass,ok := k7.(*CONSTANT_String_info)
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(cry.class_index)) + " to a *Constant_String_info");
-				}				
-				k8 := pool.constant_pool[cry.name_and_type_index]
-				cnat, ok := k8.(*CONSTANT_NameAndType_info)
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(cry.name_and_type_index)) + " to a *CONSTANT_NameAndType_info");
-				}					
-				cry.cname=klass.cstr;
+				k7, err := pool.entryOfType(cry.class_index, CONSTANT_Class, off+1)
+				if err != nil {
+					return err
+				}
+				k8, err := pool.entryOfType(cry.name_and_type_index, CONSTANT_NameAndType, off+3)
+				if err != nil {
+					return err
+				}
+				cnat := k8.(*CONSTANT_NameAndType_info)
+				cry.cname=k7.(*CONSTANT_String_info).cstr;
 				cry.name=cnat.name;
 				cry.descriptor=cnat.descriptor;
+			case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+				dy := it.(*CONSTANT_Dynamic_info)
+				k9, err := pool.entryOfType(dy.name_and_type_index, CONSTANT_NameAndType, off+3)
+				if err != nil {
+					return err
+				}
+				cnat := k9.(*CONSTANT_NameAndType_info)
+				dy.name=cnat.name;
+				dy.descriptor=cnat.descriptor;
 		}
 	}
-	
+	//third pass, method handles point at the refs from the second pass
+	for j := uint16(1); j<pool.constant_pool_count; j++ {
+		it := pool.constant_pool[j];
+		if it == nil || it.ctype() != CONSTANT_MethodHandle {
+			continue
+		}
+		off := pool.offsets[j];
+		mh := it.(*CONSTANT_MethodHandle_info)
+		tags := mh.allowedTags()
+		if tags == nil {
+			return newParseError(ErrBadRefKind, off+1, "kind "+strconv.Itoa(int(mh.reference_kind))+" at #"+strconv.Itoa(int(j)))
+		}
+		k10, err := pool.entry(mh.reference_index, off+2)
+		if err != nil {
+			return err
+		}
+		cry, ok := k10.(*CONSTANT_ref_info)
+		if ok && (cry.tag == tags[0] || (len(tags) > 1 && cry.tag == tags[1])) {
+			mh.cname=cry.cname;
+			mh.name=cry.name;
+			mh.descriptor=cry.descriptor;
+		} else {
+			return newParseError(ErrWrongTag, off+2, "#"+strconv.Itoa(int(mh.reference_index))+" has tag "+strconv.Itoa(int(k10.ctype()))+", which doesn't match method handle kind "+strconv.Itoa(int(mh.reference_kind)))
+		}
+	}
+	return nil
 }	//end improve
 
 //============================
@@ -441,6 +1485,11 @@ func (k *CONSTANT_String_info) load(buf *Buffer) {
 	k.name_index=buf.readUShort();
 }
 
+func (k *CONSTANT_String_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(k.name_index);
+}
+
 func (k *CONSTANT_String_info) dump() {
 	if k.tag == CONSTANT_Class {
 		fmt.Print("[Class: "+k.cstr+"]");
@@ -478,6 +1527,12 @@ func (k *CONSTANT_ref_info) load(buf *Buffer) {
     k.name_and_type_index=buf.readUShort(); 
 }
 
+func (k *CONSTANT_ref_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(k.class_index);
+	w.writeUShort(k.name_and_type_index);
+}
+
 func (k *CONSTANT_ref_info) dump() {
 	if k.tag == CONSTANT_Fieldref {
 		fmt.Print("[Field: (class"+k.cname+") "+k.name+" (sig "+k.descriptor+")]");
@@ -511,6 +1566,11 @@ func (k *CONSTANT_Integer_info) load(buf *Buffer) {
 	k.ival = int(k.bytes); 
 }
 
+func (k *CONSTANT_Integer_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUInt(k.bytes);
+}
+
 func (k CONSTANT_Integer_info) dump() {
 	fmt.Print("[Integer: "+strconv.Itoa(k.ival)+"]");
 }
@@ -535,15 +1595,16 @@ func (k *CONSTANT_Float_info) ctype() uint8 {
 	return k.tag;
 }
 
-// test this!
 func (k *CONSTANT_Float_info) load(buf *Buffer) {
 	b4 := buf.read4Bytes()
-	//this is in little-endian format, does it matter?
 	k.bytes = buf.toUint32(b4)
-	//convert the 4-byte slice to bits in big-endian format		
-    bits := binary.BigEndian.Uint32(b4)
-    //finally, use math to convert it to a float
-    k.fval = math.Float32frombits(bits)
+	k.fval = math.Float32frombits(k.bytes)
+}
+
+//use the bits of the value, since bytes is in the wrong order
+func (k *CONSTANT_Float_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUInt(math.Float32bits(k.fval));
 }
 
 func (k* CONSTANT_Float_info) dump() {
@@ -573,13 +1634,20 @@ func (k *CONSTANT_Long_info) ctype() uint8 {
 }
 
 func (k *CONSTANT_Long_info) load(buf *Buffer) {
-	k.high_bytes = buf.readUInt()
-	k.low_bytes = buf.readUInt()
-	//TO DO: convert to int64
+	v := buf.readULong()
+	k.high_bytes = uint32(v>>32)
+	k.low_bytes = uint32(v)
+	k.lval = int64(uint64(k.high_bytes)<<32 | uint64(k.low_bytes))
+}
+
+func (k *CONSTANT_Long_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUInt(k.high_bytes);
+	w.writeUInt(k.low_bytes);
 }
 
 func (k *CONSTANT_Long_info) dump() {
-	fmt.Print("[Long: (unimplemented)]");
+	fmt.Print("[Long: "+strconv.FormatInt(k.lval, 10)+"]");
 }
 
 //================================
@@ -590,7 +1658,7 @@ type CONSTANT_Double_info struct {
 	tag uint8;
     high_bytes uint32;
     low_bytes uint32;
-    dval float64;	//not implemented
+    dval float64;
 }
 
 func NewDoubleInfo() *CONSTANT_Double_info {
@@ -604,12 +1672,20 @@ func (k *CONSTANT_Double_info) ctype() uint8 {
 }
 
 func (k *CONSTANT_Double_info) load(buf *Buffer) {
-	k.high_bytes = buf.readUInt()
-	k.low_bytes = buf.readUInt()
+	v := buf.readULong()
+	k.high_bytes = uint32(v>>32)
+	k.low_bytes = uint32(v)
+	k.dval = math.Float64frombits(uint64(k.high_bytes)<<32 | uint64(k.low_bytes))
+}
+
+func (k *CONSTANT_Double_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUInt(k.high_bytes);
+	w.writeUInt(k.low_bytes);
 }
 
 func (k *CONSTANT_Double_info) dump() {
-	fmt.Print("[Double: (unimplemented)]");
+	fmt.Print("[Double: "+strconv.FormatFloat(k.dval, 'g', -1, 64)+"]");
 }
 
 
@@ -638,6 +1714,12 @@ func (k *CONSTANT_NameAndType_info) load(buf *Buffer) {
 	k.descriptor_index = buf.readUShort();
 }
 
+func (k *CONSTANT_NameAndType_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(k.name_index);
+	w.writeUShort(k.descriptor_index);
+}
+
 func (k *CONSTANT_NameAndType_info) dump() {
 	fmt.Print("[NameAndType: "+k.name+" ("+k.descriptor+")]");
 }
@@ -649,6 +1731,8 @@ type CONSTANT_Utf8_info struct {
     length uint16;
     //u1 bytes[length];
     bytes []byte;
+    //the bytes decoded to UTF-16, like the chars in a Java String
+    chars []uint16;
     utf8 string;
 }
 
@@ -662,84 +1746,317 @@ func (k *CONSTANT_Utf8_info) ctype() uint8 {
 	return k.tag;
 }
 
-func (k *CONSTANT_Utf8_info) load(buf *Buffer) {
+//this returns the offset in the bytes of the first bad byte, or -1 if there isn't one
+func (k *CONSTANT_Utf8_info) load(buf *Buffer) int {
 	k.length = buf.readUShort();
 	if (k.length >0) {
-		k.bytes = make([]byte,k.length)
-		var i uint16 = 0
-    	for i = 0; i<k.length; i++ {
-    		k.bytes[i]=buf.readByte();
-    	}		
+		k.bytes = buf.readBytes(int(k.length))
+	}
+	if buf.err != nil {
+		return -1
+	}
+	chars, bad := decodeModifiedUTF8(k.bytes)
+	k.chars = chars;
+	//a string with a lone surrogate can't be a Go string.  It gets U+FFFD instead
+	k.utf8 = string(utf16.Decode(chars));
+	return bad
+}
+
+//decode Java's modified UTF-8 (JVMS 4.4.7) into UTF-16 chars, which is what a Java String holds.
+//It differs from standard UTF-8 in 2 ways: NUL is written as the 2 bytes C0 80, and a character
+//outside the BMP is written as its 2 surrogates, 3 bytes each, instead of as 4 bytes.
+//This returns the offset of the first bad byte, or -1 if they are all good
+func decodeModifiedUTF8(b []byte) ([]uint16, int) {
+	chars := make([]uint16, 0, len(b))
+	i := 0
+	for i < len(b) {
+		c := b[i]
+		switch {
+			case c != 0 && c < 0x80:
+				chars = append(chars, uint16(c))
+				i++
+			case c & 0xE0 == 0xC0:
+				if i+1 >= len(b) || b[i+1] & 0xC0 != 0x80 {
+					return chars, i
+				}
+				chars = append(chars, uint16(c & 0x1F)<<6 | uint16(b[i+1] & 0x3F))
+				i = i + 2
+			case c & 0xF0 == 0xE0:
+				if i+2 >= len(b) || b[i+1] & 0xC0 != 0x80 || b[i+2] & 0xC0 != 0x80 {
+					return chars, i
+				}
+				chars = append(chars, uint16(c & 0x0F)<<12 | uint16(b[i+1] & 0x3F)<<6 | uint16(b[i+2] & 0x3F))
+				i = i + 3
+			default:
+				//0 and the bytes from F0 on never appear
+				return chars, i
+		}
 	}
-	k.utf8 = string(k.bytes);
+	return chars, -1
+}
+
+
+//write the original bytes, so nothing is lost converting to a string and back
+func (k *CONSTANT_Utf8_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(uint16(len(k.bytes)));
+	w.writeBytes(k.bytes);
 }
 
 func (k *CONSTANT_Utf8_info) dump() {
 	fmt.Print("[Utf8: "+k.utf8+"]");
 }
 
-//=========================================
-//=========================================
+//================================
+//A method handle points at a field or method ref.  The kind says what the handle does with it
+const (
+	REF_getField =            1;
+	REF_getStatic =           2;
+	REF_putField =            3;
+	REF_putStatic =           4;
+	REF_invokeVirtual =       5;
+	REF_invokeStatic =        6;
+	REF_invokeSpecial =       7;
+	REF_newInvokeSpecial =    8;
+	REF_invokeInterface =     9;
+)
 
-//used for both fields and methods
-//the only difference is that a method will have a code attribute
-//
-//The value of the name_index item must be a valid index into the constant_pool table. 
-//The constant_pool entry at that index must be a CONSTANT_Utf8_info structure (4.4.7) 
-//which represents a valid unqualified name denoting a field (4.2.2).
-type MemberInfo struct {
-	pool *ConstantPool;
-    access_flags uint16;
-    name_index uint16;
-    member_name string;			//fill this in later
-    descriptor_index uint16;
+type CONSTANT_MethodHandle_info struct {
+	//The tag item of the CONSTANT_MethodHandle_info structure has the value CONSTANT_MethodHandle (15).
+	tag uint8;
+    reference_kind uint8;
+    reference_index uint16;
+    //these are copied from the ref in improve()
+    cname string;
+    name string;
     descriptor string;
-    attribute_table *AttributeTable;
 }
- 
-func NewMemberInfo(p *ConstantPool) *MemberInfo {
-	return &MemberInfo{
-		pool: p,
+
+func NewMethodHandleInfo() *CONSTANT_MethodHandle_info {
+	return &CONSTANT_MethodHandle_info {
+		tag: CONSTANT_MethodHandle,
 	}
 }
 
-func (m *MemberInfo) load (buf *Buffer) {
-	m.access_flags = buf.readUShort();
-    m.name_index = buf.readUShort();
-    m.descriptor_index = buf.readUShort();
-    
-	//load attributes
-	n := buf.readUShort();
-	at := NewAttributeTable(m.pool, n);
-	at.load(buf);
-	m.attribute_table = at;
-	
-	//load name
-	k := m.pool.constant_pool[m.name_index];
-	uk,ok := k.(*CONSTANT_Utf8_info);
-	if !ok {
-		fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(m.name_index)) + " to a *CONSTANT_Utf8_info");
-	}				
-	m.member_name = uk.utf8
-				
-	//load descriptor
-	k2 := m.pool.constant_pool[m.descriptor_index];
-	uk2,ok := k2.(*CONSTANT_Utf8_info);
-	if !ok {
-		fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(m.descriptor_index)) + " to a *CONSTANT_Utf8_info");
-	}				
-	m.descriptor = uk2.utf8
+func (k *CONSTANT_MethodHandle_info) ctype() uint8 {
+	return k.tag;
 }
 
-func (m *MemberInfo) name() string {
-	return m.member_name;
+func (k *CONSTANT_MethodHandle_info) load(buf *Buffer) {
+	k.reference_kind = buf.readByte();
+	k.reference_index = buf.readUShort();
 }
 
-func (m *MemberInfo) sig() string {
-	return m.descriptor;
+func (k *CONSTANT_MethodHandle_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeByte(k.reference_kind);
+	w.writeUShort(k.reference_index);
 }
 
-//======================================================
+func (k *CONSTANT_MethodHandle_info) dump() {
+	fmt.Print("[MethodHandle: (kind "+strconv.Itoa(int(k.reference_kind))+") (class"+k.cname+") "+k.name+" (sig "+k.descriptor+")]");
+}
+
+//return the tags that the reference_index may point to for this kind
+func (k *CONSTANT_MethodHandle_info) allowedTags() []uint8 {
+	switch (k.reference_kind) {
+		case REF_getField, REF_getStatic, REF_putField, REF_putStatic:
+			return []uint8{CONSTANT_Fieldref}
+		case REF_invokeVirtual, REF_newInvokeSpecial:
+			return []uint8{CONSTANT_Methodref}
+		case REF_invokeStatic, REF_invokeSpecial:
+			//interface methods are allowed from class file version 52
+			return []uint8{CONSTANT_Methodref, CONSTANT_InterfaceMethodref}
+		case REF_invokeInterface:
+			return []uint8{CONSTANT_InterfaceMethodref}
+	}
+	return nil
+}
+
+//================================
+type CONSTANT_MethodType_info struct {
+	//The tag item of the CONSTANT_MethodType_info structure has the value CONSTANT_MethodType (16).
+	tag uint8;
+    descriptor_index uint16;
+    descriptor string;
+}
+
+func NewMethodTypeInfo() *CONSTANT_MethodType_info {
+	return &CONSTANT_MethodType_info {
+		tag: CONSTANT_MethodType,
+	}
+}
+
+func (k *CONSTANT_MethodType_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_MethodType_info) load(buf *Buffer) {
+	k.descriptor_index = buf.readUShort();
+}
+
+func (k *CONSTANT_MethodType_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(k.descriptor_index);
+}
+
+func (k *CONSTANT_MethodType_info) dump() {
+	fmt.Print("[MethodType: "+k.descriptor+"]");
+}
+
+//================================
+// This holds either a Dynamic (17) or an InvokeDynamic (18), distinguished by the tag.
+// Dynamic is a computed constant and InvokeDynamic is a call site, but the structure is the same
+type CONSTANT_Dynamic_info struct {
+	tag uint8;
+	//this is an index into the bootstrap_methods array of the BootstrapMethods attribute,
+	//not into the constant pool
+    bootstrap_method_attr_index uint16;
+    name_and_type_index uint16;
+    name string;
+    descriptor string;
+}
+
+func NewDynamicInfo(t uint8) *CONSTANT_Dynamic_info {
+	return &CONSTANT_Dynamic_info {
+		tag: t,
+	}
+}
+
+func (k *CONSTANT_Dynamic_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_Dynamic_info) load(buf *Buffer) {
+	k.bootstrap_method_attr_index = buf.readUShort();
+	k.name_and_type_index = buf.readUShort();
+}
+
+func (k *CONSTANT_Dynamic_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(k.bootstrap_method_attr_index);
+	w.writeUShort(k.name_and_type_index);
+}
+
+func (k *CONSTANT_Dynamic_info) dump() {
+	if k.tag == CONSTANT_Dynamic {
+		fmt.Print("[Dynamic: (bootstrap "+strconv.Itoa(int(k.bootstrap_method_attr_index))+") "+k.name+" (sig "+k.descriptor+")]");
+	} else if k.tag == CONSTANT_InvokeDynamic {
+		fmt.Print("[InvokeDynamic: (bootstrap "+strconv.Itoa(int(k.bootstrap_method_attr_index))+") "+k.name+" (sig "+k.descriptor+")]");
+	}
+}
+
+//================================
+// This holds either a Module (19) or a Package (20), distinguished by the tag.
+// These are only found in module-info.class
+type CONSTANT_Module_info struct {
+	tag uint8;
+	name_index uint16;
+	name string;
+}
+
+func NewModuleInfo(t uint8) *CONSTANT_Module_info {
+	return &CONSTANT_Module_info {
+		tag: t,
+	}
+}
+
+func (k *CONSTANT_Module_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_Module_info) load(buf *Buffer) {
+	k.name_index = buf.readUShort();
+}
+
+func (k *CONSTANT_Module_info) write(w *ClassWriter) {
+	w.writeByte(k.tag);
+	w.writeUShort(k.name_index);
+}
+
+func (k *CONSTANT_Module_info) dump() {
+	if k.tag == CONSTANT_Module {
+		fmt.Print("[Module: "+k.name+"]");
+	} else if k.tag == CONSTANT_Package {
+		fmt.Print("[Package: "+k.name+"]");
+	}
+}
+
+//=========================================
+//=========================================
+
+//used for both fields and methods
+//the only difference is that a method will have a code attribute
+//
+//The value of the name_index item must be a valid index into the constant_pool table. 
+//The constant_pool entry at that index must be a CONSTANT_Utf8_info structure (4.4.7) 
+//which represents a valid unqualified name denoting a field (4.2.2).
+type MemberInfo struct {
+	pool *ConstantPool;
+    access_flags uint16;
+    name_index uint16;
+    member_name string;			//fill this in later
+    descriptor_index uint16;
+    descriptor string;
+    attribute_table *AttributeTable;
+}
+ 
+func NewMemberInfo(p *ConstantPool) *MemberInfo {
+	return &MemberInfo{
+		pool: p,
+	}
+}
+
+func (m *MemberInfo) load (buf *Buffer) error {
+	m.access_flags = buf.readUShort();
+	off := buf.pos
+    m.name_index = buf.readUShort();
+    m.descriptor_index = buf.readUShort();
+    
+	//load attributes
+	n := buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	at := NewAttributeTable(m.pool, n);
+	err := at.load(buf);
+	if err != nil {
+		return err
+	}
+	m.attribute_table = at;
+	
+	//load name
+	name, err := m.pool.utf8At(m.name_index, off);
+	if err != nil {
+		return err
+	}
+	m.member_name = name
+				
+	//load descriptor
+	desc, err := m.pool.utf8At(m.descriptor_index, off+2);
+	if err != nil {
+		return err
+	}
+	m.descriptor = desc
+	return nil
+}
+
+func (m *MemberInfo) write(w *ClassWriter) error {
+	w.writeUShort(m.access_flags);
+	w.writeUShort(m.name_index);
+	w.writeUShort(m.descriptor_index);
+	return m.attribute_table.write(w)
+}
+
+func (m *MemberInfo) name() string {
+	return m.member_name;
+}
+
+func (m *MemberInfo) sig() string {
+	return m.descriptor;
+}
+
+//======================================================
 //=====================================================
 //this is the interface that all attributes must implement
 type AttributeInfo interface {
@@ -752,6 +2069,8 @@ type AttributeInfo interface {
 	//The length does not include the initial six bytes that contain the attribute_name_index
 	//and attribute_length items.
 	attribute_length() uint32;	
+	//write the body of the attribute, without the name and length
+	write(w *ClassWriter) error;
 }
 // This is useful but not part of the interface
 //	load(buf *Buffer);
@@ -772,82 +2091,207 @@ func NewAttributeTable(p *ConstantPool, ac uint16) *AttributeTable {
 	return at;
 }
 
-func (atab *AttributeTable) load(buf* Buffer)  {
-	//fmt.Println("DEBUG: AttributeTable.load() ... incomplete");
-
-	if atab.attributes_count == 0 {
-		return;
-	}	
+//AttributeDecoder reads the body of an attribute.  buf is at the start of the body, and the
+//decoder must read exactly alen bytes.  nix is the attribute_name_index
+type AttributeDecoder func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error)
+
+//the decoders, by attribute name.  Attributes without a decoder are kept as a Generic_attribute
+var attributeDecoders = map[string]AttributeDecoder{}
+
+//RegisterAttribute adds a decoder for the attributes with this name, such as a vendor attribute.
+//This replaces the built-in decoder if there is one
+func RegisterAttribute(name string, d AttributeDecoder) {
+	attributeDecoders[name] = d
+}
+
+//LocalVariableTable and LocalVariableTypeTable are the same apart from the name
+func loadLocalVariables(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+	lvt := NewLocalVariableTable(pool.getName(int(nix)), nix, alen);
+	return lvt, lvt.load(buf, pool)
+}
+
+//the attributes that we know about
+func init() {
+	RegisterAttribute("ConstantValue", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		cva := NewConstantValue_attribute(nix);
+		return cva, cva.load(buf, pool)
+	})
+	RegisterAttribute("Code", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		coda := NewCodeAttribute(pool, nix, alen);
+		return coda, coda.load(buf)
+	})
+	RegisterAttribute("Exceptions", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		x := NewExceptions(nix, alen);
+		x.load(buf);
+		return x, nil
+	})
+	RegisterAttribute("LineNumberTable", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		lnt := NewLineNumberTable(nix, alen);
+		lnt.load(buf);
+		return lnt, nil
+	})
+	RegisterAttribute("LocalVariableTable", loadLocalVariables)
+	RegisterAttribute("LocalVariableTypeTable", loadLocalVariables)
+	RegisterAttribute("SourceDebugExtension", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		sde := NewSourceDebugExtension(nix, alen);
+		sde.load(buf);
+		return sde, nil
+	})
+	RegisterAttribute("SourceFile", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		sf := NewSourceFile(nix);
+		sf.load(buf);
+		return sf, nil
+	})
+	RegisterAttribute("InnerClasses", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		nc := NewInnerClasses(nix, alen);
+		return nc, nc.load(buf, pool)
+	})
+	RegisterAttribute("EnclosingMethod", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		em := NewEnclosingMethod(nix);
+		return em, em.load(buf, pool)
+	})
+	RegisterAttribute("Synthetic", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		return NewSynthetic(nix), nil
+	})
+	RegisterAttribute("Signature", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		sig := NewSignature(nix);
+		sig.load(buf);
+		return sig, nil
+	})
+	RegisterAttribute("Deprecated", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		return NewDeprecated(nix), nil
+	})
+	RegisterAttribute("RuntimeVisibleAnnotations", loadAnnotations)
+	RegisterAttribute("RuntimeInvisibleAnnotations", loadAnnotations)
+	RegisterAttribute("RuntimeVisibleParameterAnnotations", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		pa := NewParameterAnnotations(pool.getName(int(nix)), nix, alen);
+		return pa, pa.load(buf, pool)
+	})
+	RegisterAttribute("RuntimeInvisibleParameterAnnotations", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		pa := NewParameterAnnotations(pool.getName(int(nix)), nix, alen);
+		return pa, pa.load(buf, pool)
+	})
+	RegisterAttribute("AnnotationDefault", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		ad := NewAnnotationDefault(nix, alen);
+		return ad, ad.load(buf, pool)
+	})
+	RegisterAttribute("BootstrapMethods", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		bm := NewBootstrapMethods(nix, alen);
+		return bm, bm.load(buf, pool)
+	})
+	RegisterAttribute("NestHost", loadClassIndex)
+	RegisterAttribute("ModuleMainClass", loadClassIndex)
+	RegisterAttribute("NestMembers", loadIndexList)
+	RegisterAttribute("PermittedSubclasses", loadIndexList)
+	RegisterAttribute("ModulePackages", loadIndexList)
+	RegisterAttribute("Record", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		r := NewRecord(nix, alen);
+		return r, r.load(buf, pool)
+	})
+	RegisterAttribute("MethodParameters", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		mp := NewMethodParameters(nix, alen);
+		return mp, mp.load(buf, pool)
+	})
+	RegisterAttribute("Module", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		mo := NewModule(nix, alen);
+		return mo, mo.load(buf, pool)
+	})
+}
+
+func (atab *AttributeTable) load(buf* Buffer) error {
 	for i := uint16(0); i<atab.attributes_count; i++ {
 		//we get 3 items for every attribute:
 		//	index and length.  Name is looked up from the index
+		off := buf.pos
 		idx := buf.readUShort();
 		alen := buf.readUInt();
+		if buf.err != nil {
+			return buf.err
+		}
 		
 		//get the name of the attribute
-		k := atab.pool.constant_pool[idx];
-		if k.ctype() != 1 {
-			fmt.Println("ERR: AttributeTable.load(), tag is "+strconv.Itoa(int(idx)))
+		aname, err := atab.pool.utf8At(idx, off);
+		if err != nil {
+			return err
 		}
-		u,ok := k.(*CONSTANT_Utf8_info);
-		if !ok {
-			fmt.Println("ERR: constant pool "+strconv.Itoa(int(idx))+" is not expected CONSTANT_Utf8_info");
-			return;
+		if debug {
+			fmt.Println("DEBUG: attribute name=" + aname);
 		}
-		aname := u.utf8;
-		fmt.Println("DEBUG: attribute name=" + aname);
-		
-		//this could be a switch statement
-		if (aname == "ConstantValue") {
-			if alen !=2 {
-				fmt.Println("DEBUG: ConstantValue length is " + strconv.Itoa(int(alen)) + "; expecting 2");
-			}
-			cva := NewConstantValue_attribute(idx);
-			atab.attributes[i]=cva;
-		} else if (aname == "Code") {
-			coda := NewCodeAttribute(atab.pool,idx,alen);
-			coda.load(buf);
-			atab.attributes[i]=coda;	
-		} else if (aname == "Exceptions") {
-			x := NewExceptions(idx,alen);
-			x.load(buf);
-			atab.attributes[i]=x;
-		} else if (aname == "LineNumberTable") {
-			lnt := NewLineNumberTable(idx,alen)
-			lnt.load(buf);
-			atab.attributes[i]=lnt;			
-		} else if (aname == "StackMapTable") {
-			g := NewGenericAttribute(aname, idx, alen)		
-			g.load(buf);
-			atab.attributes[i]=g;		
-		} else if (aname == "SourceFile") {
-			sf := NewSourceFile(idx);
-			sf.load(buf);
-			atab.attributes[i]=sf;	
-		} else if (aname == "InnerClasses") {
-			nc := NewInnerClasses(idx,alen)
-			nc.load(buf);
-			atab.attributes[i]=nc;				
-		} else if (aname == "EnclosingMethod") {
-			em := NewEnclosingMethod(idx)
-			em.load(buf);
-			atab.attributes[i]=em;				
-		} else if (aname == "Synthetic") {
-			sy := NewSynthetic(idx)
-			sy.load(buf);
-			atab.attributes[i]=sy;					
-		} else if (aname == "Signature") {
-			sig := NewSignature(idx)
-			sig.load(buf);
-			atab.attributes[i]=sig;				
-		} else if (aname == "Deprecated") {
-			d := NewDeprecated(idx)
-			d.load(buf);
-			atab.attributes[i]=d;
+		//the decoder only gets the body, so it can't read into the next attribute
+		start := buf.pos
+		body := buf.sub(int(alen), aname)
+		if buf.err != nil {
+			return buf.err
+		}
+		var attr AttributeInfo
+		decode, ok := attributeDecoders[aname]
+		//an attribute that is newer than the class file is ignored, as the JVM does
+		if ok && atab.pool.major_version < attributeSince[aname] {
+			ok = false
+		}
+		if ok {
+			attr, err = decode(body, atab.pool, idx, alen);
+			if err != nil {
+				return err
+			}
 		} else {
-			fmt.Println("DEBUG: unknown attribute "+aname);
-		}				
+			if debug {
+				fmt.Println("DEBUG: unknown attribute "+aname+", keeping the bytes");
+			}
+			g := NewGenericAttribute(aname, idx, alen)
+			g.load(body);
+			attr = g
+		}
+		if body.err != nil {
+			return body.err
+		}
+		//the body has to be used up, or the attribute wasn't what the decoder thought it was
+		if body.remaining() != 0 {
+			return newParseError(ErrBadLength, start, aname+" has length "+strconv.Itoa(int(alen))+", but "+strconv.Itoa(int(alen)-body.remaining())+" bytes were read")
+		}
+		atab.attributes[i]=attr;
+	}
+	return nil
+}
+
+//RuntimeVisibleAnnotations and RuntimeInvisibleAnnotations are the same apart from the name
+func loadAnnotations(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+	aa := NewAnnotations(pool.getName(int(nix)), nix, alen);
+	return aa, aa.load(buf, pool)
+}
+
+func loadClassIndex(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+	ci := NewClassIndex(pool.getName(int(nix)), nix);
+	return ci, ci.load(buf, pool)
+}
+
+func loadIndexList(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+	il := NewIndexList(pool.getName(int(nix)), nix, alen);
+	return il, il.load(buf, pool)
+}
+
+//write the count and then each attribute with its name and length.
+//The length is measured from the body, not copied from attribute_length()
+func (atab *AttributeTable) write(w *ClassWriter) error {
+	if atab == nil {
+		w.writeUShort(0);
+		return nil
+	}
+	w.writeUShort(uint16(len(atab.attributes)));
+	for i, attr := range atab.attributes {
+		if attr == nil {
+			return errors.New("attribute "+strconv.Itoa(i)+" was not loaded, so it can't be written")
+		}
+		body := NewClassWriter();
+		err := attr.write(body);
+		if err != nil {
+			return err
+		}
+		w.writeUShort(attr.attribute_name_index());
+		w.writeUInt(uint32(len(body.data)));
+		w.writeBytes(body.data);
 	}
+	return nil
 }
 
 
@@ -882,8 +2326,26 @@ func (attr *ConstantValue_attribute) attribute_length() uint32 {
 	return attr.alength;
 }
 
-func (attr *ConstantValue_attribute) load(buf *Buffer) {
+func (attr *ConstantValue_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	off := buf.pos
 	attr.cp_index = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	k, err := pool.entry(attr.cp_index, off)
+	if err != nil {
+		return err
+	}
+	switch k.ctype() {
+		case CONSTANT_Integer, CONSTANT_Long, CONSTANT_Float, CONSTANT_Double, CONSTANT_String:
+			return nil
+	}
+	return newParseError(ErrWrongTag, off, "#"+strconv.Itoa(int(attr.cp_index))+" has tag "+strconv.Itoa(int(k.ctype()))+", which isn't a constant value")
+}
+
+func (attr *ConstantValue_attribute) write(w *ClassWriter) error {
+	w.writeUShort(attr.cp_index);
+	return nil
 }
 
 func (attr *ConstantValue_attribute) constantvalue_index() uint16 {
@@ -894,7 +2356,7 @@ func (attr *ConstantValue_attribute) constantvalue_index() uint16 {
 
 //StackMapTable Attribute
 
-//this is a placeholder for attributes that I don't care about but have to deal with
+//this holds the raw bytes of an attribute that has no decoder, so it can be written back out
 type Generic_attribute struct {
 	aname string;
 	aname_index uint16;
@@ -924,13 +2386,16 @@ func (attr *Generic_attribute) attribute_length() uint32 {
 
 func (attr *Generic_attribute) load(buf *Buffer) {
 	if attr.alength>0 {
-		attr.garbage = make([]byte, attr.alength)
-		for j := 0;j < int(attr.alength);j++ {
-			attr.garbage[j]=buf.readByte();
-		}
+		attr.garbage = buf.readBytes(int(attr.alength))
 	}
 }
 
+//write back the bytes exactly as they were read
+func (attr *Generic_attribute) write(w *ClassWriter) error {
+	w.writeBytes(attr.garbage);
+	return nil
+}
+
 //============================
 
 //Each value in the exception_index_table array must be a valid index into the constant_pool table.
@@ -974,6 +2439,14 @@ func (attr *Exceptions_attribute) load(buf *Buffer) {
 	}
 }
 
+func (attr *Exceptions_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.exception_index_table)));
+	for _, x := range attr.exception_index_table {
+		w.writeUShort(x);
+	}
+	return nil
+}
+
 //==============================================
 type InnerClasses_attribute struct {
 	aname string;
@@ -1010,7 +2483,7 @@ func (attr *InnerClasses_attribute) attribute_length() uint32 {
 	return attr.alength;
 }
 
-func (attr *InnerClasses_attribute) load(buf *Buffer) {
+func (attr *InnerClasses_attribute) load(buf *Buffer, pool *ConstantPool) error {
 	attr.num_classes = buf.readUShort();
 	if (attr.num_classes > 0) {
 		attr.classes = make([]*inner_class_info, attr.num_classes)
@@ -1020,10 +2493,30 @@ func (attr *InnerClasses_attribute) load(buf *Buffer) {
 			ik.inner_class_info_index=buf.readUShort();
 			ik.outer_class_info_index=buf.readUShort();
 			ik.inner_name_index=buf.readUShort();
+			off := buf.pos
 			ik.inner_class_access_flags=buf.readUShort();
+			if buf.err != nil {
+				return buf.err
+			}
+			msg := InnerClassFlags(ik.inner_class_access_flags).check(pool.major_version)
+			if msg != "" {
+				return newParseError(ErrBadFlags, off, "inner class: "+msg)
+			}
 			attr.classes[i]=ik;					
 		}
 	}
+	return nil
+}
+
+func (attr *InnerClasses_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.classes)));
+	for _, ik := range attr.classes {
+		w.writeUShort(ik.inner_class_info_index);
+		w.writeUShort(ik.outer_class_info_index);
+		w.writeUShort(ik.inner_name_index);
+		w.writeUShort(ik.inner_class_access_flags);
+	}
+	return nil
 }
  
 //========================
@@ -1057,9 +2550,29 @@ func (attr *EnclosingMethod_attribute) attribute_length() uint32 {
 	return attr.alength;
 }
 
-func (attr *EnclosingMethod_attribute) load(buf *Buffer) {
+//class_index must be a CONSTANT_Class, and method_index is 0 or a CONSTANT_NameAndType
+func (attr *EnclosingMethod_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	off := buf.pos
 	attr.class_index = buf.readUShort();
 	attr.method_index = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	_, err := pool.entryOfType(attr.class_index, CONSTANT_Class, off)
+	if err != nil {
+		return err
+	}
+	//0 means the class isn't in a method, like one in a field initializer
+	if attr.method_index != 0 {
+		_, err = pool.entryOfType(attr.method_index, CONSTANT_NameAndType, off+2)
+	}
+	return err
+}
+
+func (attr *EnclosingMethod_attribute) write(w *ClassWriter) error {
+	w.writeUShort(attr.class_index);
+	w.writeUShort(attr.method_index);
+	return nil
 }
 
 //========================
@@ -1097,6 +2610,11 @@ func (attr *Synthetic_attribute) load(buf *Buffer) {
 	//nothing to do
 }
 
+func (attr *Synthetic_attribute) write(w *ClassWriter) error {
+	//nothing to do
+	return nil
+}
+
 //====================================
 type Deprecated_attribute struct {
 	aname string;
@@ -1129,6 +2647,11 @@ func (attr *Deprecated_attribute) load(buf *Buffer) {
 	//nothing to do
 }
 
+func (attr *Deprecated_attribute) write(w *ClassWriter) error {
+	//nothing to do
+	return nil
+}
+
 //=====================================
 type Signature_attribute struct {
 	aname string;
@@ -1162,6 +2685,11 @@ func (attr *Signature_attribute) load(buf *Buffer) {
 	attr.signature_index = buf.readUShort();
 }
 
+func (attr *Signature_attribute) write(w *ClassWriter) error {
+	w.writeUShort(attr.signature_index);
+	return nil
+}
+
 
 //=====================================
 
@@ -1197,6 +2725,11 @@ func (attr *SourceFile_attribute) load(buf *Buffer) {
 	attr.sourcefile_index = buf.readUShort();
 }
 
+func (attr *SourceFile_attribute) write(w *ClassWriter) error {
+	w.writeUShort(attr.sourcefile_index);
+	return nil
+}
+
 //==============================
 
 //LineNumberTable attribute is part of the code attribute
@@ -1250,6 +2783,154 @@ func (attr *LineNumberTable_attribute) load(buf *Buffer) {
 	}
 }
 
+func (attr *LineNumberTable_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.line_number_table)));
+	for _, lni := range attr.line_number_table {
+		w.writeUShort(lni.start_pc);
+		w.writeUShort(lni.line_number);
+	}
+	return nil
+}
+
+//==============================
+
+//LocalVariableTable is part of the code attribute.  It gives the name and type of each local
+//variable and the range of code where it has a value.  LocalVariableTypeTable has the same
+//layout, but it only lists the variables with a generic type, and it has the signature instead
+//of the descriptor.  They are told apart by the name
+type LocalVariableTable_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    local_variable_table_length uint16;
+    local_variable_table []*local_variable_info;
+}
+
+type local_variable_info struct {
+	start_pc uint16;
+	length uint16;
+	name_index uint16;
+	//the signature in a LocalVariableTypeTable
+	descriptor_index uint16;
+	index uint16;
+	name string;
+	descriptor string;
+}
+
+func NewLocalVariableTable(n string, nix uint16, alen uint32) *LocalVariableTable_attribute {
+	return &LocalVariableTable_attribute {
+		aname: n,
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *LocalVariableTable_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *LocalVariableTable_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *LocalVariableTable_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *LocalVariableTable_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.local_variable_table_length = buf.readUShort();
+	attr.local_variable_table = make([]*local_variable_info, attr.local_variable_table_length)
+	for i := 0; i<int(attr.local_variable_table_length); i++ {
+		lv := &local_variable_info{}
+		off := buf.pos
+		lv.start_pc = buf.readUShort();
+		lv.length = buf.readUShort();
+		lv.name_index = buf.readUShort();
+		lv.descriptor_index = buf.readUShort();
+		lv.index = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		var err error
+		lv.name, err = pool.utf8At(lv.name_index, off+4)
+		if err != nil {
+			return err
+		}
+		lv.descriptor, err = pool.utf8At(lv.descriptor_index, off+6)
+		if err != nil {
+			return err
+		}
+		attr.local_variable_table[i]=lv;
+	}
+	return nil
+}
+
+func (attr *LocalVariableTable_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.local_variable_table)));
+	for _, lv := range attr.local_variable_table {
+		w.writeUShort(lv.start_pc);
+		w.writeUShort(lv.length);
+		w.writeUShort(lv.name_index);
+		w.writeUShort(lv.descriptor_index);
+		w.writeUShort(lv.index);
+	}
+	return nil
+}
+
+//is the variable in scope at this pc
+func (lv *local_variable_info) covers(pc int) bool {
+	return pc >= int(lv.start_pc) && pc < int(lv.start_pc)+int(lv.length)
+}
+
+//==============================
+
+//SourceDebugExtension is a class attribute with extra debugging information, such as the
+//SMAP that maps a JSP or Kotlin file onto the generated class.  The JVM doesn't look inside it
+type SourceDebugExtension_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    debug_extension []byte;
+}
+
+func NewSourceDebugExtension(nix uint16, alen uint32) *SourceDebugExtension_attribute {
+	return &SourceDebugExtension_attribute {
+		aname: "SourceDebugExtension",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *SourceDebugExtension_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *SourceDebugExtension_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *SourceDebugExtension_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *SourceDebugExtension_attribute) load(buf *Buffer) {
+	attr.debug_extension = buf.readBytes(int(attr.alength))
+}
+
+func (attr *SourceDebugExtension_attribute) write(w *ClassWriter) error {
+	w.writeBytes(attr.debug_extension);
+	return nil
+}
+
+//the SMAP or other text in the SourceDebugExtension, or "" if there isn't one
+func (cf *ClassFile) sourceDebugExtension() string {
+	sde, ok := cf.attribute_table.find("SourceDebugExtension").(*SourceDebugExtension_attribute)
+	if !ok {
+		return ""
+	}
+	return string(sde.debug_extension)
+}
+
 //===========================================================
 
 type Code_attribute struct {
@@ -1296,16 +2977,12 @@ func (attr *Code_attribute) attribute_length() uint32 {
 	return attr.alength;
 }    
     
-func (ca *Code_attribute) load(buf *Buffer) {
+func (ca *Code_attribute) load(buf *Buffer) error {
 	ca.max_stack=buf.readUShort();
 	ca.max_locals=buf.readUShort();
 	ca.code_length=buf.readUInt();
 	if ca.code_length > 0 {
-		ca.code = make([]uint8,ca.code_length);
-		//is there a faster way of reading this in?
-		for i :=uint32(0);i<ca.code_length;i++ {
-			ca.code[i]=buf.readByte();
-		}
+		ca.code = buf.readBytes(int(ca.code_length));
 		ca.exception_table_length=buf.readUShort();
 		if (ca.exception_table_length>0) {
 			ca.exception_table=make([]*exception_table_entry, ca.exception_table_length);
@@ -1321,25 +2998,3716 @@ func (ca *Code_attribute) load(buf *Buffer) {
 		
 		//attributes
 		n := buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
 		at := NewAttributeTable(ca.pool,n);
-		at.load(buf);
+		err := at.load(buf);
+		if err != nil {
+			return err
+		}
 		ca.attribute_table = at;
 	} 
+	return buf.err
+}
+
+//the lengths are taken from the slices, so the code can be changed before it is written
+func (ca *Code_attribute) write(w *ClassWriter) error {
+	w.writeUShort(ca.max_stack);
+	w.writeUShort(ca.max_locals);
+	w.writeUInt(uint32(len(ca.code)));
+	w.writeBytes(ca.code);
+	w.writeUShort(uint16(len(ca.exception_table)));
+	for _, x := range ca.exception_table {
+		w.writeUShort(x.start_pc);
+		w.writeUShort(x.end_pc);
+		w.writeUShort(x.handler_pc);
+		w.writeUShort(x.catch_type);
+	}
+	return ca.attribute_table.write(w)
 }
 
-//==============================
+//the source line of the instruction at pc, or 0 if there is no LineNumberTable
+func (ca *Code_attribute) lineNumber(pc int) int {
+	line := 0
+	best := -1
+	if ca.attribute_table == nil {
+		return 0
+	}
+	//there can be more than one LineNumberTable, and the entries can be in any order
+	for _, attr := range ca.attribute_table.attributes {
+		lnt, ok := attr.(*LineNumberTable_attribute)
+		if ok {
+			for _, ln := range lnt.line_number_table {
+				if int(ln.start_pc) <= pc && int(ln.start_pc) > best {
+					best = int(ln.start_pc)
+					line = int(ln.line_number)
+				}
+			}
+		}
+	}
+	return line
+}
 
-func main() {
-	args := os.Args
-	cfname:= args[1]
-	//create a buffer
-	buf := NewBuffer(cfname);
-	cf := NewClassFile();
-	cf.load(buf);
+//all the entries of the LocalVariableTable or LocalVariableTypeTable attributes
+func (ca *Code_attribute) variables(aname string) []*local_variable_info {
+	list := []*local_variable_info{}
+	if ca.attribute_table == nil {
+		return list
+	}
+	for _, attr := range ca.attribute_table.attributes {
+		lvt, ok := attr.(*LocalVariableTable_attribute)
+		if ok && lvt.aname == aname {
+			list = append(list, lvt.local_variable_table...)
+		}
+	}
+	return list
+}
+
+//the local variables of the method, from the LocalVariableTable
+func (ca *Code_attribute) localVariables() []*local_variable_info {
+	return ca.variables("LocalVariableTable")
+}
+
+//the variable in this slot at this pc, or nil if it isn't known
+func (ca *Code_attribute) localVariable(slot int, pc int) *local_variable_info {
+	for _, lv := range ca.localVariables() {
+		if int(lv.index) == slot && lv.covers(pc) {
+			return lv
+		}
+	}
+	return nil
+}
+
+//the same, but from the LocalVariableTypeTable, so the descriptor is the generic signature
+func (ca *Code_attribute) localVariableType(slot int, pc int) *local_variable_info {
+	for _, lv := range ca.variables("LocalVariableTypeTable") {
+		if int(lv.index) == slot && lv.covers(pc) {
+			return lv
+		}
+	}
+	return nil
+}
+
+//===========================================================
+// Annotations.  These are in RuntimeVisibleAnnotations and RuntimeInvisibleAnnotations on classes,
+// fields and methods, in the Parameter versions of those on methods, and in AnnotationDefault
+// on the methods of an annotation interface
+
+type annotation struct {
+	//a field descriptor, like Lcom/acme/Inject;
+	type_index uint16;
+	type_name string;
+	num_element_value_pairs uint16;
+	element_value_pairs []*element_value_pair;
+}
+
+type element_value_pair struct {
+	element_name_index uint16;
+	element_name string;
+	value *element_value;
+}
+
+//The tag says which of the other fields are used:
+//	B C D F I J S Z s	const_value_index, a constant of the matching type.  s is a Utf8
+//	e					type_name_index and const_name_index, for an enum constant
+//	c					class_info_index, the return descriptor of a class literal
+//	@					annotation_value, for a nested annotation
+//	[					values, for an array
+type element_value struct {
+	tag uint8;
+	const_value_index uint16;
+	type_name_index uint16;
+	const_name_index uint16;
+	class_info_index uint16;
+	annotation_value *annotation;
+	num_values uint16;
+	values []*element_value;
+}
+
+func loadAnnotation(buf *Buffer, pool *ConstantPool) (*annotation, error) {
+	a := &annotation{}
+	off := buf.pos
+	a.type_index = buf.readUShort();
+	a.num_element_value_pairs = buf.readUShort();
+	if buf.err != nil {
+		return nil, buf.err
+	}
+	name, err := pool.utf8At(a.type_index, off)
+	if err != nil {
+		return nil, err
+	}
+	a.type_name = name
+	a.element_value_pairs = make([]*element_value_pair, a.num_element_value_pairs)
+	for i := 0; i<int(a.num_element_value_pairs); i++ {
+		p := &element_value_pair{}
+		off = buf.pos
+		p.element_name_index = buf.readUShort();
+		if buf.err != nil {
+			return nil, buf.err
+		}
+		p.element_name, err = pool.utf8At(p.element_name_index, off)
+		if err != nil {
+			return nil, err
+		}
+		p.value, err = loadElementValue(buf, pool)
+		if err != nil {
+			return nil, err
+		}
+		a.element_value_pairs[i]=p;
+	}
+	return a, nil
+}
+
+func loadElementValue(buf *Buffer, pool *ConstantPool) (*element_value, error) {
+	ev := &element_value{}
+	off := buf.pos
+	ev.tag = buf.readByte();
+	if buf.err != nil {
+		return nil, buf.err
+	}
+	var err error
+	switch (ev.tag) {
+		case 'B', 'C', 'I', 'S', 'Z':
+			ev.const_value_index = buf.readUShort();
+			_, err = pool.entryOfType(ev.const_value_index, CONSTANT_Integer, off+1)
+		case 'D':
+			ev.const_value_index = buf.readUShort();
+			_, err = pool.entryOfType(ev.const_value_index, CONSTANT_Double, off+1)
+		case 'F':
+			ev.const_value_index = buf.readUShort();
+			_, err = pool.entryOfType(ev.const_value_index, CONSTANT_Float, off+1)
+		case 'J':
+			ev.const_value_index = buf.readUShort();
+			_, err = pool.entryOfType(ev.const_value_index, CONSTANT_Long, off+1)
+		case 's':
+			ev.const_value_index = buf.readUShort();
+			_, err = pool.utf8At(ev.const_value_index, off+1)
+		case 'e':
+			ev.type_name_index = buf.readUShort();
+			ev.const_name_index = buf.readUShort();
+			_, err = pool.utf8At(ev.type_name_index, off+1)
+			if err == nil {
+				_, err = pool.utf8At(ev.const_name_index, off+3)
+			}
+		case 'c':
+			ev.class_info_index = buf.readUShort();
+			_, err = pool.utf8At(ev.class_info_index, off+1)
+		case '@':
+			ev.annotation_value, err = loadAnnotation(buf, pool)
+		case '[':
+			ev.num_values = buf.readUShort();
+			ev.values = make([]*element_value, ev.num_values)
+			for i := 0; i<int(ev.num_values) && err == nil; i++ {
+				ev.values[i], err = loadElementValue(buf, pool)
+			}
+		default:
+			return nil, newParseError(ErrUnknownTag, off, "element_value tag '"+string(rune(ev.tag))+"'")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ev, buf.err
+}
+
+func (a *annotation) write(w *ClassWriter) {
+	w.writeUShort(a.type_index);
+	w.writeUShort(uint16(len(a.element_value_pairs)));
+	for _, p := range a.element_value_pairs {
+		w.writeUShort(p.element_name_index);
+		p.value.write(w);
+	}
+}
+
+func (ev *element_value) write(w *ClassWriter) {
+	w.writeByte(ev.tag);
+	switch (ev.tag) {
+		case 'e':
+			w.writeUShort(ev.type_name_index);
+			w.writeUShort(ev.const_name_index);
+		case 'c':
+			w.writeUShort(ev.class_info_index);
+		case '@':
+			ev.annotation_value.write(w);
+		case '[':
+			w.writeUShort(uint16(len(ev.values)));
+			for _, v := range ev.values {
+				v.write(w);
+			}
+		default:
+			w.writeUShort(ev.const_value_index);
+	}
+}
+
+//return the value of the named element, or nil if it isn't given.
+//Elements that aren't given use the AnnotationDefault of the annotation interface
+func (a *annotation) value(name string) *element_value {
+	for _, p := range a.element_value_pairs {
+		if p.element_name == name {
+			return p.value
+		}
+	}
+	return nil
+}
+
+//the annotation as it would be written in java, like @com.acme.Inject(name="x")
+func (a *annotation) format(pool *ConstantPool) string {
+	t, _ := javaType(a.type_name, 0)
+	if len(a.element_value_pairs) == 0 {
+		return "@"+t
+	}
+	vals := []string{}
+	for _, p := range a.element_value_pairs {
+		vals = append(vals, p.element_name+"="+p.value.format(pool))
+	}
+	return "@"+t+"("+strings.Join(vals, ", ")+")"
+}
+
+func (ev *element_value) format(pool *ConstantPool) string {
+	k := pool.getConstant(int(ev.const_value_index))
+	switch (ev.tag) {
+		case 'B', 'I', 'S':
+			return strconv.Itoa(k.(*CONSTANT_Integer_info).ival)
+		case 'C':
+			return strconv.QuoteRune(rune(k.(*CONSTANT_Integer_info).ival))
+		case 'Z':
+			return strconv.FormatBool(k.(*CONSTANT_Integer_info).ival != 0)
+		case 'D':
+			return javaFloat(k.(*CONSTANT_Double_info).dval, 64)
+		case 'F':
+			return javaFloat(float64(k.(*CONSTANT_Float_info).fval), 32)+"f"
+		case 'J':
+			return strconv.FormatInt(k.(*CONSTANT_Long_info).lval, 10)+"L"
+		case 's':
+			return strconv.Quote(pool.getName(int(ev.const_value_index)))
+		case 'e':
+			t, _ := javaType(pool.getName(int(ev.type_name_index)), 0)
+			return t+"."+pool.getName(int(ev.const_name_index))
+		case 'c':
+			t, _ := javaType(pool.getName(int(ev.class_info_index)), 0)
+			return t+".class"
+		case '@':
+			return ev.annotation_value.format(pool)
+		case '[':
+			vals := []string{}
+			for _, v := range ev.values {
+				vals = append(vals, v.format(pool))
+			}
+			return "{"+strings.Join(vals, ", ")+"}"
+	}
+	return "?"
+}
+
+//==============================================
+// This holds either RuntimeVisibleAnnotations or RuntimeInvisibleAnnotations, distinguished by the name.
+// Invisible annotations are the ones with @Retention(CLASS), which reflection can't see
+type Annotations_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    num_annotations uint16;
+    annotations []*annotation;
+}
+
+func NewAnnotations(n string, nix uint16, alen uint32) *Annotations_attribute {
+	return &Annotations_attribute {
+		aname: n,
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *Annotations_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *Annotations_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *Annotations_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *Annotations_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.num_annotations = buf.readUShort();
+	attr.annotations = make([]*annotation, attr.num_annotations)
+	for i := 0; i<int(attr.num_annotations); i++ {
+		a, err := loadAnnotation(buf, pool)
+		if err != nil {
+			return err
+		}
+		attr.annotations[i]=a;
+	}
+	return buf.err
+}
+
+func (attr *Annotations_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.annotations)));
+	for _, a := range attr.annotations {
+		a.write(w);
+	}
+	return nil
+}
+
+//==============================================
+// This holds either RuntimeVisibleParameterAnnotations or RuntimeInvisibleParameterAnnotations.
+// There is a list of annotations for each parameter of the method
+type ParameterAnnotations_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    num_parameters uint8;
+    parameter_annotations [][]*annotation;
+}
+
+func NewParameterAnnotations(n string, nix uint16, alen uint32) *ParameterAnnotations_attribute {
+	return &ParameterAnnotations_attribute {
+		aname: n,
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *ParameterAnnotations_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *ParameterAnnotations_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *ParameterAnnotations_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *ParameterAnnotations_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.num_parameters = buf.readByte();
+	attr.parameter_annotations = make([][]*annotation, attr.num_parameters)
+	for i := 0; i<int(attr.num_parameters); i++ {
+		n := buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		list := make([]*annotation, n)
+		for j := 0; j<int(n); j++ {
+			a, err := loadAnnotation(buf, pool)
+			if err != nil {
+				return err
+			}
+			list[j]=a;
+		}
+		attr.parameter_annotations[i]=list;
+	}
+	return buf.err
+}
+
+func (attr *ParameterAnnotations_attribute) write(w *ClassWriter) error {
+	w.writeByte(uint8(len(attr.parameter_annotations)));
+	for _, list := range attr.parameter_annotations {
+		w.writeUShort(uint16(len(list)));
+		for _, a := range list {
+			a.write(w);
+		}
+	}
+	return nil
+}
+
+//==============================================
+// The default value of an element of an annotation interface
+type AnnotationDefault_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    default_value *element_value;
+}
+
+func NewAnnotationDefault(nix uint16, alen uint32) *AnnotationDefault_attribute {
+	return &AnnotationDefault_attribute {
+		aname: "AnnotationDefault",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *AnnotationDefault_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *AnnotationDefault_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *AnnotationDefault_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *AnnotationDefault_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	ev, err := loadElementValue(buf, pool)
+	attr.default_value = ev
+	return err
+}
+
+func (attr *AnnotationDefault_attribute) write(w *ClassWriter) error {
+	attr.default_value.write(w);
+	return nil
+}
+
+//return the visible and invisible annotations in an attribute table
+func (atab *AttributeTable) annotations() []*annotation {
+	list := []*annotation{}
+	if atab == nil {
+		return list
+	}
+	for _, attr := range atab.attributes {
+		aa, ok := attr.(*Annotations_attribute)
+		if ok {
+			list = append(list, aa.annotations...)
+		}
+	}
+	return list
+}
+
+//find an annotation by its descriptor, like "Lcom/acme/Inject;".  This returns nil if it isn't there
+func (atab *AttributeTable) annotation(desc string) *annotation {
+	for _, a := range atab.annotations() {
+		if a.type_name == desc {
+			return a
+		}
+	}
+	return nil
+}
+
+//Annotation finds an annotation on the class
+func (cf *ClassFile) Annotation(desc string) *annotation {
+	return cf.attribute_table.annotation(desc)
+}
+
+//Annotation finds an annotation on a field or method
+func (m *MemberInfo) Annotation(desc string) *annotation {
+	return m.attribute_table.annotation(desc)
+}
+
+//ParameterAnnotation finds an annotation on parameter n of a method, counting from 0.
+//For some constructors the compiler leaves out the synthetic parameters, so n may not
+//be the same as the position in the descriptor
+func (m *MemberInfo) ParameterAnnotation(n int, desc string) *annotation {
+	for _, attr := range m.attribute_table.attributes {
+		pa, ok := attr.(*ParameterAnnotations_attribute)
+		if !ok || n < 0 || n >= len(pa.parameter_annotations) {
+			continue
+		}
+		for _, a := range pa.parameter_annotations[n] {
+			if a.type_name == desc {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+//the default value of an element of an annotation interface, or nil
+func (m *MemberInfo) annotationDefault() *element_value {
+	for _, attr := range m.attribute_table.attributes {
+		ad, ok := attr.(*AnnotationDefault_attribute)
+		if ok {
+			return ad.default_value
+		}
+	}
+	return nil
+}
+
+//===========================================================
+// Attributes from Java 7 on.  These describe invokedynamic call sites, nests, records,
+// sealed classes, method parameters and modules
+
+//the first class file version that has each attribute.  An attribute in an older class file
+//is kept as a Generic_attribute, because the JVM ignores it there
+var attributeSince = map[string]uint16 {
+	"EnclosingMethod": 49,
+	"Signature": 49,
+	"RuntimeVisibleAnnotations": 49,
+	"RuntimeInvisibleAnnotations": 49,
+	"RuntimeVisibleParameterAnnotations": 49,
+	"RuntimeInvisibleParameterAnnotations": 49,
+	"AnnotationDefault": 49,
+	"SourceDebugExtension": 49,
+	"LocalVariableTypeTable": 49,
+	"StackMapTable": 50,
+	"BootstrapMethods": 51,
+	"MethodParameters": 52,
+	"Module": 53,
+	"ModulePackages": 53,
+	"ModuleMainClass": 53,
+	"NestHost": 55,
+	"NestMembers": 55,
+	"Record": 60,
+	"PermittedSubclasses": 61,
+}
+
+//read n constant pool indexes, each of which must point at an entry with tag t
+func loadIndexes(buf *Buffer, pool *ConstantPool, n int, t uint8) ([]uint16, error) {
+	list := make([]uint16, n)
+	for i := 0; i<n; i++ {
+		off := buf.pos
+		list[i] = buf.readUShort();
+		if buf.err != nil {
+			return nil, buf.err
+		}
+		_, err := pool.entryOfType(list[i], t, off)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+//check an index that may be 0, which means there isn't one
+func checkOptionalIndex(pool *ConstantPool, idx uint16, t uint8, off int) error {
+	if idx == 0 {
+		return nil
+	}
+	_, err := pool.entryOfType(idx, t, off)
+	return err
+}
+
+func writeIndexes(w *ClassWriter, list []uint16) {
+	w.writeUShort(uint16(len(list)));
+	for _, x := range list {
+		w.writeUShort(x);
+	}
+}
+
+//the names of a list of CONSTANT_Class, CONSTANT_Module or CONSTANT_Package entries
+func (p *ConstantPool) names(list []uint16) []string {
+	names := make([]string, len(list))
+	for i, x := range list {
+		names[i] = p.getName(int(x))
+	}
+	return names
+}
+
+//==============================================
+// The bootstrap methods for the InvokeDynamic and Dynamic constants, which refer to them by
+// their position in this list
+type BootstrapMethods_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    num_bootstrap_methods uint16;
+    bootstrap_methods []*bootstrap_method;
+}
+
+type bootstrap_method struct {
+	//a CONSTANT_MethodHandle
+	bootstrap_method_ref uint16;
+	num_bootstrap_arguments uint16;
+	//each of these is a loadable constant
+	bootstrap_arguments []uint16;
+}
+
+func NewBootstrapMethods(nix uint16, alen uint32) *BootstrapMethods_attribute {
+	return &BootstrapMethods_attribute {
+		aname: "BootstrapMethods",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *BootstrapMethods_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *BootstrapMethods_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *BootstrapMethods_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *BootstrapMethods_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.num_bootstrap_methods = buf.readUShort();
+	attr.bootstrap_methods = make([]*bootstrap_method, attr.num_bootstrap_methods)
+	for i := 0; i<int(attr.num_bootstrap_methods); i++ {
+		bm := &bootstrap_method{}
+		off := buf.pos
+		bm.bootstrap_method_ref = buf.readUShort();
+		bm.num_bootstrap_arguments = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		_, err := pool.entryOfType(bm.bootstrap_method_ref, CONSTANT_MethodHandle, off)
+		if err != nil {
+			return err
+		}
+		bm.bootstrap_arguments = make([]uint16, bm.num_bootstrap_arguments)
+		for j := 0; j<int(bm.num_bootstrap_arguments); j++ {
+			off = buf.pos
+			bm.bootstrap_arguments[j] = buf.readUShort();
+			if buf.err != nil {
+				return buf.err
+			}
+			_, err = pool.entry(bm.bootstrap_arguments[j], off)
+			if err != nil {
+				return err
+			}
+		}
+		attr.bootstrap_methods[i]=bm;
+	}
+	return nil
+}
+
+func (attr *BootstrapMethods_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.bootstrap_methods)));
+	for _, bm := range attr.bootstrap_methods {
+		w.writeUShort(bm.bootstrap_method_ref);
+		writeIndexes(w, bm.bootstrap_arguments);
+	}
+	return nil
+}
+
+//==============================================
+// This holds either NestHost or ModuleMainClass, distinguished by the name.  Both are
+// just a CONSTANT_Class
+type ClassIndex_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    class_index uint16;
+}
+
+func NewClassIndex(n string, nix uint16) *ClassIndex_attribute {
+	return &ClassIndex_attribute {
+		aname: n,
+		aname_index: nix,
+		alength: 2,						//hard-coded
+	}
+}
+
+func (attr *ClassIndex_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *ClassIndex_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *ClassIndex_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *ClassIndex_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	off := buf.pos
+	attr.class_index = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	_, err := pool.entryOfType(attr.class_index, CONSTANT_Class, off)
+	return err
+}
+
+func (attr *ClassIndex_attribute) write(w *ClassWriter) error {
+	w.writeUShort(attr.class_index);
+	return nil
+}
+
+//==============================================
+// This holds NestMembers, PermittedSubclasses or ModulePackages, distinguished by the name.
+// They are all a list of indexes.  The first two are classes and ModulePackages has packages
+type IndexList_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    number_of_entries uint16;
+    entries []uint16;
+}
+
+func NewIndexList(n string, nix uint16, alen uint32) *IndexList_attribute {
+	return &IndexList_attribute {
+		aname: n,
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *IndexList_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *IndexList_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *IndexList_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *IndexList_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	t := uint8(CONSTANT_Class)
+	if attr.aname == "ModulePackages" {
+		t = CONSTANT_Package
+	}
+	attr.number_of_entries = buf.readUShort();
+	list, err := loadIndexes(buf, pool, int(attr.number_of_entries), t)
+	attr.entries = list
+	return err
+}
+
+func (attr *IndexList_attribute) write(w *ClassWriter) error {
+	writeIndexes(w, attr.entries);
+	return nil
+}
+
+//==============================================
+// The components of a record class.  Each component can have its own Signature and annotations
+type Record_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    components_count uint16;
+    components []*record_component_info;
+}
+
+type record_component_info struct {
+	name_index uint16;
+	descriptor_index uint16;
+	name string;
+	descriptor string;
+	attribute_table *AttributeTable;
+}
+
+func NewRecord(nix uint16, alen uint32) *Record_attribute {
+	return &Record_attribute {
+		aname: "Record",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *Record_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *Record_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *Record_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *Record_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.components_count = buf.readUShort();
+	attr.components = make([]*record_component_info, attr.components_count)
+	for i := 0; i<int(attr.components_count); i++ {
+		rc := &record_component_info{}
+		off := buf.pos
+		rc.name_index = buf.readUShort();
+		rc.descriptor_index = buf.readUShort();
+		n := buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		var err error
+		rc.name, err = pool.utf8At(rc.name_index, off)
+		if err != nil {
+			return err
+		}
+		rc.descriptor, err = pool.utf8At(rc.descriptor_index, off+2)
+		if err != nil {
+			return err
+		}
+		rc.attribute_table = NewAttributeTable(pool, n);
+		err = rc.attribute_table.load(buf);
+		if err != nil {
+			return err
+		}
+		attr.components[i]=rc;
+	}
+	return nil
+}
+
+func (attr *Record_attribute) write(w *ClassWriter) error {
+	w.writeUShort(uint16(len(attr.components)));
+	for _, rc := range attr.components {
+		w.writeUShort(rc.name_index);
+		w.writeUShort(rc.descriptor_index);
+		err := rc.attribute_table.write(w);
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//==============================================
+// The names and modifiers of the parameters of a method, from javac -parameters
+type MethodParameters_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    parameters_count uint8;
+    parameters []*method_parameter;
+}
+
+type method_parameter struct {
+	//0 if the parameter has no name
+	name_index uint16;
+	//ACC_FINAL, ACC_SYNTHETIC or ACC_MANDATED
+	access_flags uint16;
+}
+
+func NewMethodParameters(nix uint16, alen uint32) *MethodParameters_attribute {
+	return &MethodParameters_attribute {
+		aname: "MethodParameters",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *MethodParameters_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *MethodParameters_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *MethodParameters_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *MethodParameters_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.parameters_count = buf.readByte();
+	attr.parameters = make([]*method_parameter, attr.parameters_count)
+	for i := 0; i<int(attr.parameters_count); i++ {
+		mp := &method_parameter{}
+		off := buf.pos
+		mp.name_index = buf.readUShort();
+		mp.access_flags = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		err := checkOptionalIndex(pool, mp.name_index, CONSTANT_Utf8, off)
+		if err != nil {
+			return err
+		}
+		attr.parameters[i]=mp;
+	}
+	return nil
+}
+
+func (attr *MethodParameters_attribute) write(w *ClassWriter) error {
+	w.writeByte(uint8(len(attr.parameters)));
+	for _, mp := range attr.parameters {
+		w.writeUShort(mp.name_index);
+		w.writeUShort(mp.access_flags);
+	}
+	return nil
+}
+
+//==============================================
+// The module declaration in module-info.class
+type Module_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    module_name_index uint16;		//a CONSTANT_Module
+    module_flags uint16;
+    module_version_index uint16;	//a CONSTANT_Utf8, or 0
+    requires_count uint16;
+    requires []*module_requires;
+    exports_count uint16;
+    exports []*module_exports;
+    opens_count uint16;
+    opens []*module_exports;
+    uses_count uint16;
+    uses_index []uint16;			//CONSTANT_Class
+    provides_count uint16;
+    provides []*module_provides;
+}
+
+type module_requires struct {
+	requires_index uint16;			//a CONSTANT_Module
+	requires_flags uint16;
+	requires_version_index uint16;	//a CONSTANT_Utf8, or 0
+}
+
+//this is used for both exports and opens, which have the same structure
+type module_exports struct {
+	exports_index uint16;			//a CONSTANT_Package
+	exports_flags uint16;
+	exports_to_count uint16;
+	exports_to_index []uint16;		//CONSTANT_Module.  If there are none, it is exported to everyone
+}
+
+type module_provides struct {
+	provides_index uint16;			//the service interface, a CONSTANT_Class
+	provides_with_count uint16;
+	provides_with_index []uint16;	//the implementations, CONSTANT_Class
+}
+
+func NewModule(nix uint16, alen uint32) *Module_attribute {
+	return &Module_attribute {
+		aname: "Module",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *Module_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *Module_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *Module_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *Module_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	off := buf.pos
+	attr.module_name_index = buf.readUShort();
+	attr.module_flags = buf.readUShort();
+	attr.module_version_index = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	_, err := pool.entryOfType(attr.module_name_index, CONSTANT_Module, off)
+	if err != nil {
+		return err
+	}
+	err = checkOptionalIndex(pool, attr.module_version_index, CONSTANT_Utf8, off+4)
+	if err != nil {
+		return err
+	}
+	msg := ModuleFlags(attr.module_flags).check()
+	if msg != "" {
+		return newParseError(ErrBadFlags, off+2, "module: "+msg)
+	}
+	mname := pool.getName(int(attr.module_name_index))
+
+	attr.requires_count = buf.readUShort();
+	attr.requires = make([]*module_requires, attr.requires_count)
+	for i := 0; i<int(attr.requires_count); i++ {
+		r := &module_requires{}
+		off = buf.pos
+		r.requires_index = buf.readUShort();
+		r.requires_flags = buf.readUShort();
+		r.requires_version_index = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		_, err = pool.entryOfType(r.requires_index, CONSTANT_Module, off)
+		if err != nil {
+			return err
+		}
+		err = checkOptionalIndex(pool, r.requires_version_index, CONSTANT_Utf8, off+4)
+		if err != nil {
+			return err
+		}
+		required := pool.getName(int(r.requires_index))
+		msg = RequiresFlags(r.requires_flags).check(mname, required, pool.major_version)
+		if msg != "" {
+			return newParseError(ErrBadFlags, off+2, "requires "+required+": "+msg)
+		}
+		attr.requires[i]=r;
+	}
+
+	attr.exports_count = buf.readUShort();
+	attr.exports, err = loadModuleExports(buf, pool, int(attr.exports_count), "exports")
+	if err != nil {
+		return err
+	}
+	attr.opens_count = buf.readUShort();
+	attr.opens, err = loadModuleExports(buf, pool, int(attr.opens_count), "opens")
+	if err != nil {
+		return err
+	}
+
+	attr.uses_count = buf.readUShort();
+	attr.uses_index, err = loadIndexes(buf, pool, int(attr.uses_count), CONSTANT_Class)
+	if err != nil {
+		return err
+	}
+
+	attr.provides_count = buf.readUShort();
+	attr.provides = make([]*module_provides, attr.provides_count)
+	for i := 0; i<int(attr.provides_count); i++ {
+		p := &module_provides{}
+		off = buf.pos
+		p.provides_index = buf.readUShort();
+		p.provides_with_count = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		_, err = pool.entryOfType(p.provides_index, CONSTANT_Class, off)
+		if err != nil {
+			return err
+		}
+		p.provides_with_index, err = loadIndexes(buf, pool, int(p.provides_with_count), CONSTANT_Class)
+		if err != nil {
+			return err
+		}
+		attr.provides[i]=p;
+	}
+	return nil
+}
+
+//what is exports or opens, for the error messages
+func loadModuleExports(buf *Buffer, pool *ConstantPool, n int, what string) ([]*module_exports, error) {
+	list := make([]*module_exports, n)
+	for i := 0; i<n; i++ {
+		e := &module_exports{}
+		off := buf.pos
+		e.exports_index = buf.readUShort();
+		e.exports_flags = buf.readUShort();
+		e.exports_to_count = buf.readUShort();
+		if buf.err != nil {
+			return nil, buf.err
+		}
+		_, err := pool.entryOfType(e.exports_index, CONSTANT_Package, off)
+		if err != nil {
+			return nil, err
+		}
+		msg := ModuleFlags(e.exports_flags).checkExports()
+		if msg != "" {
+			return nil, newParseError(ErrBadFlags, off+2, what+" "+pool.getName(int(e.exports_index))+": "+msg)
+		}
+		e.exports_to_index, err = loadIndexes(buf, pool, int(e.exports_to_count), CONSTANT_Module)
+		if err != nil {
+			return nil, err
+		}
+		list[i]=e;
+	}
+	return list, nil
+}
+
+func (attr *Module_attribute) write(w *ClassWriter) error {
+	w.writeUShort(attr.module_name_index);
+	w.writeUShort(attr.module_flags);
+	w.writeUShort(attr.module_version_index);
+	w.writeUShort(uint16(len(attr.requires)));
+	for _, r := range attr.requires {
+		w.writeUShort(r.requires_index);
+		w.writeUShort(r.requires_flags);
+		w.writeUShort(r.requires_version_index);
+	}
+	for _, list := range [][]*module_exports{attr.exports, attr.opens} {
+		w.writeUShort(uint16(len(list)));
+		for _, e := range list {
+			w.writeUShort(e.exports_index);
+			w.writeUShort(e.exports_flags);
+			writeIndexes(w, e.exports_to_index);
+		}
+	}
+	writeIndexes(w, attr.uses_index);
+	w.writeUShort(uint16(len(attr.provides)));
+	for _, p := range attr.provides {
+		w.writeUShort(p.provides_index);
+		writeIndexes(w, p.provides_with_index);
+	}
+	return nil
+}
+
+//return the attribute with this name, or nil
+func (atab *AttributeTable) find(aname string) AttributeInfo {
+	if atab == nil {
+		return nil
+	}
+	for _, attr := range atab.attributes {
+		if attr.attribute_name() == aname {
+			return attr
+		}
+	}
+	return nil
+}
+
+//print the records, sealed classes, nests and modules
+func (cf *ClassFile) dump_attributes() {
+	pool := cf.pool
+	at := cf.attribute_table
+	if r, ok := at.find("Record").(*Record_attribute); ok {
+		fmt.Println("record components: ");
+		for _, rc := range r.components {
+			fmt.Println("    "+rc.name+" ("+rc.descriptor+")");
+		}
+	}
+	if ps, ok := at.find("PermittedSubclasses").(*IndexList_attribute); ok {
+		fmt.Println("permitted subclasses: "+strings.Join(pool.names(ps.entries), ", "));
+	}
+	if nh, ok := at.find("NestHost").(*ClassIndex_attribute); ok {
+		fmt.Println("nest host: "+pool.getName(int(nh.class_index)));
+	}
+	if nm, ok := at.find("NestMembers").(*IndexList_attribute); ok {
+		fmt.Println("nest members: "+strings.Join(pool.names(nm.entries), ", "));
+	}
+	if bm, ok := at.find("BootstrapMethods").(*BootstrapMethods_attribute); ok {
+		fmt.Println("bootstrap methods: ");
+		for i, b := range bm.bootstrap_methods {
+			//the loader checks that this is a method handle, but the attribute could have been changed since
+			target := "#"+strconv.Itoa(int(b.bootstrap_method_ref))
+			if mh, ok := pool.getConstant(int(b.bootstrap_method_ref)).(*CONSTANT_MethodHandle_info); ok {
+				target = mh.cname+"."+mh.name
+			}
+			fmt.Println("    "+strconv.Itoa(i)+": "+target+" with "+strconv.Itoa(len(b.bootstrap_arguments))+" arguments");
+		}
+	}
+	if mo, ok := at.find("Module").(*Module_attribute); ok {
+		s := "module: "+pool.getName(int(mo.module_name_index))
+		if ModuleFlags(mo.module_flags).has(ACC_OPEN) {
+			s = "open "+s
+		}
+		if mo.module_version_index != 0 {
+			s = s + "@" + pool.getName(int(mo.module_version_index))
+		}
+		fmt.Println(s);
+		for _, r := range mo.requires {
+			mods := RequiresFlags(r.requires_flags).String()
+			if mods != "" {
+				mods = mods + " "
+			}
+			fmt.Println("    requires "+mods+pool.getName(int(r.requires_index)));
+		}
+		for _, e := range mo.exports {
+			fmt.Println("    exports "+moduleTarget(pool, e));
+		}
+		for _, e := range mo.opens {
+			fmt.Println("    opens "+moduleTarget(pool, e));
+		}
+		for _, u := range mo.uses_index {
+			fmt.Println("    uses "+pool.getName(int(u)));
+		}
+		for _, p := range mo.provides {
+			fmt.Println("    provides "+pool.getName(int(p.provides_index))+" with "+strings.Join(pool.names(p.provides_with_index), ", "));
+		}
+	}
+	smap := cf.sourceDebugExtension()
+	if smap != "" {
+		fmt.Println("source debug extension: ");
+		fmt.Println(smap);
+	}
+	if mp, ok := at.find("ModulePackages").(*IndexList_attribute); ok {
+		fmt.Println("module packages: "+strings.Join(pool.names(mp.entries), ", "));
+	}
+	if mc, ok := at.find("ModuleMainClass").(*ClassIndex_attribute); ok {
+		fmt.Println("module main class: "+pool.getName(int(mc.class_index)));
+	}
+}
+
+func moduleTarget(pool *ConstantPool, e *module_exports) string {
+	s := pool.getName(int(e.exports_index))
+	if len(e.exports_to_index) > 0 {
+		s = s + " to " + strings.Join(pool.names(e.exports_to_index), ", ")
+	}
+	return s
+}
+
+//==============================
+// Descriptors and signatures.  A descriptor like (ILjava/lang/String;)V gives the erased types
+// of a field or method, and a signature like <T:Ljava/lang/Object;>(TT;)Ljava/util/List<TT;>;
+// from a Signature attribute gives the generic types.  Signatures are a superset of descriptors,
+// so one parser handles both, with the generic parts switched off for descriptors (JVMS 4.3, 4.7.9.1)
+
+//DescriptorError says what is wrong with a descriptor or signature, and where in the string
+type DescriptorError struct {
+	Desc string;
+	Pos int;
+	Detail string;
+}
+
+func (e *DescriptorError) Error() string {
+	return "bad descriptor "+e.Desc+" at "+strconv.Itoa(e.Pos)+": "+e.Detail
+}
+
+//a type in a descriptor or signature
+type JavaType struct {
+	//one of BCDFIJSZV for a primitive or void, L for a class, T for a type variable and [ for an array
+	tag byte;
+	//the class name, like java/util/Map$Entry, or the name of the type variable
+	name string;
+	//the type arguments of a generic class
+	args []*TypeArgument;
+	//the class that an inner class is in, if it has type arguments, as in Outer<TT;>.Inner
+	outer *JavaType;
+	//the type of the elements of an array
+	elem *JavaType;
+}
+
+//a type argument is a type, or a wildcard like ? extends Number
+type TypeArgument struct {
+	//'+' for extends, '-' for super, '*' for an unbounded wildcard, or 0 for a plain type
+	wildcard byte;
+	t *JavaType;
+}
+
+//a type parameter like T extends Number & Comparable<T>
+type TypeParameter struct {
+	name string;
+	//this is nil if there are only interface bounds
+	class_bound *JavaType;
+	interface_bounds []*JavaType;
+}
+
+//the parts of a method descriptor or method signature.  A descriptor has no type
+//parameters or throws
+type MethodSignature struct {
+	type_params []*TypeParameter;
+	params []*JavaType;
+	ret *JavaType;
+	throws []*JavaType;
+}
+
+//the parts of a class signature
+type ClassSignature struct {
+	type_params []*TypeParameter;
+	super *JavaType;
+	interfaces []*JavaType;
+}
+
+func (t *JavaType) isVoid() bool {
+	return t.tag == 'V'
+}
+
+func (t *JavaType) isPrimitive() bool {
+	return strings.IndexByte("BCDFIJSZ", t.tag) >= 0
+}
+
+func (t *JavaType) isArray() bool {
+	return t.tag == '['
+}
+
+//the number of array dimensions, or 0 if it isn't an array
+func (t *JavaType) dims() int {
+	n := 0
+	for t.tag == '[' {
+		n++
+		t = t.elem
+	}
+	return n
+}
+
+//the type of the elements of an array, after all the dimensions
+func (t *JavaType) elementType() *JavaType {
+	for t.tag == '[' {
+		t = t.elem
+	}
+	return t
+}
+
+//the number of local variable slots.  long and double take 2
+func (t *JavaType) slots() int {
+	switch (t.tag) {
+		case 'J', 'D': return 2
+		case 'V': return 0
+	}
+	return 1
+}
+
+//the erased type as a descriptor.  A type variable becomes Object, because its bounds
+//are somewhere else
+func (t *JavaType) descriptor() string {
+	switch (t.tag) {
+		case 'L': return "L"+t.name+";"
+		case 'T': return "Ljava/lang/Object;"
+		case '[': return "["+t.elem.descriptor()
+	}
+	return string(t.tag)
+}
+
+//the type as it would be written in Java, like java.util.Map<K, V>.Entry or int[]
+func (t *JavaType) String() string {
+	switch (t.tag) {
+		case 'B': return "byte"
+		case 'C': return "char"
+		case 'D': return "double"
+		case 'F': return "float"
+		case 'I': return "int"
+		case 'J': return "long"
+		case 'S': return "short"
+		case 'Z': return "boolean"
+		case 'V': return "void"
+		case 'T': return t.name
+		case '[': return t.elem.String()+"[]"
+	}
+	s := ""
+	if t.outer != nil {
+		s = t.outer.String()+"."+strings.TrimPrefix(t.name, t.outer.name+"$")
+	} else {
+		s = strings.ReplaceAll(t.name, "/", ".")
+	}
+	if len(t.args) > 0 {
+		args := make([]string, len(t.args))
+		for i, a := range t.args {
+			args[i] = a.String()
+		}
+		s = s + "<" + strings.Join(args, ", ") + ">"
+	}
+	return s
+}
+
+func (a *TypeArgument) String() string {
+	switch (a.wildcard) {
+		case '*': return "?"
+		case '+': return "? extends "+a.t.String()
+		case '-': return "? super "+a.t.String()
+	}
+	return a.t.String()
+}
+
+//a bound of Object is left out, as it would be in Java
+func (tp *TypeParameter) String() string {
+	bounds := []string{}
+	if tp.class_bound != nil && !(tp.class_bound.tag == 'L' && tp.class_bound.name == "java/lang/Object") {
+		bounds = append(bounds, tp.class_bound.String())
+	}
+	for _, b := range tp.interface_bounds {
+		bounds = append(bounds, b.String())
+	}
+	if len(bounds) == 0 {
+		return tp.name
+	}
+	return tp.name+" extends "+strings.Join(bounds, " & ")
+}
+
+//the type parameters like <K, V extends Number>, or "" if there aren't any
+func typeParamsString(tps []*TypeParameter) string {
+	if len(tps) == 0 {
+		return ""
+	}
+	s := make([]string, len(tps))
+	for i, tp := range tps {
+		s[i] = tp.String()
+	}
+	return "<"+strings.Join(s, ", ")+">"
+}
+
+func typesString(ts []*JavaType) string {
+	s := make([]string, len(ts))
+	for i, t := range ts {
+		s[i] = t.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+//the number of params, not counting "this"
+func (ms *MethodSignature) paramCount() int {
+	return len(ms.params)
+}
+
+//the number of local variable slots taken by the params, not counting "this"
+func (ms *MethodSignature) paramSlots() int {
+	n := 0
+	for _, t := range ms.params {
+		n = n + t.slots()
+	}
+	return n
+}
+
+//the parser.  It stops at the first error
+type sigParser struct {
+	s string;
+	i int;
+	generic bool;		//false for a descriptor
+	err error;
+}
+
+func (p *sigParser) fail(detail string) {
+	if p.err == nil {
+		p.err = &DescriptorError{Desc: p.s, Pos: p.i, Detail: detail}
+	}
+}
+
+//the next character, or 0 at the end
+func (p *sigParser) peek() byte {
+	if p.err != nil || p.i >= len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sigParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail("expecting "+string(c))
+		return
+	}
+	p.i++
+}
+
+//an identifier can't have any of . ; [ / < > :
+func (p *sigParser) identifier() string {
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(".;[/<>:", p.s[p.i]) < 0 {
+		p.i++
+	}
+	if p.i == start {
+		p.fail("expecting an identifier")
+	}
+	return p.s[start:p.i]
+}
+
+//a field type.  void is only allowed as a return type
+func (p *sigParser) fieldType() *JavaType {
+	c := p.peek()
+	if strings.IndexByte("BCDFIJSZ", c) >= 0 {
+		p.i++
+		return &JavaType{tag: c}
+	}
+	return p.referenceType()
+}
+
+func (p *sigParser) returnType() *JavaType {
+	if p.peek() == 'V' {
+		p.i++
+		return &JavaType{tag: 'V'}
+	}
+	return p.fieldType()
+}
+
+func (p *sigParser) referenceType() *JavaType {
+	switch (p.peek()) {
+		case 'L':
+			return p.classType()
+		case '[':
+			start := p.i
+			p.i++
+			elem := p.fieldType()
+			t := &JavaType{tag: '[', elem: elem}
+			if p.err == nil && t.dims() > 255 {
+				p.i = start
+				p.fail("more than 255 array dimensions")
+			}
+			return t
+		case 'T':
+			if p.generic {
+				p.i++
+				t := &JavaType{tag: 'T', name: p.identifier()}
+				p.expect(';')
+				return t
+			}
+	}
+	p.fail("expecting a type")
+	return &JavaType{tag: 'V'}
+}
+
+//a class name is identifiers separated by /.  In a signature it can have type arguments,
+//and be followed by inner classes
+func (p *sigParser) classType() *JavaType {
+	p.expect('L')
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(".;[<>:", p.s[p.i]) < 0 {
+		p.i++
+	}
+	name := p.s[start:p.i]
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		p.i = start
+		p.fail("bad class name")
+	}
+	t := &JavaType{tag: 'L', name: name}
+	if p.generic {
+		if p.peek() == '<' {
+			t.args = p.typeArguments()
+		}
+		for p.peek() == '.' {
+			p.i++
+			inner := &JavaType{tag: 'L', outer: t}
+			inner.name = t.name+"$"+p.identifier()
+			if p.peek() == '<' {
+				inner.args = p.typeArguments()
+			}
+			t = inner
+		}
+	}
+	p.expect(';')
+	return t
+}
+
+func (p *sigParser) typeArguments() []*TypeArgument {
+	p.expect('<')
+	args := []*TypeArgument{}
+	for p.err == nil && p.peek() != '>' {
+		a := &TypeArgument{}
+		switch (p.peek()) {
+			case '*':
+				p.i++
+				a.wildcard = '*'
+			case '+', '-':
+				a.wildcard = p.peek()
+				p.i++
+				a.t = p.referenceType()
+			default:
+				a.t = p.referenceType()
+		}
+		args = append(args, a)
+	}
+	if len(args) == 0 {
+		p.fail("empty type arguments")
+	}
+	p.expect('>')
+	return args
+}
+
+func (p *sigParser) typeParameters() []*TypeParameter {
+	p.expect('<')
+	tps := []*TypeParameter{}
+	for p.err == nil && p.peek() != '>' {
+		tp := &TypeParameter{name: p.identifier()}
+		p.expect(':')
+		//the class bound can be empty when there are interface bounds
+		c := p.peek()
+		if c == 'L' || c == 'T' || c == '[' {
+			tp.class_bound = p.referenceType()
+		}
+		for p.peek() == ':' {
+			p.i++
+			tp.interface_bounds = append(tp.interface_bounds, p.referenceType())
+		}
+		tps = append(tps, tp)
+	}
+	if len(tps) == 0 {
+		p.fail("empty type parameters")
+	}
+	p.expect('>')
+	return tps
+}
+
+func (p *sigParser) method() *MethodSignature {
+	ms := &MethodSignature{}
+	if p.generic && p.peek() == '<' {
+		ms.type_params = p.typeParameters()
+	}
+	p.expect('(')
+	for p.err == nil && p.peek() != ')' {
+		ms.params = append(ms.params, p.fieldType())
+	}
+	p.expect(')')
+	ms.ret = p.returnType()
+	for p.generic && p.peek() == '^' {
+		p.i++
+		ms.throws = append(ms.throws, p.referenceType())
+	}
+	if p.err == nil && ms.paramSlots() > 255 {
+		p.fail("the params take more than 255 slots")
+	}
+	return ms
+}
+
+//check that there is nothing left over
+func (p *sigParser) end() error {
+	if p.err == nil && p.i < len(p.s) {
+		p.fail("unexpected "+p.s[p.i:])
+	}
+	return p.err
+}
+
+//parse a field descriptor like [Ljava/lang/String;
+func parseFieldDescriptor(desc string) (*JavaType, error) {
+	p := &sigParser{s: desc}
+	t := p.fieldType()
+	return t, p.end()
+}
+
+//parse a method descriptor like (IJ)V
+func parseMethodDescriptor(desc string) (*MethodSignature, error) {
+	p := &sigParser{s: desc}
+	ms := p.method()
+	return ms, p.end()
+}
+
+//parse the Signature of a field, like Ljava/util/List<TT;>;
+func parseFieldSignature(sig string) (*JavaType, error) {
+	p := &sigParser{s: sig, generic: true}
+	t := p.referenceType()
+	return t, p.end()
+}
+
+//parse the Signature of a method, like <T:Ljava/lang/Object;>(TT;)TT;^TE;
+func parseMethodSignature(sig string) (*MethodSignature, error) {
+	p := &sigParser{s: sig, generic: true}
+	ms := p.method()
+	return ms, p.end()
+}
+
+//parse the Signature of a class, like <T:Ljava/lang/Object;>Ljava/lang/Object;Ljava/lang/Comparable<TT;>;
+func parseClassSignature(sig string) (*ClassSignature, error) {
+	p := &sigParser{s: sig, generic: true}
+	cs := &ClassSignature{}
+	if p.peek() == '<' {
+		cs.type_params = p.typeParameters()
+	}
+	cs.super = p.classType()
+	for p.err == nil && p.peek() == 'L' {
+		cs.interfaces = append(cs.interfaces, p.classType())
+	}
+	return cs, p.end()
+}
+
+//the generic signature from the Signature attribute, or "" if there isn't one
+func (atab *AttributeTable) signature() string {
+	sa, ok := atab.find("Signature").(*Signature_attribute)
+	if !ok {
+		return ""
+	}
+	return atab.pool.getName(int(sa.signature_index))
+}
+
+//the generic type of a field if it has a Signature, or else the type from the descriptor.
+//This is nil if neither can be parsed
+func (f *MemberInfo) fieldType() *JavaType {
+	sig := f.attribute_table.signature()
+	if sig != "" {
+		t, err := parseFieldSignature(sig)
+		if err == nil {
+			return t
+		}
+	}
+	t, err := parseFieldDescriptor(f.sig())
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+//the same for a method
+func (m *MemberInfo) methodSignature() *MethodSignature {
+	sig := m.attribute_table.signature()
+	if sig != "" {
+		ms, err := parseMethodSignature(sig)
+		if err == nil {
+			return ms
+		}
+	}
+	ms, err := parseMethodDescriptor(m.sig())
+	if err != nil {
+		return nil
+	}
+	return ms
+}
+
+//==============================
+// Instruction decoder.  This splits the code of a method into instructions and reads their
+// operands, for every opcode in the JVM spec (chapter 6).  The disassembler and the Lava6
+// translator both work from the decoded instructions, so they agree on where each one starts
+
+//CodeError says which instruction is bad and why
+type CodeError struct {
+	PC int;
+	Detail string;
+}
+
+func (e *CodeError) Error() string {
+	return "bad instruction at pc "+strconv.Itoa(e.PC)+": "+e.Detail
+}
+
+//the operands that follow each opcode
+const (
+	opNone = iota;		//no operands
+	opByte;				//a signed byte, for bipush
+	opShort;			//a signed short, for sipush
+	opLocal;			//a local variable index, which is 2 bytes after wide
+	opConst1;			//a 1 byte constant pool index, for ldc
+	opConst2;			//a 2 byte constant pool index
+	opIinc;				//a local variable index and a signed byte, or 2 shorts after wide
+	opBranch2;			//a signed 2 byte offset
+	opBranch4;			//a signed 4 byte offset
+	opNewarray;			//the element type
+	opInterface;		//a constant pool index, the count of arg slots and a zero
+	opDynamic;			//a constant pool index and 2 zeros
+	opMultiArray;		//a constant pool index and the number of dimensions
+	opTableSwitch;
+	opLookupSwitch;
+	opWide;
+	opInvalid;			//not an opcode.  breakpoint and impdep are reserved for debuggers and the JVM
+)
+
+//the name of every opcode, indexed by the opcode
+var mnemonics = [256]string {
+	/* 0x00 */ "nop", "aconst_null", "iconst_m1", "iconst_0", "iconst_1", "iconst_2", "iconst_3", "iconst_4",
+	/* 0x08 */ "iconst_5", "lconst_0", "lconst_1", "fconst_0", "fconst_1", "fconst_2", "dconst_0", "dconst_1",
+	/* 0x10 */ "bipush", "sipush", "ldc", "ldc_w", "ldc2_w", "iload", "lload", "fload",
+	/* 0x18 */ "dload", "aload", "iload_0", "iload_1", "iload_2", "iload_3", "lload_0", "lload_1",
+	/* 0x20 */ "lload_2", "lload_3", "fload_0", "fload_1", "fload_2", "fload_3", "dload_0", "dload_1",
+	/* 0x28 */ "dload_2", "dload_3", "aload_0", "aload_1", "aload_2", "aload_3", "iaload", "laload",
+	/* 0x30 */ "faload", "daload", "aaload", "baload", "caload", "saload", "istore", "lstore",
+	/* 0x38 */ "fstore", "dstore", "astore", "istore_0", "istore_1", "istore_2", "istore_3", "lstore_0",
+	/* 0x40 */ "lstore_1", "lstore_2", "lstore_3", "fstore_0", "fstore_1", "fstore_2", "fstore_3", "dstore_0",
+	/* 0x48 */ "dstore_1", "dstore_2", "dstore_3", "astore_0", "astore_1", "astore_2", "astore_3", "iastore",
+	/* 0x50 */ "lastore", "fastore", "dastore", "aastore", "bastore", "castore", "sastore", "pop",
+	/* 0x58 */ "pop2", "dup", "dup_x1", "dup_x2", "dup2", "dup2_x1", "dup2_x2", "swap",
+	/* 0x60 */ "iadd", "ladd", "fadd", "dadd", "isub", "lsub", "fsub", "dsub",
+	/* 0x68 */ "imul", "lmul", "fmul", "dmul", "idiv", "ldiv", "fdiv", "ddiv",
+	/* 0x70 */ "irem", "lrem", "frem", "drem", "ineg", "lneg", "fneg", "dneg",
+	/* 0x78 */ "ishl", "lshl", "ishr", "lshr", "iushr", "lushr", "iand", "land",
+	/* 0x80 */ "ior", "lor", "ixor", "lxor", "iinc", "i2l", "i2f", "i2d",
+	/* 0x88 */ "l2i", "l2f", "l2d", "f2i", "f2l", "f2d", "d2i", "d2l",
+	/* 0x90 */ "d2f", "i2b", "i2c", "i2s", "lcmp", "fcmpl", "fcmpg", "dcmpl",
+	/* 0x98 */ "dcmpg", "ifeq", "ifne", "iflt", "ifge", "ifgt", "ifle", "if_icmpeq",
+	/* 0xA0 */ "if_icmpne", "if_icmplt", "if_icmpge", "if_icmpgt", "if_icmple", "if_acmpeq", "if_acmpne", "goto",
+	/* 0xA8 */ "jsr", "ret", "tableswitch", "lookupswitch", "ireturn", "lreturn", "freturn", "dreturn",
+	/* 0xB0 */ "areturn", "return", "getstatic", "putstatic", "getfield", "putfield", "invokevirtual", "invokespecial",
+	/* 0xB8 */ "invokestatic", "invokeinterface", "invokedynamic", "new", "newarray", "anewarray", "arraylength", "athrow",
+	/* 0xC0 */ "checkcast", "instanceof", "monitorenter", "monitorexit", "wide", "multianewarray", "ifnull", "ifnonnull",
+	/* 0xC8 */ "goto_w", "jsr_w", "breakpoint",
+	0xFE: "impdep1", "impdep2",
+}
+
+//the operands of each opcode.  Everything from 0xCA on is invalid
+var operandFormats [256]uint8
+
+func init() {
+	for op := 0; op < 256; op++ {
+		f := uint8(opNone)
+		switch {
+			case op == 0x10: f = opByte
+			case op == 0x11: f = opShort
+			case op == 0x12: f = opConst1
+			case op == 0x13, op == 0x14: f = opConst2
+			case op >= 0x15 && op <= 0x19, op >= 0x36 && op <= 0x3A, op == 0xA9: f = opLocal
+			case op == 0x84: f = opIinc
+			case op >= 0x99 && op <= 0xA8, op == 0xC6, op == 0xC7: f = opBranch2
+			case op == 0xAA: f = opTableSwitch
+			case op == 0xAB: f = opLookupSwitch
+			case op >= 0xB2 && op <= 0xB8, op == 0xBB, op == 0xBD, op == 0xC0, op == 0xC1: f = opConst2
+			case op == 0xB9: f = opInterface
+			case op == 0xBA: f = opDynamic
+			case op == 0xBC: f = opNewarray
+			case op == 0xC4: f = opWide
+			case op == 0xC5: f = opMultiArray
+			case op == 0xC8, op == 0xC9: f = opBranch4
+			case op >= 0xCA: f = opInvalid
+		}
+		operandFormats[op] = f
+	}
+}
+
+//one decoded instruction.  Only the fields for its operands are set
+type Instruction struct {
+	pc int;
+	opcode uint8;		//after wide, this is the opcode that wide changes
+	length int;			//including wide and any switch padding
+	wide bool;
+	index int;			//the local variable or constant pool index
+	value int;			//bipush, sipush, the iinc increment, the newarray type, the invokeinterface count or the dimensions
+	target int;			//the pc a branch goes to, or the default of a switch
+	low int;			//the first key of a tableswitch
+	keys []int;			//the keys of a switch, which for a tableswitch are low, low+1 and so on
+	targets []int;		//where each key goes
+}
+
+func (ins *Instruction) mnemonic() string {
+	return mnemonics[ins.opcode]
+}
+
+//the pc of the next instruction
+func (ins *Instruction) next() int {
+	return ins.pc + ins.length
+}
+
+func codeError(pc int, detail string) error {
+	return &CodeError{PC: pc, Detail: detail}
+}
+
+//decode the instruction at pc
+func decodeInstruction(code []byte, pc int) (*Instruction, error) {
+	//the operands, which must be in the code
+	need := func(n int) bool {
+		return pc+n <= len(code)
+	}
+	u1 := func(i int) int { return int(code[pc+i]) }
+	u2 := func(i int) int { return int(code[pc+i])<<8 | int(code[pc+i+1]) }
+	s4 := func(i int) int { return int(int32(uint32(u2(i))<<16 | uint32(u2(i+2)))) }
+
+	op := code[pc]
+	ins := &Instruction{pc: pc, opcode: op, length: 1}
+	switch (operandFormats[op]) {
+		case opNone:
+		case opByte:
+			ins.length = 2
+			if need(2) {
+				ins.value = int(int8(code[pc+1]))
+			}
+		case opShort:
+			ins.length = 3
+			if need(3) {
+				ins.value = int(int16(u2(1)))
+			}
+		case opLocal, opConst1:
+			ins.length = 2
+			if need(2) {
+				ins.index = u1(1)
+			}
+		case opConst2:
+			ins.length = 3
+			if need(3) {
+				ins.index = u2(1)
+			}
+		case opIinc:
+			ins.length = 3
+			if need(3) {
+				ins.index = u1(1)
+				ins.value = int(int8(code[pc+2]))
+			}
+		case opBranch2:
+			ins.length = 3
+			if need(3) {
+				ins.target = pc + int(int16(u2(1)))
+			}
+		case opBranch4:
+			ins.length = 5
+			if need(5) {
+				ins.target = pc + s4(1)
+			}
+		case opNewarray:
+			ins.length = 2
+			if need(2) {
+				ins.value = u1(1)
+				if ins.value < 4 || ins.value > 11 {
+					return nil, codeError(pc, "newarray has a bad type "+strconv.Itoa(ins.value))
+				}
+			}
+		case opInterface:
+			ins.length = 5
+			if need(5) {
+				ins.index = u2(1)
+				ins.value = u1(3)
+				if ins.value == 0 || u1(4) != 0 {
+					return nil, codeError(pc, "invokeinterface needs a count and a zero")
+				}
+			}
+		case opDynamic:
+			ins.length = 5
+			if need(5) {
+				ins.index = u2(1)
+				if u2(3) != 0 {
+					return nil, codeError(pc, "invokedynamic needs 2 zeros")
+				}
+			}
+		case opMultiArray:
+			ins.length = 4
+			if need(4) {
+				ins.index = u2(1)
+				ins.value = u1(3)
+				if ins.value == 0 {
+					return nil, codeError(pc, "multianewarray needs at least 1 dimension")
+				}
+			}
+		case opTableSwitch, opLookupSwitch:
+			return decodeSwitch(code, ins)
+		case opWide:
+			ins.length = 2
+			if !need(2) {
+				break
+			}
+			ins.opcode = code[pc+1]
+			ins.wide = true
+			f := operandFormats[ins.opcode]
+			if f == opIinc {
+				ins.length = 6
+				if need(6) {
+					ins.index = u2(2)
+					ins.value = int(int16(u2(4)))
+				}
+			} else if f == opLocal {
+				ins.length = 4
+				if need(4) {
+					ins.index = u2(2)
+				}
+			} else {
+				return nil, codeError(pc, "wide can't be used with opcode "+strconv.Itoa(int(ins.opcode)))
+			}
+		default:
+			return nil, codeError(pc, "unknown opcode "+strconv.Itoa(int(op)))
+	}
+	if !need(ins.length) {
+		return nil, codeError(pc, mnemonics[op]+" runs past the end of the code")
+	}
+	return ins, nil
+}
+
+//tableswitch and lookupswitch are padded so the table starts on a multiple of 4
+func decodeSwitch(code []byte, ins *Instruction) (*Instruction, error) {
+	pc := ins.pc
+	p := pc + 1
+	for p % 4 != 0 {
+		p++
+	}
+	s4 := func(i int) int {
+		return int(int32(uint32(code[i])<<24 | uint32(code[i+1])<<16 | uint32(code[i+2])<<8 | uint32(code[i+3])))
+	}
+	short := codeError(pc, mnemonics[ins.opcode]+" runs past the end of the code")
+	if p+8 > len(code) {
+		return nil, short
+	}
+	ins.target = pc + s4(p)
+	if ins.opcode == 0xAA {
+		if p+12 > len(code) {
+			return nil, short
+		}
+		ins.low = s4(p+4)
+		high := s4(p+8)
+		if high < ins.low {
+			return nil, codeError(pc, "tableswitch has high < low")
+		}
+		n := high - ins.low + 1
+		p = p + 12
+		if n > (len(code)-p)/4 {
+			return nil, short
+		}
+		for k := 0; k < n; k++ {
+			ins.keys = append(ins.keys, ins.low+k)
+			ins.targets = append(ins.targets, pc+s4(p))
+			p = p + 4
+		}
+	} else {
+		n := s4(p+4)
+		p = p + 8
+		if n < 0 {
+			return nil, codeError(pc, "lookupswitch has a negative count")
+		}
+		if n > (len(code)-p)/8 {
+			return nil, short
+		}
+		for k := 0; k < n; k++ {
+			key := s4(p)
+			if k > 0 && key <= ins.keys[k-1] {
+				return nil, codeError(pc, "the keys of lookupswitch aren't sorted")
+			}
+			ins.keys = append(ins.keys, key)
+			ins.targets = append(ins.targets, pc+s4(p+4))
+			p = p + 8
+		}
+	}
+	ins.length = p - pc
+	return ins, nil
+}
+
+//decode all the code.  If there is a bad instruction, this returns the instructions before it
+//and the error
+func decodeCode(code []byte) ([]*Instruction, error) {
+	list := []*Instruction{}
+	pc := 0
+	for pc < len(code) {
+		ins, err := decodeInstruction(code, pc)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, ins)
+		pc = ins.next()
+	}
+	return list, nil
+}
+
+//==============================
+// Disassembler.  This prints the code of every method in about the same format
+// as javap -c -l, so the output can be diffed against the JDK tool
+
+//the element types of newarray
+var newarrayTypes = map[int]string {
+	4: "boolean", 5: "char", 6: "float", 7: "double",
+	8: "byte", 9: "short", 10: "int", 11: "long",
+}
+
+//turn the field descriptor starting at i into a java type, so [Ljava/lang/String; becomes java.lang.String[].
+//This returns the type and the index after it
+func javaType(desc string, i int) (string, int) {
+	dims := 0
+	for i < len(desc) && desc[i] == '[' {
+		dims++
+		i++
+	}
+	if i >= len(desc) {
+		return "?", i
+	}
+	t := "?"
+	switch (desc[i]) {
+		case 'B': t = "byte"
+		case 'C': t = "char"
+		case 'D': t = "double"
+		case 'F': t = "float"
+		case 'I': t = "int"
+		case 'J': t = "long"
+		case 'S': t = "short"
+		case 'Z': t = "boolean"
+		case 'V': t = "void"
+		case 'L':
+			end := strings.IndexByte(desc[i:], ';')
+			if end < 0 {
+				return desc[i:], len(desc)
+			}
+			t = strings.ReplaceAll(desc[i+1:i+end], "/", ".")
+			i = i + end
+	}
+	return t + strings.Repeat("[]", dims), i+1
+}
+
+//the name of the class in this class file, like java/lang/String
+func (cf *ClassFile) getClassName() string {
+	return cf.pool.getName(int(cf.this_class))
+}
+
+//the name from the SourceFile attribute, or "" if there isn't one
+func (cf *ClassFile) sourceFileName() string {
+	for _, attr := range cf.attribute_table.attributes {
+		sf, ok := attr.(*SourceFile_attribute)
+		if ok {
+			return cf.pool.getName(int(sf.sourcefile_index))
+		}
+	}
+	return ""
+}
+
+//return the Code attribute of a method, or nil if it doesn't have one,
+//which is the case for abstract and native methods
+func (m *MemberInfo) getCode() *Code_attribute {
+	for _, attr := range m.attribute_table.attributes {
+		ca, ok := attr.(*Code_attribute)
+		if ok {
+			return ca
+		}
+	}
+	return nil
+}
+
+//print the whole class
+func (cf *ClassFile) disassemble() {
+	sf := cf.sourceFileName()
+	if sf != "" {
+		fmt.Println("Compiled from \""+sf+"\"")
+	}
+	fmt.Println(cf.classHeader()+" {")
+	//javap puts a blank line between members
+	blocks := []string{}
+	for _, f := range cf.fields {
+		t := f.sig()
+		ft := f.fieldType()
+		if ft != nil {
+			t = ft.String()
+		}
+		blocks = append(blocks, "  "+modifiers(f.access_flags, fieldFlagNames)+t+" "+f.name()+";\n")
+	}
+	for _, m := range cf.methods {
+		var w strings.Builder
+		w.WriteString("  "+cf.methodHeader(m)+";\n")
+		ca := m.getCode()
+		if ca != nil {
+			cf.disassembleCode(&w, ca)
+		}
+		blocks = append(blocks, w.String())
+	}
+	fmt.Print(strings.Join(blocks, "\n"))
+	fmt.Println("}")
+}
+
+func (cf *ClassFile) classHeader() string {
+	this := strings.ReplaceAll(cf.getClassName(), "/", ".")
+	super := ""
+	if cf.super_class != 0 {
+		super = strings.ReplaceAll(cf.pool.getName(int(cf.super_class)), "/", ".")
+	}
+	ifaces := []string{}
+	for _, i := range cf.interfaces {
+		ifaces = append(ifaces, strings.ReplaceAll(cf.pool.getName(int(i)), "/", "."))
+	}
+	//a generic class has the type parameters and type arguments in its Signature
+	sig := cf.attribute_table.signature()
+	if sig != "" {
+		cs, err := parseClassSignature(sig)
+		if err == nil {
+			this = this + typeParamsString(cs.type_params)
+			super = cs.super.String()
+			ifaces = []string{}
+			for _, t := range cs.interfaces {
+				ifaces = append(ifaces, t.String())
+			}
+		}
+	}
+	if cf.access_flags & ACC_INTERFACE != 0 {
+		//interfaces are always abstract, so javap leaves that out
+		s := modifiers(cf.access_flags &^ ACC_ABSTRACT, classFlagNames)+"interface "+this
+		if len(ifaces) > 0 {
+			s = s + " extends "+strings.Join(ifaces, ",")
+		}
+		return s
+	}
+	s := modifiers(cf.access_flags, classFlagNames)+"class "+this
+	if super != "" && super != "java.lang.Object" {
+		s = s + " extends "+super
+	}
+	if len(ifaces) > 0 {
+		s = s + " implements "+strings.Join(ifaces, ",")
+	}
+	return s
+}
+
+func (cf *ClassFile) methodHeader(m *MemberInfo) string {
+	if m.name() == "<clinit>" {
+		return "static {}"
+	}
+	s := modifiers(m.access_flags, methodFlagNames)
+	ms := m.methodSignature()
+	if ms == nil {
+		//show the descriptor as it is if it can't be parsed
+		return s + m.name() + m.sig()
+	}
+	tps := typeParamsString(ms.type_params)
+	if tps != "" {
+		s = s + tps + " "
+	}
+	if m.name() == "<init>" {
+		//constructors are shown with the class name
+		s = s + strings.ReplaceAll(cf.getClassName(), "/", ".")
+	} else {
+		s = s + ms.ret.String() + " " + m.name()
+	}
+	s = s + "(" + typesString(ms.params) + ")"
+	//the throws in the Signature have the type arguments, if there are any
+	throws := typesString(ms.throws)
+	if throws == "" {
+		ex, ok := m.attribute_table.find("Exceptions").(*Exceptions_attribute)
+		if ok {
+			names := []string{}
+			for _, x := range ex.exception_index_table {
+				names = append(names, strings.ReplaceAll(cf.pool.getName(int(x)), "/", "."))
+			}
+			throws = strings.Join(names, ", ")
+		}
+	}
+	if throws != "" {
+		s = s + " throws " + throws
+	}
+	return s
+}
+
+func (cf *ClassFile) disassembleCode(w *strings.Builder, ca *Code_attribute) {
+	w.WriteString("    Code:\n")
+	list, err := decodeCode(ca.code)
+	for _, ins := range list {
+		text := cf.formatInstruction(ins)
+		//show the variable name for loads and stores
+		slot, store, ok := ins.localSlot()
+		if ok {
+			at := ins.pc
+			if store {
+				at = ins.next()
+			}
+			lv := ca.localVariable(slot, at)
+			if lv != nil {
+				text = fmt.Sprintf("%-33s // %s", text, lv.name)
+			}
+		}
+		fmt.Fprintf(w, "    %4d: %s\n", ins.pc, text)
+	}
+	if err != nil {
+		w.WriteString("    error: "+err.Error()+"\n")
+	}
+	if len(ca.exception_table) > 0 {
+		w.WriteString("      Exception table:\n")
+		w.WriteString("         from    to  target type\n")
+		for _, x := range ca.exception_table {
+			t := "any"
+			if x.catch_type != 0 {
+				t = "Class "+cf.pool.getName(int(x.catch_type))
+			}
+			fmt.Fprintf(w, "%14d%6d%6d   %s\n", x.start_pc, x.end_pc, x.handler_pc, t)
+		}
+	}
+	for _, attr := range ca.attribute_table.attributes {
+		lnt, ok := attr.(*LineNumberTable_attribute)
+		if ok {
+			w.WriteString("    LineNumberTable:\n")
+			for _, ln := range lnt.line_number_table {
+				fmt.Fprintf(w, "      line %d: %d\n", ln.line_number, ln.start_pc)
+			}
+		}
+	}
+	for _, aname := range []string{"LocalVariableTable", "LocalVariableTypeTable"} {
+		list := ca.variables(aname)
+		if len(list) > 0 {
+			w.WriteString("    "+aname+":\n")
+			w.WriteString("      Start  Length  Slot  Name   Signature\n")
+			for _, lv := range list {
+				fmt.Fprintf(w, "%11d%8d%6d%6s   %s\n", lv.start_pc, lv.length, lv.index, lv.name, lv.descriptor)
+			}
+		}
+	}
+}
+
+//the local variable slot used by a load, store or iinc.  store is true if the instruction
+//gives the variable its value, because then the variable is only in scope after it
+func (ins *Instruction) localSlot() (slot int, store bool, ok bool) {
+	op := int(ins.opcode)
+	switch {
+		case op >= 0x15 && op <= 0x19, op == 0x84:	//iload etc. and iinc
+			return ins.index, false, true
+		case op >= 0x1A && op <= 0x2D:	//iload_0 etc.
+			return (op-0x1A)%4, false, true
+		case op >= 0x36 && op <= 0x3A:	//istore etc.
+			return ins.index, true, true
+		case op >= 0x3B && op <= 0x4E:	//istore_0 etc.
+			return (op-0x3B)%4, true, true
+	}
+	return 0, false, false
+}
+
+//format one instruction the way javap does
+func (cf *ClassFile) formatInstruction(ins *Instruction) string {
+	mn := ins.mnemonic()
+	if ins.wide {
+		//wide changes the next instruction to use 2 byte indexes
+		if ins.opcode == 0x84 {
+			return fmt.Sprintf("%-13s %s %d, %d", "wide", mn, ins.index, ins.value)
+		}
+		return fmt.Sprintf("%-13s %s %d", "wide", mn, ins.index)
+	}
+	switch (operandFormats[ins.opcode]) {
+		case opByte, opShort:
+			return fmt.Sprintf("%-13s %d", mn, ins.value)
+		case opLocal:
+			return fmt.Sprintf("%-13s %d", mn, ins.index)
+		case opConst1, opConst2:
+			return cf.constantOperand(mn, ins.index, "")
+		case opIinc:
+			return fmt.Sprintf("%-13s %d, %d", mn, ins.index, ins.value)
+		case opBranch2, opBranch4:
+			return fmt.Sprintf("%-13s %d", mn, ins.target)
+		case opInterface, opMultiArray:
+			//invokeinterface has a count, and multianewarray has the dimensions
+			return cf.constantOperand(mn, ins.index, ",  "+strconv.Itoa(ins.value))
+		case opDynamic:
+			return cf.constantOperand(mn, ins.index, ",  0")
+		case opNewarray:
+			return fmt.Sprintf("%-13s %s", mn, newarrayTypes[ins.value])
+		case opTableSwitch, opLookupSwitch:
+			return formatSwitch(ins)
+	}
+	return mn
+}
+
+func formatSwitch(ins *Instruction) string {
+	var w strings.Builder
+	if ins.opcode == 0xAA {
+		fmt.Fprintf(&w, "%-13s { // %d to %d\n", ins.mnemonic(), ins.low, ins.low+len(ins.keys)-1)
+	} else {
+		fmt.Fprintf(&w, "%-13s { // %d\n", ins.mnemonic(), len(ins.keys))
+	}
+	for k, key := range ins.keys {
+		fmt.Fprintf(&w, "%24d: %d\n", key, ins.targets[k])
+	}
+	fmt.Fprintf(&w, "%24s: %d\n", "default", ins.target)
+	w.WriteString("          }")
+	return w.String()
+}
+
+//format an instruction with a constant pool index, and the constant as a comment
+func (cf *ClassFile) constantOperand(mn string, idx int, extra string) string {
+	operand := "#"+strconv.Itoa(idx)+extra
+	comment := cf.constantComment(idx)
+	if comment == "" {
+		return fmt.Sprintf("%-13s %s", mn, operand)
+	}
+	return fmt.Sprintf("%-13s %-19s // %s", mn, operand, comment)
+}
+
+//describe a constant the way javap does, like "Method java/io/PrintStream.println:(I)V"
+func (cf *ClassFile) constantComment(idx int) string {
+	pool := cf.pool
+	if idx < 1 || idx >= pool.size() || pool.constant_pool[idx] == nil {
+		return ""
+	}
+	k := pool.constant_pool[idx]
+	switch (k.ctype()) {
+		case CONSTANT_Class:
+			name := k.(*CONSTANT_String_info).cstr
+			if strings.HasPrefix(name, "[") {
+				return "class \""+name+"\""
+			}
+			return "class "+name
+		case CONSTANT_String:
+			return "String "+k.(*CONSTANT_String_info).cstr
+		case CONSTANT_Integer:
+			return "int "+strconv.Itoa(k.(*CONSTANT_Integer_info).ival)
+		case CONSTANT_Float:
+			return "float "+javaFloat(float64(k.(*CONSTANT_Float_info).fval), 32)+"f"
+		case CONSTANT_Long:
+			return "long "+strconv.FormatInt(k.(*CONSTANT_Long_info).lval, 10)+"l"
+		case CONSTANT_Double:
+			return "double "+javaFloat(k.(*CONSTANT_Double_info).dval, 64)+"d"
+		case CONSTANT_Fieldref:
+			r := k.(*CONSTANT_ref_info)
+			return "Field "+cf.memberRef(r.cname, r.name, r.descriptor)
+		case CONSTANT_Methodref:
+			r := k.(*CONSTANT_ref_info)
+			return "Method "+cf.memberRef(r.cname, r.name, r.descriptor)
+		case CONSTANT_InterfaceMethodref:
+			r := k.(*CONSTANT_ref_info)
+			return "InterfaceMethod "+cf.memberRef(r.cname, r.name, r.descriptor)
+		case CONSTANT_MethodType:
+			return "MethodType "+k.(*CONSTANT_MethodType_info).descriptor
+		case CONSTANT_MethodHandle:
+			mh := k.(*CONSTANT_MethodHandle_info)
+			return "MethodHandle "+strconv.Itoa(int(mh.reference_kind))+":"+cf.memberRef(mh.cname, mh.name, mh.descriptor)
+		case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+			dy := k.(*CONSTANT_Dynamic_info)
+			kind := "Dynamic "
+			if dy.tag == CONSTANT_InvokeDynamic {
+				kind = "InvokeDynamic "
+			}
+			return kind+"#"+strconv.Itoa(int(dy.bootstrap_method_attr_index))+":"+dy.name+":"+dy.descriptor
+	}
+	return ""
+}
+
+//javap leaves out the class name for members of this class, and quotes <init> and <clinit>
+func (cf *ClassFile) memberRef(cname string, name string, desc string) string {
+	if strings.HasPrefix(name, "<") {
+		name = "\""+name+"\""
+	}
+	if cname != cf.getClassName() {
+		name = cname+"."+name
+	}
+	return name+":"+desc
+}
+
+//format a float the way Java does, which always has a decimal point
+func javaFloat(v float64, bits int) string {
+	s := strconv.FormatFloat(v, 'g', -1, bits)
+	if !strings.ContainsAny(s, ".eEnI") {
+		s = s + ".0"
+	}
+	return s
+}
+
+//==============================
+// Export.  This turns the whole class file into a tree of objects, lists and plain values,
+// which can be written as JSON or YAML for scripts to read.  Objects keep their keys in the
+// order they were added, so the same class always gives the same output and it can be
+// compared against a saved copy
+
+//an object is a list of keys and values, written in order
+type exportObject []exportField
+
+type exportField struct {
+	key string;
+	value interface{};
+}
+
+func (o *exportObject) add(key string, value interface{}) {
+	*o = append(*o, exportField{key, value})
+}
+
+var constantTagNames = map[int]string {
+	CONSTANT_Utf8: "Utf8", CONSTANT_Integer: "Integer", CONSTANT_Float: "Float",
+	CONSTANT_Long: "Long", CONSTANT_Double: "Double", CONSTANT_Class: "Class",
+	CONSTANT_String: "String", CONSTANT_Fieldref: "Fieldref", CONSTANT_Methodref: "Methodref",
+	CONSTANT_InterfaceMethodref: "InterfaceMethodref", CONSTANT_NameAndType: "NameAndType",
+	CONSTANT_MethodHandle: "MethodHandle", CONSTANT_MethodType: "MethodType",
+	CONSTANT_Dynamic: "Dynamic", CONSTANT_InvokeDynamic: "InvokeDynamic",
+	CONSTANT_Module: "Module", CONSTANT_Package: "Package",
+}
+
+//JSON has no NaN or infinity, so those are written as strings
+func exportFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
+
+//a name from the pool, or nil for index 0
+func (cf *ClassFile) exportName(idx uint16) interface{} {
+	if idx == 0 {
+		return nil
+	}
+	return cf.pool.getName(int(idx))
+}
+
+func (cf *ClassFile) exportNames(list []uint16) []interface{} {
+	names := []interface{}{}
+	for _, x := range list {
+		names = append(names, cf.pool.getName(int(x)))
+	}
+	return names
+}
+
+//the value of a loadable constant, as a number or a string.  The loader checks the index, but the
+//attribute could have been changed since
+func (cf *ClassFile) exportValue(idx uint16) (interface{}, error) {
+	k := cf.pool.getConstant(int(idx))
+	switch v := k.(type) {
+		case nil: return nil, fmt.Errorf("%w: #%d (pool count is %d)", ErrBadIndex, idx, cf.pool.size())
+		case *CONSTANT_Integer_info: return v.ival, nil
+		case *CONSTANT_Float_info: return exportFloat(float64(v.fval)), nil
+		case *CONSTANT_Long_info: return v.lval, nil
+		case *CONSTANT_Double_info: return exportFloat(v.dval), nil
+	}
+	return cf.constantComment(int(idx)), nil
+}
+
+func (cf *ClassFile) exportConstant(i int, k CP_Info) exportObject {
+	o := exportObject{}
+	o.add("index", i)
+	o.add("tag", constantTagNames[int(k.ctype())])
+	switch v := k.(type) {
+		case *CONSTANT_Utf8_info:
+			o.add("value", v.utf8)
+		case *CONSTANT_Integer_info:
+			o.add("value", v.ival)
+		case *CONSTANT_Float_info:
+			o.add("value", exportFloat(float64(v.fval)))
+		case *CONSTANT_Long_info:
+			o.add("value", v.lval)
+		case *CONSTANT_Double_info:
+			o.add("value", exportFloat(v.dval))
+		case *CONSTANT_String_info:
+			o.add("name_index", int(v.name_index))
+			o.add("value", v.cstr)
+		case *CONSTANT_ref_info:
+			o.add("class_index", int(v.class_index))
+			o.add("name_and_type_index", int(v.name_and_type_index))
+			o.add("class", v.cname)
+			o.add("name", v.name)
+			o.add("descriptor", v.descriptor)
+		case *CONSTANT_NameAndType_info:
+			o.add("name_index", int(v.name_index))
+			o.add("descriptor_index", int(v.descriptor_index))
+			o.add("name", v.name)
+			o.add("descriptor", v.descriptor)
+		case *CONSTANT_MethodHandle_info:
+			o.add("reference_kind", int(v.reference_kind))
+			o.add("reference_index", int(v.reference_index))
+			o.add("class", v.cname)
+			o.add("name", v.name)
+			o.add("descriptor", v.descriptor)
+		case *CONSTANT_MethodType_info:
+			o.add("descriptor_index", int(v.descriptor_index))
+			o.add("descriptor", v.descriptor)
+		case *CONSTANT_Dynamic_info:
+			o.add("bootstrap_method_attr_index", int(v.bootstrap_method_attr_index))
+			o.add("name_and_type_index", int(v.name_and_type_index))
+			o.add("name", v.name)
+			o.add("descriptor", v.descriptor)
+		case *CONSTANT_Module_info:
+			o.add("name_index", int(v.name_index))
+			o.add("name", v.name)
+	}
+	return o
+}
+
+func (cf *ClassFile) exportMember(m *MemberInfo, modifiers string) (exportObject, error) {
+	o := exportObject{}
+	o.add("name", m.name())
+	o.add("descriptor", m.sig())
+	o.add("access_flags", int(m.access_flags))
+	o.add("modifiers", modifiers)
+	attrs, err := cf.exportAttributes(m.attribute_table)
+	o.add("attributes", attrs)
+	return o, err
+}
+
+func (cf *ClassFile) exportAttributes(atab *AttributeTable) ([]interface{}, error) {
+	list := []interface{}{}
+	if atab == nil {
+		return list, nil
+	}
+	for _, attr := range atab.attributes {
+		a, err := cf.exportAttribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+func (cf *ClassFile) exportAnnotations(list []*annotation) []interface{} {
+	s := []interface{}{}
+	for _, a := range list {
+		s = append(s, a.format(cf.pool))
+	}
+	return s
+}
+
+//the name and length of an attribute, and then what it holds
+func (cf *ClassFile) exportAttribute(attr AttributeInfo) (exportObject, error) {
+	o := exportObject{}
+	o.add("name", attr.attribute_name())
+	o.add("length", int(attr.attribute_length()))
+	switch a := attr.(type) {
+		case *ConstantValue_attribute:
+			v, err := cf.exportValue(a.cp_index)
+			if err != nil {
+				return nil, err
+			}
+			o.add("value", v)
+		case *Code_attribute:
+			o.add("max_stack", int(a.max_stack))
+			o.add("max_locals", int(a.max_locals))
+			o.add("code_length", len(a.code))
+			o.add("code", cf.exportCode(a.code))
+			ex := []interface{}{}
+			for _, x := range a.exception_table {
+				e := exportObject{}
+				e.add("start_pc", int(x.start_pc))
+				e.add("end_pc", int(x.end_pc))
+				e.add("handler_pc", int(x.handler_pc))
+				e.add("catch_type", cf.exportName(x.catch_type))
+				ex = append(ex, e)
+			}
+			o.add("exception_table", ex)
+			attrs, err := cf.exportAttributes(a.attribute_table)
+			if err != nil {
+				return nil, err
+			}
+			o.add("attributes", attrs)
+		case *Exceptions_attribute:
+			o.add("exceptions", cf.exportNames(a.exception_index_table))
+		case *LineNumberTable_attribute:
+			lines := []interface{}{}
+			for _, ln := range a.line_number_table {
+				l := exportObject{}
+				l.add("start_pc", int(ln.start_pc))
+				l.add("line_number", int(ln.line_number))
+				lines = append(lines, l)
+			}
+			o.add("line_numbers", lines)
+		case *LocalVariableTable_attribute:
+			//the descriptor is a signature in a LocalVariableTypeTable
+			dname := "descriptor"
+			if a.aname == "LocalVariableTypeTable" {
+				dname = "signature"
+			}
+			vars := []interface{}{}
+			for _, lv := range a.local_variable_table {
+				v := exportObject{}
+				v.add("start_pc", int(lv.start_pc))
+				v.add("length", int(lv.length))
+				v.add("name", lv.name)
+				v.add(dname, lv.descriptor)
+				v.add("index", int(lv.index))
+				vars = append(vars, v)
+			}
+			o.add("local_variables", vars)
+		case *SourceFile_attribute:
+			o.add("sourcefile", cf.pool.getName(int(a.sourcefile_index)))
+		case *SourceDebugExtension_attribute:
+			o.add("debug_extension", string(a.debug_extension))
+		case *Signature_attribute:
+			o.add("signature", cf.pool.getName(int(a.signature_index)))
+		case *InnerClasses_attribute:
+			classes := []interface{}{}
+			for _, ic := range a.classes {
+				c := exportObject{}
+				c.add("inner_class", cf.exportName(ic.inner_class_info_index))
+				c.add("outer_class", cf.exportName(ic.outer_class_info_index))
+				c.add("inner_name", cf.exportName(ic.inner_name_index))
+				c.add("access_flags", int(ic.inner_class_access_flags))
+				c.add("modifiers", InnerClassFlags(ic.inner_class_access_flags).String())
+				classes = append(classes, c)
+			}
+			o.add("classes", classes)
+		case *EnclosingMethod_attribute:
+			o.add("class", cf.exportName(a.class_index))
+			var method interface{}
+			if a.method_index != 0 {
+				//the loader checks that this is a NameAndType, but the attribute could have been changed since
+				if nat, ok := cf.pool.getConstant(int(a.method_index)).(*CONSTANT_NameAndType_info); ok {
+					method = nat.name+nat.descriptor
+				} else {
+					method = cf.constantComment(int(a.method_index))
+				}
+			}
+			o.add("method", method)
+		case *Annotations_attribute:
+			o.add("annotations", cf.exportAnnotations(a.annotations))
+		case *ParameterAnnotations_attribute:
+			params := []interface{}{}
+			for _, pa := range a.parameter_annotations {
+				params = append(params, cf.exportAnnotations(pa))
+			}
+			o.add("parameters", params)
+		case *AnnotationDefault_attribute:
+			o.add("default_value", a.default_value.format(cf.pool))
+		case *BootstrapMethods_attribute:
+			bms := []interface{}{}
+			for _, bm := range a.bootstrap_methods {
+				b := exportObject{}
+				b.add("method_handle", cf.constantComment(int(bm.bootstrap_method_ref)))
+				args := []interface{}{}
+				for _, x := range bm.bootstrap_arguments {
+					v, err := cf.exportValue(x)
+					if err != nil {
+						return nil, err
+					}
+					args = append(args, v)
+				}
+				b.add("arguments", args)
+				bms = append(bms, b)
+			}
+			o.add("bootstrap_methods", bms)
+		case *ClassIndex_attribute:
+			o.add("class", cf.pool.getName(int(a.class_index)))
+		case *IndexList_attribute:
+			o.add("entries", cf.exportNames(a.entries))
+		case *Record_attribute:
+			comps := []interface{}{}
+			for _, rc := range a.components {
+				c := exportObject{}
+				c.add("name", rc.name)
+				c.add("descriptor", rc.descriptor)
+				attrs, err := cf.exportAttributes(rc.attribute_table)
+				if err != nil {
+					return nil, err
+				}
+				c.add("attributes", attrs)
+				comps = append(comps, c)
+			}
+			o.add("components", comps)
+		case *MethodParameters_attribute:
+			params := []interface{}{}
+			for _, mp := range a.parameters {
+				p := exportObject{}
+				p.add("name", cf.exportName(mp.name_index))
+				p.add("access_flags", int(mp.access_flags))
+				params = append(params, p)
+			}
+			o.add("parameters", params)
+		case *Module_attribute:
+			cf.exportModule(&o, a)
+		case *Generic_attribute:
+			o.add("bytes", fmt.Sprintf("%x", a.garbage))
+	}
+	return o, nil
+}
+
+func (cf *ClassFile) exportModule(o *exportObject, mo *Module_attribute) {
+	o.add("module", cf.pool.getName(int(mo.module_name_index)))
+	o.add("module_flags", int(mo.module_flags))
+	o.add("version", cf.exportName(mo.module_version_index))
+	requires := []interface{}{}
+	for _, r := range mo.requires {
+		x := exportObject{}
+		x.add("module", cf.pool.getName(int(r.requires_index)))
+		x.add("flags", int(r.requires_flags))
+		x.add("version", cf.exportName(r.requires_version_index))
+		requires = append(requires, x)
+	}
+	o.add("requires", requires)
+	for _, key := range []string{"exports", "opens"} {
+		list := mo.exports
+		if key == "opens" {
+			list = mo.opens
+		}
+		out := []interface{}{}
+		for _, e := range list {
+			x := exportObject{}
+			x.add("package", cf.pool.getName(int(e.exports_index)))
+			x.add("flags", int(e.exports_flags))
+			x.add("to", cf.exportNames(e.exports_to_index))
+			out = append(out, x)
+		}
+		o.add(key, out)
+	}
+	o.add("uses", cf.exportNames(mo.uses_index))
+	provides := []interface{}{}
+	for _, p := range mo.provides {
+		x := exportObject{}
+		x.add("service", cf.pool.getName(int(p.provides_index)))
+		x.add("with", cf.exportNames(p.provides_with_index))
+		provides = append(provides, x)
+	}
+	o.add("provides", provides)
+}
+
+//each instruction with its offset, opcode and the text the disassembler shows
+func (cf *ClassFile) exportCode(code []byte) []interface{} {
+	list := []interface{}{}
+	insns, err := decodeCode(code)
+	for _, ins := range insns {
+		x := exportObject{}
+		x.add("pc", ins.pc)
+		x.add("opcode", int(code[ins.pc]))
+		x.add("text", cf.formatInstruction(ins))
+		list = append(list, x)
+	}
+	if err != nil {
+		x := exportObject{}
+		x.add("pc", err.(*CodeError).PC)
+		x.add("error", err.Error())
+		list = append(list, x)
+	}
+	return list
+}
+
+//the whole class file
+func (cf *ClassFile) export() (exportObject, error) {
+	o := exportObject{}
+	o.add("magic", fmt.Sprintf("0x%08X", cf.magic))
+	o.add("minor_version", int(cf.minor_version))
+	o.add("major_version", int(cf.major_version))
+	pool := []interface{}{}
+	for i := 1; i < cf.pool.size(); i++ {
+		//the slot after a long or double is empty
+		k := cf.pool.constant_pool[i]
+		if k != nil {
+			pool = append(pool, cf.exportConstant(i, k))
+		}
+	}
+	o.add("constant_pool", pool)
+	o.add("access_flags", int(cf.access_flags))
+	o.add("modifiers", cf.flags().String())
+	o.add("this_class", cf.getClassName())
+	o.add("super_class", cf.exportName(cf.super_class))
+	o.add("interfaces", cf.exportNames(cf.interfaces))
+	fields := []interface{}{}
+	for _, f := range cf.fields {
+		fo, err := cf.exportMember(f, f.fieldFlags().String())
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fo)
+	}
+	o.add("fields", fields)
+	methods := []interface{}{}
+	for _, m := range cf.methods {
+		mo, err := cf.exportMember(m, m.methodFlags().String())
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, mo)
+	}
+	o.add("methods", methods)
+	attrs, err := cf.exportAttributes(cf.attribute_table)
+	o.add("attributes", attrs)
+	return o, err
+}
+
+//quote a string for JSON.  YAML accepts the same thing as a double quoted string
+func jsonQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+			case r == '"': b.WriteString("\\\"")
+			case r == '\\': b.WriteString("\\\\")
+			case r == '\n': b.WriteString("\\n")
+			case r == '\r': b.WriteString("\\r")
+			case r == '\t': b.WriteString("\\t")
+			case r < 0x20 || r == 0x7F: fmt.Fprintf(&b, "\\u%04x", r)
+			default: b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+//a value that isn't an object or a list
+func exportScalar(v interface{}) string {
+	switch x := v.(type) {
+		case nil: return "null"
+		case string: return jsonQuote(x)
+		case bool: return strconv.FormatBool(x)
+		case int: return strconv.Itoa(x)
+		case int64: return strconv.FormatInt(x, 10)
+		case float64: return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return jsonQuote(fmt.Sprint(v))
+}
+
+//write the value as JSON, indented by 2 spaces at each level
+func writeJSON(w *strings.Builder, v interface{}, indent string) {
+	switch x := v.(type) {
+		case exportObject:
+			if len(x) == 0 {
+				w.WriteString("{}")
+				return
+			}
+			w.WriteString("{\n")
+			for i, f := range x {
+				w.WriteString(indent+"  "+jsonQuote(f.key)+": ")
+				writeJSON(w, f.value, indent+"  ")
+				if i < len(x)-1 {
+					w.WriteString(",")
+				}
+				w.WriteString("\n")
+			}
+			w.WriteString(indent+"}")
+		case []interface{}:
+			if len(x) == 0 {
+				w.WriteString("[]")
+				return
+			}
+			w.WriteString("[\n")
+			for i, item := range x {
+				w.WriteString(indent+"  ")
+				writeJSON(w, item, indent+"  ")
+				if i < len(x)-1 {
+					w.WriteString(",")
+				}
+				w.WriteString("\n")
+			}
+			w.WriteString(indent+"]")
+		default:
+			w.WriteString(exportScalar(v))
+	}
+}
+
+//write the value as YAML, in block style.  Empty objects and lists use the flow style {} and []
+func writeYAML(w *strings.Builder, v interface{}, indent string) {
+	switch x := v.(type) {
+		case exportObject:
+			for _, f := range x {
+				w.WriteString(indent+f.key+":")
+				writeYAMLValue(w, f.value, indent+"  ")
+			}
+		case []interface{}:
+			for _, item := range x {
+				if exportEmpty(item) {
+					w.WriteString(indent+"-")
+					writeYAMLValue(w, item, indent+"  ")
+					continue
+				}
+				//the first line of the item goes after the dash
+				var b strings.Builder
+				writeYAML(&b, item, indent+"  ")
+				w.WriteString(indent+"- "+strings.TrimPrefix(b.String(), indent+"  "))
+			}
+		default:
+			w.WriteString(indent+exportScalar(v)+"\n")
+	}
+}
+
+//is this an empty object or list
+func exportEmpty(v interface{}) bool {
+	switch x := v.(type) {
+		case exportObject: return len(x) == 0
+		case []interface{}: return len(x) == 0
+	}
+	return false
+}
+
+//the value of a key, either on the same line or in a block below it
+func writeYAMLValue(w *strings.Builder, v interface{}, indent string) {
+	switch x := v.(type) {
+		case exportObject:
+			if len(x) == 0 {
+				w.WriteString(" {}\n")
+				return
+			}
+		case []interface{}:
+			if len(x) == 0 {
+				w.WriteString(" []\n")
+				return
+			}
+		default:
+			w.WriteString(" "+exportScalar(v)+"\n")
+			return
+	}
+	w.WriteString("\n")
+	writeYAML(w, v, indent)
+}
+
+func (cf *ClassFile) JSON() (string, error) {
+	o, err := cf.export()
+	if err != nil {
+		return "", err
+	}
+	var w strings.Builder
+	writeJSON(&w, o, "")
+	w.WriteString("\n")
+	return w.String(), nil
+}
+
+func (cf *ClassFile) YAML() (string, error) {
+	o, err := cf.export()
+	if err != nil {
+		return "", err
+	}
+	var w strings.Builder
+	writeYAML(&w, o, "")
+	return w.String(), nil
+}
+
+//==============================
+// Analyzer.  This loads every class on a class path, and builds the class hierarchy and a
+// graph of which methods call which methods and use which fields.  It can answer who calls
+// a method, find private methods that nothing uses, and write the graphs for Graphviz
+
+//a use of a method or field by some code.  Members are written as class.name:descriptor
+type Reference struct {
+	from string;		//the method with the code, or just the class for a method handle
+	to string;			//the method or field that is used
+	kind string;		//the instruction, like invokevirtual, or MethodHandle
+	pc int;
+}
+
+type ClassSet struct {
+	classes map[string]*ClassFile;
+	names []string;						//sorted
+	subclasses map[string][]string;		//the direct subclasses of each class
+	implementors map[string][]string;	//the classes that directly implement each interface
+	refs []*Reference;
+	//the classes that couldn't be loaded, and why
+	bad map[string]error;
+}
+
+func memberKey(cname string, name string, desc string) string {
+	return cname+"."+name+":"+desc
+}
+
+//split a member key back into the class, the name and the descriptor
+func splitMemberKey(key string) (string, string, string) {
+	rest, desc, _ := strings.Cut(key, ":")
+	dot := strings.LastIndexByte(rest, '.')
+	if dot < 0 {
+		return "", rest, desc
+	}
+	return rest[:dot], rest[dot+1:], desc
+}
+
+//load every class on the class path.  If the same class is in more than one place, the
+//first one wins, as it does for the JVM
+func NewClassSet(cp *ClassPath) (*ClassSet, error) {
+	cs := &ClassSet {
+		classes: map[string]*ClassFile{},
+		subclasses: map[string][]string{},
+		implementors: map[string][]string{},
+		bad: map[string]error{},
+	}
+	for _, e := range cp.entries {
+		names, err := e.list()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if cs.classes[name] != nil || cs.bad[name] != nil {
+				continue
+			}
+			cf, err := cp.LoadClass(name)
+			if err != nil {
+				cs.bad[name] = err
+				continue
+			}
+			cs.classes[name] = cf
+			cs.names = append(cs.names, name)
+		}
+	}
+	sort.Strings(cs.names)
+	for _, name := range cs.names {
+		cs.addClass(cs.classes[name])
+	}
+	return cs, nil
+}
+
+func (cs *ClassSet) addClass(cf *ClassFile) {
+	name := cf.getClassName()
+	if cf.super_class != 0 {
+		super := cf.pool.getName(int(cf.super_class))
+		cs.subclasses[super] = append(cs.subclasses[super], name)
+	}
+	for _, i := range cf.interfaces {
+		iname := cf.pool.getName(int(i))
+		cs.implementors[iname] = append(cs.implementors[iname], name)
+	}
+	for _, m := range cf.methods {
+		ca := m.getCode()
+		if ca != nil {
+			cs.addCode(cf, memberKey(name, m.name(), m.sig()), ca.code)
+		}
+	}
+	//lambdas and method references are only used through method handles in the pool
+	for i := 1; i < cf.pool.size(); i++ {
+		mh, ok := cf.pool.constant_pool[i].(*CONSTANT_MethodHandle_info)
+		if ok {
+			cs.refs = append(cs.refs, &Reference{from: name, to: memberKey(mh.cname, mh.name, mh.descriptor), kind: "MethodHandle", pc: -1})
+		}
+	}
+}
+
+//find the field and method refs in the code
+func (cs *ClassSet) addCode(cf *ClassFile, from string, code []byte) {
+	//a bad instruction ends the code, but the refs before it are still kept
+	insns, _ := decodeCode(code)
+	for _, ins := range insns {
+		op := int(ins.opcode)
+		//getstatic, putstatic, getfield, putfield and the invokes, except invokedynamic
+		if op >= 0xB2 && op <= 0xB9 {
+			r, ok := cf.pool.getConstant(ins.index).(*CONSTANT_ref_info)
+			if ok {
+				cs.refs = append(cs.refs, &Reference{from: from, to: memberKey(r.cname, r.name, r.descriptor), kind: ins.mnemonic(), pc: ins.pc})
+			}
+		}
+	}
+}
+
+//the superclass and the interfaces of a class, if the class is in the set
+func (cs *ClassSet) supertypes(name string) []string {
+	cf := cs.classes[name]
+	if cf == nil {
+		return nil
+	}
+	list := []string{}
+	if cf.super_class != 0 {
+		list = append(list, cf.pool.getName(int(cf.super_class)))
+	}
+	for _, i := range cf.interfaces {
+		list = append(list, cf.pool.getName(int(i)))
+	}
+	return list
+}
+
+//is sub the same as super, or does it extend or implement it, directly or not
+func (cs *ClassSet) isSubtype(sub string, super string) bool {
+	if sub == super {
+		return true
+	}
+	for _, s := range cs.supertypes(sub) {
+		if cs.isSubtype(s, super) {
+			return true
+		}
+	}
+	return false
+}
+
+//the field or method with this name and descriptor that the class declares, or nil
+func (cs *ClassSet) member(cname string, name string, desc string) *MemberInfo {
+	cf := cs.classes[cname]
+	if cf == nil {
+		return nil
+	}
+	for _, list := range [][]*MemberInfo{cf.methods, cf.fields} {
+		for _, m := range list {
+			if m.name() == name && m.sig() == desc {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+//the class that declares the member a reference names.  This is the class in the reference,
+//or the first of its supertypes with the member, or "" if none of them in the set have it
+func (cs *ClassSet) declaringClass(cname string, name string, desc string) string {
+	if cs.member(cname, name, desc) != nil {
+		return cname
+	}
+	for _, s := range cs.supertypes(cname) {
+		d := cs.declaringClass(s, name, desc)
+		if d != "" {
+			return d
+		}
+	}
+	return ""
+}
+
+//can a call through class rclass run the method in tclass that overrides it?  Only invokevirtual
+//and invokeinterface choose the method by the class of the object, and static and private methods
+//and constructors aren't overridden
+func (cs *ClassSet) dispatchesTo(r *Reference, rclass string, tclass string, name string, desc string) bool {
+	if r.kind != "invokevirtual" && r.kind != "invokeinterface" {
+		return false
+	}
+	m := cs.member(tclass, name, desc)
+	if m == nil || name == "<init>" || m.methodFlags().has(ACC_STATIC) || m.methodFlags().has(ACC_PRIVATE) {
+		return false
+	}
+	return cs.isSubtype(tclass, rclass)
+}
+
+//the references that may reach the member.  A reference through a subclass reaches the
+//member that it inherits, and a call to a method of a superclass or interface may run an
+//override in a subclass.  The target can leave out the descriptor to match all the overloads
+func (cs *ClassSet) Callers(target string) []*Reference {
+	tclass, tname, tdesc := splitMemberKey(target)
+	list := []*Reference{}
+	for _, r := range cs.refs {
+		rclass, rname, rdesc := splitMemberKey(r.to)
+		if rname != tname || (tdesc != "" && rdesc != tdesc) {
+			continue
+		}
+		d := cs.declaringClass(rclass, rname, rdesc)
+		if d != "" {
+			rclass = d
+		}
+		if rclass == tclass || cs.dispatchesTo(r, rclass, tclass, rname, rdesc) {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+//the private methods that no code or method handle uses.  The static initializer is
+//run by the JVM, so it is never unused
+func (cs *ClassSet) UnusedPrivateMethods() []string {
+	used := map[string]bool{}
+	for _, r := range cs.refs {
+		used[r.to] = true
+	}
+	list := []string{}
+	for _, name := range cs.names {
+		for _, m := range cs.classes[name].methods {
+			key := memberKey(name, m.name(), m.sig())
+			if m.methodFlags().has(ACC_PRIVATE) && m.name() != "<clinit>" && !used[key] {
+				list = append(list, key)
+			}
+		}
+	}
+	return list
+}
+
+//print the classes as a tree, starting from the classes whose superclass isn't in the set.
+//Interfaces are shown in brackets after the class
+func (cs *ClassSet) printTree() {
+	roots := []string{}
+	for _, name := range cs.names {
+		cf := cs.classes[name]
+		if cf.super_class == 0 || cs.classes[cf.pool.getName(int(cf.super_class))] == nil {
+			roots = append(roots, name)
+		}
+	}
+	for _, name := range roots {
+		cs.printSubtree(name, "")
+	}
+}
+
+func (cs *ClassSet) printSubtree(name string, indent string) {
+	s := indent+name
+	cf := cs.classes[name]
+	if cf.flags().has(ACC_INTERFACE) {
+		//the superclass of an interface is always java/lang/Object
+		s = indent+"interface "+name
+	} else if cf.super_class != 0 && indent == "" {
+		s = s + " extends "+cf.pool.getName(int(cf.super_class))
+	}
+	if len(cf.interfaces) > 0 {
+		s = s + " [" + strings.Join(cs.supertypes(name)[1:], ", ") + "]"
+	}
+	fmt.Println(s)
+	subs := append([]string{}, cs.subclasses[name]...)
+	sort.Strings(subs)
+	for _, sub := range subs {
+		cs.printSubtree(sub, indent+"    ")
+	}
+}
+
+//the hierarchy in the DOT language.  Classes that aren't in the set are drawn dashed
+func (cs *ClassSet) HierarchyDOT() string {
+	var w strings.Builder
+	w.WriteString("digraph hierarchy {\n")
+	w.WriteString("  rankdir=BT;\n")
+	w.WriteString("  node [shape=box];\n")
+	outside := map[string]bool{}
+	for _, name := range cs.names {
+		cf := cs.classes[name]
+		shape := ""
+		if cf.flags().has(ACC_INTERFACE) {
+			shape = " [style=rounded]"
+		}
+		fmt.Fprintf(&w, "  %s%s;\n", jsonQuote(name), shape)
+		for i, s := range cs.supertypes(name) {
+			if i == 0 && shape != "" {
+				//the superclass of an interface is always java/lang/Object
+				continue
+			}
+			if cs.classes[s] == nil {
+				outside[s] = true
+			}
+			//the first one is the superclass unless this is java/lang/Object
+			style := " [arrowhead=empty, style=dashed]"
+			if i == 0 && cf.super_class != 0 {
+				style = " [arrowhead=empty]"
+			}
+			fmt.Fprintf(&w, "  %s -> %s%s;\n", jsonQuote(name), jsonQuote(s), style)
+		}
+	}
+	ext := []string{}
+	for s := range outside {
+		ext = append(ext, s)
+	}
+	sort.Strings(ext)
+	for _, s := range ext {
+		fmt.Fprintf(&w, "  %s [style=dashed];\n", jsonQuote(s))
+	}
+	w.WriteString("}\n")
+	return w.String()
+}
+
+//the method calls in the DOT language, with the methods grouped by class.  Field uses
+//are left out
+func (cs *ClassSet) CallGraphDOT() string {
+	var w strings.Builder
+	w.WriteString("digraph calls {\n")
+	w.WriteString("  node [shape=box];\n")
+	for i, name := range cs.names {
+		fmt.Fprintf(&w, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&w, "    label=%s;\n", jsonQuote(name))
+		for _, m := range cs.classes[name].methods {
+			fmt.Fprintf(&w, "    %s;\n", jsonQuote(memberKey(name, m.name(), m.sig())))
+		}
+		w.WriteString("  }\n")
+	}
+	seen := map[string]bool{}
+	for _, r := range cs.refs {
+		if !strings.HasPrefix(r.kind, "invoke") || seen[r.from+" "+r.to] {
+			continue
+		}
+		seen[r.from+" "+r.to] = true
+		fmt.Fprintf(&w, "  %s -> %s;\n", jsonQuote(r.from), jsonQuote(r.to))
+	}
+	w.WriteString("}\n")
+	return w.String()
+}
+
+//the -analyze command.  args are the class path, the command and its argument
+func analyze(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: -analyze classpath tree|callers member|unused|dot-hierarchy|dot-calls")
+	}
+	cp, err := NewClassPath(args[0])
+	if err != nil {
+		return err
+	}
+	defer cp.Close()
+	cs, err := NewClassSet(cp)
+	if err != nil {
+		return err
+	}
+	for name, e := range cs.bad {
+		fmt.Fprintln(os.Stderr, "skipped "+name+": "+e.Error())
+	}
+	switch (args[1]) {
+		case "tree":
+			cs.printTree()
+		case "callers":
+			if len(args) < 3 {
+				return errors.New("callers needs a member, like pkg/Cls.name or pkg/Cls.name:(I)V")
+			}
+			for _, r := range cs.Callers(args[2]) {
+				at := ""
+				if r.pc >= 0 {
+					at = " at pc "+strconv.Itoa(r.pc)
+				}
+				fmt.Println(r.from+at+": "+r.kind+" "+r.to)
+			}
+		case "unused":
+			for _, key := range cs.UnusedPrivateMethods() {
+				fmt.Println(key)
+			}
+		case "dot-hierarchy":
+			fmt.Print(cs.HierarchyDOT())
+		case "dot-calls":
+			fmt.Print(cs.CallGraphDOT())
+		default:
+			return errors.New("unknown command "+args[1])
+	}
+	return nil
+}
+
+//==============================
+// Control flow graph.  This splits the code of a method into basic blocks, which are runs of
+// instructions that always run from the first to the last, and links the blocks with an edge
+// for each way of getting from one to another.  From the edges it works out the dominators of
+// each block, the loop headers, and the blocks that can never run
+
+//a run of instructions with a branch in only at the start and a branch out only at the end
+type BasicBlock struct {
+	id int;
+	start int;			//the pc of the first instruction
+	end int;			//the pc after the last instruction
+	insns []*Instruction;
+	succs []*CFGEdge;
+	preds []*CFGEdge;
+	reachable bool;		//from the start of the method
+	idom *BasicBlock;	//the immediate dominator, or nil for the entry and for blocks that can't be reached
+	loopHeader bool;	//the target of a back edge
+	order int;			//the reverse postorder, used to work out the dominators
+}
+
+//kind is fall, branch, switch, jsr or exception
+type CFGEdge struct {
+	from *BasicBlock;
+	to *BasicBlock;
+	kind string;
+}
+
+type CFG struct {
+	blocks []*BasicBlock;		//in order of pc, so the first one is the entry
+	byPC map[int]*BasicBlock;	//the block that starts at each pc
+}
+
+//the pcs a branch or switch can go to.  jsr is included, but ret isn't, because
+//where it goes depends on the jsr that called the subroutine
+func (ins *Instruction) branchTargets() []int {
+	switch (operandFormats[ins.opcode]) {
+		case opBranch2, opBranch4:
+			return []int{ins.target}
+		case opTableSwitch, opLookupSwitch:
+			return append([]int{ins.target}, ins.targets...)
+	}
+	return nil
+}
+
+//the next instruction can run after this one.  For jsr this is where the subroutine returns to
+func (ins *Instruction) fallsThrough() bool {
+	op := ins.opcode
+	switch {
+		case op == 0xA7, op == 0xC8:	//goto, goto_w
+			return false
+		case op == 0xA9, op == 0xAA, op == 0xAB:	//ret and the switches
+			return false
+		case op >= 0xAC && op <= 0xB1, op == 0xBF:	//the returns and athrow
+			return false
+	}
+	return true
+}
+
+//split the code into basic blocks and link them.  The code must decode, and every branch
+//and exception handler must go to the start of an instruction
+func NewCFG(ca *Code_attribute) (*CFG, error) {
+	g := &CFG{byPC: make(map[int]*BasicBlock)}
+	insns, err := decodeCode(ca.code)
+	if err != nil {
+		return nil, err
+	}
+	if len(insns) == 0 {
+		return g, nil
+	}
+	starts := map[int]bool{}
+	for _, ins := range insns {
+		starts[ins.pc] = true
+	}
+	//a block starts at every branch target and after every branch
+	leaders := map[int]bool{0: true}
+	for _, ins := range insns {
+		targets := ins.branchTargets()
+		for _, t := range targets {
+			if !starts[t] {
+				return nil, codeError(ins.pc, "branch to "+strconv.Itoa(t)+", which isn't the start of an instruction")
+			}
+			leaders[t] = true
+		}
+		if len(targets) > 0 || !ins.fallsThrough() {
+			leaders[ins.next()] = true
+		}
+	}
+	//and at the start and end of every try block and at every handler, so a block
+	//is either all in a try block or all out of it
+	for _, x := range ca.exception_table {
+		for _, pc := range []int{int(x.start_pc), int(x.end_pc), int(x.handler_pc)} {
+			if !starts[pc] && pc != len(ca.code) {
+				return nil, codeError(pc, "the exception table has an entry that isn't at the start of an instruction")
+			}
+			leaders[pc] = true
+		}
+	}
+
+	var cur *BasicBlock
+	for _, ins := range insns {
+		if leaders[ins.pc] {
+			cur = &BasicBlock{id: len(g.blocks), start: ins.pc}
+			g.blocks = append(g.blocks, cur)
+			g.byPC[ins.pc] = cur
+		}
+		cur.insns = append(cur.insns, ins)
+		cur.end = ins.next()
+	}
+	for i, b := range g.blocks {
+		last := b.insns[len(b.insns)-1]
+		kind := "branch"
+		if last.opcode == 0xAA || last.opcode == 0xAB {
+			kind = "switch"
+		} else if last.opcode == 0xA8 || last.opcode == 0xC9 {
+			kind = "jsr"
+		}
+		for _, t := range last.branchTargets() {
+			g.addEdge(b, g.byPC[t], kind)
+		}
+		//the verifier checks that the code doesn't fall off the end
+		if last.fallsThrough() && i+1 < len(g.blocks) {
+			g.addEdge(b, g.blocks[i+1], "fall")
+		}
+	}
+	//an exception can happen anywhere in a try block
+	for _, x := range ca.exception_table {
+		h := g.byPC[int(x.handler_pc)]
+		for _, b := range g.blocks {
+			if b.start >= int(x.start_pc) && b.start < int(x.end_pc) {
+				g.addEdge(b, h, "exception")
+			}
+		}
+	}
+	g.findDominators()
+	g.findLoops()
+	return g, nil
+}
+
+//add an edge, unless there already is one of the same kind
+func (g *CFG) addEdge(from *BasicBlock, to *BasicBlock, kind string) {
+	for _, e := range from.succs {
+		if e.to == to && e.kind == kind {
+			return
+		}
+	}
+	e := &CFGEdge{from: from, to: to, kind: kind}
+	from.succs = append(from.succs, e)
+	to.preds = append(to.preds, e)
+}
+
+//work out the immediate dominator of every block that can be reached, using the
+//algorithm in "A Simple, Fast Dominance Algorithm" by Cooper, Harvey and Kennedy
+func (g *CFG) findDominators() {
+	order := []*BasicBlock{}
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		b.reachable = true
+		for _, e := range b.succs {
+			if !e.to.reachable {
+				visit(e.to)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(g.blocks[0])
+	//reverse the postorder, so every block comes before the blocks it dominates
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	for i, b := range order {
+		b.order = i
+	}
+	entry := order[0]
+	entry.idom = entry
+	changed := true
+	for changed {
+		changed = false
+		for _, b := range order[1:] {
+			var d *BasicBlock
+			for _, e := range b.preds {
+				if e.from.idom == nil {
+					continue
+				}
+				if d == nil {
+					d = e.from
+				} else {
+					d = intersectDominators(e.from, d)
+				}
+			}
+			if d != b.idom {
+				b.idom = d
+				changed = true
+			}
+		}
+	}
+	entry.idom = nil
+}
+
+//the closest block that dominates both a and b.  The entry is its own idom while this is used
+func intersectDominators(a *BasicBlock, b *BasicBlock) *BasicBlock {
+	for a != b {
+		for a.order > b.order {
+			a = a.idom
+		}
+		for b.order > a.order {
+			b = b.idom
+		}
+	}
+	return a
+}
+
+//a dominates b if every way from the entry to b goes through a.  A block dominates itself
+func (g *CFG) dominates(a *BasicBlock, b *BasicBlock) bool {
+	if !a.reachable || !b.reachable {
+		return false
+	}
+	for d := b; d != nil; d = d.idom {
+		if d == a {
+			return true
+		}
+	}
+	return false
+}
+
+//an edge back to a block that dominates where it comes from closes a loop
+func (g *CFG) findLoops() {
+	for _, b := range g.blocks {
+		for _, e := range b.succs {
+			if g.dominates(e.to, b) {
+				e.to.loopHeader = true
+			}
+		}
+	}
+}
+
+//the blocks that can never run
+func (g *CFG) deadBlocks() []*BasicBlock {
+	list := []*BasicBlock{}
+	for _, b := range g.blocks {
+		if !b.reachable {
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+//the graph in the DOT language of Graphviz.  Each block shows its instructions and its
+//immediate dominator.  Loop headers have a double border and dead blocks are grey
+func (g *CFG) DOT(name string) string {
+	var w strings.Builder
+	fmt.Fprintf(&w, "digraph %s {\n", jsonQuote(name))
+	w.WriteString("  node [shape=box, fontname=monospace];\n")
+	for _, b := range g.blocks {
+		label := "B"+strconv.Itoa(b.id)
+		if b.idom != nil {
+			label = label + " (idom B"+strconv.Itoa(b.idom.id)+")"
+		}
+		label = label + "\\l"
+		for _, ins := range b.insns {
+			label = label + strconv.Itoa(ins.pc) + ": " + ins.mnemonic() + "\\l"
+		}
+		style := ""
+		if b.loopHeader {
+			style = style + ", peripheries=2"
+		}
+		if !b.reachable {
+			style = style + ", style=filled, fillcolor=lightgrey"
+		}
+		fmt.Fprintf(&w, "  B%d [label=\"%s\"%s];\n", b.id, label, style)
+	}
+	for _, b := range g.blocks {
+		for _, e := range b.succs {
+			switch (e.kind) {
+				case "fall":
+					fmt.Fprintf(&w, "  B%d -> B%d;\n", b.id, e.to.id)
+				case "exception":
+					fmt.Fprintf(&w, "  B%d -> B%d [label=exception, style=dashed];\n", b.id, e.to.id)
+				default:
+					fmt.Fprintf(&w, "  B%d -> B%d [label=%s];\n", b.id, e.to.id, e.kind)
+			}
+		}
+	}
+	w.WriteString("}\n")
+	return w.String()
+}
+
+//print the control flow graph of every method with code
+func (cf *ClassFile) printCFGs() {
+	for _, m := range cf.methods {
+		ca := m.getCode()
+		if ca == nil {
+			continue
+		}
+		g, err := NewCFG(ca)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERR: "+m.name()+m.sig()+": "+err.Error())
+			continue
+		}
+		fmt.Print(g.DOT(memberKey(cf.getClassName(), m.name(), m.sig())))
+	}
+}
+
+//print the code that can never run
+func (cf *ClassFile) printDeadCode() {
+	for _, m := range cf.methods {
+		ca := m.getCode()
+		if ca == nil {
+			continue
+		}
+		key := memberKey(cf.getClassName(), m.name(), m.sig())
+		g, err := NewCFG(ca)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERR: "+key+": "+err.Error())
+			continue
+		}
+		for _, b := range g.deadBlocks() {
+			fmt.Printf("%s: pc %d to %d can't be reached\n", key, b.start, b.insns[len(b.insns)-1].pc)
+		}
+	}
+}
+
+//==============================
+
+func main() {
+	args := os.Args
+	//-c prints the code, like javap -c -l
+	disasm := len(args) > 2 && args[1] == "-c"
+	if disasm {
+		debug = false
+		args = args[1:]
+	}
+	//-analyze classpath command looks at all the classes together
+	if len(args) > 1 && args[1] == "-analyze" {
+		debug = false
+		err := analyze(args[2:])
+		if err != nil {
+			fmt.Println("ERR: "+err.Error());
+			os.Exit(1);
+		}
+		return
+	}
+	//-json and -yaml print the whole class file for scripts to read.  -cfg prints the control
+	//flow graph of each method, and -dead prints the code that can never run
+	format := ""
+	if len(args) > 2 && (args[1] == "-json" || args[1] == "-yaml" || args[1] == "-cfg" || args[1] == "-dead") {
+		debug = false
+		format = args[1]
+		args = args[1:]
+	}
+	//-w out.class writes the class back out, which should give the same bytes
+	outname := ""
+	if len(args) > 3 && args[1] == "-w" {
+		debug = false
+		outname = args[2]
+		args = args[2:]
+	}
+	cf, cfname, _, err := loadFromArgs(args[1:]);
+	if err != nil {
+		fmt.Println("ERR: "+cfname+": "+err.Error());
+		os.Exit(1);
+	}
+	if disasm {
+		cf.disassemble();
+		return
+	}
+	if format == "-json" || format == "-yaml" {
+		var out string
+		if format == "-json" {
+			out, err = cf.JSON()
+		} else {
+			out, err = cf.YAML()
+		}
+		if err != nil {
+			fmt.Println("ERR: "+cfname+": "+err.Error());
+			os.Exit(1);
+		}
+		fmt.Print(out);
+		return
+	}
+	if format == "-cfg" {
+		cf.printCFGs();
+		return
+	}
+	if format == "-dead" {
+		cf.printDeadCode();
+		return
+	}
+	if outname != "" {
+		body, err := cf.Bytes();
+		if err == nil {
+			err = os.WriteFile(outname, body, 0644);
+		}
+		if err != nil {
+			fmt.Println("ERR: "+outname+": "+err.Error());
+			os.Exit(1);
+		}
+		return
+	}
 
 	//print magic number
 	fmt.Println("Magic := " + strconv.Itoa(int(cf.magic)));
 	cf.dump_fields();
 	cf.dump_methods();
+	cf.dump_attributes();
 }
 
@@ -0,0 +1,494 @@
+package main
+
+//every .go file here is its own program, so run these with
+//	go test classfile.go classfile_test.go
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//an EnclosingMethod whose method_index isn't a NameAndType is rejected when it is loaded
+func TestEnclosingMethodIndex(t *testing.T) {
+	debug = false;
+	_, err := ParseFile(filepath.Join("testdata", "Encl$2.class"));
+	if (!errors.Is(err, ErrWrongTag)) {
+		t.Errorf("got %v, want %v", err, ErrWrongTag);
+	}
+	var pe *ParseError;
+	if (!errors.As(err, &pe)) {
+		t.Errorf("%v is not a ParseError", err);
+	}
+}
+
+//a ConstantValue has to point at an int, long, float, double or string constant
+func TestConstantValueIndex(t *testing.T) {
+	debug = false;
+	for name, want := range map[string]error{"CValBadIndex.class": ErrBadIndex, "CValBadTag.class": ErrWrongTag} {
+		_, err := ParseFile(filepath.Join("testdata", name));
+		if (!errors.Is(err, want)) {
+			t.Errorf("%s: got %v, want %v", name, err, want);
+		}
+	}
+}
+
+//the export checks the index again, in case the attribute was changed after it was loaded
+func TestExportBadConstantValue(t *testing.T) {
+	debug = false;
+	cf, err := ParseFile(filepath.Join("testdata", "CVal.class"));
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	cva, ok := cf.fields[0].attribute_table.attributes[0].(*ConstantValue_attribute);
+	if (!ok) {
+		t.Fatal("the first attribute of CVal.K isn't a ConstantValue");
+	}
+	cva.cp_index = 999;
+	if _, err := cf.JSON(); !errors.Is(err, ErrBadIndex) {
+		t.Errorf("JSON: got %v, want %v", err, ErrBadIndex);
+	}
+	if _, err := cf.YAML(); !errors.Is(err, ErrBadIndex) {
+		t.Errorf("YAML: got %v, want %v", err, ErrBadIndex);
+	}
+}
+
+//the classes in testdata are small hand-assembled class files.  Between them they have every kind of
+//constant, the debug attributes, annotations, a module, a record, an inner class and more than 255 constants
+var roundTripClasses = []string{
+	"Anno.class", "Big.class", "Box.class", "CVal.class", "Coll.class", "Debug.class", "Encl$1.class",
+	"Exc.class", "Hello.class", "IntMath.class", "Modern.class", "MyErr.class", "Ops2.class",
+	"Utf.class", "Wide.class", "module-info.class",
+}
+
+//-w writes the class back out, which has to give the same bytes that were read
+func TestWriteRoundTrip(t *testing.T) {
+	debug = false;
+	for _, name := range roundTripClasses {
+		fname := filepath.Join("testdata", name);
+		want, err := os.ReadFile(fname);
+		if (err != nil) {
+			t.Fatal(err);
+		}
+		cf, err := ParseFile(fname);
+		if (err != nil) {
+			t.Errorf("%s: %v", name, err);
+			continue;
+		}
+		got, err := cf.Bytes();
+		if (err != nil) {
+			t.Errorf("%s: %v", name, err);
+		} else if (!bytes.Equal(got, want)) {
+			t.Errorf("%s: wrote %d bytes that don't match the %d that were read", name, len(got), len(want));
+		}
+	}
+}
+
+//go test classfile.go classfile_test.go -update rewrites the golden files
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden");
+
+//-json and -yaml have to keep giving the output in testdata/golden, so scripts that read it don't break
+func TestExportGolden(t *testing.T) {
+	debug = false;
+	for _, name := range roundTripClasses {
+		cf, err := ParseFile(filepath.Join("testdata", name));
+		if (err != nil) {
+			t.Errorf("%s: %v", name, err);
+			continue;
+		}
+		for _, format := range []string{"json", "yaml"} {
+			var got string;
+			if (format == "json") {
+				got, err = cf.JSON();
+			} else {
+				got, err = cf.YAML();
+			}
+			if (err != nil) {
+				t.Errorf("%s: %v", name, err);
+				continue;
+			}
+			golden := filepath.Join("testdata", "golden", strings.TrimSuffix(name, ".class")+"."+format);
+			if (*update) {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err);
+				}
+				continue;
+			}
+			want, err := os.ReadFile(golden);
+			if (err != nil) {
+				t.Fatal(err);
+			}
+			if (got != string(want)) {
+				t.Errorf("%s doesn't match %s", format, golden);
+			}
+		}
+	}
+}
+
+func TestParseMethodDescriptor(t *testing.T) {
+	tests := []struct {
+		desc string;
+		params, slots int;
+		ret string;
+	}{
+		{"()V", 0, 0, "V"},
+		{"(I)I", 1, 1, "I"},
+		{"(IJ)D", 2, 3, "D"},
+		{"(ZBCSFD)J", 6, 7, "J"},
+		{"([Ljava/lang/String;)V", 1, 1, "V"},
+		{"(Ljava/util/Map$Entry;[[I)Ljava/lang/Object;", 2, 2, "Ljava/lang/Object;"},
+		{"([[J)[D", 1, 1, "[D"},
+	};
+	for _, tt := range tests {
+		ms, err := parseMethodDescriptor(tt.desc);
+		if (err != nil) {
+			t.Errorf("%s: %v", tt.desc, err);
+			continue;
+		}
+		if (ms.paramCount() != tt.params || ms.paramSlots() != tt.slots || ms.ret.descriptor() != tt.ret) {
+			t.Errorf("%s: got %d params, %d slots, returns %s", tt.desc, ms.paramCount(), ms.paramSlots(), ms.ret.descriptor());
+		}
+	}
+}
+
+func TestParseMethodDescriptorErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"()",
+		"(I",
+		"I)V",
+		"(V)V",
+		"()II",
+		"()[V",
+		"([)V",
+		"(Q)V",
+		"(L;)V",
+		"(Ljava/lang/String)V",
+		"(La.b;)V",
+		//a descriptor can't have the generic parts of a signature
+		"(TT;)V",
+		"(Ljava/util/List<Ljava/lang/String;>;)V",
+		"<T:Ljava/lang/Object;>()V",
+		"(I)V^Ljava/lang/Exception;",
+		//the params can't take more than 255 slots
+		"("+strings.Repeat("J", 128)+")V",
+	};
+	for _, desc := range bad {
+		if _, err := parseMethodDescriptor(desc); err == nil {
+			t.Errorf("%q should be rejected", desc);
+		}
+	}
+}
+
+//write a jar with a manifest and some classes from testdata
+func writeJar(t *testing.T, fname string, manifest string, classes ...string) {
+	f, err := os.Create(fname);
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	defer f.Close();
+	zw := zip.NewWriter(f);
+	if (manifest != "") {
+		w, _ := zw.Create("META-INF/MANIFEST.MF");
+		w.Write([]byte("Manifest-Version: 1.0\r\n"+manifest+"\r\n\r\n"));
+	}
+	for _, c := range classes {
+		body, err := os.ReadFile(filepath.Join("testdata", c+".class"));
+		if (err != nil) {
+			t.Fatal(err);
+		}
+		w, _ := zw.Create("pkg/"+c+".class");
+		w.Write(body);
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err);
+	}
+}
+
+//classes are found in a directory, in a jar, and in the jars in the Class-Path of its manifest.
+//A jar in the Class-Path that isn't there is skipped
+func TestClassPath(t *testing.T) {
+	debug = false;
+	dir := t.TempDir();
+	os.Mkdir(filepath.Join(dir, "lib"), 0755);
+	writeJar(t, filepath.Join(dir, "app.jar"), "Main-Class: pkg.Hello\r\nClass-Path: lib/b.jar missing.jar", "Hello");
+	writeJar(t, filepath.Join(dir, "lib", "b.jar"), "", "Wide");
+	cp, err := NewClassPath("testdata"+string(os.PathListSeparator)+filepath.Join(dir, "app.jar"));
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	defer cp.Close();
+	for _, name := range []string{"Box", "pkg/Hello", "pkg.Wide"} {
+		if _, err := cp.LoadClass(name); err != nil {
+			t.Errorf("%s: %v", name, err);
+		}
+	}
+	if main, err := cp.MainClass(); main != "pkg.Hello" || err != nil {
+		t.Errorf("MainClass() = %q, %v", main, err);
+	}
+	if _, err := cp.LoadClass("pkg/Nothing"); !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("got %v, want %v", err, ErrClassNotFound);
+	}
+}
+
+//like java, an empty class path is the current directory
+func TestClassPathEmpty(t *testing.T) {
+	debug = false;
+	t.Chdir("testdata");
+	cp, err := NewClassPath("");
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	defer cp.Close();
+	if _, err := cp.LoadClass("Hello"); err != nil {
+		t.Error(err);
+	}
+}
+
+//Dog extends Animal, which implements Speaker, and Dog overrides speak.  MyErr extends RuntimeException
+func TestCallers(t *testing.T) {
+	debug = false;
+	cp, err := NewClassPath("testdata");
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	defer cp.Close();
+	cs, err := NewClassSet(cp);
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	tests := []struct {
+		target string;
+		want []string;
+	}{
+		//calls through Animal and Speaker can run the override
+		{"Dog.speak", []string{"Zoo.main:([Ljava/lang/String;)V@14", "Zoo.main:([Ljava/lang/String;)V@34", "Zoo.main:([Ljava/lang/String;)V@57"}},
+		//a constructor isn't overridden, so super() only calls the super class
+		{"MyErr.<init>", []string{"Exc.main:([Ljava/lang/String;)V@26", "Exc.main:([Ljava/lang/String;)V@120", "Exc.main:([Ljava/lang/String;)V@141"}},
+		{"java/lang/RuntimeException.<init>", []string{"MyErr.<init>:(Ljava/lang/String;)V@2"}},
+		{"Animal.<init>", []string{"Dog.<init>:(Ljava/lang/String;)V@3", "Zoo.main:([Ljava/lang/String;)V@49"}},
+		//Dog.count is the static field that Dog inherits from Animal
+		{"Animal.count:I", []string{"Dog.<init>:(Ljava/lang/String;)V@6", "Dog.<init>:(Ljava/lang/String;)V@11", "Zoo.main:([Ljava/lang/String;)V@86"}},
+		{"Dog.count:I", nil},
+	};
+	for _, tt := range tests {
+		got := []string{};
+		for _, r := range cs.Callers(tt.target) {
+			got = append(got, r.from+"@"+strconv.Itoa(r.pc));
+		}
+		if (strings.Join(got, " ") != strings.Join(tt.want, " ")) {
+			t.Errorf("callers of %s:\n got %v\nwant %v", tt.target, got, tt.want);
+		}
+	}
+}
+
+//a loop that counts to 10, with a return after it that can't be reached, and a try block
+//with a handler.  The edges, the immediate dominators, the loop headers and the dead blocks
+//are written as strings so they are easy to compare
+func TestCFG(t *testing.T) {
+	tests := []struct {
+		name string;
+		ca *Code_attribute;
+		edges, idoms, loops, dead string;
+	}{
+		{"loop", &Code_attribute{code: []byte{
+			0x03, 0x3B,				//0: iconst_0, istore_0
+			0x1A, 0x10, 10,			//2: iload_0, bipush 10
+			0xA2, 0x00, 0x09,		//5: if_icmpge 14
+			0x84, 0x00, 0x01,		//8: iinc 0 1
+			0xA7, 0xFF, 0xF7,		//11: goto 2
+			0x1A, 0xAC,				//14: iload_0, ireturn
+			0x04, 0xAC,				//16: iconst_1, ireturn
+		}},
+			"B0->B1 fall, B1->B3 branch, B1->B2 fall, B2->B1 branch", "B1:B0 B2:B1 B3:B1", "B1", "B4"},
+		{"try", &Code_attribute{code: []byte{
+			0x04, 0xAC,				//0: iconst_1, ireturn
+			0x4B, 0x03, 0xAC,		//2: astore_0, iconst_0, ireturn
+		}, exception_table: []*exception_table_entry{{start_pc: 0, end_pc: 2, handler_pc: 2}}},
+			"B0->B1 exception", "B1:B0", "", ""},
+		{"switch", &Code_attribute{code: []byte{
+			0x1A,							//0: iload_0
+			0xAB, 0, 0,						//1: lookupswitch, padded to 4
+			0, 0, 0, 21, 0, 0, 0, 1,		//default 22, 1 pair
+			0, 0, 0, 5, 0, 0, 0, 19,		//5: 20
+			0x04, 0xAC,						//20: iconst_1, ireturn
+			0x03, 0xAC,						//22: iconst_0, ireturn
+		}},
+			"B0->B2 switch, B0->B1 switch", "B1:B0 B2:B0", "", ""},
+	};
+	for _, tt := range tests {
+		g, err := NewCFG(tt.ca);
+		if (err != nil) {
+			t.Errorf("%s: %v", tt.name, err);
+			continue;
+		}
+		edges, idoms, loops, dead := []string{}, []string{}, []string{}, []string{};
+		for _, b := range g.blocks {
+			for _, e := range b.succs {
+				edges = append(edges, "B"+strconv.Itoa(b.id)+"->B"+strconv.Itoa(e.to.id)+" "+e.kind);
+			}
+			if (b.idom != nil) {
+				idoms = append(idoms, "B"+strconv.Itoa(b.id)+":B"+strconv.Itoa(b.idom.id));
+			}
+			if (b.loopHeader) {
+				loops = append(loops, "B"+strconv.Itoa(b.id));
+			}
+		}
+		for _, b := range g.deadBlocks() {
+			dead = append(dead, "B"+strconv.Itoa(b.id));
+		}
+		got := []string{strings.Join(edges, ", "), strings.Join(idoms, " "), strings.Join(loops, " "), strings.Join(dead, " ")};
+		want := []string{tt.edges, tt.idoms, tt.loops, tt.dead};
+		for i, what := range []string{"edges", "idoms", "loop headers", "dead blocks"} {
+			if (got[i] != want[i]) {
+				t.Errorf("%s: %s are %q, want %q", tt.name, what, got[i], want[i]);
+			}
+		}
+	}
+}
+
+//a branch into the middle of an instruction is rejected
+func TestCFGBadBranch(t *testing.T) {
+	ca := &Code_attribute{code: []byte{0xA7, 0x00, 0x01, 0xB1}};	//goto 1, return
+	if _, err := NewCFG(ca); err == nil {
+		t.Error("goto 1 should be rejected");
+	}
+}
+
+//NUL is C0 80 and a character outside the BMP is 2 surrogates of 3 bytes each
+func TestDecodeModifiedUTF8(t *testing.T) {
+	tests := []struct {
+		in []byte;
+		want []uint16;
+		bad int;
+	}{
+		{[]byte("abc"), []uint16{'a', 'b', 'c'}, -1},
+		{[]byte{0xC0, 0x80}, []uint16{0}, -1},
+		{[]byte{'a', 0xC0, 0x80, 'b'}, []uint16{'a', 0, 'b'}, -1},
+		{[]byte{0xC3, 0xA9}, []uint16{0xE9}, -1},
+		{[]byte{0xE2, 0x82, 0xAC}, []uint16{0x20AC}, -1},
+		//U+1F600 is D83D DE00
+		{[]byte{0xED, 0xA0, 0xBD, 0xED, 0xB8, 0x80}, []uint16{0xD83D, 0xDE00}, -1},
+		//a lone surrogate is allowed
+		{[]byte{0xED, 0xA0, 0xBD}, []uint16{0xD83D}, -1},
+		//a plain 0 and the 4 byte form of standard UTF-8 never appear
+		{[]byte{'a', 0}, []uint16{'a'}, 1},
+		{[]byte{0xF0, 0x9F, 0x98, 0x80}, []uint16{}, 0},
+		//and a character can't be cut short
+		{[]byte{'a', 0xC3}, []uint16{'a'}, 1},
+		{[]byte{0xE2, 0x82}, []uint16{}, 0},
+		{[]byte{0xE2, 0x41, 0xAC}, []uint16{}, 0},
+		{[]byte{0x80}, []uint16{}, 0},
+	};
+	for _, tt := range tests {
+		got, bad := decodeModifiedUTF8(tt.in);
+		if (bad != tt.bad || !slices.Equal(got, tt.want)) {
+			t.Errorf("% X: got %X, %d, want %X, %d", tt.in, got, bad, tt.want, tt.bad);
+		}
+	}
+}
+
+//a bad byte in a Utf8 constant is a ParseError at the offset of that byte.  The first constant
+//of Hello is java/lang/System, and its bytes start at 13, so 15 is the v
+func TestBadUtf8Constant(t *testing.T) {
+	debug = false;
+	body, err := os.ReadFile(filepath.Join("testdata", "Hello.class"));
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	for _, b := range []byte{0x00, 0xF0, 0x80} {
+		bad := bytes.Clone(body);
+		bad[15] = b;
+		_, err := Parse(bytes.NewReader(bad));
+		var pe *ParseError;
+		if (!errors.Is(err, ErrBadUtf8) || !errors.As(err, &pe) || pe.Offset != 15) {
+			t.Errorf("%02X: got %v, want %v at 15", b, err, ErrBadUtf8);
+		}
+	}
+}
+
+//flags that can't go together, and some that can.  want is part of the message, or "" if they are fine
+func TestFlagChecks(t *testing.T) {
+	tests := []struct {
+		what string;
+		msg string;
+		want string;
+	}{
+		{"public class", ClassFlags(ACC_PUBLIC|ACC_SUPER).check(52), ""},
+		{"abstract final class", ClassFlags(ACC_ABSTRACT|ACC_FINAL).check(52), "abstract and final"},
+		{"interface", ClassFlags(ACC_INTERFACE|ACC_ABSTRACT).check(52), ""},
+		{"interface without abstract", ClassFlags(ACC_INTERFACE).check(52), "must be abstract"},
+		{"old interface without abstract", ClassFlags(ACC_INTERFACE).check(49), ""},
+		{"super interface", ClassFlags(ACC_INTERFACE|ACC_ABSTRACT|ACC_SUPER).check(52), "super"},
+		{"enum interface", ClassFlags(ACC_INTERFACE|ACC_ABSTRACT|ACC_ENUM).check(52), "enum"},
+		{"annotation class", ClassFlags(ACC_ANNOTATION).check(52), "must be an interface"},
+		{"public module", ClassFlags(ACC_MODULE|ACC_PUBLIC).check(53), "module"},
+		{"public private field", FieldFlags(ACC_PUBLIC|ACC_PRIVATE).check(false), "more than one"},
+		{"final volatile field", FieldFlags(ACC_FINAL|ACC_VOLATILE).check(false), "final and volatile"},
+		{"interface field", FieldFlags(ACC_PUBLIC|ACC_STATIC|ACC_FINAL).check(true), ""},
+		{"private interface field", FieldFlags(ACC_PRIVATE|ACC_STATIC|ACC_FINAL).check(true), "public static final"},
+		{"abstract private method", MethodFlags(ACC_ABSTRACT|ACC_PRIVATE).check("m", false, 52), "abstract"},
+		{"abstract strictfp method", MethodFlags(ACC_ABSTRACT|ACC_STRICT).check("m", false, 52), "strictfp"},
+		{"abstract strictfp method in 61", MethodFlags(ACC_ABSTRACT|ACC_STRICT).check("m", false, 61), ""},
+		{"default method", MethodFlags(ACC_PUBLIC).check("m", true, 52), ""},
+		{"default method in 51", MethodFlags(ACC_PUBLIC).check("m", true, 51), "public abstract"},
+		{"static constructor", MethodFlags(ACC_STATIC).check("<init>", false, 52), "constructor"},
+		{"clinit", MethodFlags(0xFFFF).check("<clinit>", false, 52), ""},
+		//0x0020 is ACC_SUPER, which an inner class doesn't use
+		{"inner class", InnerClassFlags(ACC_PRIVATE|ACC_STATIC|0x0020).check(52), ""},
+		{"public private inner class", InnerClassFlags(ACC_PUBLIC|ACC_PRIVATE).check(52), "more than one"},
+		{"inner interface without abstract", InnerClassFlags(ACC_STATIC|ACC_INTERFACE).check(52), "must be abstract"},
+		{"final inner interface", InnerClassFlags(ACC_INTERFACE|ACC_ABSTRACT|ACC_FINAL).check(52), "final"},
+		{"open module", ModuleFlags(ACC_OPEN|ACC_SYNTHETIC).check(), ""},
+		{"static module", ModuleFlags(ACC_STATIC_PHASE).check(), "open, synthetic or mandated"},
+		{"mandated exports", ModuleFlags(ACC_MANDATED).checkExports(), ""},
+		{"open exports", ModuleFlags(ACC_OPEN).checkExports(), "synthetic or mandated"},
+		{"requires transitive", RequiresFlags(ACC_TRANSITIVE).check("a", "b", 54), ""},
+		{"requires public", RequiresFlags(ACC_PUBLIC).check("a", "b", 54), "transitive, static"},
+		{"requires java.base transitive", RequiresFlags(ACC_TRANSITIVE).check("a", "java.base", 54), "java.base"},
+		{"requires java.base static in 53", RequiresFlags(ACC_STATIC_PHASE).check("a", "java.base", 53), ""},
+		{"java.base requires java.base static", RequiresFlags(ACC_STATIC_PHASE).check("java.base", "java.base", 54), ""},
+	};
+	for _, tt := range tests {
+		if (tt.want == "" && tt.msg != "") {
+			t.Errorf("%s: %s", tt.what, tt.msg);
+		} else if (tt.want != "" && !strings.Contains(tt.msg, tt.want)) {
+			t.Errorf("%s: got %q, want %q", tt.what, tt.msg, tt.want);
+		}
+	}
+}
+
+//the loader checks the flags of the class, of its inner classes and of a module
+func TestBadFlags(t *testing.T) {
+	debug = false;
+	for _, name := range []string{"FSuper", "FInner", "ModFlags", "ModRequires", "ModExports", "ModOpens"} {
+		_, err := ParseFile(filepath.Join("testdata", name+".class"));
+		if (!errors.Is(err, ErrBadFlags)) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrBadFlags);
+		}
+	}
+	if _, err := ParseFile(filepath.Join("testdata", "ModOld.class")); err != nil {
+		t.Errorf("ModOld: %v", err);
+	}
+}
+
+//nothing can come after the attributes of the class
+func TestTrailingBytes(t *testing.T) {
+	debug = false;
+	body, err := os.ReadFile(filepath.Join("testdata", "Hello.class"));
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	_, err = Parse(bytes.NewReader(append(bytes.Clone(body), 0, 0)));
+	var pe *ParseError;
+	if (!errors.Is(err, ErrBadLength) || !errors.As(err, &pe) || pe.Offset != len(body)) {
+		t.Errorf("got %v, want %v at %d", err, ErrBadLength, len(body));
+	}
+}
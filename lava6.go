@@ -1,84 +1,247 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
 	"fmt"
-    "io/ioutil"
-    "encoding/binary"
+	"errors"
+	"io"
+	"bytes"
 	"math"
+	"math/rand"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
 	"os"
+	"path/filepath"
 )
 
+//print what the loader and the compiler are doing, to stderr so it doesn't mix with the output
+//of the program.  This is turned on with -verbose
+var debug = false
+
 //========================
-// Buffer class
+// Buffer class.  A Buffer reads a class file from an io.ReaderAt, so the file doesn't have
+// to be read into memory first.  An *os.File, a *bytes.Reader and a memory mapped file are
+// all ReaderAts.  Every read is checked against the end of the buffer, and a sub buffer can
+// be made for the body of an attribute, so its decoder can't read past attribute_length
+
+//the bytes behind a buffer and its sub buffers.  Reads from a file are done a chunk at a time
+type byteSource struct {
+	r io.ReaderAt
+	size int
+	//the chunk that was read last, and where it starts
+	window []byte
+	wstart int
+}
+
+const bufferChunk = 64*1024
+
 type Buffer struct {
-	data []byte
+	src *byteSource
+	//pos is the offset in the whole class file, even in a sub buffer, so error offsets are right
 	pos int
+	end int
+	//the attribute a sub buffer holds, for error messages
+	name string
+	//err holds the first read error.  Once it is set every read returns zero,
+	//so the loaders only need to check it once in a while instead of after every read
+	err error
+}
+
+//read size bytes from r
+func NewBuffer(r io.ReaderAt, size int64) *Buffer {
+	return &Buffer {
+		src: &byteSource{r: r, size: int(size)},
+		pos: 0,
+		end: int(size),
+	}
 }
 
-func NewBuffer(fname string) *Buffer {
-   	body, err := ioutil.ReadFile(fname)
-    if err != nil {
-        fmt.Printf("unable to read file: %v", err)
-    }
-    buf := &Buffer {
-    	data: body,
-    	pos: 0,
-    }
-    return buf;
+//wrap bytes that are already in memory.  They are all in the window, so nothing is copied
+func NewBufferFromBytes(body []byte) *Buffer {
+	buf := NewBuffer(bytes.NewReader(body), int64(len(body)))
+	buf.src.window = body
+	return buf
+}
+
+//a buffer for the next n bytes, which are the body of the named attribute.  This buffer
+//skips over them, whether or not the sub buffer reads them all
+func (buf *Buffer) sub(n int, name string) *Buffer {
+	if !buf.need(n) {
+		return &Buffer{src: buf.src, pos: buf.pos, end: buf.pos, name: name, err: buf.err}
+	}
+	s := &Buffer{src: buf.src, pos: buf.pos, end: buf.pos+n, name: name}
+	buf.pos = buf.pos + n
+	return s
+}
+
+//the number of bytes that haven't been read
+func (buf *Buffer) remaining() int {
+	return buf.end - buf.pos
+}
+
+//check that there are n more bytes to read.  If not, remember the error
+func (buf *Buffer) need(n int) bool {
+	if buf.err != nil {
+		return false
+	}
+	if n < 0 || n > buf.end - buf.pos {
+		if buf.name != "" {
+			buf.err = newParseError(ErrBadLength, buf.pos, "the "+buf.name+" attribute needs "+strconv.Itoa(n)+" more bytes, only "+strconv.Itoa(buf.end-buf.pos)+" are left")
+		} else {
+			buf.err = newParseError(ErrTruncated, buf.pos, "need "+strconv.Itoa(n)+" bytes, only "+strconv.Itoa(buf.end-buf.pos)+" left")
+		}
+		return false
+	}
+	return true
+}
+
+//the next n bytes.  These may be shared with the window, so they mustn't be changed or kept
+func (buf *Buffer) next(n int) []byte {
+	if !buf.need(n) {
+		return nil
+	}
+	src := buf.src
+	if buf.pos < src.wstart || buf.pos+n > src.wstart+len(src.window) {
+		size := bufferChunk
+		if size < n {
+			size = n
+		}
+		if size > src.size - buf.pos {
+			size = src.size - buf.pos
+		}
+		w := make([]byte, size)
+		got, err := src.r.ReadAt(w, int64(buf.pos))
+		if got < n {
+			if err == nil || err == io.EOF {
+				err = ErrTruncated
+			}
+			buf.err = newParseError(err, buf.pos, "read "+strconv.Itoa(got)+" of "+strconv.Itoa(n)+" bytes")
+			return nil
+		}
+		src.window = w[:got]
+		src.wstart = buf.pos
+	}
+	i := buf.pos - src.wstart
+	buf.pos = buf.pos + n
+	return src.window[i:i+n]
+}
+
+//read n bytes into a new slice, or nil if they aren't there
+func (buf *Buffer) readBytes(n int) []byte {
+	if !buf.need(n) {
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, buf.next(n))
+	return b
 }
 
 func (buf *Buffer) readByte() byte {
-	b := buf.data[buf.pos]
-	buf.pos = buf.pos + 1
-	return b;
+	b := buf.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0];
 }
 
 func (buf *Buffer) readUShort() uint16 {
-	var v uint16
-	v |= uint16(buf.data[buf.pos]) << 8
-	v |= uint16(buf.data[buf.pos+1]) 
-	buf.pos = buf.pos + 2
-	return v
+	b := buf.next(2)
+	if b == nil {
+		return 0
+	}
+	return getU2(b)
 }
 
-//little-endian format
 func (buf *Buffer) readUInt() uint32 {
-	var value uint32
-	value |= uint32(buf.data[buf.pos]) << 24
-	value |= uint32(buf.data[buf.pos+1]) << 16
-	value |= uint32(buf.data[buf.pos+2]) << 8
-	value |= uint32(buf.data[buf.pos+3])  
-	buf.pos = buf.pos + 4
-	return value
+	b := buf.next(4)
+	if b == nil {
+		return 0
+	}
+	return getU4(b)
+}
+
+func (buf *Buffer) readULong() uint64 {
+	b := buf.next(8)
+	if b == nil {
+		return 0
+	}
+	return getU8(b)
 }
 
 //read 4 bytes from the buffer, returning a slice
 func (buf *Buffer) read4Bytes() []byte {
-    bytes := make([]byte,4)
-    bytes[0] = buf.data[buf.pos]
-    bytes[1] = buf.data[buf.pos+1]
-    bytes[2] = buf.data[buf.pos+2]
-    bytes[3] = buf.data[buf.pos+3]
-    buf.pos = buf.pos + 4
-    return bytes
+	bytes := make([]byte, 4)
+	copy(bytes, buf.next(4))
+	return bytes
 }
 
-//static helper function, related
-//returns uint32 in little-endian format
+//returns the 4 bytes as a uint32.  Class files are big-endian
 func (buf *Buffer) toUint32(bytes []byte) uint32 {
 	if (len(bytes)!=4) {
 		fmt.Println("ERR: length of bytes is " + strconv.Itoa(len(bytes)));
 		return 0;
-	} else {
-		var value uint32
-		value |= uint32(bytes[0]) << 24
-		value |= uint32(bytes[0]) << 16
-		value |= uint32(bytes[0]) << 8
-		value |= uint32(bytes[0])  
-		return value
 	}
+	return getU4(bytes)
+}
+
+//big-endian helpers, for a u2, u4 or u8 at the start of b
+func getU2(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func getU4(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func getU8(b []byte) uint64 {
+	return uint64(getU4(b))<<32 | uint64(getU4(b[4:]))
+}
+
+//========================
+// Parse errors
+
+//these are the kinds of ParseError.  Use errors.Is to tell them apart
+var (
+	ErrTruncated = errors.New("truncated class file")
+	ErrBadMagic = errors.New("bad magic number")
+	ErrBadIndex = errors.New("bad constant pool index")
+	ErrWrongTag = errors.New("wrong constant pool tag")
+	ErrUnknownTag = errors.New("unknown constant pool tag")
+	ErrBadRefKind = errors.New("bad method handle reference kind")
+	ErrBadLength = errors.New("attribute length doesn't match its contents")
+	ErrBadUtf8 = errors.New("bad modified UTF-8")
+	ErrBadFlags = errors.New("illegal access flags")
+)
+
+//ParseError says what went wrong and where.  Offset is the byte offset in the class file
+type ParseError struct {
+	Err error;
+	Offset int;
+	Detail string;
+}
+
+func newParseError(e error, off int, detail string) *ParseError {
+	return &ParseError {
+		Err: e,
+		Offset: off,
+		Detail: detail,
+	}
+}
+
+func (e *ParseError) Error() string {
+	s := e.Err.Error() + " at offset " + strconv.Itoa(e.Offset)
+	if e.Detail != "" {
+		s = s + ": " + e.Detail
+	}
+	return s
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 //========================
@@ -110,21 +273,336 @@ func NewClassFile() *ClassFile {
 	};
 }
 
-func (cf *ClassFile) load(buf *Buffer) {
+//Parse reads a complete class file from r.  If the class file is bad, this returns
+//a *ParseError with the offset where it went wrong instead of crashing
+func Parse(r io.Reader) (*ClassFile, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cf := NewClassFile();
+	err = cf.load(NewBufferFromBytes(body));
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+//ParseFile opens the named class file and parses it.  The file is read as it is parsed,
+//instead of all at once
+func ParseFile(fname string) (*ClassFile, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	cf := NewClassFile();
+	err = cf.load(NewBuffer(f, st.Size()));
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+//========================
+// ClassPath.  This finds class files by their binary name, like com/acme/Foo,
+// in a list of directories and .jar or .zip files, in the same way as java -cp
+
+var ErrClassNotFound = errors.New("class not found")
+
+//one directory or archive on the class path.  read returns ErrClassNotFound if the
+//file isn't there
+type ClassPathEntry interface {
+	read(fname string) ([]byte, error)
+	close()
+}
+
+type ClassPath struct {
+	entries []ClassPathEntry;
+}
+
+//path is a list separated by os.PathListSeparator, like "lib/a.jar:classes".
+//An empty element means the current directory, and so does an empty path
+func NewClassPath(path string) (*ClassPath, error) {
+	cp := &ClassPath{}
+	if path == "" {
+		path = "."
+	}
+	seen := make(map[string]bool)
+	for _, p := range filepath.SplitList(path) {
+		if p == "" {
+			p = "."
+		}
+		err := cp.add(p, seen, false)
+		if err != nil {
+			cp.Close()
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+//add a directory or an archive, and then the archives in the Class-Path of its manifest, which are
+//relative to it.  Like java, an entry that is already there is skipped, and so is an archive from a
+//Class-Path that can't be opened
+func (cp *ClassPath) add(p string, seen map[string]bool, fromManifest bool) error {
+	p = filepath.Clean(p)
+	if seen[p] {
+		return nil
+	}
+	seen[p] = true
+	lp := strings.ToLower(p)
+	if !strings.HasSuffix(lp, ".jar") && !strings.HasSuffix(lp, ".zip") {
+		cp.entries = append(cp.entries, &DirEntry{dir: p})
+		return nil
+	}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		if fromManifest {
+			return nil
+		}
+		return err
+	}
+	jar := &JarEntry{path: p, zr: zr}
+	cp.entries = append(cp.entries, jar)
+	body, err := jar.read("META-INF/MANIFEST.MF")
+	if err == ErrClassNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, u := range strings.Fields(manifestAttribute(body, "Class-Path")) {
+		err = cp.add(filepath.Join(filepath.Dir(p), filepath.FromSlash(u)), seen, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cp *ClassPath) Close() {
+	for _, e := range cp.entries {
+		e.close()
+	}
+}
+
+//read the bytes of a class.  The name can use dots or slashes, and may end in .class
+func (cp *ClassPath) readClass(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".class")
+	name = strings.ReplaceAll(name, ".", "/") + ".class"
+	for _, e := range cp.entries {
+		body, err := e.read(name)
+		if err == ErrClassNotFound {
+			continue
+		}
+		return body, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrClassNotFound, name)
+}
+
+//find a class and load it
+func (cp *ClassPath) LoadClass(name string) (*ClassFile, error) {
+	body, err := cp.readClass(name)
+	if err != nil {
+		return nil, err
+	}
+	cf := NewClassFile();
+	err = cf.load(NewBufferFromBytes(body));
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+//return the Main-Class from the manifest of the first jar that has one, or ""
+func (cp *ClassPath) MainClass() (string, error) {
+	for _, e := range cp.entries {
+		jar, ok := e.(*JarEntry)
+		if !ok {
+			continue
+		}
+		body, err := jar.read("META-INF/MANIFEST.MF")
+		if err == ErrClassNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		main := manifestAttribute(body, "Main-Class")
+		if main != "" {
+			return main, nil
+		}
+	}
+	return "", nil
+}
+
+//look up an attribute in the main section of a manifest.  Long values are
+//split over several lines, where each extra line starts with a space
+func manifestAttribute(body []byte, key string) string {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == "" {
+			//the main section ends at the first blank line
+			break
+		}
+		k, v, ok := strings.Cut(lines[i], ":")
+		if !ok || !strings.EqualFold(k, key) {
+			continue
+		}
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], " ") {
+			i++
+			v = v + lines[i][1:]
+		}
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+//-----------------------
+type DirEntry struct {
+	dir string;
+}
+
+func (d *DirEntry) read(fname string) ([]byte, error) {
+	body, err := os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(fname)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrClassNotFound
+	}
+	return body, err
+}
+
+func (d *DirEntry) close() {}
+
+//-----------------------
+type JarEntry struct {
+	path string;
+	zr *zip.ReadCloser;
+}
+
+func (j *JarEntry) read(fname string) ([]byte, error) {
+	f, err := j.zr.Open(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrClassNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (j *JarEntry) close() {
+	j.zr.Close()
+}
+
+//load the class named on the command line.  args are the arguments after the program name:
+//	[-cp path] name args...
+//where name is a .class file, a .jar with a Main-Class, or a class on the class path,
+//which defaults to $CLASSPATH or the current directory.  This returns the class, the class path
+//to load the other classes from, the name to show in messages and the arguments that are left.
+//The caller closes the class path
+func loadFromArgs(args []string) (*ClassFile, *ClassPath, string, []string, error) {
+	path := os.Getenv("CLASSPATH")
+	if len(args) > 1 && (args[0] == "-cp" || args[0] == "-classpath") {
+		path = args[1]
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return nil, nil, "", nil, errors.New("missing class name")
+	}
+	name := args[0]
+	args = args[1:]
+	if strings.HasSuffix(name, ".class") {
+		_, err := os.Stat(name)
+		if err == nil {
+			//the classes it uses are probably next to it
+			cp, err := NewClassPath(filepath.Dir(name) + string(os.PathListSeparator) + path)
+			if err != nil {
+				return nil, nil, name, args, err
+			}
+			cf, err := ParseFile(name)
+			return cf, cp, name, args, err
+		}
+	}
+	lname := strings.ToLower(name)
+	if strings.HasSuffix(lname, ".jar") || strings.HasSuffix(lname, ".zip") {
+		//java -jar ignores the class path
+		path = name
+	}
+	cp, err := NewClassPath(path)
+	if err != nil {
+		return nil, nil, name, args, err
+	}
+	if path == name {
+		main, err := cp.MainClass()
+		if err != nil {
+			return nil, cp, name, args, err
+		}
+		if main == "" {
+			return nil, cp, name, args, errors.New("no Main-Class in manifest")
+		}
+		name = main
+	}
+	cf, err := cp.LoadClass(name)
+	return cf, cp, name, args, err
+}
+
+
+func (cf *ClassFile) load(buf *Buffer) error {
 	cf.magic = buf.readUInt();
+	if buf.err != nil {
+		return buf.err
+	}
+	if cf.magic != 0xCAFEBABE {
+		return newParseError(ErrBadMagic, 0, fmt.Sprintf("0x%08X", cf.magic))
+	}
 	cf.minor_version = buf.readUShort();
 	cf.major_version = buf.readUShort();
 	
 	//pool
 	pcount := buf.readUShort();
-	fmt.Println("pool count is "+ strconv.Itoa(int(pcount)) );
+	if buf.err != nil {
+		return buf.err
+	}
+	if debug {
+		fmt.Fprintln(os.Stderr, "pool count is "+ strconv.Itoa(int(pcount)) );
+	}
 	cf.pool = NewConstantPool(pcount);
-	cf.pool.load(buf);
-	cf.pool.improve();
+	cf.pool.major_version = cf.major_version;
+	err := cf.pool.load(buf);
+	if err != nil {
+		return err
+	}
+	err = cf.pool.improve();
+	if err != nil {
+		return err
+	}
 
+	//the offsets of the access flags, for error messages
+	offs := []int{buf.pos}
 	cf.access_flags = buf.readUShort();
+	off := buf.pos
 	cf.this_class = buf.readUShort();
 	cf.super_class = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	_, err = cf.pool.entryOfType(cf.this_class, CONSTANT_Class, off)
+	if err != nil {
+		return err
+	}
+	//only java/lang/Object has no superclass
+	if cf.super_class != 0 {
+		_, err = cf.pool.entryOfType(cf.super_class, CONSTANT_Class, off+2)
+		if err != nil {
+			return err
+		}
+	}
 
 	//interfaces
 	cf.interfaces_count = buf.readUShort();
@@ -132,7 +610,15 @@ func (cf *ClassFile) load(buf *Buffer) {
 	for i :=uint16(0); i<cf.interfaces_count; i++ {
 	    //The constant_pool entry at each value of interfaces[i] 
 	    //must be a CONSTANT_Class_info structure
+	    off = buf.pos
 	    cf.interfaces[i]=buf.readUShort();
+	    if buf.err != nil {
+	    	return buf.err
+	    }
+	    _, err = cf.pool.entryOfType(cf.interfaces[i], CONSTANT_Class, off)
+	    if err != nil {
+	    	return err
+	    }
     }
 	
 	//fields
@@ -140,7 +626,11 @@ func (cf *ClassFile) load(buf *Buffer) {
 	cf.fields = make([]*MemberInfo,cf.fields_count);
 	for i :=uint16(0); i<cf.fields_count; i++ {	
 		cf.fields[i] = NewMemberInfo(cf.pool);
-		cf.fields[i].load(buf);
+		offs = append(offs, buf.pos)
+		err = cf.fields[i].load(buf);
+		if err != nil {
+			return err
+		}
 	}
 	
 	//methods
@@ -148,17 +638,36 @@ func (cf *ClassFile) load(buf *Buffer) {
 	cf.methods = make([]*MemberInfo,cf.methods_count);
 	for i :=uint16(0); i<cf.methods_count; i++ {	
 		cf.methods[i] = NewMemberInfo(cf.pool);
-		cf.methods[i].load(buf);
+		offs = append(offs, buf.pos)
+		err = cf.methods[i].load(buf);
+		if err != nil {
+			return err
+		}
+	}
+	err = cf.checkFlags(offs);
+	if err != nil {
+		return err
 	}
 
 	//load attributes
 	//n := buf.readUShort();
 	cf.attributes_count = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
 	if (cf.attributes_count > uint16(0)) {
 		at := NewAttributeTable(cf.pool, cf.attributes_count);
-		at.load(buf);
+		err = at.load(buf);
+		if err != nil {
+			return err
+		}
 		cf.attribute_table = at;
 	}
+	//the class file ends with its attributes
+	if buf.remaining() != 0 {
+		return newParseError(ErrBadLength, buf.pos, strconv.Itoa(buf.remaining())+" bytes after the attributes of the class")
+	}
+	return nil
 }
 
 func (cf *ClassFile) dump_fields() {
@@ -177,13 +686,45 @@ func (cf *ClassFile) dump_methods() {
 	}
 }
 
-//func (cf *ClassFile) getClassName() string {
-//	k := cf.pool.getConstant(index);
+func (cf *ClassFile) getClassName() string {
+	k := cf.pool.getConstant(int(cf.this_class));
+
+	//the type has to be CONSTANT_Class or CONSTANT_String or this will crash.
+	//Parse has already checked it
+	cc := k.(*CONSTANT_String_info);
+	return cc.cstr;
+}
+
+//the name of the super class, or "" for java/lang/Object, which doesn't have one
+func (cf *ClassFile) getSuperClassName() string {
+	if (cf.super_class == 0) {
+		return "";
+	}
+	return cf.pool.getName(int(cf.super_class));
+}
+
+//the names of the interfaces that this class implements
+func (cf *ClassFile) getInterfaceNames() []string {
+	names := []string{};
+	for _, ix := range cf.interfaces {
+		names = append(names, cf.pool.getName(int(ix)));
+	}
+	return names;
+}
 
-	//the type has to be CONSTANT_Class or CONSTANT_String or this will crash
-//	cc := k.(*CONSTANT_String_info);
-//	return cc.cstr;
-//}
+//the name from the SourceFile attribute, or "" if there isn't one
+func (cf *ClassFile) sourceFileName() string {
+	if (cf.attribute_table == nil) {
+		return "";
+	}
+	for _, attr := range cf.attribute_table.attributes {
+		sf, ok := attr.(*SourceFile_attribute);
+		if (ok) {
+			return cf.pool.getName(int(sf.sourcefile_index));
+		}
+	}
+	return "";
+}
 
 //==============================
 
@@ -206,6 +747,14 @@ const (
 	ACC_ANNOTATION =          0x2000;
 	ACC_ENUM =                0x4000;
 	ACC_MODULE        		= 0x8000;
+	//these share values with the flags above, but are used in other places
+	ACC_BRIDGE =              0x0040;
+	ACC_VARARGS =             0x0080;
+	ACC_STRICT =              0x0800;
+	ACC_OPEN =                0x0020;
+	ACC_TRANSITIVE =          0x0020;
+	ACC_STATIC_PHASE =        0x0040;
+	ACC_MANDATED =            0x8000;
 )
 
 // constant tags
@@ -229,98 +778,380 @@ const (
 	CONSTANT_Package = 				  20;
 )
 
-//==============================================
-//ConstantPool class
-type ConstantPool struct {
-    constant_pool_count  uint16;
-    //cp_info[constant_pool_count-1] constant_pool;
-    //The constant_pool table is indexed from 1 to constant_pool_count-1
-    constant_pool []CP_Info;	
+//==============================
+// Access flags.  The same bit means different things in different places, for example 0x0020
+// is ACC_SUPER on a class and ACC_SYNCHRONIZED on a method, so each place has its own type
+
+type ClassFlags uint16
+type FieldFlags uint16
+type MethodFlags uint16
+type InnerClassFlags uint16
+//used to turn access flags into java modifiers, in the order java writes them
+type flagName struct {
+	flag uint16;
+	name string;
 }
 
-//rant: interfaces suck in Golang.  Use them very sparingly
-//Research: "X does not implement Y (... method has a pointer receiver)"
-//This compile-time error arises when you try to assign or pass (or convert) a concrete type to 
-//an interface type; and the type itself does not implement the interface, only a pointer to the type.
-type CP_Info interface {
-    ctype() uint8;
-    dump();
+var classFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_ABSTRACT, "abstract"}, {ACC_FINAL, "final"},
 }
-// this should be part of the interface, but instead it is just a convention
-//	load(b *Buffer);
 
+var fieldFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_PRIVATE, "private"}, {ACC_PROTECTED, "protected"},
+	{ACC_STATIC, "static"}, {ACC_FINAL, "final"}, {ACC_VOLATILE, "volatile"}, {ACC_TRANSIENT, "transient"},
+}
 
+var methodFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_PRIVATE, "private"}, {ACC_PROTECTED, "protected"},
+	{ACC_ABSTRACT, "abstract"}, {ACC_STATIC, "static"}, {ACC_FINAL, "final"},
+	{ACC_SYNCHRONIZED, "synchronized"}, {ACC_NATIVE, "native"}, {ACC_STRICT, "strictfp"},
+}
 
-//create an empty ConstantPool
-func NewConstantPool(count uint16) *ConstantPool {
-	p := make([]CP_Info,count)
-	cp := &ConstantPool{
-		constant_pool_count: count,
-		constant_pool:  p,
+var innerClassFlagNames = []flagName {
+	{ACC_PUBLIC, "public"}, {ACC_PRIVATE, "private"}, {ACC_PROTECTED, "protected"},
+	{ACC_ABSTRACT, "abstract"}, {ACC_STATIC, "static"}, {ACC_FINAL, "final"},
+}
+//return the modifiers for the flags, with a trailing space if there are any
+func modifiers(flags uint16, names []flagName) string {
+	s := ""
+	for _, fn := range names {
+		if flags & fn.flag != 0 {
+			s = s + fn.name + " "
+		}
 	}
-	return cp;
+	return s
 }
 
-//to make this easier to use, this is the size of the constant pool.
-//entry 0 is empty and the entries from 1..size()-1 are used
-func (p *ConstantPool) size() int {
-	return int(p.constant_pool_count)
+//the modifiers as java writes them, like "public static final"
+func (f ClassFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), classFlagNames))
 }
 
-//return the tag of the entry
-func (p *ConstantPool) tag(n int) int {
-	return int(p.constant_pool[n].ctype());
+func (f FieldFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), fieldFlagNames))
 }
 
-func (p *ConstantPool) getConstant(idx int) CP_Info {
-	return p.constant_pool[idx];
+func (f MethodFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), methodFlagNames))
 }
 
-//return the closest value to a "name" or string
-//	for entry 0 this will be empty ("")
-//	for utf8, this will the ascii value
-//	for class or string, this will the ascii value
-//  for NameAndType, this will be the name
-//	for fields, methods and interfaces, this will be the name
-//	for other values, this will be empty
-func (p *ConstantPool) getName(n int) string {
-	if n > 1 {return ""}
-	t := p.tag(n)
-	k := p.constant_pool[n];
-	switch(t) {
-		case CONSTANT_Utf8:
-			u := k.(*CONSTANT_Utf8_info);
-			return u.utf8;
-		case CONSTANT_Class, CONSTANT_String:
-			cs := k.(*CONSTANT_String_info);
-			return cs.cstr;
-		case CONSTANT_NameAndType:
-			cnat := k.(*CONSTANT_NameAndType_info);
-			return cnat.name;
-		case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
-			r := k.(*CONSTANT_ref_info);
-			return r.name;
-		default:
-			fmt.Println("DEBUG: getName() requested for type "+strconv.Itoa(t))
-			return "";
+func (f InnerClassFlags) String() string {
+	return strings.TrimSpace(modifiers(uint16(f), innerClassFlagNames))
+}
+func (f ClassFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f FieldFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f MethodFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+func (f InnerClassFlags) has(flag uint16) bool {
+	return uint16(f) & flag != 0
+}
+
+//the number of flags in the list that are set
+func countFlags(flags uint16, list ...uint16) int {
+	n := 0
+	for _, x := range list {
+		if flags & x != 0 {
+			n++
+		}
 	}
+	return n
 }
 
-//note that entry 0 is unused
-func (p *ConstantPool) insert(num uint16,entry CP_Info) {
-	fmt.Println("DEBUG: entering pool # "+strconv.Itoa(int(num))+" of type "+strconv.Itoa(int(entry.ctype())))
-	p.constant_pool[num] = entry
+func (cf *ClassFile) flags() ClassFlags {
+	return ClassFlags(cf.access_flags)
 }
 
-func (p *ConstantPool) load(buf *Buffer) {
-	//the constant pool starts at 1. leave 0 empty
-	for i := uint16(1);i<p.constant_pool_count;i++ {
-		//read the tag
-		t := buf.readByte();
-		switch(t) {
+func (m *MemberInfo) fieldFlags() FieldFlags {
+	return FieldFlags(m.access_flags)
+}
+
+func (m *MemberInfo) methodFlags() MethodFlags {
+	return MethodFlags(m.access_flags)
+}
+
+//check the class flags (JVMS 4.1).  This returns what is wrong, or "" if they are fine
+func (f ClassFlags) check(major uint16) string {
+	if f.has(ACC_MODULE) {
+		if uint16(f) != ACC_MODULE {
+			return "a module can't have any other flags"
+		}
+		return ""
+	}
+	if f.has(ACC_INTERFACE) {
+		//before version 50 the JVM adds abstract to interfaces itself
+		if major >= 50 && !f.has(ACC_ABSTRACT) {
+			return "an interface must be abstract"
+		}
+		if f.has(ACC_FINAL) || f.has(ACC_SUPER) || f.has(ACC_ENUM) {
+			return "an interface can't be final, super or an enum"
+		}
+		return ""
+	}
+	if f.has(ACC_ANNOTATION) {
+		return "an annotation must be an interface"
+	}
+	if f.has(ACC_FINAL) && f.has(ACC_ABSTRACT) {
+		return "a class can't be both abstract and final"
+	}
+	return ""
+}
+
+//check the flags of a field (JVMS 4.5).  iface is true for a field of an interface
+func (f FieldFlags) check(iface bool) string {
+	if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED) > 1 {
+		return "more than one of public, private and protected"
+	}
+	if f.has(ACC_FINAL) && f.has(ACC_VOLATILE) {
+		return "a field can't be both final and volatile"
+	}
+	if iface {
+		if !f.has(ACC_PUBLIC) || !f.has(ACC_STATIC) || !f.has(ACC_FINAL) {
+			return "a field of an interface must be public static final"
+		}
+		if uint16(f) &^ (ACC_PUBLIC|ACC_STATIC|ACC_FINAL|ACC_SYNTHETIC) != 0 {
+			return "a field of an interface can only be public static final"
+		}
+	}
+	return ""
+}
+
+//check the flags of a method (JVMS 4.6).  The rules depend on the name, on whether it is
+//in an interface and on the class file version
+func (f MethodFlags) check(name string, iface bool, major uint16) string {
+	//the static initializer can have any flags.  They are ignored
+	if name == "<clinit>" {
+		return ""
+	}
+	if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED) > 1 {
+		return "more than one of public, private and protected"
+	}
+	if iface {
+		if major < 52 {
+			if !f.has(ACC_PUBLIC) || !f.has(ACC_ABSTRACT) {
+				return "a method of an interface must be public abstract"
+			}
+		} else {
+			if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE) != 1 {
+				return "a method of an interface must be public or private"
+			}
+			if countFlags(uint16(f), ACC_PROTECTED, ACC_FINAL, ACC_SYNCHRONIZED, ACC_NATIVE) > 0 {
+				return "a method of an interface can't be protected, final, synchronized or native"
+			}
+		}
+	}
+	if f.has(ACC_ABSTRACT) {
+		if countFlags(uint16(f), ACC_PRIVATE, ACC_STATIC, ACC_FINAL, ACC_SYNCHRONIZED, ACC_NATIVE) > 0 {
+			return "an abstract method can't be private, static, final, synchronized or native"
+		}
+		//strictfp means nothing from version 61 on
+		if f.has(ACC_STRICT) && major >= 46 && major < 61 {
+			return "an abstract method can't be strictfp"
+		}
+	}
+	if name == "<init>" {
+		if uint16(f) &^ (ACC_PUBLIC|ACC_PRIVATE|ACC_PROTECTED|ACC_VARARGS|ACC_STRICT|ACC_SYNTHETIC) != 0 {
+			return "a constructor can only be public, private, protected, varargs, strictfp or synthetic"
+		}
+	}
+	return ""
+}
+
+//check the flags of an inner class (JVMS 4.7.6).  These are the flags it has in the source, so
+//the rules for a class apply.  The bits an inner class doesn't use, like ACC_SUPER, are ignored
+func (f InnerClassFlags) check(major uint16) string {
+	if countFlags(uint16(f), ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED) > 1 {
+		return "more than one of public, private and protected"
+	}
+	used := uint16(ACC_PUBLIC|ACC_PRIVATE|ACC_PROTECTED|ACC_STATIC|ACC_FINAL|ACC_INTERFACE|ACC_ABSTRACT|
+		ACC_SYNTHETIC|ACC_ANNOTATION|ACC_ENUM)
+	return ClassFlags(uint16(f) & used).check(major)
+}
+
+//check the flags of the class, the fields and the methods.  offs has the offset of the
+//access_flags of the class, then each field and then each method
+func (cf *ClassFile) checkFlags(offs []int) error {
+	bad := func(off int, what string, msg string) error {
+		return newParseError(ErrBadFlags, off, what+": "+msg)
+	}
+	cflags := cf.flags()
+	msg := cflags.check(cf.major_version)
+	if msg != "" {
+		return bad(offs[0], "class", msg)
+	}
+	iface := cflags.has(ACC_INTERFACE)
+	for i, f := range cf.fields {
+		msg = f.fieldFlags().check(iface)
+		if msg != "" {
+			return bad(offs[1+i], "field "+f.name(), msg)
+		}
+	}
+	for i, m := range cf.methods {
+		msg = m.methodFlags().check(m.name(), iface, cf.major_version)
+		if msg != "" {
+			return bad(offs[1+len(cf.fields)+i], "method "+m.name()+m.sig(), msg)
+		}
+	}
+	return nil
+}
+
+//==============================================
+//ConstantPool class
+type ConstantPool struct {
+    constant_pool_count  uint16;
+    //cp_info[constant_pool_count-1] constant_pool;
+    //The constant_pool table is indexed from 1 to constant_pool_count-1
+    constant_pool []CP_Info;	
+    //the byte offset of each entry in the class file, used for error messages
+    offsets []int;
+    //the version of the class file, which decides which flags are allowed
+    major_version uint16;
+}
+
+//rant: interfaces suck in Golang.  Use them very sparingly
+//Research: "X does not implement Y (... method has a pointer receiver)"
+//This compile-time error arises when you try to assign or pass (or convert) a concrete type to 
+//an interface type; and the type itself does not implement the interface, only a pointer to the type.
+type CP_Info interface {
+    ctype() uint8;
+    dump();
+}
+// this should be part of the interface, but instead it is just a convention
+//	load(b *Buffer);
+
+
+
+//create an empty ConstantPool
+func NewConstantPool(count uint16) *ConstantPool {
+	p := make([]CP_Info,count)
+	cp := &ConstantPool{
+		constant_pool_count: count,
+		constant_pool:  p,
+		offsets: make([]int,count),
+	}
+	return cp;
+}
+
+//return entry n, or an error if n doesn't point at an entry.
+//off is the byte offset of the index, which is reported in the error
+func (p *ConstantPool) entry(n uint16, off int) (CP_Info, error) {
+	if n == 0 || n >= p.constant_pool_count || p.constant_pool[n] == nil {
+		return nil, newParseError(ErrBadIndex, off, "#"+strconv.Itoa(int(n))+" (pool count is "+strconv.Itoa(int(p.constant_pool_count))+")")
+	}
+	return p.constant_pool[n], nil
+}
+
+//same as entry, but also check that the entry has tag t
+func (p *ConstantPool) entryOfType(n uint16, t uint8, off int) (CP_Info, error) {
+	k, err := p.entry(n, off)
+	if err != nil {
+		return nil, err
+	}
+	if k.ctype() != t {
+		return nil, newParseError(ErrWrongTag, off, "#"+strconv.Itoa(int(n))+" has tag "+strconv.Itoa(int(k.ctype()))+", expecting "+strconv.Itoa(int(t)))
+	}
+	return k, nil
+}
+
+//return the string in the CONSTANT_Utf8_info at n
+func (p *ConstantPool) utf8At(n uint16, off int) (string, error) {
+	k, err := p.entryOfType(n, CONSTANT_Utf8, off)
+	if err != nil {
+		return "", err
+	}
+	return k.(*CONSTANT_Utf8_info).utf8, nil
+}
+
+//to make this easier to use, this is the size of the constant pool.
+//entry 0 is empty and the entries from 1..size()-1 are used
+func (p *ConstantPool) size() int {
+	return int(p.constant_pool_count)
+}
+
+//return the tag of the entry
+func (p *ConstantPool) tag(n int) int {
+	return int(p.constant_pool[n].ctype());
+}
+
+func (p *ConstantPool) getConstant(idx int) CP_Info {
+	return p.constant_pool[idx];
+}
+
+//return the closest value to a "name" or string
+//	for entry 0 this will be empty ("")
+//	for utf8, this will the ascii value
+//	for class or string, this will the ascii value
+//  for NameAndType, this will be the name
+//	for fields, methods and interfaces, this will be the name
+//	for method handles, dynamic constants and call sites, this will be the name
+//	for modules and packages, this will be the name
+//	for other values, this will be empty
+func (p *ConstantPool) getName(n int) string {
+	if n < 1 || n >= p.size() || p.constant_pool[n] == nil {return ""}
+	t := p.tag(n)
+	k := p.constant_pool[n];
+	switch(t) {
+		case CONSTANT_Utf8:
+			u := k.(*CONSTANT_Utf8_info);
+			return u.utf8;
+		case CONSTANT_Class, CONSTANT_String:
+			cs := k.(*CONSTANT_String_info);
+			return cs.cstr;
+		case CONSTANT_NameAndType:
+			cnat := k.(*CONSTANT_NameAndType_info);
+			return cnat.name;
+		case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+			r := k.(*CONSTANT_ref_info);
+			return r.name;
+		case CONSTANT_MethodHandle:
+			mh := k.(*CONSTANT_MethodHandle_info);
+			return mh.name;
+		case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+			dy := k.(*CONSTANT_Dynamic_info);
+			return dy.name;
+		case CONSTANT_Module, CONSTANT_Package:
+			mo := k.(*CONSTANT_Module_info);
+			return mo.name;
+		default:
+			if debug {
+				fmt.Fprintln(os.Stderr, "DEBUG: getName() requested for type "+strconv.Itoa(t))
+			}
+			return "";
+	}
+}
+
+//note that entry 0 is unused
+func (p *ConstantPool) insert(num uint16,entry CP_Info) {
+	if debug {
+		fmt.Fprintln(os.Stderr, "DEBUG: entering pool # "+strconv.Itoa(int(num))+" of type "+strconv.Itoa(int(entry.ctype())))
+	}
+	p.constant_pool[num] = entry
+}
+
+func (p *ConstantPool) load(buf *Buffer) error {
+	//the constant pool starts at 1. leave 0 empty
+	for i := uint16(1);i<p.constant_pool_count;i++ {
+		p.offsets[i] = buf.pos
+		//read the tag
+		t := buf.readByte();
+		if buf.err != nil {
+			return buf.err
+		}
+		switch(t) {
 			case CONSTANT_Utf8:
 				u := NewUtf8Info();
-				u.load(buf);
+				bad := u.load(buf);
+				if bad >= 0 {
+					//the bytes start after the tag and the length
+					return newParseError(ErrBadUtf8, p.offsets[i]+3+bad, "in #"+strconv.Itoa(int(i)))
+				}
 				p.insert(i,u);
 			case CONSTANT_Integer:
 				ki := NewIntegerInfo();
@@ -354,82 +1185,139 @@ func (p *ConstantPool) load(buf *Buffer) {
 				cnat := NewNameAndTypeInfo();
 				cnat.load(buf);
 				p.insert(i,cnat);				
+			case CONSTANT_MethodHandle:
+				mh := NewMethodHandleInfo();
+				mh.load(buf);
+				p.insert(i,mh);
+			case CONSTANT_MethodType:
+				mt := NewMethodTypeInfo();
+				mt.load(buf);
+				p.insert(i,mt);
+			case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+				//these 2 are identical except for the tag
+				dy := NewDynamicInfo(t);
+				dy.load(buf);
+				p.insert(i,dy);
+			case CONSTANT_Module, CONSTANT_Package:
+				mo := NewModuleInfo(t);
+				mo.load(buf);
+				p.insert(i,mo);
 			default:										
-				fmt.Println("ERR: unable to handle Constant type "+ strconv.Itoa(int(t)) + " in constant pool");								
+				return newParseError(ErrUnknownTag, p.offsets[i], "tag "+strconv.Itoa(int(t))+" at #"+strconv.Itoa(int(i)))
+		}
+		if buf.err != nil {
+			return buf.err
 		}
 	}
+	return nil
 }
 
-func (pool *ConstantPool) improve() {
+func (pool *ConstantPool) improve() error {
 	//first pass
 	for i := uint16(1); i<pool.constant_pool_count; i++ {
 		it := pool.constant_pool[i];
-		mytag := it.ctype();
-		switch (mytag) {
+		if it == nil {
+			//this is the unused slot after a long or double
+			continue
+		}
+		off := pool.offsets[i];
+		switch (it.ctype()) {
 			case CONSTANT_Class, CONSTANT_String:
-				//the compiler is giving me errors so I need to expand this a bit
-				k1 := pool.constant_pool[i];
-				//cast the CP_Info to a string struct, which includes both classes and strings
-				ks,ok := k1.(*CONSTANT_String_info);  
-				if !ok {
-					fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(i)) + " to a CONSTANT_String_info");
-				}
-				k2 := pool.constant_pool[ks.name_index];
-				uk2,ok := k2.(*CONSTANT_Utf8_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(ks.name_index)) + " to a *CONSTANT_Utf8_info");
-				}				
-				ks.cstr = uk2.utf8
+				//the CONSTANT_String_info struct holds both classes and strings
+				ks := it.(*CONSTANT_String_info);  
+				str, err := pool.utf8At(ks.name_index, off+1);
+				if err != nil {
+					return err
+				}
+				ks.cstr = str
 			case CONSTANT_NameAndType:
-				k3 := pool.constant_pool[i];
-				cnat,ok := k3.(*CONSTANT_NameAndType_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(i)) + " to a *CONSTANT_NameAndType_info");
-				}					
-				k4 := pool.constant_pool[cnat.name_index];
-				uk4,ok := k4.(*CONSTANT_Utf8_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(i)) + " to a *CONSTANT_Utf8_info");
-				}					
-				k5 := pool.constant_pool[cnat.descriptor_index];
-				uk5,ok := k5.(*CONSTANT_Utf8_info);
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(i)) + " to a *CONSTANT_Utf8_info");
-				}	
-				cnat.name = uk4.utf8;
-				cnat.descriptor=uk5.utf8;
+				cnat := it.(*CONSTANT_NameAndType_info);
+				name, err := pool.utf8At(cnat.name_index, off+1);
+				if err != nil {
+					return err
+				}
+				desc, err := pool.utf8At(cnat.descriptor_index, off+3);
+				if err != nil {
+					return err
+				}
+				cnat.name = name;
+				cnat.descriptor = desc;
+			case CONSTANT_MethodType:
+				mt := it.(*CONSTANT_MethodType_info);
+				desc, err := pool.utf8At(mt.descriptor_index, off+1);
+				if err != nil {
+					return err
+				}
+				mt.descriptor = desc;
+			case CONSTANT_Module, CONSTANT_Package:
+				mo := it.(*CONSTANT_Module_info);
+				name, err := pool.utf8At(mo.name_index, off+1);
+				if err != nil {
+					return err
+				}
+				mo.name = name;
 		}
 	}
 	//second pass, do field,method, interface
-	
-	//fmt.Println("DEBUG: skipping 2nd part of improve constant pool")
-	
 	for j := uint16(1); j<pool.constant_pool_count; j++ {
-		jt := pool.constant_pool[j].ctype();
-		switch (jt) {
+		it := pool.constant_pool[j];
+		if it == nil {
+			continue
+		}
+		off := pool.offsets[j];
+		switch (it.ctype()) {
 			case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
-				k6 := pool.constant_pool[j];
-				cry,ok := k6.(*CONSTANT_ref_info)
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(j)) + " to a *CONSTANT_ref_info");
-				}
-				k7 := pool.constant_pool[cry.class_index]
+				cry := it.(*CONSTANT_ref_info)
 				//get the name of the class, which is in the Constant_String_info
-				klass,ok := k7.(*CONSTANT_String_info)
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(cry.class_index)) + " to a *Constant_String_info");
-				}				
-				k8 := pool.constant_pool[cry.name_and_type_index]
-				cnat, ok := k8.(*CONSTANT_NameAndType_info)
-				if !ok {
-					fmt.Println("ERR: unable to convert constant # " + strconv.Itoa(int(cry.name_and_type_index)) + " to a *CONSTANT_NameAndType_info");
-				}					
-				cry.cname=klass.cstr;
+				k7, err := pool.entryOfType(cry.class_index, CONSTANT_Class, off+1)
+				if err != nil {
+					return err
+				}
+				k8, err := pool.entryOfType(cry.name_and_type_index, CONSTANT_NameAndType, off+3)
+				if err != nil {
+					return err
+				}
+				cnat := k8.(*CONSTANT_NameAndType_info)
+				cry.cname=k7.(*CONSTANT_String_info).cstr;
 				cry.name=cnat.name;
 				cry.descriptor=cnat.descriptor;
+			case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+				dy := it.(*CONSTANT_Dynamic_info)
+				k9, err := pool.entryOfType(dy.name_and_type_index, CONSTANT_NameAndType, off+3)
+				if err != nil {
+					return err
+				}
+				cnat := k9.(*CONSTANT_NameAndType_info)
+				dy.name=cnat.name;
+				dy.descriptor=cnat.descriptor;
 		}
 	}
-	
+	//third pass, method handles point at the refs from the second pass
+	for j := uint16(1); j<pool.constant_pool_count; j++ {
+		it := pool.constant_pool[j];
+		if it == nil || it.ctype() != CONSTANT_MethodHandle {
+			continue
+		}
+		off := pool.offsets[j];
+		mh := it.(*CONSTANT_MethodHandle_info)
+		tags := mh.allowedTags()
+		if tags == nil {
+			return newParseError(ErrBadRefKind, off+1, "kind "+strconv.Itoa(int(mh.reference_kind))+" at #"+strconv.Itoa(int(j)))
+		}
+		k10, err := pool.entry(mh.reference_index, off+2)
+		if err != nil {
+			return err
+		}
+		cry, ok := k10.(*CONSTANT_ref_info)
+		if ok && (cry.tag == tags[0] || (len(tags) > 1 && cry.tag == tags[1])) {
+			mh.cname=cry.cname;
+			mh.name=cry.name;
+			mh.descriptor=cry.descriptor;
+		} else {
+			return newParseError(ErrWrongTag, off+2, "#"+strconv.Itoa(int(mh.reference_index))+" has tag "+strconv.Itoa(int(k10.ctype()))+", which doesn't match method handle kind "+strconv.Itoa(int(mh.reference_kind)))
+		}
+	}
+	return nil
 }	//end improve
 
 
@@ -562,15 +1450,10 @@ func (k *CONSTANT_Float_info) ctype() uint8 {
 	return k.tag;
 }
 
-// test this!
 func (k *CONSTANT_Float_info) load(buf *Buffer) {
 	b4 := buf.read4Bytes()
-	//this is in little-endian format, does it matter?
 	k.bytes = buf.toUint32(b4)
-	//convert the 4-byte slice to bits in big-endian format		
-    bits := binary.BigEndian.Uint32(b4)
-    //finally, use math to convert it to a float
-    k.fval = math.Float32frombits(bits)
+	k.fval = math.Float32frombits(k.bytes)
 }
 
 func (k* CONSTANT_Float_info) dump() {
@@ -579,8 +1462,6 @@ func (k* CONSTANT_Float_info) dump() {
 }
 
 //================================
-// I'm not going to support longs in my program, but we need to be able to read them
-// in from the class file if they are present
 type CONSTANT_Long_info struct {
 	//The tag item of the CONSTANT_Long_info structure has the value CONSTANT_Long (5).
 	tag uint8;
@@ -600,24 +1481,23 @@ func (k *CONSTANT_Long_info) ctype() uint8 {
 }
 
 func (k *CONSTANT_Long_info) load(buf *Buffer) {
-	k.high_bytes = buf.readUInt()
-	k.low_bytes = buf.readUInt()
-	//TO DO: convert to int64
+	v := buf.readULong()
+	k.high_bytes = uint32(v>>32)
+	k.low_bytes = uint32(v)
+	k.lval = int64(v)
 }
 
 func (k *CONSTANT_Long_info) dump() {
-	fmt.Print("[Long: (unimplemented)]");
+	fmt.Print("[Long: "+strconv.FormatInt(k.lval, 10)+"]");
 }
 
 //================================
-//not fully supported
-//it wouldn't take that much work but it can be done later
 type CONSTANT_Double_info struct {
-	//The tag item of the CONSTANT_Long_info structure has the value CONSTANT_Long (5).
+	//The tag item of the CONSTANT_Double_info structure has the value CONSTANT_Double (6).
 	tag uint8;
     high_bytes uint32;
     low_bytes uint32;
-    dval float64;	//not implemented
+    dval float64;
 }
 
 func NewDoubleInfo() *CONSTANT_Double_info {
@@ -631,12 +1511,14 @@ func (k *CONSTANT_Double_info) ctype() uint8 {
 }
 
 func (k *CONSTANT_Double_info) load(buf *Buffer) {
-	k.high_bytes = buf.readUInt()
-	k.low_bytes = buf.readUInt()
+	v := buf.readULong()
+	k.high_bytes = uint32(v>>32)
+	k.low_bytes = uint32(v)
+	k.dval = math.Float64frombits(v)
 }
 
 func (k *CONSTANT_Double_info) dump() {
-	fmt.Print("[Double: (unimplemented)]");
+	fmt.Print("[Double: "+strconv.FormatFloat(k.dval, 'g', -1, 64)+"]");
 }
 
 
@@ -684,6 +1566,8 @@ type CONSTANT_Utf8_info struct {
     length uint16;
     //u1 bytes[length];
     bytes []byte;
+    //the bytes decoded to UTF-16, like the chars in a Java String
+    chars []uint16;
     utf8 string;
 }
 
@@ -697,22 +1581,213 @@ func (k *CONSTANT_Utf8_info) ctype() uint8 {
 	return k.tag;
 }
 
-func (k *CONSTANT_Utf8_info) load(buf *Buffer) {
+//this returns the offset in the bytes of the first bad byte, or -1 if there isn't one
+func (k *CONSTANT_Utf8_info) load(buf *Buffer) int {
 	k.length = buf.readUShort();
 	if (k.length >0) {
-		k.bytes = make([]byte,k.length)
-		var i uint16 = 0
-    	for i = 0; i<k.length; i++ {
-    		k.bytes[i]=buf.readByte();
-    	}		
+		k.bytes = buf.readBytes(int(k.length))
+	}
+	if buf.err != nil {
+		return -1
+	}
+	chars, bad := decodeModifiedUTF8(k.bytes)
+	k.chars = chars;
+	//a string with a lone surrogate can't be a Go string.  It gets U+FFFD instead
+	k.utf8 = string(utf16.Decode(chars));
+	return bad
+}
+
+//decode Java's modified UTF-8 (JVMS 4.4.7) into UTF-16 chars, which is what a Java String holds.
+//It differs from standard UTF-8 in 2 ways: NUL is written as the 2 bytes C0 80, and a character
+//outside the BMP is written as its 2 surrogates, 3 bytes each, instead of as 4 bytes.
+//This returns the offset of the first bad byte, or -1 if they are all good
+func decodeModifiedUTF8(b []byte) ([]uint16, int) {
+	chars := make([]uint16, 0, len(b))
+	i := 0
+	for i < len(b) {
+		c := b[i]
+		switch {
+			case c != 0 && c < 0x80:
+				chars = append(chars, uint16(c))
+				i++
+			case c & 0xE0 == 0xC0:
+				if i+1 >= len(b) || b[i+1] & 0xC0 != 0x80 {
+					return chars, i
+				}
+				chars = append(chars, uint16(c & 0x1F)<<6 | uint16(b[i+1] & 0x3F))
+				i = i + 2
+			case c & 0xF0 == 0xE0:
+				if i+2 >= len(b) || b[i+1] & 0xC0 != 0x80 || b[i+2] & 0xC0 != 0x80 {
+					return chars, i
+				}
+				chars = append(chars, uint16(c & 0x0F)<<12 | uint16(b[i+1] & 0x3F)<<6 | uint16(b[i+2] & 0x3F))
+				i = i + 3
+			default:
+				//0 and the bytes from F0 on never appear
+				return chars, i
+		}
 	}
-	k.utf8 = string(k.bytes);
+	return chars, -1
 }
 
+
 func (k *CONSTANT_Utf8_info) dump() {
 	fmt.Print("[Utf8: "+k.utf8+"]");
 }
 
+//================================
+//A method handle points at a field or method ref.  The kind says what the handle does with it
+const (
+	REF_getField =            1;
+	REF_getStatic =           2;
+	REF_putField =            3;
+	REF_putStatic =           4;
+	REF_invokeVirtual =       5;
+	REF_invokeStatic =        6;
+	REF_invokeSpecial =       7;
+	REF_newInvokeSpecial =    8;
+	REF_invokeInterface =     9;
+)
+
+type CONSTANT_MethodHandle_info struct {
+	//The tag item of the CONSTANT_MethodHandle_info structure has the value CONSTANT_MethodHandle (15).
+	tag uint8;
+    reference_kind uint8;
+    reference_index uint16;
+    //these are copied from the ref in improve()
+    cname string;
+    name string;
+    descriptor string;
+}
+
+func NewMethodHandleInfo() *CONSTANT_MethodHandle_info {
+	return &CONSTANT_MethodHandle_info {
+		tag: CONSTANT_MethodHandle,
+	}
+}
+
+func (k *CONSTANT_MethodHandle_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_MethodHandle_info) load(buf *Buffer) {
+	k.reference_kind = buf.readByte();
+	k.reference_index = buf.readUShort();
+}
+
+func (k *CONSTANT_MethodHandle_info) dump() {
+	fmt.Print("[MethodHandle: (kind "+strconv.Itoa(int(k.reference_kind))+") (class"+k.cname+") "+k.name+" (sig "+k.descriptor+")]");
+}
+
+//return the tags that the reference_index may point to for this kind
+func (k *CONSTANT_MethodHandle_info) allowedTags() []uint8 {
+	switch (k.reference_kind) {
+		case REF_getField, REF_getStatic, REF_putField, REF_putStatic:
+			return []uint8{CONSTANT_Fieldref}
+		case REF_invokeVirtual, REF_newInvokeSpecial:
+			return []uint8{CONSTANT_Methodref}
+		case REF_invokeStatic, REF_invokeSpecial:
+			//interface methods are allowed from class file version 52
+			return []uint8{CONSTANT_Methodref, CONSTANT_InterfaceMethodref}
+		case REF_invokeInterface:
+			return []uint8{CONSTANT_InterfaceMethodref}
+	}
+	return nil
+}
+
+//================================
+type CONSTANT_MethodType_info struct {
+	//The tag item of the CONSTANT_MethodType_info structure has the value CONSTANT_MethodType (16).
+	tag uint8;
+    descriptor_index uint16;
+    descriptor string;
+}
+
+func NewMethodTypeInfo() *CONSTANT_MethodType_info {
+	return &CONSTANT_MethodType_info {
+		tag: CONSTANT_MethodType,
+	}
+}
+
+func (k *CONSTANT_MethodType_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_MethodType_info) load(buf *Buffer) {
+	k.descriptor_index = buf.readUShort();
+}
+
+func (k *CONSTANT_MethodType_info) dump() {
+	fmt.Print("[MethodType: "+k.descriptor+"]");
+}
+
+//================================
+// This holds either a Dynamic (17) or an InvokeDynamic (18), distinguished by the tag.
+// Dynamic is a computed constant and InvokeDynamic is a call site, but the structure is the same
+type CONSTANT_Dynamic_info struct {
+	tag uint8;
+	//this is an index into the bootstrap_methods array of the BootstrapMethods attribute,
+	//not into the constant pool
+    bootstrap_method_attr_index uint16;
+    name_and_type_index uint16;
+    name string;
+    descriptor string;
+}
+
+func NewDynamicInfo(t uint8) *CONSTANT_Dynamic_info {
+	return &CONSTANT_Dynamic_info {
+		tag: t,
+	}
+}
+
+func (k *CONSTANT_Dynamic_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_Dynamic_info) load(buf *Buffer) {
+	k.bootstrap_method_attr_index = buf.readUShort();
+	k.name_and_type_index = buf.readUShort();
+}
+
+func (k *CONSTANT_Dynamic_info) dump() {
+	if k.tag == CONSTANT_Dynamic {
+		fmt.Print("[Dynamic: (bootstrap "+strconv.Itoa(int(k.bootstrap_method_attr_index))+") "+k.name+" (sig "+k.descriptor+")]");
+	} else if k.tag == CONSTANT_InvokeDynamic {
+		fmt.Print("[InvokeDynamic: (bootstrap "+strconv.Itoa(int(k.bootstrap_method_attr_index))+") "+k.name+" (sig "+k.descriptor+")]");
+	}
+}
+
+//================================
+// This holds either a Module (19) or a Package (20), distinguished by the tag.
+// These are only found in module-info.class
+type CONSTANT_Module_info struct {
+	tag uint8;
+	name_index uint16;
+	name string;
+}
+
+func NewModuleInfo(t uint8) *CONSTANT_Module_info {
+	return &CONSTANT_Module_info {
+		tag: t,
+	}
+}
+
+func (k *CONSTANT_Module_info) ctype() uint8 {
+	return k.tag;
+}
+
+func (k *CONSTANT_Module_info) load(buf *Buffer) {
+	k.name_index = buf.readUShort();
+}
+
+func (k *CONSTANT_Module_info) dump() {
+	if k.tag == CONSTANT_Module {
+		fmt.Print("[Module: "+k.name+"]");
+	} else if k.tag == CONSTANT_Package {
+		fmt.Print("[Package: "+k.name+"]");
+	}
+}
+
 //=========================================
 //=========================================
 
@@ -740,34 +1815,40 @@ func NewMemberInfo(p *ConstantPool) *MemberInfo {
 	}
 }
 
-func (m *MemberInfo) load (buf *Buffer) {
+func (m *MemberInfo) load (buf *Buffer) error {
 	m.access_flags = buf.readUShort();
+	off := buf.pos
     m.name_index = buf.readUShort();
     m.descriptor_index = buf.readUShort();
     
 	//load attributes
 	m.attributes_count = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
 	if (m.attributes_count > uint16(0)) {
 		at := NewAttributeTable(m.pool, m.attributes_count);
-		at.load(buf);
+		err := at.load(buf);
+		if err != nil {
+			return err
+		}
 		m.attribute_table = at;
 	}
 	
 	//load name
-	k := m.pool.constant_pool[m.name_index];
-	uk,ok := k.(*CONSTANT_Utf8_info);
-	if !ok {
-		fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(m.name_index)) + " to a *CONSTANT_Utf8_info");
-	}				
-	m.member_name = uk.utf8
+	name, err := m.pool.utf8At(m.name_index, off);
+	if err != nil {
+		return err
+	}
+	m.member_name = name
 				
 	//load descriptor
-	k2 := m.pool.constant_pool[m.descriptor_index];
-	uk2,ok := k2.(*CONSTANT_Utf8_info);
-	if !ok {
-		fmt.Println("ERR: unable to convert constant #" + strconv.Itoa(int(m.descriptor_index)) + " to a *CONSTANT_Utf8_info");
-	}				
-	m.descriptor = uk2.utf8
+	desc, err := m.pool.utf8At(m.descriptor_index, off+2);
+	if err != nil {
+		return err
+	}
+	m.descriptor = desc
+	return nil
 }
 
 func (m *MemberInfo) name() string {
@@ -780,8 +1861,22 @@ func (m *MemberInfo) sig() string {
 
 //test this!
 func (m *MemberInfo) isStatic() bool {
-	qstat := m.access_flags & ACC_STATIC;
-	return (qstat == ACC_STATIC);
+	return m.methodFlags().has(ACC_STATIC);
+}
+
+//return the Code attribute of a method, or nil if it doesn't have one,
+//which is the case for abstract and native methods
+func (m *MemberInfo) getCode() *Code_attribute {
+	if (m.attribute_table == nil) {
+		return nil
+	}
+	for _, attr := range m.attribute_table.attributes {
+		ca, ok := attr.(*Code_attribute)
+		if ok {
+			return ca
+		}
+	}
+	return nil
 }
 
 //From the Java Virtual Machine Specification chapter 4:
@@ -797,8 +1892,7 @@ func (m *MemberInfo) isStatic() bool {
 /**
 * Get the CP index of the constant value associated with this static field.
 * Return zero if it doesn't apply.
-* The constant value will be either an integer, float or string
-* (it could also be a long or double but I don't support those)
+* The constant value will be an integer, float, long, double or string
 */
 func (m *MemberInfo) getConstantValueIndex() uint16 {
 	if (m.attributes_count == 0) {
@@ -854,83 +1948,145 @@ func NewAttributeTable(p *ConstantPool, ac uint16) *AttributeTable {
 	return at;
 }
 
-func (atab *AttributeTable) load(buf* Buffer)  {
-
-	if atab.attributes_count == 0 {
-		//this should never happen because we don't even create an AttributeTable
-		//if attributes_count is zero
-		return;
-	}	
+//AttributeDecoder reads the body of an attribute.  buf is at the start of the body, and the
+//decoder must read exactly alen bytes.  nix is the attribute_name_index
+type AttributeDecoder func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error)
+
+//the decoders, by attribute name.  Attributes without a decoder are kept as a Generic_attribute
+var attributeDecoders = map[string]AttributeDecoder{}
+
+//RegisterAttribute adds a decoder for the attributes with this name, such as a vendor attribute.
+//This replaces the built-in decoder if there is one
+func RegisterAttribute(name string, d AttributeDecoder) {
+	attributeDecoders[name] = d
+}
+
+//LocalVariableTable and LocalVariableTypeTable are the same apart from the name
+func loadLocalVariables(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+	lvt := NewLocalVariableTable(pool.getName(int(nix)), nix, alen);
+	return lvt, lvt.load(buf, pool)
+}
+
+//the attributes that we know about
+func init() {
+	RegisterAttribute("ConstantValue", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		cva := NewConstantValue_attribute(nix);
+		return cva, cva.load(buf, pool)
+	})
+	RegisterAttribute("Code", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		coda := NewCodeAttribute(pool, nix, alen);
+		return coda, coda.load(buf)
+	})
+	RegisterAttribute("Exceptions", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		x := NewExceptions(nix, alen);
+		x.load(buf);
+		return x, nil
+	})
+	RegisterAttribute("LineNumberTable", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		lnt := NewLineNumberTable(nix, alen);
+		lnt.load(buf);
+		return lnt, nil
+	})
+	RegisterAttribute("LocalVariableTable", loadLocalVariables)
+	RegisterAttribute("LocalVariableTypeTable", loadLocalVariables)
+	RegisterAttribute("SourceDebugExtension", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		sde := NewSourceDebugExtension(nix, alen);
+		sde.load(buf);
+		return sde, nil
+	})
+	RegisterAttribute("StackMapTable", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		smt := NewStackMapTable(nix, alen);
+		return smt, smt.load(buf)
+	})
+	RegisterAttribute("SourceFile", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		sf := NewSourceFile(nix);
+		sf.load(buf);
+		return sf, nil
+	})
+	RegisterAttribute("InnerClasses", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		nc := NewInnerClasses(nix, alen);
+		return nc, nc.load(buf, pool)
+	})
+	RegisterAttribute("EnclosingMethod", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		em := NewEnclosingMethod(nix);
+		return em, em.load(buf, pool)
+	})
+	RegisterAttribute("Synthetic", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		return NewSynthetic(nix), nil
+	})
+	RegisterAttribute("Signature", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		sig := NewSignature(nix);
+		sig.load(buf);
+		return sig, nil
+	})
+	RegisterAttribute("Deprecated", func(buf *Buffer, pool *ConstantPool, nix uint16, alen uint32) (AttributeInfo, error) {
+		return NewDeprecated(nix), nil
+	})
+}
+
+func (atab *AttributeTable) load(buf* Buffer) error {
 	for i := uint16(0); i<atab.attributes_count; i++ {
 		//we get 3 items for every attribute:
 		//	index and length.  Name is looked up from the index
+		off := buf.pos
 		idx := buf.readUShort();
 		alen := buf.readUInt();
+		if buf.err != nil {
+			return buf.err
+		}
 		
 		//get the name of the attribute
-		k := atab.pool.constant_pool[idx];
-		if k.ctype() != 1 {
-			fmt.Println("ERR: AttributeTable.load(), tag is "+strconv.Itoa(int(idx)))
+		aname, err := atab.pool.utf8At(idx, off);
+		if err != nil {
+			return err
 		}
-		u,ok := k.(*CONSTANT_Utf8_info);
-		if !ok {
-			fmt.Println("ERR: constant pool "+strconv.Itoa(int(idx))+" is not expected CONSTANT_Utf8_info");
-			return;
+		if debug {
+			fmt.Fprintln(os.Stderr, "DEBUG: attribute name=" + aname);
 		}
-		aname := u.utf8;
-		fmt.Println("DEBUG: attribute name=" + aname);
-		
-		//this could be a switch statement
-		if (aname == "ConstantValue") {
-			if alen !=2 {
-				fmt.Println("DEBUG: ConstantValue length is " + strconv.Itoa(int(alen)) + "; expecting 2");
-			}
-			cva := NewConstantValue_attribute(idx);
-			atab.attributes[i]=cva;
-		} else if (aname == "Code") {
-			coda := NewCodeAttribute(atab.pool,idx,alen);
-			coda.load(buf);
-			atab.attributes[i]=coda;	
-		} else if (aname == "Exceptions") {
-			x := NewExceptions(idx,alen);
-			x.load(buf);
-			atab.attributes[i]=x;
-		} else if (aname == "LineNumberTable") {
-			lnt := NewLineNumberTable(idx,alen)
-			lnt.load(buf);
-			atab.attributes[i]=lnt;			
-		} else if (aname == "StackMapTable") {
-			g := NewGenericAttribute(aname, idx, alen)		
-			g.load(buf);
-			atab.attributes[i]=g;		
-		} else if (aname == "SourceFile") {
-			sf := NewSourceFile(idx);
-			sf.load(buf);
-			atab.attributes[i]=sf;	
-		} else if (aname == "InnerClasses") {
-			nc := NewInnerClasses(idx,alen)
-			nc.load(buf);
-			atab.attributes[i]=nc;				
-		} else if (aname == "EnclosingMethod") {
-			em := NewEnclosingMethod(idx)
-			em.load(buf);
-			atab.attributes[i]=em;				
-		} else if (aname == "Synthetic") {
-			sy := NewSynthetic(idx)
-			sy.load(buf);
-			atab.attributes[i]=sy;					
-		} else if (aname == "Signature") {
-			sig := NewSignature(idx)
-			sig.load(buf);
-			atab.attributes[i]=sig;				
-		} else if (aname == "Deprecated") {
-			d := NewDeprecated(idx)
-			d.load(buf);
-			atab.attributes[i]=d;
+		//the decoder only gets the body, so it can't read into the next attribute
+		start := buf.pos
+		body := buf.sub(int(alen), aname)
+		if buf.err != nil {
+			return buf.err
+		}
+		var attr AttributeInfo
+		decode, ok := attributeDecoders[aname]
+		if ok {
+			attr, err = decode(body, atab.pool, idx, alen);
+			if err != nil {
+				return err
+			}
 		} else {
-			fmt.Println("DEBUG: unknown attribute "+aname);
-		}				
+			if debug {
+				fmt.Fprintln(os.Stderr, "DEBUG: unknown attribute "+aname+", keeping the bytes");
+			}
+			g := NewGenericAttribute(aname, idx, alen)
+			g.load(body);
+			attr = g
+		}
+		if body.err != nil {
+			return body.err
+		}
+		//the body has to be used up, or the attribute wasn't what the decoder thought it was
+		if body.remaining() != 0 {
+			return newParseError(ErrBadLength, start, aname+" has length "+strconv.Itoa(int(alen))+", but "+strconv.Itoa(int(alen)-body.remaining())+" bytes were read")
+		}
+		atab.attributes[i]=attr;
+	}
+	return nil
+}
+
+//return the attribute with this name, or nil
+func (atab *AttributeTable) find(aname string) AttributeInfo {
+	if atab == nil {
+		return nil
+	}
+	for _, attr := range atab.attributes {
+		if attr.attribute_name() == aname {
+			return attr
+		}
 	}
+	return nil
 }
 
 
@@ -965,8 +2121,21 @@ func (attr *ConstantValue_attribute) attribute_length() uint32 {
 	return attr.alength;
 }
 
-func (attr *ConstantValue_attribute) load(buf *Buffer) {
+func (attr *ConstantValue_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	off := buf.pos
 	attr.cp_index = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	k, err := pool.entry(attr.cp_index, off)
+	if err != nil {
+		return err
+	}
+	switch k.ctype() {
+		case CONSTANT_Integer, CONSTANT_Long, CONSTANT_Float, CONSTANT_Double, CONSTANT_String:
+			return nil
+	}
+	return newParseError(ErrWrongTag, off, "#"+strconv.Itoa(int(attr.cp_index))+" has tag "+strconv.Itoa(int(k.ctype()))+", which isn't a constant value")
 }
 
 func (attr *ConstantValue_attribute) constantvalue_index() uint16 {
@@ -974,10 +2143,143 @@ func (attr *ConstantValue_attribute) constantvalue_index() uint16 {
 }
 
 //============================
+//StackMapTable attribute.  This is part of the Code attribute in class files from version 50 (Java 6) on.
+//It gives the types of the locals and the stack at the start of some instructions, which the
+//verifier checks the code against
+
+//the tags of verification_type_info
+const (
+	ITEM_Top =                0;
+	ITEM_Integer =            1;
+	ITEM_Float =              2;
+	ITEM_Double =             3;
+	ITEM_Long =               4;
+	ITEM_Null =               5;
+	ITEM_UninitializedThis =  6;
+	ITEM_Object =             7;
+	ITEM_Uninitialized =      8;
+)
+
+type verification_type_info struct {
+	tag uint8;
+	//for ITEM_Object, the index of a CONSTANT_Class_info
+	cpool_index uint16;
+	//for ITEM_Uninitialized, the offset of the new instruction that created the object
+	offset uint16;
+}
+
+//one frame.  The frame_type says which of the other fields are used:
+//	0-63		same_frame, the offset_delta is the frame_type
+//	64-127		same_locals_1_stack_item, the offset_delta is frame_type-64
+//	247			same_locals_1_stack_item_extended
+//	248-250		chop_frame, which removes the last 251-frame_type locals
+//	251			same_frame_extended
+//	252-254		append_frame, which adds frame_type-251 locals
+//	255			full_frame
+type stack_map_frame struct {
+	frame_type uint8;
+	offset_delta uint16;
+	locals []*verification_type_info;
+	stack []*verification_type_info;
+}
+
+type StackMapTable_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    number_of_entries uint16;
+    entries []*stack_map_frame;
+}
+
+func NewStackMapTable(nix uint16,alen uint32) *StackMapTable_attribute {
+	return &StackMapTable_attribute {
+		aname: "StackMapTable",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *StackMapTable_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *StackMapTable_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *StackMapTable_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *StackMapTable_attribute) load(buf *Buffer) error {
+	attr.number_of_entries = buf.readUShort();
+	attr.entries = make([]*stack_map_frame, attr.number_of_entries)
+	for i := 0; i<int(attr.number_of_entries); i++ {
+		off := buf.pos
+		f := &stack_map_frame{}
+		f.frame_type = buf.readByte();
+		var err error
+		t := f.frame_type
+		switch {
+			case t <= 63:
+				f.offset_delta = uint16(t);
+			case t <= 127:
+				f.offset_delta = uint16(t-64);
+				f.stack, err = loadVerificationTypes(buf, 1);
+			case t < 247:
+				return newParseError(ErrUnknownTag, off, "stack map frame type "+strconv.Itoa(int(t)))
+			case t == 247:
+				f.offset_delta = buf.readUShort();
+				f.stack, err = loadVerificationTypes(buf, 1);
+			case t <= 251:
+				f.offset_delta = buf.readUShort();
+			case t <= 254:
+				f.offset_delta = buf.readUShort();
+				f.locals, err = loadVerificationTypes(buf, int(t)-251);
+			default:
+				f.offset_delta = buf.readUShort();
+				n := buf.readUShort();
+				f.locals, err = loadVerificationTypes(buf, int(n));
+				if err == nil {
+					n = buf.readUShort();
+					f.stack, err = loadVerificationTypes(buf, int(n));
+				}
+		}
+		if err != nil {
+			return err
+		}
+		if buf.err != nil {
+			return buf.err
+		}
+		attr.entries[i]=f;
+	}
+	return nil
+}
+
+func loadVerificationTypes(buf *Buffer, n int) ([]*verification_type_info, error) {
+	vt := make([]*verification_type_info, n)
+	for i := 0; i<n; i++ {
+		off := buf.pos
+		vi := &verification_type_info{}
+		vi.tag = buf.readByte();
+		if vi.tag == ITEM_Object {
+			vi.cpool_index = buf.readUShort();
+		} else if vi.tag == ITEM_Uninitialized {
+			vi.offset = buf.readUShort();
+		} else if vi.tag > ITEM_Uninitialized {
+			return nil, newParseError(ErrUnknownTag, off, "verification type "+strconv.Itoa(int(vi.tag)))
+		}
+		if buf.err != nil {
+			return nil, buf.err
+		}
+		vt[i]=vi;
+	}
+	return vt, nil
+}
 
-//StackMapTable Attribute
+//============================
 
-//this is a placeholder for attributes that I don't care about but have to deal with
+//this holds the raw bytes of an attribute that has no decoder, so it can be written back out
 type Generic_attribute struct {
 	aname string;
 	aname_index uint16;
@@ -1007,10 +2309,7 @@ func (attr *Generic_attribute) attribute_length() uint32 {
 
 func (attr *Generic_attribute) load(buf *Buffer) {
 	if attr.alength>0 {
-		attr.garbage = make([]byte, attr.alength)
-		for j := 0;j < int(attr.alength);j++ {
-			attr.garbage[j]=buf.readByte();
-		}
+		attr.garbage = buf.readBytes(int(attr.alength))
 	}
 }
 
@@ -1093,7 +2392,7 @@ func (attr *InnerClasses_attribute) attribute_length() uint32 {
 	return attr.alength;
 }
 
-func (attr *InnerClasses_attribute) load(buf *Buffer) {
+func (attr *InnerClasses_attribute) load(buf *Buffer, pool *ConstantPool) error {
 	attr.num_classes = buf.readUShort();
 	if (attr.num_classes > 0) {
 		attr.classes = make([]*inner_class_info, attr.num_classes)
@@ -1103,10 +2402,19 @@ func (attr *InnerClasses_attribute) load(buf *Buffer) {
 			ik.inner_class_info_index=buf.readUShort();
 			ik.outer_class_info_index=buf.readUShort();
 			ik.inner_name_index=buf.readUShort();
+			off := buf.pos
 			ik.inner_class_access_flags=buf.readUShort();
+			if buf.err != nil {
+				return buf.err
+			}
+			msg := InnerClassFlags(ik.inner_class_access_flags).check(pool.major_version)
+			if msg != "" {
+				return newParseError(ErrBadFlags, off, "inner class: "+msg)
+			}
 			attr.classes[i]=ik;					
 		}
 	}
+	return nil
 }
  
 //========================
@@ -1140,9 +2448,23 @@ func (attr *EnclosingMethod_attribute) attribute_length() uint32 {
 	return attr.alength;
 }
 
-func (attr *EnclosingMethod_attribute) load(buf *Buffer) {
+//class_index must be a CONSTANT_Class, and method_index is 0 or a CONSTANT_NameAndType
+func (attr *EnclosingMethod_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	off := buf.pos
 	attr.class_index = buf.readUShort();
 	attr.method_index = buf.readUShort();
+	if buf.err != nil {
+		return buf.err
+	}
+	_, err := pool.entryOfType(attr.class_index, CONSTANT_Class, off)
+	if err != nil {
+		return err
+	}
+	//0 means the class isn't in a method, like one in a field initializer
+	if attr.method_index != 0 {
+		_, err = pool.entryOfType(attr.method_index, CONSTANT_NameAndType, off+2)
+	}
+	return err
 }
 
 //========================
@@ -1333,6 +2655,128 @@ func (attr *LineNumberTable_attribute) load(buf *Buffer) {
 	}
 }
 
+//==============================
+
+//LocalVariableTable is part of the code attribute.  It gives the name and type of each local
+//variable and the range of code where it has a value.  LocalVariableTypeTable has the same
+//layout, but it only lists the variables with a generic type, and it has the signature instead
+//of the descriptor.  They are told apart by the name
+type LocalVariableTable_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    local_variable_table_length uint16;
+    local_variable_table []*local_variable_info;
+}
+
+type local_variable_info struct {
+	start_pc uint16;
+	length uint16;
+	name_index uint16;
+	//the signature in a LocalVariableTypeTable
+	descriptor_index uint16;
+	index uint16;
+	name string;
+	descriptor string;
+}
+
+func NewLocalVariableTable(n string, nix uint16, alen uint32) *LocalVariableTable_attribute {
+	return &LocalVariableTable_attribute {
+		aname: n,
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *LocalVariableTable_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *LocalVariableTable_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *LocalVariableTable_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *LocalVariableTable_attribute) load(buf *Buffer, pool *ConstantPool) error {
+	attr.local_variable_table_length = buf.readUShort();
+	attr.local_variable_table = make([]*local_variable_info, attr.local_variable_table_length)
+	for i := 0; i<int(attr.local_variable_table_length); i++ {
+		lv := &local_variable_info{}
+		off := buf.pos
+		lv.start_pc = buf.readUShort();
+		lv.length = buf.readUShort();
+		lv.name_index = buf.readUShort();
+		lv.descriptor_index = buf.readUShort();
+		lv.index = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
+		var err error
+		lv.name, err = pool.utf8At(lv.name_index, off+4)
+		if err != nil {
+			return err
+		}
+		lv.descriptor, err = pool.utf8At(lv.descriptor_index, off+6)
+		if err != nil {
+			return err
+		}
+		attr.local_variable_table[i]=lv;
+	}
+	return nil
+}
+
+//is the variable in scope at this pc
+func (lv *local_variable_info) covers(pc int) bool {
+	return pc >= int(lv.start_pc) && pc < int(lv.start_pc)+int(lv.length)
+}
+
+//==============================
+
+//SourceDebugExtension is a class attribute with extra debugging information, such as the
+//SMAP that maps a JSP or Kotlin file onto the generated class.  The JVM doesn't look inside it
+type SourceDebugExtension_attribute struct {
+	aname string;
+    aname_index uint16;
+    alength uint32;
+    debug_extension []byte;
+}
+
+func NewSourceDebugExtension(nix uint16, alen uint32) *SourceDebugExtension_attribute {
+	return &SourceDebugExtension_attribute {
+		aname: "SourceDebugExtension",		//hard-coded
+		aname_index: nix,
+		alength: alen,
+	}
+}
+
+func (attr *SourceDebugExtension_attribute) attribute_name() string {
+	return attr.aname;
+}
+
+func (attr *SourceDebugExtension_attribute) attribute_name_index() uint16 {
+	return attr.aname_index;
+}
+
+func (attr *SourceDebugExtension_attribute) attribute_length() uint32 {
+	return attr.alength;
+}
+
+func (attr *SourceDebugExtension_attribute) load(buf *Buffer) {
+	attr.debug_extension = buf.readBytes(int(attr.alength))
+}
+
+//the SMAP or other text in the SourceDebugExtension, or "" if there isn't one
+func (cf *ClassFile) sourceDebugExtension() string {
+	sde, ok := cf.attribute_table.find("SourceDebugExtension").(*SourceDebugExtension_attribute)
+	if !ok {
+		return ""
+	}
+	return string(sde.debug_extension)
+}
+
 //===========================================================
 
 type Code_attribute struct {
@@ -1379,16 +2823,12 @@ func (attr *Code_attribute) attribute_length() uint32 {
 	return attr.alength;
 }    
     
-func (ca *Code_attribute) load(buf *Buffer) {
+func (ca *Code_attribute) load(buf *Buffer) error {
 	ca.max_stack=buf.readUShort();
 	ca.max_locals=buf.readUShort();
 	ca.code_length=buf.readUInt();
 	if ca.code_length > 0 {
-		ca.code = make([]uint8,ca.code_length);
-		//is there a faster way of reading this in?
-		for i :=uint32(0);i<ca.code_length;i++ {
-			ca.code[i]=buf.readByte();
-		}
+		ca.code = buf.readBytes(int(ca.code_length));
 		ca.exception_table_length=buf.readUShort();
 		if (ca.exception_table_length>0) {
 			ca.exception_table=make([]*exception_table_entry, ca.exception_table_length);
@@ -1404,12 +2844,81 @@ func (ca *Code_attribute) load(buf *Buffer) {
 		
 		//attributes
 		ca.attributes_count = buf.readUShort();
+		if buf.err != nil {
+			return buf.err
+		}
 		if (ca.attributes_count>0) {
 			at := NewAttributeTable(ca.pool, ca.attributes_count);
-			at.load(buf);
+			err := at.load(buf);
+			if err != nil {
+				return err
+			}
 			ca.attribute_table = at;
 		}
 	} 
+	return buf.err
+}
+
+//the source line of the instruction at pc, or 0 if there is no LineNumberTable
+func (ca *Code_attribute) lineNumber(pc int) int {
+	line := 0
+	best := -1
+	if ca.attribute_table == nil {
+		return 0
+	}
+	//there can be more than one LineNumberTable, and the entries can be in any order
+	for _, attr := range ca.attribute_table.attributes {
+		lnt, ok := attr.(*LineNumberTable_attribute)
+		if ok {
+			for _, ln := range lnt.line_number_table {
+				if int(ln.start_pc) <= pc && int(ln.start_pc) > best {
+					best = int(ln.start_pc)
+					line = int(ln.line_number)
+				}
+			}
+		}
+	}
+	return line
+}
+
+//all the entries of the LocalVariableTable or LocalVariableTypeTable attributes
+func (ca *Code_attribute) variables(aname string) []*local_variable_info {
+	list := []*local_variable_info{}
+	if ca.attribute_table == nil {
+		return list
+	}
+	for _, attr := range ca.attribute_table.attributes {
+		lvt, ok := attr.(*LocalVariableTable_attribute)
+		if ok && lvt.aname == aname {
+			list = append(list, lvt.local_variable_table...)
+		}
+	}
+	return list
+}
+
+//the local variables of the method, from the LocalVariableTable
+func (ca *Code_attribute) localVariables() []*local_variable_info {
+	return ca.variables("LocalVariableTable")
+}
+
+//the variable in this slot at this pc, or nil if it isn't known
+func (ca *Code_attribute) localVariable(slot int, pc int) *local_variable_info {
+	for _, lv := range ca.localVariables() {
+		if int(lv.index) == slot && lv.covers(pc) {
+			return lv
+		}
+	}
+	return nil
+}
+
+//the same, but from the LocalVariableTypeTable, so the descriptor is the generic signature
+func (ca *Code_attribute) localVariableType(slot int, pc int) *local_variable_info {
+	for _, lv := range ca.variables("LocalVariableTypeTable") {
+		if int(lv.index) == slot && lv.covers(pc) {
+			return lv
+		}
+	}
+	return nil
 }
 
 //===============================================
@@ -1421,46 +2930,30 @@ func (ca *Code_attribute) load(buf *Buffer) {
 const (
 	NONE = uint16(0);
 	NIL =  uint16(256);
+	ARRY = uint16(0xA773);	//42867 - for Java arrays, and arrays of arrays
+	BOOL = uint16(0xB664);	//46692 - for boolean array elements
+	BYTE = uint16(0xB3DE);	//46046 - for byte array elements
+	CHAR = uint16(0xC2A7);	//49831 - for char array elements
 	CLAS = uint16(50344);	//for class. This is the constant table
 	CLIN = uint16(50229);	//for class init
 	CNAM = uint16(50597);	//for class name, a string
+	DUBL = uint16(0xD9B4);	//55732 - for doubles
 	FLOT = uint16(0xF46D);	//62573 - for floats
+	FREF = uint16(0xF7EF);	//63471 - for a reference to a field
 	INIT = uint16(13629);
 	INTG = uint16(13777);	//for integers
+	LONG = uint16(0x4651);	//18001 - for longs
 	MAIN = uint16(23093);
+	MESG = uint16(0x5E81);	//24193 - for the message of an exception
 	METH = uint16(24274);	//for method
+	MREF = uint16(0x57EF);	//22511 - for a reference to a method
+	OBJT = uint16(0x6B1D);	//27421 - for objects.  This is a table of the fields
+	SHRT = uint16(0x827D);	//33405 - for short array elements
 	STRG = uint16(36209);	//for Strings
+	VALU = uint16(0xFA49);	//64073 - for the value inside a built-in object, like the string in a StringBuilder
 
-	//other built-in objects and methods
-	//the numbering will be changed in future versions
-	//java/lang/Object."<init>":()V
-	OBJINIT = uint16(0xFE01);
-	//java/lang/System field is out.  This is an object of class PrintStream
-	SYSOUT = uint16(0xFE02);
-	//java/io/PrintStream method is println; type is (Ljava/lang/String;)V
-	PRNS = uint16(0xFE03);
-	//java/io/PrintStream method println, type (I)V
-	PRNI = uint16(0xFE04);
-	//java/io/PrintStream method println, type (F)V
-	PRNF = uint16(0xFE05);
-	//java/lang/Integer static method is parseInt; type is (Ljava/lang/String;)I
-	PARSEINT = uint16(0xFE06);
-	//java/lang/StringBuilder method is <init>; type is ()V
-	//there is also an initializer that accepts a string, but I don't handle that
-	SB_INIT = uint16(0xFE07);
-	//java/lang/StringBuilder method is append; type is (Ljava/lang/String;)Ljava/lang/StringBuilder;
-	SB_APPEND_STR = uint16(0xFE08);
-	//java/lang/StringBuilder method is append; type is (I)Ljava/lang/StringBuilder;
-	SB_APPEND_I = uint16(0xFE09);
-	//java/lang/StringBuilder method is toString; type is ()Ljava/lang/String;
-	SB_TOSTR = uint16(0xFE0A);
-	//this symbol represents the class java/lang/StringBuilder which is handled specially
-	CLASS_SB = uint16(0xFE0B);
-	//StringBuilder Object
-	SB_OBJ = uint16(0xFE0C);
-
-	//to do
-	//public final static String PARSEINT="java/lang/Integer.parseInt:(Ljava/lang/String;)I";
+	//the methods of the built-in classes, like java/io/PrintStream.println, are natives.  They get
+	//their Idents from NATIVE_BASE up when they are registered
 
 	//these lookup the constant pool
 	ANEWARRAY = uint16(0x00BD);
@@ -1471,8 +2964,10 @@ const (
 	INVOKEVIRTUAL = uint16(0x00B6);
 	INVOKESPECIAL = uint16(0x00B7);
 	INVOKESTATIC = uint16(0x00B8);
+	INVOKEINTERFACE = uint16(0x00B9);
 
 	LDC = uint16(0x0012);
+	LDC_W = uint16(0x0013);
 	NEWOBJ = uint16(0x00BB);
 	PUTFIELD = uint16(0x00B5);
 	PUTSTATIC = uint16(0x00B3);
@@ -1481,8 +2976,11 @@ const (
 	RETURNV = uint16(0x00B1);		//177 aka RETURN
 	IRETURN = uint16(0x00AC);		//172 return an int from method
 	ARETURN = uint16(0x00B0);		//return object from a method
+	ATHROW = uint16(0x00BF);
 
 	//now the regular byte code
+	NOP = uint16(0x0000);
+	ACONST_NULL = uint16(0x0001);
 	BIPUSH = uint16(0x0010); 		//decimal 16
 	SIPUSH = uint16(0x0011);			//17
 	ICONST_M1 = uint16(0x0002);
@@ -1492,14 +2990,16 @@ const (
 	ICONST_3 = uint16(0x0006);
 	ICONST_4 = uint16(0x0007);
 	ICONST_5 = uint16(0x0008);
+	FCONST_0 = uint16(0x000B);
+	FCONST_1 = uint16(0x000C);
+	FCONST_2 = uint16(0x000D);
 
 	DUP = uint16(0x0059);
 	POP = uint16(0x0057);			//87
 
 	//these complete the minimal set
 	ALOAD_0 = uint16(0x002A);		//42
-	AALOAD = uint16(0x0032);
-	ILOAD = uint16(0x0015);		//26
+	ILOAD = uint16(0x0015);		//21
 	ILOAD_0 = uint16(0x001A);		//26
 	ILOAD_1 = uint16(0x001B);		//27
 	ILOAD_2 = uint16(0x001C);		//28
@@ -1509,8 +3009,32 @@ const (
 	ISTORE_2 = uint16(0x003D);		//61
 	 ISTORE_3 = uint16(0x003E);		//62
 
+	//locals hold a Num48 whatever the type, so these work the same way as ILOAD and ISTORE
+	ISTORE = uint16(0x0036);		//54
+	FLOAD = uint16(0x0017);
+	FLOAD_0 = uint16(0x0022);
+	FLOAD_1 = uint16(0x0023);
+	FLOAD_2 = uint16(0x0024);
+	FLOAD_3 = uint16(0x0025);
+	FSTORE = uint16(0x0038);
+	FSTORE_0 = uint16(0x0043);
+	FSTORE_1 = uint16(0x0044);
+	FSTORE_2 = uint16(0x0045);
+	FSTORE_3 = uint16(0x0046);
+	ALOAD = uint16(0x0019);
+	ALOAD_1 = uint16(0x002B);
+	ALOAD_2 = uint16(0x002C);
+	ALOAD_3 = uint16(0x002D);
+	ASTORE = uint16(0x003A);
+	ASTORE_0 = uint16(0x004B);
+	ASTORE_1 = uint16(0x004C);
+	ASTORE_2 = uint16(0x004D);
+	ASTORE_3 = uint16(0x004E);
+	IINC = uint16(0x0084);			//132, used by every for loop
+
 	JMP = uint16(0x00A7);			//167 same as GOTO
 	IF_ACMPEQ = uint16(0x00A5);
+	IF_ACMPNE = uint16(0x00A6);
 	IF_ICMPEQ = uint16(0x009F);	//159
 	IF_ICMPGE = uint16(0x00A2); 	//162
 	IF_ICMPGT = uint16(0x00A3); 	//163
@@ -1538,19 +3062,136 @@ const (
 	FMUL = uint16(0x006a);
 	FDIV = uint16(0x006e);
 	FNEG = uint16(0x0076);
+	I2F = uint16(0x0086);
+	F2I = uint16(0x008B);
+	FCMPL = uint16(0x0095);
+	FCMPG = uint16(0x0096);
+	FRETURN = uint16(0x00AE);
+
+	//longs and doubles take 2 slots on the stack and in the locals, as in the JVM
+	LCONST_0 = uint16(0x0009);
+	LCONST_1 = uint16(0x000A);
+	DCONST_0 = uint16(0x000E);
+	DCONST_1 = uint16(0x000F);
+	LDC2_W = uint16(0x0014);		//this looks up the constant pool too
+	LLOAD = uint16(0x0016);
+	DLOAD = uint16(0x0018);
+	LLOAD_0 = uint16(0x001E);
+	LLOAD_1 = uint16(0x001F);
+	LLOAD_2 = uint16(0x0020);
+	LLOAD_3 = uint16(0x0021);
+	DLOAD_0 = uint16(0x0026);
+	DLOAD_1 = uint16(0x0027);
+	DLOAD_2 = uint16(0x0028);
+	DLOAD_3 = uint16(0x0029);
+	LSTORE = uint16(0x0037);
+	DSTORE = uint16(0x0039);
+	LSTORE_0 = uint16(0x003F);
+	LSTORE_1 = uint16(0x0040);
+	LSTORE_2 = uint16(0x0041);
+	LSTORE_3 = uint16(0x0042);
+	DSTORE_0 = uint16(0x0047);
+	DSTORE_1 = uint16(0x0048);
+	DSTORE_2 = uint16(0x0049);
+	DSTORE_3 = uint16(0x004A);
+	POP2 = uint16(0x0058);
+	DUP2 = uint16(0x005C);
+	LADD = uint16(0x0061);
+	DADD = uint16(0x0063);
+	LSUB = uint16(0x0065);
+	DSUB = uint16(0x0067);
+	LMUL = uint16(0x0069);
+	DMUL = uint16(0x006B);
+	LDIV = uint16(0x006D);
+	DDIV = uint16(0x006F);
+	LREM = uint16(0x0071);
+	DREM = uint16(0x0073);
+	LNEG = uint16(0x0075);
+	DNEG = uint16(0x0077);
+	LSHL = uint16(0x0079);
+	LSHR = uint16(0x007B);
+	LUSHR = uint16(0x007D);
+	LAND = uint16(0x007F);
+	LOR = uint16(0x0081);
+	LXOR = uint16(0x0083);
+	I2L = uint16(0x0085);
+	I2D = uint16(0x0087);
+	L2I = uint16(0x0088);
+	L2F = uint16(0x0089);
+	L2D = uint16(0x008A);
+	F2L = uint16(0x008C);
+	F2D = uint16(0x008D);
+	D2I = uint16(0x008E);
+	D2L = uint16(0x008F);
+	D2F = uint16(0x0090);
+	LCMP = uint16(0x0094);
+	DCMPL = uint16(0x0097);
+	DCMPG = uint16(0x0098);
+	LRETURN = uint16(0x00AD);
+	DRETURN = uint16(0x00AF);
+
+	//arrays
+	NEWARRAY = uint16(0x00BC);
+	ARRAYLENGTH = uint16(0x00BE);
+	MULTIANEWARRAY = uint16(0x00C5);	//this looks up the constant pool too
+	IALOAD = uint16(0x002E);
+	LALOAD = uint16(0x002F);
+	FALOAD = uint16(0x0030);
+	DALOAD = uint16(0x0031);
+	AALOAD = uint16(0x0032);
+	BALOAD = uint16(0x0033);
+	CALOAD = uint16(0x0034);
+	SALOAD = uint16(0x0035);
+	IASTORE = uint16(0x004F);
+	LASTORE = uint16(0x0050);
+	FASTORE = uint16(0x0051);
+	DASTORE = uint16(0x0052);
+	AASTORE = uint16(0x0053);
+	BASTORE = uint16(0x0054);
+	CASTORE = uint16(0x0055);
+	SASTORE = uint16(0x0056);
+
+	//the rest of what javac emits for ordinary code, like switch statements and compound assignments
+	ISHL = uint16(0x0078);
+	ISHR = uint16(0x007A);
+	IUSHR = uint16(0x007C);
+	IAND = uint16(0x007E);
+	IOR = uint16(0x0080);
+	IXOR = uint16(0x0082);
+	FREM = uint16(0x0072);
+	I2B = uint16(0x0091);
+	I2C = uint16(0x0092);
+	I2S = uint16(0x0093);
+	DUP_X1 = uint16(0x005A);
+	DUP_X2 = uint16(0x005B);
+	DUP2_X1 = uint16(0x005D);
+	DUP2_X2 = uint16(0x005E);
+	SWAP = uint16(0x005F);
+	TABLESWITCH = uint16(0x00AA);
+	LOOKUPSWITCH = uint16(0x00AB);
+	GOTO_W = uint16(0x00C8);
+	WIDE = uint16(0x00C4);
+	MONITORENTER = uint16(0x00C2);
+	MONITOREXIT = uint16(0x00C3);
+
+	//these are not supported, so translateCode rejects a method that uses them
+	JSR = uint16(0x00A8);
+	RET = uint16(0x00A9);
+	JSR_W = uint16(0x00C9);
+	INVOKEDYNAMIC = uint16(0x00BA);
 )
 
 //==============================
 // Num48 - This is a 48-bit number than can handle either ints or floats
 // It is made up of 3 parts, each one holding a value from 0..63999
-// For an int, just multiply it by 64000
-// A float is almost the same, just multiply it by 64000.0
+// An int is stored as it is, so every Java int fits
+// A float is multiplied by 64000.0
 // for negative numbers, this uses 2's complement
 // The reason why I do this is I want to have one internal representation 
-// of a number
+// of a number.  Int math is done in int32 (see intMath), so it wraps the way Java does
 
 //Num48 is an alias of int64
-// the actual range of allowed input is -2,047,999,999 .. 2,047,999,999
+// the actual range of allowed float input is about -2,047,999,999 .. 2,047,999,999
 // this is 32000 * 64000 -1.  I don't check it.  But I could 	
 type Num48 uint64;
 
@@ -1563,11 +3204,13 @@ const NEG_POINT = Num48(32000) * K64 * K64;
 //-------------------
 //Constructors
 // to Num48
+//the int is cut to 32 bits first, like a Java int
 func IntToNum48(ival int) Num48 {
-	if ival < 0 {
-		return Num48(ival) * K64 + FULL48;	
+	iv := int64(int32(ival));
+	if iv < 0 {
+		return Num48(iv + int64(FULL48));	
 	} else {
-		return Num48(ival) * K64;
+		return Num48(iv);
 	}
 }
 
@@ -1575,7 +3218,8 @@ func IntToNum48(ival int) Num48 {
 //this doesn't have very much precision, only to 1/64000
 func FloatToNum48(fval float32) Num48 {
 	if (fval < float32(0.0)) {
-		return Num48(fval * F64) + FULL48;	
+		//go through int64 because converting a negative float straight to unsigned is undefined
+		return Num48(int64(fval * F64)) + FULL48;	
 	} else {
 		return Num48(fval * F64);
 	}
@@ -1588,19 +3232,22 @@ func CharsToNum48(c0 uint16,c1 uint16, c2 uint16) Num48 {
 
 //-------------------------------------
 //from Num48
-//lval must be in the range 0.. 32000*64000*64000 -1
-func Num48ToInt(lval Num48) int32 {
+//the signed value, still multiplied by 64000 if it is a float.  Num48 is unsigned, so
+//subtracting FULL48 from it directly would wrap around
+func signed48(lval Num48) int64 {
 	if lval >= NEG_POINT {
-		lval = lval - FULL48;
+		return int64(lval) - int64(FULL48);
 	}
-	return int32(lval / K64);
+	return int64(lval);
+}
+
+//lval must be in the range 0.. 32000*64000*64000 -1
+func Num48ToInt(lval Num48) int32 {
+	return int32(signed48(lval));
 }
 
 func Num48ToFloat(lval Num48) float32 {
-	if (lval >= NEG_POINT) {
-		lval = lval - FULL48;
-	}
-	return float32( float64(lval) / float64(K64));
+	return float32( float64(signed48(lval)) / float64(K64));
 }
 
 func Num48ToChars(lval Num48) (uint16,uint16,uint16) {
@@ -1613,6 +3260,7 @@ func Num48ToChars(lval Num48) (uint16,uint16,uint16) {
 }	
 
 //------------------------------------
+//ADD, SUB, NEG, MUL and NUM48_FDIV are for floats.  Ints use intMath
 func ADD(a Num48, b Num48) Num48 {
 	c := a + b;
 	if (c >= FULL48) {
@@ -1623,16 +3271,19 @@ func ADD(a Num48, b Num48) Num48 {
 
 //subtract, using 2's complement
 func SUB(a Num48, b Num48) Num48 {
-	c := a - b;
-	if (c < 0) {
-			c = c + FULL48;
+	//Num48 is unsigned so check before subtracting, not after
+	if (a < b) {
+		return a + (FULL48 - b);
 	}
-	return c;
+	return a - b;
 }
 
 //Negate
 func NEG(a Num48) Num48 {
-	if (a >= NEG_POINT ) {
+	if (a == 0) {
+		//there is no negative zero
+		return a;
+	} else if (a >= NEG_POINT ) {
 		//its a negative number, change to positive
 		a = 0 - (a - FULL48);
 	} else {
@@ -1663,7 +3314,7 @@ func MUL(a Num48, b Num48) Num48 {
 	c := a * b / K64;
 
 	//step 3 - fix the signs again
-	if (diff) {
+	if (diff && c != 0) {
 		c = (0 - c) + FULL48;
 	}
 	return c;
@@ -1679,7 +3330,7 @@ func MUL(a Num48, b Num48) Num48 {
 func NUM48_FDIV(a Num48, b Num48) Num48 {
 	result := Num48(0);
 	if (b == Num48(0)) {
-		fmt.Println("[NUM48_FDIV] ERROR: in NUM48_FDIV trying to divide by zero");
+		fmt.Fprintln(os.Stderr, "[NUM48_FDIV] ERROR: in NUM48_FDIV trying to divide by zero");
 		return b;
 	}
 	
@@ -1711,61 +3362,63 @@ func NUM48_FDIV(a Num48, b Num48) Num48 {
 	result = c * K64 + e;
 
 	//step 5 - fix the signs again
-	if (diff) {
+	if (diff && result != 0) {
 		result = (0 - result) + FULL48;
 	}
 	return result;
 }
 
-	/**
-	* IDIV - Divide a by b
-	* If you divide by 0, this only gives a warning and returns zero.
-	*
-	* Note that this does the equivalent of int division.
-	*/
-
-func NUM48_IDIV(a Num48, b Num48) Num48 {
-	result := Num48(0);
-	if (b==Num48(0)) {
-		fmt.Println("[Num48_IDIV] ERROR: in DIV trying to divide by zero");
-		return b;
-	}
-	
-	//step 1 - look at the signs
-	//diff is true if and only if the inputs have different signs
-	//usually this is when the dividend is negative
-	diff := false;
-	if (a >= NEG_POINT) {
-		a = 0 - (a - FULL48);
-		diff = true;
-	}
-	if (b >= NEG_POINT) {
-		b = 0 - (b - FULL48);
-		if (diff==false) {
-			diff = true;
-		} else {
-			diff = false;
-		}
+/**
+* intMath - the int opcodes, done the way Java does them.  The result wraps around in 32 bits,
+* division truncates toward zero, and the remainder has the sign of the dividend.
+* Go's int32 does all of this, even MinInt32 / -1, which is MinInt32.
+* Shifts only use the low 5 bits of b, as in Java.
+* Returns false if it divides by zero, which is an ArithmeticException in Java
+*/
+func intMath(op uint16, a int32, b int32) (int32, bool) {
+	switch (op) {
+		case IADD:
+			return a + b, true;
+		case ISUB:
+			return a - b, true;
+		case IMUL:
+			return a * b, true;
+		case IDIV, IREM:
+			if (b == 0) {
+				return 0, false;
+			}
+			if (op == IDIV) {
+				return a / b, true;
+			}
+			return a % b, true;
+		case ISHL:
+			return a << uint(b & 31), true;
+		case ISHR:
+			return a >> uint(b & 31), true;
+		case IUSHR:
+			return int32(uint32(a) >> uint(b & 31)), true;
+		case IAND:
+			return a & b, true;
+		case IOR:
+			return a | b, true;
+		case IXOR:
+			return a ^ b, true;
 	}
-
-	//step 2 - do the integer division
-	c := a / b;
-
-	//step 4 - convert it
-	result = c * K64;
-
-	//step 5 - fix the signs again
-	if (diff) {
-		result = (0 - result) + FULL48;
+	fmt.Fprintln(os.Stderr, "[intMath] ERROR: opcode "+strconv.Itoa(int(op))+" is not int math");
+	return 0, false;
+}
+
+//compare a and b, taking the sign into account.
+//returns -1 if a < b, 0 if they are equal and 1 if a > b
+func CMP(a Num48, b Num48) int {
+	sa := signed48(a);
+	sb := signed48(b);
+	if (sa < sb) {
+		return -1;
+	} else if (sa > sb) {
+		return 1;
 	}
-	return result;
-}
-
-//only for use with integer division
-//not tested with negative numbers
-func NUM48_IREM(a Num48, b Num48) Num48 {
-	//note not multiplied by 64000
-	return a % b;
+	return 0;
 }
 
 //=============================
@@ -1819,46 +3472,44 @@ type Ident uint16
 		d := int(b);
 		//the minimum is space (32) and the maximum is little z (122)
 		if (d < 32 || d > 122) {
-			fmt.Printf("[encode16]" + strconv.Itoa(d)+" is out of range")
+			fmt.Fprintln(os.Stderr, "[encode16]" + strconv.Itoa(d)+" is out of range")
 		}
 		switch (d) {
-			case 32: case 48: case 95: // space,0,underscore
+			case 32, 48, 95: // space,0,underscore
 				return Digit16(0);
-			case 49: case 71: case 74: case 103: case 106:	//1 = 1,G,J
+			case 49, 71, 74, 103, 106:	//1 = 1,G,J
 				return Digit16(1);
-			case 50: case 72: case 88: case 104: case 120:	//2 = 2,H,X
+			case 50, 72, 88, 104, 120:	//2 = 2,H,X
 				return Digit16(2);
-			case 51: case 73: case 89: case 105: case 121:	//3 = 3,I,Y
+			case 51, 73, 89, 105, 121:	//3 = 3,I,Y
 				return Digit16(3);
-			case 52: case 76: case 108:	//4 = 4,L
+			case 52, 76, 108:	//4 = 4,L
 				return Digit16(4);
-			case 53: case 77: case 78: case 109: case 110:	//5 = 5,M,N
+			case 53, 77, 78, 109, 110:	//5 = 5,M,N
 				return Digit16(5);
-			case 54: case 79: case 111:	//6 = 6,O
+			case 54, 79, 111:	//6 = 6,O
 				return Digit16(6);
-			case 55: case 82: case 114:	//7 = 7,R
+			case 55, 82, 114:	//7 = 7,R
 				return Digit16(7);
-			case 56: case 83: case 90: case 115: case 122:	//8 = 8,S,Z
+			case 56, 83, 90, 115, 122:	//8 = 8,S,Z
 				return Digit16(8);
-			case 57: case 85: case 87: case 117: case 119:	//9 = 9,U,W
+			case 57, 85, 87, 117, 119:	//9 = 9,U,W
 				return Digit16(9);
-			case 65: case 97:	//10 = A
+			case 65, 97:	//10 = A
 				return Digit16(10);
-			case 66: case 80: case 98: case 112:	//11 = B,P
+			case 66, 80, 98, 112:	//11 = B,P
 				return Digit16(11);
-			case 67: case 75: case 81: case 99: case 107: case 113:	//12 = C,K,Q
+			case 67, 75, 81, 99, 107, 113:	//12 = C,K,Q
 				return Digit16(12);
-			case 68: case 84: case 100: case 116:	//13 = D,T
+			case 68, 84, 100, 116:	//13 = D,T
 				return Digit16(13);
-			case 69: case 101:	//14 = E
+			case 69, 101:	//14 = E
 				return Digit16(14);
-			case 70: case 86: case 102: case 118: //15 = F,V
+			case 70, 86, 102, 118: //15 = F,V
 				return Digit16(15);
 			default:
 				return Digit16(0);
 		}
-		//this is not needed but the compiler requires it
-		return Digit16(0);
 	}
 
 	/**
@@ -1866,7 +3517,7 @@ type Ident uint16
 	*/
 	func decode16(i Digit16) Ascii {
 		if (i > Digit16(15)) {
-			fmt.Println("[decode16]" + strconv.Itoa(int(i))+" is out of range");
+			fmt.Fprintln(os.Stderr, "[decode16]" + strconv.Itoa(int(i))+" is out of range");
 		}
 		switch (i) {
 			case 1: return Ascii('G');
@@ -1884,18 +3535,15 @@ type Ident uint16
 			case 13: return Ascii('D');
 			case 14: return Ascii('E');
 			case 15: return Ascii('F');
-			case 0:
-			default:
+			default:	//includes 0
 				return Ascii('_');	
 		}
-		//this is not needed but the compiler requires it
-		return Ascii(32);		
 	}
 
 	//alternative values
 	func altDecode(i Digit16) Ascii {
 		if (i > Digit16(15)) {
-			fmt.Println("[altDecode]" + strconv.Itoa(int(i))+" is out of range");
+			fmt.Fprintln(os.Stderr, "[altDecode]" + strconv.Itoa(int(i))+" is out of range");
 		}
 		switch (i) {
 			case 1: return Ascii('J');
@@ -1913,12 +3561,9 @@ type Ident uint16
 			case 13: return Ascii('T');
 			case 14: return Ascii('e');	//the same
 			case 15: return Ascii('V');
-			case 0:
-			default:
+			default:	//includes 0
 				return Ascii('0');
 		}
-		//this is not needed but the compiler requires it
-		return Ascii(32);		
 	}
 	
 	//quick and dirty substitute for java String.substring
@@ -1962,7 +3607,7 @@ type Ident uint16
 	func fromIdent(c Ident,set int) string {
 		w := int(c);
 		if (w < 257 || w > 65407) {
-			fmt.Println("[fromIdent]" + strconv.Itoa(w) + " is out of range");
+			fmt.Fprintln(os.Stderr, "[fromIdent]" + strconv.Itoa(w) + " is out of range");
 			return "";
 		}
 		// create a byte array of size 4.  Even though the size is known
@@ -1997,7 +3642,11 @@ then a space.
 3. Float.  This is the type FLOT, and 3 characters holding the number, and a zero.
 (Note that this isn't actually floating point because it can only hold a value from 0..63999 to the right
 of the decimal point.  See Num48 above. The precision is more like a half-float).
+3a. Long and double.  These are the type LONG or DUBL, 4 chars holding all 64 bits, high first, and a zero.
+A Num48 can't hold them, so they are kept exactly, and a double is a real IEEE double.
 4. Arrays of references. It stores the type, which is an Ident of the name.
+4a. Java arrays.  These are the type ARRY, the length, the Ident of the element type and then the elements.
+Each element takes as many chars as it needs, so an int takes 3, the same as an INTG.
 5. Maps.  This is my version of a hashtable, with a map of the Ident to the ref.
 Maps are used to store classes and objects.  They use a lot of memory, so this places a limit
 on how many objects you can create.
@@ -2010,6 +3659,9 @@ To provide a little bit of type safety, when accessing the memory you have to us
 
 const MEMBASE = 256;
 
+//the biggest memory that a Ref can reach
+const MEMSIZE = 65536 - MEMBASE;
+
 type Ref uint16;
 
 var memory []uint16;
@@ -2030,18 +3682,15 @@ func getType(r Ref) Ident {
 	return Ident(memory[int(r) - MEMBASE]);
 }
 
-//in Java, you can get the chars from a String with toCharArray.  This does the same thing
-//Note that in Java, strings are made up of chars so this is easy.
-//In golang, strings are made up of characters which can have more than one byte.
-//We could theoretically handle characters made up of two-bytes, but in practice these
-//are all ascii and we waste the extra byte
+//in Java, you can get the chars from a String with toCharArray.  This does the same thing.
+//Java chars are UTF-16, so a character outside the BMP takes 2 chars, the same as in Java
 func toCharArray(str string) []uint16 {
-	ba := []byte(str)
-	ca := make([]uint16,len(ba))
-	for i :=0; i< len(ba); i++ {
-		ca[i] = uint16(ba[i]);
-	}
-	return ca;
+	return utf16.Encode([]rune(str));
+}
+
+//the opposite of toCharArray
+func fromCharArray(ca []uint16) string {
+	return string(utf16.Decode(ca));
 }
 
 //a replacement for java System.arraycopy, but this only works with []uint16 arrays
@@ -2089,8 +3738,12 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 		return newNum(Ident(INTG),iv);
 	}
 	
-	//could this be combined with newArray?
+	//could this be combined with newArray?  Returns NIL if there isn't room
 	func newNum(typ Ident,iv Num48) Ref {
+		if (ptr+5 > len(memory)) {
+			fmt.Fprintln(os.Stderr, "[newNum] no room for a number");
+			return Ref(NIL);
+		}
 		addr := ptr;
 		//the size allocated is 2 greater than the length because we save the word "INTG", and add a 0 to the end
 		ptr = addr + 5;
@@ -2109,7 +3762,7 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 	//return the Num48 representation of an int from memory
 	func readInt(r Ref) Num48 {
 		p := int(r) - MEMBASE;
-		lv := uint64(memory[p+1]*C64*C64) + uint64(memory[p+2]*C64) + uint64(memory[p+3])
+		lv := uint64(memory[p+1])*uint64(C64)*uint64(C64) + uint64(memory[p+2])*uint64(C64) + uint64(memory[p+3])
 		return Num48(lv);
 	}
 
@@ -2126,7 +3779,9 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 		addr := int(iref)-MEMBASE;
 		name := memory[addr];
 		if (name==INTG) {
-			fmt.Println("[updateInt] changing value of reference"+strconv.Itoa(int(iref))+" to "+strconv.Itoa(int(iv)));
+			if debug {
+				fmt.Fprintln(os.Stderr, "[updateInt] changing value of reference"+strconv.Itoa(int(iref))+" to "+strconv.Itoa(int(iv)));
+			}
 			c0,c1,c2 := Num48ToChars(iv);
 			memory[addr+1]=c0;
 			memory[addr+2]=c1;
@@ -2137,6 +3792,77 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 		}
 	}
 
+	//updates an int or a float to the new value, without the debugging of updateInt
+	//returns false if the ref is not a number
+	func updateNum(nref Ref,v Num48) bool {
+		addr := int(nref)-MEMBASE;
+		name := memory[addr];
+		if (name==INTG || name==FLOT) {
+			c0,c1,c2 := Num48ToChars(v);
+			memory[addr+1]=c0;
+			memory[addr+2]=c1;
+			memory[addr+3]=c2;
+			return true;
+		}
+		return false;
+	}
+
+	//---------------------------------------------
+	//create longs and doubles
+	/**
+	* Store the 64 bits of a long or double and return the reference.
+	* We store the ident LONG or DUBL, 4 chars and a zero, so 6 chars in all.
+	* Returns NIL if there isn't room
+	*/
+	func newWide(typ Ident, bits uint64) Ref {
+		if (ptr+6 > len(memory)) {
+			fmt.Fprintln(os.Stderr, "[newWide] no room for a long or double");
+			return Ref(NIL);
+		}
+		addr := ptr;
+		ptr = addr + 6;
+		memory[addr] = uint16(typ);
+		for i := 0; i < 4; i++ {
+			memory[addr+1+i] = uint16(bits >> uint(48-16*i));
+		}
+		return Ref(addr + MEMBASE);
+	}
+
+	func newLong(lv int64) Ref {
+		return newWide(Ident(LONG), uint64(lv));
+	}
+
+	func newDouble(dv float64) Ref {
+		return newWide(Ident(DUBL), math.Float64bits(dv));
+	}
+
+	//is this a long or a double?
+	func isWide(r Ref) bool {
+		return r != Ref(NIL) && (getType(r) == Ident(LONG) || getType(r) == Ident(DUBL));
+	}
+
+	//return the 64 bits of a long or double from memory
+	func readWide(r Ref) uint64 {
+		p := int(r) - MEMBASE;
+		bits := uint64(0);
+		for i := 0; i < 4; i++ {
+			bits = bits<<16 | uint64(memory[p+1+i]);
+		}
+		return bits;
+	}
+
+	//updates a long or double to the new value.  Returns false if the ref is not one
+	func updateWide(r Ref, bits uint64) bool {
+		if (!isWide(r)) {
+			return false;
+		}
+		addr := int(r) - MEMBASE;
+		for i := 0; i < 4; i++ {
+			memory[addr+1+i] = uint16(bits >> uint(48-16*i));
+		}
+		return true;
+	}
+
 	//--------------------------------------------
 	//array
 	/**
@@ -2144,9 +3870,9 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 	// maybe makeEmptyArray
 	*/
 	func newEmptyArray(ty Ident,alen int) Ref {
-		//this is arbitrary
-		if (alen<0 || alen>1023) {
-			fmt.Println("[newEmptyArray] array is too long "+strconv.Itoa(alen));
+		//the length has to fit in 1 char, and the array has to fit in memory
+		if (alen<0 || alen>65535 || ptr+alen+3 > len(memory)) {
+			fmt.Fprintln(os.Stderr, "[newEmptyArray] no room for an array of length "+strconv.Itoa(alen));
 			return Ref(NIL);
 		}
 		addr := ptr;
@@ -2164,8 +3890,8 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 	*/
 	func newArray(ty Ident, ca []uint16) Ref {
 		alen := len(ca)
-		if (len(ca) > 1023) {
-			fmt.Println("[newArray] array is too long "+strconv.Itoa(alen));
+		if (alen>65535 || ptr+alen+3 > len(memory)) {
+			fmt.Fprintln(os.Stderr, "[newArray] no room for an array of length "+strconv.Itoa(alen));
 			return Ref(NIL);
 		}
 		addr := ptr;
@@ -2193,24 +3919,134 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 		return memory[int(aref)-MEMBASE+index+2];
 	}
 
+	//--------------------------------------------
+	//Java arrays
 	/**
-	* Create a new table.  The type is usually CLASS or OBJECT or TABLE but it could be something else.
-	* Specify the max number of rows needed.  The table can't be resized.
-	*
-	* This uses my Hashtable algorithm, which doesn't need linked lists.  This calculates the number
-	* of rows, which is always an odd number.  The slot number is the key mod rows.
+	* Create a Java array of the given element type, with every element 0, or NIL for references.
+	* The element types are:
+	*	BOOL, BYTE, CHAR and SHRT, which take 1 char
+	*	INTG and FLOT, which take 3 chars, the same as a Num48
+	*	LONG and DUBL, which take 4 chars, the same as newWide
+	*	STRG, OBJT and ARRY, which are references and take 1 char
+	* The length is the number of elements, so arrayLength works on these too
 	*/
-	func newTable(tipe Ident,rows int) Ref {
-		if (rows<2 || rows>127) {
-			fmt.Println("[newTable] table is too big "+strconv.Itoa(rows));
+	func newJavaArray(elem Ident, alen int) Ref {
+		width := elementWidth(elem);
+		if (alen<0 || alen>65535 || ptr+alen*width+4 > len(memory)) {
+			fmt.Fprintln(os.Stderr, "[newJavaArray] no room for an array of length "+strconv.Itoa(alen));
+			return Ref(NIL);
+		}
+		addr := ptr;
+		memory[addr]=uint16(ARRY);
+		memory[addr+1]=uint16(alen);
+		memory[addr+2]=uint16(elem);
+		if (isRefElement(elem)) {
+			for i := 0; i < alen; i++ {
+				memory[addr+3+i]=NIL;
+			}
+		}
+		//this has a trailing zero for spacing
+		ptr = addr+alen*width+4;
+		return Ref(addr+MEMBASE);
+	}
+
+	func arrayElementType(aref Ref) Ident {
+		return Ident(memory[int(aref)-MEMBASE+2]);
+	}
+
+	func isRefElement(elem Ident) bool {
+		return elem == Ident(STRG) || elem == Ident(OBJT) || elem == Ident(ARRY);
+	}
+
+	//the number of chars that each element takes
+	func elementWidth(elem Ident) int {
+		switch (elem) {
+			case Ident(INTG), Ident(FLOT):
+				return 3;
+			case Ident(LONG), Ident(DUBL):
+				return 4;
+		}
+		return 1;
+	}
+
+	//where element i starts in memory
+	func elementAddr(aref Ref, i int) int {
+		return int(aref)-MEMBASE+3+i*elementWidth(arrayElementType(aref));
+	}
+
+	//the value of element i.  Small ints are sign extended, except for chars.  This doesn't
+	//work for longs and doubles, which use loadWideElement
+	func loadElement(aref Ref, i int) Num48 {
+		a := elementAddr(aref, i);
+		switch (arrayElementType(aref)) {
+			case Ident(BOOL), Ident(BYTE):
+				return IntToNum48(int(int8(memory[a])));
+			case Ident(CHAR):
+				return IntToNum48(int(memory[a]));
+			case Ident(SHRT):
+				return IntToNum48(int(int16(memory[a])));
+			case Ident(INTG), Ident(FLOT):
+				return CharsToNum48(memory[a], memory[a+1], memory[a+2]);
+		}
+		return Num48(memory[a]);
+	}
+
+	//set element i.  Small ints are cut down to size, the same as in Java
+	func storeElement(aref Ref, i int, v Num48) {
+		a := elementAddr(aref, i);
+		switch (arrayElementType(aref)) {
+			case Ident(BOOL):
+				memory[a] = uint16(Num48ToInt(v) & 1);
+			case Ident(BYTE):
+				memory[a] = uint16(uint8(Num48ToInt(v)));
+			case Ident(CHAR), Ident(SHRT):
+				memory[a] = uint16(Num48ToInt(v));
+			case Ident(INTG), Ident(FLOT):
+				memory[a], memory[a+1], memory[a+2] = Num48ToChars(v);
+			default:
+				memory[a] = uint16(v);
+		}
+	}
+
+	func loadWideElement(aref Ref, i int) uint64 {
+		a := elementAddr(aref, i);
+		bits := uint64(0);
+		for j := 0; j < 4; j++ {
+			bits = bits<<16 | uint64(memory[a+j]);
+		}
+		return bits;
+	}
+
+	func storeWideElement(aref Ref, i int, bits uint64) {
+		a := elementAddr(aref, i);
+		for j := 0; j < 4; j++ {
+			memory[a+j] = uint16(bits >> uint(48-16*j));
+		}
+	}
+
+	/**
+	* Create a new table.  The type is usually CLASS or OBJECT or TABLE but it could be something else.
+	* Specify the max number of rows needed.  The table can't be resized.
+	*
+	* This uses my Hashtable algorithm, which doesn't need linked lists.  This calculates the number
+	* of rows, which is always an odd number.  The slot number is the key mod rows.
+	* Returns NIL if there isn't room for it
+	*/
+	func newTable(tipe Ident,rows int) Ref {
+		if (rows<2) {
+			rows = 2;
+		}
+		//allocate the number of rows, making this bigger than requested	
+		rows = int(float64(rows) * 1.3)+1;
+		//make it an odd number
+		if ((rows % 2) == 0) {
+			rows++;
+		}
+		//the rows have to fit in 1 char, and the table has to fit in memory
+		if (rows>65535 || ptr+(rows*2)+4 > len(memory)) {
+			fmt.Fprintln(os.Stderr, "[newTable] no room for a table with "+strconv.Itoa(rows)+" rows");
 			return Ref(NIL);
 		}
-		//allocate the number of rows, making this bigger than requested	
-		rows = int(float64(rows) * 1.3)+1;
-		//make it an odd number
-		if ((rows % 2) == 0) {
-			rows++;
-		}
 		//System.out.println("DEBUG: Memory.createTable creating table with "+rows+" rows");
 		addr := ptr;
 		//System.out.println("debug: Memory.newTable type="+type+", tid = "+(int)tid);
@@ -2226,424 +4062,4867 @@ func arraycopy(src []uint16, srcpos int, dest []uint16, destpos int, alen int) {
 	func tableRows(r Ref) int {
 		return int(memory[r-MEMBASE+1]);
 	}
+
+	//the address of the slot for a key.  This starts at key mod rows and then looks at the next row,
+	//wrapping around, until it finds the key or an empty slot.  It looks at every row before it gives up,
+	//and returns -1 if the table is full
+	func findSlot(tref Ref,key Ident) int {
+		rows := tableRows(tref);
+		hash := int(key) % rows;
+		for misses := 0; misses < rows; misses++ {
+			slot := int(tref)-MEMBASE+(((hash+misses) % rows)*2)+2;
+			k2 := int(memory[slot]);
+			if (k2==0 || k2==int(key)) {
+				return slot;
+			}
+			//taken by another key, so look further
+		}
+		return -1;
+	}
 	
 	/**
 	* Put a value in the table.  The key must be an ident and the value
-	* must be a ref
+	* must be a ref.  If the key is already there, the value is replaced.
+	* Returns an error if the table is full
 	*/
-	func put(tref Ref,key Ident,val Ref) {
-		rows := tableRows(tref);
-		hash := int(key) % rows;
-		slot := int(tref)-MEMBASE+(hash*2)+2;
-		k2 := int(memory[slot]);
-		looking := true;
-		misses := 0;
-		for looking {
-			if k2==0 {
-				//found an empty slot, use it
-				memory[slot]=uint16(key);
-				memory[slot+1]=uint16(val);
-				looking=false;
-				//System.out.println("DEBUG: Memory.put found an empty slot at "+slot+"; filling it");				
-			} else if k2==int(key) {
-				//it already exists, replace the value
-				memory[slot+1]=uint16(val);
-				looking=false;
-				//System.out.println("DEBUG: Memory.put found the same slot at "+slot+"; replacing it");
-			} else {
-				misses++;
-				if (misses>2) {
-					fmt.Println("[put] too many slot misses, please increase table size");
-					looking = false;
-					//just return without saving it
-				}
-				//taken by another slot
-				//System.out.println("DEBUG: Memory.put; the slot at "+slot+" is used by "+k2+"; looking further");
-				slot = slot + 2;
-				if (slot > (int(tref)-MEMBASE+rows*2)) {
-					slot = slot - (rows*2);
-					k2 = int(memory[slot]);
-					//System.out.println("DEBUG: Memory.put is looking for the next slot at "+slot+"; wrapping");
-				} else {
-					k2 = int(memory[slot]);
-					//System.out.println("DEBUG: Memory.put is looking for the next slot at "+slot);
-				}
-			}
+	func put(tref Ref,key Ident,val Ref) error {
+		slot := findSlot(tref, key);
+		if (slot < 0) {
+			return errors.New("the table at "+strconv.Itoa(int(tref))+" is full, so "+to_hex(key)+" can't be put in it");
 		}
+		memory[slot]=uint16(key);
+		memory[slot+1]=uint16(val);
+		return nil;
 	}
 
-
 	/**
 	* Retrieve a value from the table.  The value will be NIL (256)
 	* if it doesn't exist
 	*/
 
 	func get(tref Ref,key Ident) Ref {
-		rows := tableRows(tref);
-		hash := int(key) % rows;
-		slot := int(tref)-MEMBASE+(hash*2)+2;
-		k2 := int(memory[slot]);
-		looking := true;
-		misses := 0;
-		for looking {
-			if k2==0 {
-				//not found
-				looking=false;
-				return Ref(NIL);			
-			} else if k2==int(key) {
-				//found
-				looking=false;
-				return Ref(memory[slot+1]);
-			} else {
-				misses++;
-				if (misses>2) {
-					fmt.Println("[put] too many slot misses, please increase table size");
-					looking = false;
-					return Ref(NIL);
-				}
-				//taken by another slot
-				//System.out.println("DEBUG: Memory.put; the slot at "+slot+" is used by "+k2+"; looking further");
-				slot = slot + 2;
-				if (slot > (int(tref)-MEMBASE+rows*2)) {
-					slot = slot - (rows*2);
-					k2 = int(memory[slot]);
-					//System.out.println("DEBUG: Memory.get is looking for the next slot at "+slot+"; wrapping");
-				} else {
-					k2 = int(memory[slot]);
-					//System.out.println("DEBUG: Memory.get is looking for the next slot at "+slot);
-				}
-			}
+		slot := findSlot(tref, key);
+		if (slot < 0 || memory[slot] == 0) {
+			//not found
+			return Ref(NIL);
 		}
-		return Ref(NIL);
+		return Ref(memory[slot+1]);
 	}
-//==============================================
-/** Compiler.  This reads in the Class file and converts it to the format that I want in memory.
-*/
+//==============================
+// Descriptors.  A descriptor like (ILjava/lang/String;)V gives the types of a field or method.
+// This is the same parser as in classfile.go, which also reads the generic signatures from the
+// Signature attribute.  The VM only needs descriptors, so generic is never set here (JVMS 4.3)
 
-//returns classfile table ref
-func run_compiler(cf *ClassFile) Ref {
+//DescriptorError says what is wrong with a descriptor or signature, and where in the string
+type DescriptorError struct {
+	Desc string;
+	Pos int;
+	Detail string;
+}
 
-	cref := createClassTable(cf);
-	loadConstants(cf, cref)	
-	loadFields(cf, cref);
-		//loadMethods(jclass,ctable);
-	return cref;
+func (e *DescriptorError) Error() string {
+	return "bad descriptor "+e.Desc+" at "+strconv.Itoa(e.Pos)+": "+e.Detail
 }
 
-func createClassTable(cf *ClassFile) Ref {
-	pool := cf.pool;
-	plen := pool.size();
+//a type in a descriptor or signature
+type JavaType struct {
+	//one of BCDFIJSZV for a primitive or void, L for a class, T for a type variable and [ for an array
+	tag byte;
+	//the class name, like java/util/Map$Entry, or the name of the type variable
+	name string;
+	//the type arguments of a generic class
+	args []*TypeArgument;
+	//the class that an inner class is in, if it has type arguments, as in Outer<TT;>.Inner
+	outer *JavaType;
+	//the type of the elements of an array
+	elem *JavaType;
+}
 
-	//the rule of thumb is that we want the cpool length / 2 + 3;
-	//we add 1 for rounding, 1 for cname, and 1 for main
-	tlen := (plen / 2) + 3;
-	fmt.Println("creating class table with "+strconv.Itoa(tlen)+" rows");
-	//create a table to store the constant pool
-	cref := newTable( Ident(CLAS),tlen);
-	return cref;
+//a type argument is a type, or a wildcard like ? extends Number
+type TypeArgument struct {
+	//'+' for extends, '-' for super, '*' for an unbounded wildcard, or 0 for a plain type
+	wildcard byte;
+	t *JavaType;
 }
 
-func loadConstants(cf *ClassFile, cref Ref) {
-	cpool := cf.pool;
-	for i := 1;i<cpool.size();i++ {
-		t := cpool.tag(i);
-		k := cpool.constant_pool[i]
-		if t==CONSTANT_String {
-			cs := k.(*CONSTANT_String_info);
-			str := cs.cstr
-			//store string in memory
-			chars := toCharArray(str)
-			sref := newString(chars)
-			fmt.Println("[loadConstants] saved string '"+str+"' in memory as "+strconv.Itoa(int(sref))) 
-			key := strconv.Itoa(9000 + i)
-			idk := toIdent(key)
-			fmt.Println("[loadConstants] storing key "+strconv.Itoa(int(idk))+", value "+strconv.Itoa(int(sref)))
-			put(cref,idk,sref)
-		} else if t==CONSTANT_Class {	//almost identical to Constant_String
-			cs := k.(*CONSTANT_String_info);
-			str := cs.cstr
-			//store string in memory
-			chars := toCharArray(str)
-			sref := newClass(chars)
-			fmt.Println("[loadConstants] saved class '"+str+"' in memory as "+strconv.Itoa(int(sref))) 
-			key := strconv.Itoa(9000 + i)
-			idk := toIdent(key)
-			fmt.Println("[loadConstants] storing key "+strconv.Itoa(int(idk))+", value "+strconv.Itoa(int(sref)))
-			put(cref,idk,sref)
-		} else if t==CONSTANT_Integer {
-			ci := k.(*CONSTANT_Integer_info)
-			ival := ci.ival;		
-			n := IntToNum48(ival)
-			//store the int in memory. This takes up 4 chars!
-			iref := newInt(n);		
-			key := strconv.Itoa(9000 + i)
-			idk := toIdent(key)
-			fmt.Println("[loadConstants], storing Integer into "+strconv.Itoa(int(idk)));
-			put(cref,idk,iref);
-		} else if t==CONSTANT_Float {
-			cf := k.(*CONSTANT_Float_info)
-			fval := cf.fval;		
-			n := FloatToNum48(fval)
-			//store the float in memory. This takes up 4 chars!
-			fref := newFloat(n);		
-			key := strconv.Itoa(9000 + i)
-			idk := toIdent(key)
-			fmt.Println("[loadConstants], storing Float into "+strconv.Itoa(int(idk)));
-			put(cref,idk,fref);
-		}
-		//these are the only constants we care about, although there could be debugging here
+//a type parameter like T extends Number & Comparable<T>
+type TypeParameter struct {
+	name string;
+	//this is nil if there are only interface bounds
+	class_bound *JavaType;
+	interface_bounds []*JavaType;
+}
+
+//the parts of a method descriptor or method signature.  A descriptor has no type
+//parameters or throws
+type MethodSignature struct {
+	type_params []*TypeParameter;
+	params []*JavaType;
+	ret *JavaType;
+	throws []*JavaType;
+}
+
+func (t *JavaType) isVoid() bool {
+	return t.tag == 'V'
+}
+
+func (t *JavaType) isPrimitive() bool {
+	return strings.IndexByte("BCDFIJSZ", t.tag) >= 0
+}
+
+func (t *JavaType) isArray() bool {
+	return t.tag == '['
+}
+
+//the number of array dimensions, or 0 if it isn't an array
+func (t *JavaType) dims() int {
+	n := 0
+	for t.tag == '[' {
+		n++
+		t = t.elem
 	}
+	return n
 }
 
-//this only looks at static fields because non-static fields are stored
-//in the object
-func loadFields(cf *ClassFile, cref Ref) {
-	fa := cf.fields
-	for i := 0;i<len(fa);i++ {
-		f := fa[1];
-		if (f.isStatic()) {
-			fname := f.name();
-			idf := toIdent(fname);
-			cvx := f.getConstantValueIndex()
-			if cvx == 0 {
-				put(cref,idf,Ref(NIL));
-			} else {
-				key := strconv.Itoa(9000 + i)
-				idk := toIdent(key)
-				//get the constant from the constant pool
-				v := get(cref,idk)
-				//v could be nil, which would be an error
-				put(cref,idf,v)
-			}	
+//the type of the elements of an array, after all the dimensions
+func (t *JavaType) elementType() *JavaType {
+	for t.tag == '[' {
+		t = t.elem
+	}
+	return t
+}
+
+//the number of local variable slots.  long and double take 2
+func (t *JavaType) slots() int {
+	switch (t.tag) {
+		case 'J', 'D': return 2
+		case 'V': return 0
+	}
+	return 1
+}
 
+//the erased type as a descriptor.  A type variable becomes Object, because its bounds
+//are somewhere else
+func (t *JavaType) descriptor() string {
+	switch (t.tag) {
+		case 'L': return "L"+t.name+";"
+		case 'T': return "Ljava/lang/Object;"
+		case '[': return "["+t.elem.descriptor()
+	}
+	return string(t.tag)
+}
+
+//the type as it would be written in Java, like java.util.Map<K, V>.Entry or int[]
+func (t *JavaType) String() string {
+	switch (t.tag) {
+		case 'B': return "byte"
+		case 'C': return "char"
+		case 'D': return "double"
+		case 'F': return "float"
+		case 'I': return "int"
+		case 'J': return "long"
+		case 'S': return "short"
+		case 'Z': return "boolean"
+		case 'V': return "void"
+		case 'T': return t.name
+		case '[': return t.elem.String()+"[]"
+	}
+	s := ""
+	if t.outer != nil {
+		s = t.outer.String()+"."+strings.TrimPrefix(t.name, t.outer.name+"$")
+	} else {
+		s = strings.ReplaceAll(t.name, "/", ".")
+	}
+	if len(t.args) > 0 {
+		args := make([]string, len(t.args))
+		for i, a := range t.args {
+			args[i] = a.String()
 		}
+		s = s + "<" + strings.Join(args, ", ") + ">"
 	}
+	return s
 }
 
-//=================================
-/**
-* Translate the java byte code to my format.  The only change is the constant pool lookup
-* The translated code is 2 more than the input because:
-*	it has the method name and it has the number of params
-*/
+func (a *TypeArgument) String() string {
+	switch (a.wildcard) {
+		case '*': return "?"
+		case '+': return "? extends "+a.t.String()
+		case '-': return "? super "+a.t.String()
+	}
+	return a.t.String()
+}
 
-//func translateCode(cf *ClassFile,mname uint16, params int, mcode byte[]) {
-//	cpool := cf.pool;
-//	thisName := cf.getClassName();
-	
-	//xxxxxxxxxxx
-
-
-	//private char[] translateCode(JavaClass jclass,char mname,int params,byte[] code) {
-	//	ConstantPool cpool = jclass.getConstantPool();
-//		String thisName = jclass.getClassName();
-//		char[] out = new char[code.length+2];
-//		out[0]=mname;
-//		out[1]=(char)params;
-
-//		char bytecode = (char)0;
-//		byte indexbyte1 = (byte)0;
-//		byte indexbyte2 = (byte)0;
-//		int index=0;
-//		char key=(char)0;
-
-//		for (int i=0;i<code.length;i++) {
-
-//			bytecode = (char)(code[i] & 0xFF);
-			//System.out.println("analyzing bytecode for "+(int)bytecode);
-
-			//only change the code that uses the constant pool
-			//which is:
-			//	anewarray
-			//	checkcast
-			//	getfield
-			//	getstatic
-			//	instanceof
-			//	invokespecial
-			//	invokestatic
-			//	invokevirtual
-			//	ldc
-			//	multianewarray - skip this
-			//	newobj
-			//	putfield
-			//	putstatic
-
-//			switch(bytecode) {
-
-//				case LDC:
-					//LDC takes one argument, which is the index
-//					index = (int)code[i+1];
-//					key=lookupConstant(cpool,index,thisName);
-//					out[i+2]=bytecode;
-//					out[i+3]=key;
-					//advance counter by 1
-//					i=i+1;
-//					break;
-//				case ANEWARRAY:
-//				case CHECKCAST:
-//				case GETFIELD:
-//				case GETSTATIC:
-//				case INSTANCEOF:
-//				case INVOKESPECIAL:
-//				case INVOKESTATIC:
-//				case INVOKEVIRTUAL:
-//				case NEWOBJ:
-//				case PUTFIELD:
-//				case PUTSTATIC:
-//					indexbyte1 = code[i+1];
-					//System.out.println("indexbyte1="+(int)indexbyte1);
-//					indexbyte2 = code[i+2];
-					//System.out.println("indexbyte2="+(int)indexbyte2);
-//					index = indexbyte1 << 8 | indexbyte2;
-					//System.out.println("debug: Compiler.translateCode bytecode="+(int)bytecode+",index="+index);
-//					key=lookupConstant(cpool,index,thisName);
-//					out[i+2]=bytecode;
-//					out[i+3]=key;
-//					out[i+4]=NOP;	//0
-					//advance counter by 2
-//					i=i+2;
-//					break;
-//				default: out[i+2]=bytecode;
-//			}	//end switch
-//		} //end for
-//		return out;
-//	} //end translate code
+//a bound of Object is left out, as it would be in Java
+func (tp *TypeParameter) String() string {
+	bounds := []string{}
+	if tp.class_bound != nil && !(tp.class_bound.tag == 'L' && tp.class_bound.name == "java/lang/Object") {
+		bounds = append(bounds, tp.class_bound.String())
+	}
+	for _, b := range tp.interface_bounds {
+		bounds = append(bounds, b.String())
+	}
+	if len(bounds) == 0 {
+		return tp.name
+	}
+	return tp.name+" extends "+strings.Join(bounds, " & ")
+}
 
+//the number of params, not counting "this"
+func (ms *MethodSignature) paramCount() int {
+	return len(ms.params)
+}
 
-//===================================================
-	/**
-	* We are helping a bytecode that is referring to something in the constant pool.
-	* What we do is lookup the constant pool, and then translate it to our numbering system.
-	* We return the u16 that has the name, which is either the method or field name, index + 9000,
-	* or special name
-	*/
-func lookupConstant(cpool *ConstantPool, index int, thisClassName string) uint16 {
-	t := cpool.tag(index);
-	k := cpool.getConstant(index);
-	//this is the return value
-	name := uint16(0);
-	
-	if t==CONSTANT_Class {	//almost identical to Constant_String
-		cc := k.(*CONSTANT_String_info);
-		className := cc.cstr;
-		if className=="java/lang/StringBuilder" {
-			name = CLASS_SB;
-		} else {
-			fmt.Println("[lookupConstant] className: "+ className +" not found; code may need enhanced")
-			key := strconv.Itoa(9000+index);
-			name = uint16(toIdent(key));
-		} 
-	} else if t==CONSTANT_Fieldref {
-		cfr := k.(*CONSTANT_ref_info);
-		//this points to the class
-		k2 := cpool.getConstant(int(cfr.class_index));
-		//we should probably get the tag before casting this
-		//but lets risk it
-		cc2 := k2.(*CONSTANT_String_info);
-		fcname := cc2.cstr
-		natx := cfr.getNameAndTypeIndex();
-		k3 := cpool.getConstant(int(natx));
-		cnat := k3.(*CONSTANT_NameAndType_info);
-		fname := cnat.getName()
-		//so we are looking for a fieldref. If it is the same class, then just lookup by name
-		if (fcname==thisClassName) {
-			name = uint16(toIdent(fname));
-		} else {
-			//special cases
-			if (fcname=="java/lang/System" && fname=="out") {
-				name = SYSOUT; 
-			} else {
-				//not found - this is bad
-				fmt.Println("[lookupConstant] ERROR: unable to lookup fieldref,class is "+fcname+" field is "+fname); 
-			}
-		}
-	} else if t==CONSTANT_Methodref {
-		cmr := k.(*CONSTANT_ref_info);
-		class_index := cmr.getClassIndex();
-		k4 := cpool.getConstant(int(class_index));
-		cc2 := k4.(*CONSTANT_String_info);
-		mcname := cc2.cstr	
-		natx := cmr.getNameAndTypeIndex();
-		k5 := cpool.getConstant(int(natx));
-		cnat := k5.(*CONSTANT_NameAndType_info);
-		mname := cnat.getName()
-		msig := cnat.getSignature()
-		if mcname==thisClassName {
-			name = uint16(toIdent(mname));
-		} else {
-			//special cases
-			if (mcname=="java/lang/Object" && mname=="<init>") {
-				name = OBJINIT;
-			} else if mcname=="java/io/PrintStream" && mname=="println" && msig=="(Ljava/lang/String;)V" {
-					name = PRNS;
-			} else if mcname=="java/io/PrintStream" && mname=="println" && msig=="(I)V" {
-					name = PRNI;
-			} else if mcname=="java/io/PrintStream" && mname=="println" && msig=="(F)V" {
-					name = PRNF;
-			} else if mcname=="java/lang/Integer" && mname=="parseInt" {
-					name = PARSEINT;
-			} else if mcname=="java/lang/StringBuilder" && mname=="<init>" {
-					name = SB_INIT;
-			} else if mcname=="java/lang/StringBuilder" && mname=="append" && msig=="(Ljava/lang/String;)Ljava/lang/StringBuilder;" {
-					name = SB_APPEND_STR;
-			} else if mcname=="java/lang/StringBuilder" && mname=="append" && msig=="(I)Ljava/lang/StringBuilder;" {
-					name = SB_APPEND_I;
-			} else if mcname=="java/lang/StringBuilder" && mname=="toString" {
-					name = SB_TOSTR;
-			} else {
-				fmt.Println("[lookupConstant] ERROR: methodref, class is "+mcname+" method is "+mname+"; sig is "+msig); 
+//the number of local variable slots taken by the params, not counting "this"
+func (ms *MethodSignature) paramSlots() int {
+	n := 0
+	for _, t := range ms.params {
+		n = n + t.slots()
+	}
+	return n
+}
+
+//the parser.  It stops at the first error
+type sigParser struct {
+	s string;
+	i int;
+	generic bool;		//false for a descriptor
+	err error;
+}
+
+func (p *sigParser) fail(detail string) {
+	if p.err == nil {
+		p.err = &DescriptorError{Desc: p.s, Pos: p.i, Detail: detail}
+	}
+}
+
+//the next character, or 0 at the end
+func (p *sigParser) peek() byte {
+	if p.err != nil || p.i >= len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sigParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail("expecting "+string(c))
+		return
+	}
+	p.i++
+}
+
+//an identifier can't have any of . ; [ / < > :
+func (p *sigParser) identifier() string {
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(".;[/<>:", p.s[p.i]) < 0 {
+		p.i++
+	}
+	if p.i == start {
+		p.fail("expecting an identifier")
+	}
+	return p.s[start:p.i]
+}
+
+//a field type.  void is only allowed as a return type
+func (p *sigParser) fieldType() *JavaType {
+	c := p.peek()
+	if strings.IndexByte("BCDFIJSZ", c) >= 0 {
+		p.i++
+		return &JavaType{tag: c}
+	}
+	return p.referenceType()
+}
+
+func (p *sigParser) returnType() *JavaType {
+	if p.peek() == 'V' {
+		p.i++
+		return &JavaType{tag: 'V'}
+	}
+	return p.fieldType()
+}
+
+func (p *sigParser) referenceType() *JavaType {
+	switch (p.peek()) {
+		case 'L':
+			return p.classType()
+		case '[':
+			start := p.i
+			p.i++
+			elem := p.fieldType()
+			t := &JavaType{tag: '[', elem: elem}
+			if p.err == nil && t.dims() > 255 {
+				p.i = start
+				p.fail("more than 255 array dimensions")
+			}
+			return t
+		case 'T':
+			if p.generic {
+				p.i++
+				t := &JavaType{tag: 'T', name: p.identifier()}
+				p.expect(';')
+				return t
+			}
+	}
+	p.fail("expecting a type")
+	return &JavaType{tag: 'V'}
+}
+
+//a class name is identifiers separated by /.  In a signature it can have type arguments,
+//and be followed by inner classes
+func (p *sigParser) classType() *JavaType {
+	p.expect('L')
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(".;[<>:", p.s[p.i]) < 0 {
+		p.i++
+	}
+	name := p.s[start:p.i]
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		p.i = start
+		p.fail("bad class name")
+	}
+	t := &JavaType{tag: 'L', name: name}
+	if p.generic {
+		if p.peek() == '<' {
+			t.args = p.typeArguments()
+		}
+		for p.peek() == '.' {
+			p.i++
+			inner := &JavaType{tag: 'L', outer: t}
+			inner.name = t.name+"$"+p.identifier()
+			if p.peek() == '<' {
+				inner.args = p.typeArguments()
 			}
+			t = inner
 		}
-	} else if t==CONSTANT_String  {
-		//this is easy, just lookup the k value
-		key := strconv.Itoa(9000+index);
-		name = uint16(toIdent(key));
-	} else if t==CONSTANT_Integer {
-		key := strconv.Itoa(9000+index);
-		name = uint16(toIdent(key));	
-	} else if t==CONSTANT_Float {
-		key := strconv.Itoa(9000+index);
-		name = uint16(toIdent(key));	
-	} else {
-		//this is certainly unexpected
-		fmt.Println("[lookupConstant] ERROR: Constant is tag "+strconv.Itoa(t));
 	}
-	return name;
+	p.expect(';')
+	return t
+}
+
+func (p *sigParser) typeArguments() []*TypeArgument {
+	p.expect('<')
+	args := []*TypeArgument{}
+	for p.err == nil && p.peek() != '>' {
+		a := &TypeArgument{}
+		switch (p.peek()) {
+			case '*':
+				p.i++
+				a.wildcard = '*'
+			case '+', '-':
+				a.wildcard = p.peek()
+				p.i++
+				a.t = p.referenceType()
+			default:
+				a.t = p.referenceType()
+		}
+		args = append(args, a)
+	}
+	if len(args) == 0 {
+		p.fail("empty type arguments")
+	}
+	p.expect('>')
+	return args
+}
+
+func (p *sigParser) typeParameters() []*TypeParameter {
+	p.expect('<')
+	tps := []*TypeParameter{}
+	for p.err == nil && p.peek() != '>' {
+		tp := &TypeParameter{name: p.identifier()}
+		p.expect(':')
+		//the class bound can be empty when there are interface bounds
+		c := p.peek()
+		if c == 'L' || c == 'T' || c == '[' {
+			tp.class_bound = p.referenceType()
+		}
+		for p.peek() == ':' {
+			p.i++
+			tp.interface_bounds = append(tp.interface_bounds, p.referenceType())
+		}
+		tps = append(tps, tp)
+	}
+	if len(tps) == 0 {
+		p.fail("empty type parameters")
+	}
+	p.expect('>')
+	return tps
 }
 
-//===================================================
-//main
+func (p *sigParser) method() *MethodSignature {
+	ms := &MethodSignature{}
+	if p.generic && p.peek() == '<' {
+		ms.type_params = p.typeParameters()
+	}
+	p.expect('(')
+	for p.err == nil && p.peek() != ')' {
+		ms.params = append(ms.params, p.fieldType())
+	}
+	p.expect(')')
+	ms.ret = p.returnType()
+	for p.generic && p.peek() == '^' {
+		p.i++
+		ms.throws = append(ms.throws, p.referenceType())
+	}
+	if p.err == nil && ms.paramSlots() > 255 {
+		p.fail("the params take more than 255 slots")
+	}
+	return ms
+}
 
-const LAVA_VERSION=6;
+//check that there is nothing left over
+func (p *sigParser) end() error {
+	if p.err == nil && p.i < len(p.s) {
+		p.fail("unexpected "+p.s[p.i:])
+	}
+	return p.err
+}
 
-func main() {
-	fmt.Println("Lava version: "+strconv.Itoa(LAVA_VERSION));
-	args := os.Args
-	//load classfile
-	cfname:= args[1]
-	buf := NewBuffer(cfname);
-	cf := NewClassFile();
-	cf.load(buf);
+//parse a field descriptor like [Ljava/lang/String;
+func parseFieldDescriptor(desc string) (*JavaType, error) {
+	p := &sigParser{s: desc}
+	t := p.fieldType()
+	return t, p.end()
+}
 
-	//print format number
-	fmt.Println("Classfile major version = "+strconv.Itoa(int(cf.major_version)));
-	
-	//load parameters. We don't need the first one, which is the classname
-	//var args2 []string
-	//if (len(args)>1) {
-	//	args2 := make([]string, len(args))
-	//	for i := 1; i < len(args); i++ {
-	//		args2[i-1] = args[i];
-	//	}
-	//}
-	
-	//create memory
-	initialize_memory(4096);
-	
-	//compile the program
-	//tref := run_compiler(cf);
-	
-		//run the program
-		//Processor p = new Processor(m,tref);
-		//p.start(args2);
+//parse a method descriptor like (IJ)V
+func parseMethodDescriptor(desc string) (*MethodSignature, error) {
+	p := &sigParser{s: desc}
+	ms := p.method()
+	return ms, p.end()
+}
+
+//==============================
+// Instruction decoder.  This splits the code of a method into instructions and reads their
+// operands, for every opcode in the JVM spec (chapter 6).  This is the same decoder as in
+// classfile.go.  The verifier and translateCode both work from the decoded instructions, so they
+// agree on where each one starts
+
+//CodeError says which instruction is bad and why
+type CodeError struct {
+	PC int;
+	Detail string;
+}
+
+func (e *CodeError) Error() string {
+	return "bad instruction at pc "+strconv.Itoa(e.PC)+": "+e.Detail
+}
+
+//the operands that follow each opcode
+const (
+	opNone = iota;		//no operands
+	opByte;				//a signed byte, for bipush
+	opShort;			//a signed short, for sipush
+	opLocal;			//a local variable index, which is 2 bytes after wide
+	opConst1;			//a 1 byte constant pool index, for ldc
+	opConst2;			//a 2 byte constant pool index
+	opIinc;				//a local variable index and a signed byte, or 2 shorts after wide
+	opBranch2;			//a signed 2 byte offset
+	opBranch4;			//a signed 4 byte offset
+	opNewarray;			//the element type
+	opInterface;		//a constant pool index, the count of arg slots and a zero
+	opDynamic;			//a constant pool index and 2 zeros
+	opMultiArray;		//a constant pool index and the number of dimensions
+	opTableSwitch;
+	opLookupSwitch;
+	opWide;
+	opInvalid;			//not an opcode.  breakpoint and impdep are reserved for debuggers and the JVM
+)
+
+//the name of every opcode, indexed by the opcode
+var mnemonics = [256]string {
+	/* 0x00 */ "nop", "aconst_null", "iconst_m1", "iconst_0", "iconst_1", "iconst_2", "iconst_3", "iconst_4",
+	/* 0x08 */ "iconst_5", "lconst_0", "lconst_1", "fconst_0", "fconst_1", "fconst_2", "dconst_0", "dconst_1",
+	/* 0x10 */ "bipush", "sipush", "ldc", "ldc_w", "ldc2_w", "iload", "lload", "fload",
+	/* 0x18 */ "dload", "aload", "iload_0", "iload_1", "iload_2", "iload_3", "lload_0", "lload_1",
+	/* 0x20 */ "lload_2", "lload_3", "fload_0", "fload_1", "fload_2", "fload_3", "dload_0", "dload_1",
+	/* 0x28 */ "dload_2", "dload_3", "aload_0", "aload_1", "aload_2", "aload_3", "iaload", "laload",
+	/* 0x30 */ "faload", "daload", "aaload", "baload", "caload", "saload", "istore", "lstore",
+	/* 0x38 */ "fstore", "dstore", "astore", "istore_0", "istore_1", "istore_2", "istore_3", "lstore_0",
+	/* 0x40 */ "lstore_1", "lstore_2", "lstore_3", "fstore_0", "fstore_1", "fstore_2", "fstore_3", "dstore_0",
+	/* 0x48 */ "dstore_1", "dstore_2", "dstore_3", "astore_0", "astore_1", "astore_2", "astore_3", "iastore",
+	/* 0x50 */ "lastore", "fastore", "dastore", "aastore", "bastore", "castore", "sastore", "pop",
+	/* 0x58 */ "pop2", "dup", "dup_x1", "dup_x2", "dup2", "dup2_x1", "dup2_x2", "swap",
+	/* 0x60 */ "iadd", "ladd", "fadd", "dadd", "isub", "lsub", "fsub", "dsub",
+	/* 0x68 */ "imul", "lmul", "fmul", "dmul", "idiv", "ldiv", "fdiv", "ddiv",
+	/* 0x70 */ "irem", "lrem", "frem", "drem", "ineg", "lneg", "fneg", "dneg",
+	/* 0x78 */ "ishl", "lshl", "ishr", "lshr", "iushr", "lushr", "iand", "land",
+	/* 0x80 */ "ior", "lor", "ixor", "lxor", "iinc", "i2l", "i2f", "i2d",
+	/* 0x88 */ "l2i", "l2f", "l2d", "f2i", "f2l", "f2d", "d2i", "d2l",
+	/* 0x90 */ "d2f", "i2b", "i2c", "i2s", "lcmp", "fcmpl", "fcmpg", "dcmpl",
+	/* 0x98 */ "dcmpg", "ifeq", "ifne", "iflt", "ifge", "ifgt", "ifle", "if_icmpeq",
+	/* 0xA0 */ "if_icmpne", "if_icmplt", "if_icmpge", "if_icmpgt", "if_icmple", "if_acmpeq", "if_acmpne", "goto",
+	/* 0xA8 */ "jsr", "ret", "tableswitch", "lookupswitch", "ireturn", "lreturn", "freturn", "dreturn",
+	/* 0xB0 */ "areturn", "return", "getstatic", "putstatic", "getfield", "putfield", "invokevirtual", "invokespecial",
+	/* 0xB8 */ "invokestatic", "invokeinterface", "invokedynamic", "new", "newarray", "anewarray", "arraylength", "athrow",
+	/* 0xC0 */ "checkcast", "instanceof", "monitorenter", "monitorexit", "wide", "multianewarray", "ifnull", "ifnonnull",
+	/* 0xC8 */ "goto_w", "jsr_w", "breakpoint",
+	0xFE: "impdep1", "impdep2",
+}
+
+//the operands of each opcode.  Everything from 0xCA on is invalid
+var operandFormats [256]uint8
+
+func init() {
+	for op := 0; op < 256; op++ {
+		f := uint8(opNone)
+		switch {
+			case op == 0x10: f = opByte
+			case op == 0x11: f = opShort
+			case op == 0x12: f = opConst1
+			case op == 0x13, op == 0x14: f = opConst2
+			case op >= 0x15 && op <= 0x19, op >= 0x36 && op <= 0x3A, op == 0xA9: f = opLocal
+			case op == 0x84: f = opIinc
+			case op >= 0x99 && op <= 0xA8, op == 0xC6, op == 0xC7: f = opBranch2
+			case op == 0xAA: f = opTableSwitch
+			case op == 0xAB: f = opLookupSwitch
+			case op >= 0xB2 && op <= 0xB8, op == 0xBB, op == 0xBD, op == 0xC0, op == 0xC1: f = opConst2
+			case op == 0xB9: f = opInterface
+			case op == 0xBA: f = opDynamic
+			case op == 0xBC: f = opNewarray
+			case op == 0xC4: f = opWide
+			case op == 0xC5: f = opMultiArray
+			case op == 0xC8, op == 0xC9: f = opBranch4
+			case op >= 0xCA: f = opInvalid
+		}
+		operandFormats[op] = f
+	}
+}
+
+//one decoded instruction.  Only the fields for its operands are set
+type Instruction struct {
+	pc int;
+	opcode uint8;		//after wide, this is the opcode that wide changes
+	length int;			//including wide and any switch padding
+	wide bool;
+	index int;			//the local variable or constant pool index
+	value int;			//bipush, sipush, the iinc increment, the newarray type, the invokeinterface count or the dimensions
+	target int;			//the pc a branch goes to, or the default of a switch
+	low int;			//the first key of a tableswitch
+	keys []int;			//the keys of a switch, which for a tableswitch are low, low+1 and so on
+	targets []int;		//where each key goes
+}
+
+func (ins *Instruction) mnemonic() string {
+	return mnemonics[ins.opcode]
+}
+
+//the pc of the next instruction
+func (ins *Instruction) next() int {
+	return ins.pc + ins.length
+}
+
+func codeError(pc int, detail string) error {
+	return &CodeError{PC: pc, Detail: detail}
+}
+
+//decode the instruction at pc
+func decodeInstruction(code []byte, pc int) (*Instruction, error) {
+	//the operands, which must be in the code
+	need := func(n int) bool {
+		return pc+n <= len(code)
+	}
+	u1 := func(i int) int { return int(code[pc+i]) }
+	u2 := func(i int) int { return int(code[pc+i])<<8 | int(code[pc+i+1]) }
+	s4 := func(i int) int { return int(int32(uint32(u2(i))<<16 | uint32(u2(i+2)))) }
+
+	op := code[pc]
+	ins := &Instruction{pc: pc, opcode: op, length: 1}
+	switch (operandFormats[op]) {
+		case opNone:
+		case opByte:
+			ins.length = 2
+			if need(2) {
+				ins.value = int(int8(code[pc+1]))
+			}
+		case opShort:
+			ins.length = 3
+			if need(3) {
+				ins.value = int(int16(u2(1)))
+			}
+		case opLocal, opConst1:
+			ins.length = 2
+			if need(2) {
+				ins.index = u1(1)
+			}
+		case opConst2:
+			ins.length = 3
+			if need(3) {
+				ins.index = u2(1)
+			}
+		case opIinc:
+			ins.length = 3
+			if need(3) {
+				ins.index = u1(1)
+				ins.value = int(int8(code[pc+2]))
+			}
+		case opBranch2:
+			ins.length = 3
+			if need(3) {
+				ins.target = pc + int(int16(u2(1)))
+			}
+		case opBranch4:
+			ins.length = 5
+			if need(5) {
+				ins.target = pc + s4(1)
+			}
+		case opNewarray:
+			ins.length = 2
+			if need(2) {
+				ins.value = u1(1)
+				if ins.value < 4 || ins.value > 11 {
+					return nil, codeError(pc, "newarray has a bad type "+strconv.Itoa(ins.value))
+				}
+			}
+		case opInterface:
+			ins.length = 5
+			if need(5) {
+				ins.index = u2(1)
+				ins.value = u1(3)
+				if ins.value == 0 || u1(4) != 0 {
+					return nil, codeError(pc, "invokeinterface needs a count and a zero")
+				}
+			}
+		case opDynamic:
+			ins.length = 5
+			if need(5) {
+				ins.index = u2(1)
+				if u2(3) != 0 {
+					return nil, codeError(pc, "invokedynamic needs 2 zeros")
+				}
+			}
+		case opMultiArray:
+			ins.length = 4
+			if need(4) {
+				ins.index = u2(1)
+				ins.value = u1(3)
+				if ins.value == 0 {
+					return nil, codeError(pc, "multianewarray needs at least 1 dimension")
+				}
+			}
+		case opTableSwitch, opLookupSwitch:
+			return decodeSwitch(code, ins)
+		case opWide:
+			ins.length = 2
+			if !need(2) {
+				break
+			}
+			ins.opcode = code[pc+1]
+			ins.wide = true
+			f := operandFormats[ins.opcode]
+			if f == opIinc {
+				ins.length = 6
+				if need(6) {
+					ins.index = u2(2)
+					ins.value = int(int16(u2(4)))
+				}
+			} else if f == opLocal {
+				ins.length = 4
+				if need(4) {
+					ins.index = u2(2)
+				}
+			} else {
+				return nil, codeError(pc, "wide can't be used with opcode "+strconv.Itoa(int(ins.opcode)))
+			}
+		default:
+			return nil, codeError(pc, "unknown opcode "+strconv.Itoa(int(op)))
+	}
+	if !need(ins.length) {
+		return nil, codeError(pc, mnemonics[op]+" runs past the end of the code")
+	}
+	return ins, nil
+}
+
+//tableswitch and lookupswitch are padded so the table starts on a multiple of 4
+func decodeSwitch(code []byte, ins *Instruction) (*Instruction, error) {
+	pc := ins.pc
+	p := pc + 1
+	for p % 4 != 0 {
+		p++
+	}
+	s4 := func(i int) int {
+		return int(int32(uint32(code[i])<<24 | uint32(code[i+1])<<16 | uint32(code[i+2])<<8 | uint32(code[i+3])))
+	}
+	short := codeError(pc, mnemonics[ins.opcode]+" runs past the end of the code")
+	if p+8 > len(code) {
+		return nil, short
+	}
+	ins.target = pc + s4(p)
+	if ins.opcode == 0xAA {
+		if p+12 > len(code) {
+			return nil, short
+		}
+		ins.low = s4(p+4)
+		high := s4(p+8)
+		if high < ins.low {
+			return nil, codeError(pc, "tableswitch has high < low")
+		}
+		n := high - ins.low + 1
+		p = p + 12
+		if n > (len(code)-p)/4 {
+			return nil, short
+		}
+		for k := 0; k < n; k++ {
+			ins.keys = append(ins.keys, ins.low+k)
+			ins.targets = append(ins.targets, pc+s4(p))
+			p = p + 4
+		}
+	} else {
+		n := s4(p+4)
+		p = p + 8
+		if n < 0 {
+			return nil, codeError(pc, "lookupswitch has a negative count")
+		}
+		if n > (len(code)-p)/8 {
+			return nil, short
+		}
+		for k := 0; k < n; k++ {
+			key := s4(p)
+			if k > 0 && key <= ins.keys[k-1] {
+				return nil, codeError(pc, "the keys of lookupswitch aren't sorted")
+			}
+			ins.keys = append(ins.keys, key)
+			ins.targets = append(ins.targets, pc+s4(p+4))
+			p = p + 8
+		}
+	}
+	ins.length = p - pc
+	return ins, nil
+}
+
+//decode all the code.  If there is a bad instruction, this returns the instructions before it
+//and the error
+func decodeCode(code []byte) ([]*Instruction, error) {
+	list := []*Instruction{}
+	pc := 0
+	for pc < len(code) {
+		ins, err := decodeInstruction(code, pc)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, ins)
+		pc = ins.next()
+	}
+	return list, nil
+}
+
+//==============================================
+/** Verifier.  This checks the code of every method before it is run, in the same way as the
+* type checking verifier in the JVM spec (4.10.1).  It works out the type of every local and
+* stack slot at every instruction, and checks that each instruction gets the types it expects,
+* that the stack stays within max_stack and the locals within max_locals, and that the types match
+* the StackMapTable at every branch target.
+*
+* Class files older than version 50 have no StackMapTable, so the types at a branch target are
+* merged from all the ways of getting there instead, as the old inference verifier did.
+*
+* We don't load the other classes, so we can't check that one class is a subclass of another.
+* Any reference is accepted where an object is expected, except that arrays are checked
+*/
+
+//VerifyError says which method failed and at which instruction
+type VerifyError struct {
+	Method string;		//name and descriptor
+	PC int;
+	Line int;			//from the LineNumberTable, or 0
+	Detail string;
+}
+
+func (e *VerifyError) Error() string {
+	at := "pc "+strconv.Itoa(e.PC)
+	if e.Line > 0 {
+		at = at + " (line "+strconv.Itoa(e.Line)+")"
+	}
+	return "verify error in "+e.Method+" at "+at+": "+e.Detail
+}
+
+//the type of a local or a stack slot
+type VType struct {
+	tag uint8;		//one of the ITEM_ values
+	name string;	//the class name or array descriptor of an ITEM_Object
+	offset int;		//the offset of the new instruction of an ITEM_Uninitialized
+}
+
+var (
+	vTop = VType{tag: ITEM_Top}
+	vInt = VType{tag: ITEM_Integer}
+	vFloat = VType{tag: ITEM_Float}
+	vLong = VType{tag: ITEM_Long}
+	vDouble = VType{tag: ITEM_Double}
+	vNull = VType{tag: ITEM_Null}
+	vObject = VType{tag: ITEM_Object, name: "java/lang/Object"}
+)
+
+func objectType(name string) VType {
+	return VType{tag: ITEM_Object, name: name}
+}
+
+//long and double take 2 slots
+func (t VType) size() int {
+	if t.tag == ITEM_Long || t.tag == ITEM_Double {
+		return 2
+	}
+	return 1
+}
+
+func (t VType) isRef() bool {
+	return t.tag == ITEM_Object || t.tag == ITEM_Null || t.tag == ITEM_UninitializedThis || t.tag == ITEM_Uninitialized
+}
+
+func (t VType) isArray() bool {
+	return t.tag == ITEM_Object && strings.HasPrefix(t.name, "[")
+}
+
+func (t VType) String() string {
+	switch (t.tag) {
+		case ITEM_Top: return "top"
+		case ITEM_Integer: return "int"
+		case ITEM_Float: return "float"
+		case ITEM_Long: return "long"
+		case ITEM_Double: return "double"
+		case ITEM_Null: return "null"
+		case ITEM_UninitializedThis: return "uninitializedThis"
+		case ITEM_Uninitialized: return "uninitialized("+strconv.Itoa(t.offset)+")"
+	}
+	return t.name
+}
+
+//the class hierarchy that the verifier checks references against.  Classes are read from the
+//class path the first time they are needed, but they aren't compiled
+type Hierarchy struct {
+	cp *ClassPath;
+	classes map[string]*ClassFile;	//nil for a class that isn't on the class path
+}
+
+func NewHierarchy(cp *ClassPath) *Hierarchy {
+	return &Hierarchy{
+		cp: cp,
+		classes: make(map[string]*ClassFile),
+	}
+}
+
+//the class file of a class that isn't built in, or nil if it can't be found
+func (h *Hierarchy) classFile(name string) *ClassFile {
+	cf, ok := h.classes[name]
+	if !ok {
+		if h.cp != nil {
+			cf, _ = h.cp.LoadClass(name)
+		}
+		h.classes[name] = cf
+	}
+	return cf
+}
+
+//the super class of a class, or "" for Object and for a class that can't be found
+func (h *Hierarchy) superClass(name string) string {
+	if name == "java/lang/Object" {
+		return ""
+	}
+	if isBuiltinClass(name) {
+		s, ok := builtinSupers[name]
+		if !ok {
+			s = "java/lang/Object"
+		}
+		return s
+	}
+	cf := h.classFile(name)
+	if cf == nil {
+		return ""
+	}
+	return cf.getSuperClassName()
+}
+
+//is this known to be a class, rather than an interface?  A built-in class is only known if it is
+//one of the exceptions or it has natives
+func (h *Hierarchy) isClass(name string) bool {
+	if isBuiltinClass(name) {
+		_, ok := builtinSupers[name]
+		return ok || nativeOwners[name]
+	}
+	cf := h.classFile(name)
+	return cf != nil && cf.access_flags & ACC_INTERFACE == 0
+}
+
+//can an object of class from be used as a to?  Like the JVM, the verifier treats interfaces
+//as Object, so this is only false when to is a class and from isn't below it
+func (h *Hierarchy) subclassOf(from string, to string) bool {
+	if to == "java/lang/Object" || !h.isClass(to) {
+		return true
+	}
+	//a bad class file could have a loop of super classes
+	seen := make(map[string]bool)
+	for c := from; c != "" && !seen[c]; c = h.superClass(c) {
+		if c == to {
+			return true
+		}
+		seen[c] = true
+	}
+	return false
+}
+
+//can a value of type from be used where type to is expected?
+func (h *Hierarchy) assignable(from VType, to VType) bool {
+	switch (to.tag) {
+		case ITEM_Top:
+			return true
+		case ITEM_Object:
+			if from.tag == ITEM_Null {
+				return true
+			}
+			if from.tag != ITEM_Object {
+				return false
+			}
+			if from.isArray() && to.isArray() {
+				fe := from.name[1:]
+				te := to.name[1:]
+				//arrays of primitives must match exactly
+				if !strings.HasPrefix(fe, "L") && !strings.HasPrefix(fe, "[") ||
+					!strings.HasPrefix(te, "L") && !strings.HasPrefix(te, "[") {
+					return fe == te
+				}
+				return h.assignable(descriptorVType(fe), descriptorVType(te))
+			}
+			if to.isArray() {
+				return false
+			}
+			if from.isArray() {
+				return to.name == "java/lang/Object" || to.name == "java/lang/Cloneable" || to.name == "java/io/Serializable"
+			}
+			return h.subclassOf(from.name, to.name)
+		case ITEM_Uninitialized:
+			return from.tag == ITEM_Uninitialized && from.offset == to.offset
+	}
+	return from.tag == to.tag
+}
+
+//the type of a field descriptor.  byte, char, short and boolean are all ints on the stack
+func descriptorVType(desc string) VType {
+	if desc == "" {
+		return vTop
+	}
+	switch (desc[0]) {
+		case 'B', 'C', 'I', 'S', 'Z': return vInt
+		case 'F': return vFloat
+		case 'J': return vLong
+		case 'D': return vDouble
+		case 'L': return objectType(strings.TrimSuffix(desc[1:], ";"))
+		case '[': return objectType(desc)
+	}
+	return vTop
+}
+
+//split a method descriptor into the types of the params and the return type.
+//The return type is nil for void
+func methodVTypes(desc string) ([]VType, *VType, bool) {
+	ms, err := parseMethodDescriptor(desc)
+	if err != nil {
+		return nil, nil, false
+	}
+	params := []VType{}
+	for _, t := range ms.params {
+		params = append(params, descriptorVType(t.descriptor()))
+	}
+	if ms.ret.isVoid() {
+		return params, nil, true
+	}
+	ret := descriptorVType(ms.ret.descriptor())
+	return params, &ret, true
+}
+
+//the types at the start of one instruction.  long and double are followed by top in the locals,
+//but only take one entry on the stack
+type vstate struct {
+	locals []VType;
+	stack []VType;
+}
+
+func (s *vstate) copy() *vstate {
+	return &vstate{
+		locals: append([]VType{}, s.locals...),
+		stack: append([]VType{}, s.stack...),
+	}
+}
+
+//the size of the stack in slots, which is what max_stack counts
+func (s *vstate) depth() int {
+	d := 0
+	for _, t := range s.stack {
+		d = d + t.size()
+	}
+	return d
+}
+
+func (s *vstate) String() string {
+	l := make([]string, len(s.locals))
+	for i, t := range s.locals {
+		l[i] = t.String()
+	}
+	st := make([]string, len(s.stack))
+	for i, t := range s.stack {
+		st[i] = t.String()
+	}
+	return "locals ["+strings.Join(l, ", ")+"] stack ["+strings.Join(st, ", ")+"]"
+}
+
+func (s *vstate) assignableTo(f *vstate, h *Hierarchy) bool {
+	if len(s.locals) != len(f.locals) || len(s.stack) != len(f.stack) {
+		return false
+	}
+	for i := range s.locals {
+		if !h.assignable(s.locals[i], f.locals[i]) {
+			return false
+		}
+	}
+	for i := range s.stack {
+		if !h.assignable(s.stack[i], f.stack[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//merge two types, for class files without a StackMapTable.  Different references become
+//Object, and anything else that doesn't match becomes top
+func mergeVType(a VType, b VType) VType {
+	if a == b {
+		return a
+	}
+	if a.tag == ITEM_Null && b.tag == ITEM_Object {
+		return b
+	}
+	if b.tag == ITEM_Null && a.tag == ITEM_Object {
+		return a
+	}
+	if a.tag == ITEM_Object && b.tag == ITEM_Object {
+		return vObject
+	}
+	return vTop
+}
+
+type Verifier struct {
+	cf *ClassFile;
+	m *MemberInfo;
+	ca *Code_attribute;
+	code []byte;
+	h *Hierarchy;
+	//class files with a StackMapTable must have a frame at every branch target
+	strict bool;
+	insns map[int]*Instruction;	//by their offset
+	frames map[int]*vstate;		//from the StackMapTable
+	states map[int]*vstate;		//what we have worked out so far
+	work []int;					//instructions to look at
+	ret *VType;					//the return type of the method, or nil for void
+	cur *vstate;
+	pc int;
+	err error;
+}
+
+func NewVerifier(cf *ClassFile, m *MemberInfo, ca *Code_attribute, h *Hierarchy) *Verifier {
+	return &Verifier{
+		cf: cf,
+		m: m,
+		ca: ca,
+		code: ca.code,
+		h: h,
+		strict: cf.major_version >= 50,
+		insns: make(map[int]*Instruction),
+		frames: make(map[int]*vstate),
+		states: make(map[int]*vstate),
+	}
+}
+
+//verify every method with code, and return the first error.  The other classes that the code
+//uses are read from cp
+func verifyClass(cf *ClassFile, cp *ClassPath) error {
+	h := NewHierarchy(cp)
+	//the class might not be on the class path, if it was loaded from a file
+	h.classes[cf.getClassName()] = cf
+	for _, m := range cf.methods {
+		ca := m.getCode();
+		if ca == nil {
+			continue
+		}
+		err := NewVerifier(cf, m, ca, h).verify();
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//remember the first error.  Everything after that is ignored
+func (v *Verifier) fail(msg string) {
+	if v.err == nil {
+		v.err = &VerifyError{Method: v.m.name()+v.m.sig(), PC: v.pc, Line: v.ca.lineNumber(v.pc), Detail: msg}
+	}
+}
+
+//describe a local for an error message, with its name if there is a LocalVariableTable
+func (v *Verifier) localName(n int) string {
+	s := "local "+strconv.Itoa(n)
+	lv := v.ca.localVariable(n, v.pc)
+	if lv != nil {
+		s = s + " (" + lv.name + ")"
+	}
+	return s
+}
+
+func (v *Verifier) verify() error {
+	if len(v.code) == 0 {
+		v.fail("the method has no code")
+		return v.err
+	}
+	list, err := decodeCode(v.code)
+	if err != nil {
+		v.pc = err.(*CodeError).PC
+		v.fail(err.(*CodeError).Detail)
+		return v.err
+	}
+	for _, ins := range list {
+		v.insns[ins.pc] = ins
+	}
+	params, ret, ok := methodVTypes(v.m.sig())
+	if !ok {
+		v.fail("bad method descriptor "+v.m.sig())
+		return v.err
+	}
+	v.ret = ret
+	//the first locals are "this" and the params.  These are the starting point for the StackMapTable
+	args := []VType{}
+	if !v.m.isStatic() {
+		if v.m.name() == "<init>" && v.cf.getClassName() != "java/lang/Object" {
+			args = append(args, VType{tag: ITEM_UninitializedThis})
+		} else {
+			args = append(args, objectType(v.cf.getClassName()))
+		}
+	}
+	args = append(args, params...)
+	init := &vstate{locals: v.expandLocals(args)}
+	if v.err != nil {
+		return v.err
+	}
+	v.loadFrames(args)
+	if v.err != nil {
+		return v.err
+	}
+	v.flow(0, init, false)
+	for len(v.work) > 0 && v.err == nil {
+		pc := v.work[len(v.work)-1]
+		v.work = v.work[:len(v.work)-1]
+		v.pc = pc
+		v.cur = v.states[pc].copy()
+		v.step()
+	}
+	return v.err
+}
+
+//turn a list of types into locals, where long and double take 2 slots
+func (v *Verifier) expandLocals(types []VType) []VType {
+	locals := make([]VType, v.ca.max_locals)
+	n := 0
+	for _, t := range types {
+		if n+t.size() > len(locals) {
+			v.fail("the locals need more than max_locals "+strconv.Itoa(len(locals)))
+			return locals
+		}
+		locals[n] = t
+		if t.size() == 2 {
+			locals[n+1] = vTop
+		}
+		n = n + t.size()
+	}
+	return locals
+}
+
+func (v *Verifier) verificationType(vi *verification_type_info) VType {
+	switch (vi.tag) {
+		case ITEM_Object:
+			return v.classType(int(vi.cpool_index))
+		case ITEM_Uninitialized:
+			off := int(vi.offset)
+			if v.insns[off] != nil && v.insns[off].opcode == 0xBB {
+				return VType{tag: ITEM_Uninitialized, offset: off}
+			}
+			v.fail("stack map frame has uninitialized("+strconv.Itoa(off)+"), which isn't a new instruction")
+		default:
+			return VType{tag: vi.tag}
+	}
+	return vTop
+}
+
+func (v *Verifier) verificationTypes(vis []*verification_type_info) []VType {
+	types := make([]VType, len(vis))
+	for i, vi := range vis {
+		types[i] = v.verificationType(vi)
+	}
+	return types
+}
+
+//work out the state at each frame in the StackMapTable.  Each frame is given as a change
+//from the one before, starting with the params
+func (v *Verifier) loadFrames(args []VType) {
+	var smt *StackMapTable_attribute
+	if v.ca.attribute_table != nil {
+		for _, attr := range v.ca.attribute_table.attributes {
+			s, ok := attr.(*StackMapTable_attribute)
+			if ok {
+				smt = s
+			}
+		}
+	}
+	if smt == nil {
+		return
+	}
+	locals := args
+	pc := -1
+	for _, f := range smt.entries {
+		pc = pc + int(f.offset_delta) + 1
+		v.pc = pc
+		var stack []VType
+		t := int(f.frame_type)
+		switch {
+			case t <= 127, t == 247:
+				stack = v.verificationTypes(f.stack)
+			case t <= 250:
+				k := 251 - t
+				if k > len(locals) {
+					v.fail("chop_frame removes more locals than there are")
+					return
+				}
+				locals = locals[:len(locals)-k]
+			case t <= 254:
+				locals = append(append([]VType{}, locals...), v.verificationTypes(f.locals)...)
+			case t == 255:
+				locals = v.verificationTypes(f.locals)
+				stack = v.verificationTypes(f.stack)
+		}
+		if v.insns[pc] == nil {
+			v.fail("stack map frame isn't at the start of an instruction")
+		}
+		if v.frames[pc] != nil {
+			v.fail("there are 2 stack map frames here")
+		}
+		s := &vstate{locals: v.expandLocals(locals), stack: stack}
+		if s.depth() > int(v.ca.max_stack) {
+			v.fail("stack map frame has more than max_stack "+strconv.Itoa(int(v.ca.max_stack)))
+		}
+		if v.err != nil {
+			return
+		}
+		v.frames[pc] = s
+	}
+}
+
+//the state s can reach target.  jump is false for falling through to the next instruction
+func (v *Verifier) flow(target int, s *vstate, jump bool) {
+	if v.err != nil {
+		return
+	}
+	if v.insns[target] == nil {
+		v.fail("branch to "+strconv.Itoa(target)+", which isn't the start of an instruction")
+		return
+	}
+	f := v.frames[target]
+	if f != nil {
+		if !s.assignableTo(f, v.h) {
+			v.fail("the types don't match the stack map frame at "+strconv.Itoa(target)+": have "+s.String()+", frame has "+f.String())
+			return
+		}
+		if v.states[target] == nil {
+			v.states[target] = f
+			v.work = append(v.work, target)
+		}
+		return
+	}
+	if v.strict && jump {
+		v.fail("there is no stack map frame at branch target "+strconv.Itoa(target))
+		return
+	}
+	old := v.states[target]
+	if old == nil {
+		v.states[target] = s.copy()
+		v.work = append(v.work, target)
+		return
+	}
+	if len(old.stack) != len(s.stack) {
+		v.fail("the stack height is different on 2 paths to "+strconv.Itoa(target))
+		return
+	}
+	changed := false
+	for i := range old.locals {
+		t := mergeVType(old.locals[i], s.locals[i])
+		if t != old.locals[i] {
+			old.locals[i] = t
+			changed = true
+		}
+	}
+	for i := range old.stack {
+		t := mergeVType(old.stack[i], s.stack[i])
+		if t.tag == ITEM_Top {
+			v.fail("the stack has "+old.stack[i].String()+" on one path to "+strconv.Itoa(target)+" and "+s.stack[i].String()+" on another")
+			return
+		}
+		if t != old.stack[i] {
+			old.stack[i] = t
+			changed = true
+		}
+	}
+	if changed {
+		v.work = append(v.work, target)
+	}
+}
+
+func (v *Verifier) push(t VType) {
+	if v.cur.depth() + t.size() > int(v.ca.max_stack) {
+		v.fail("stack overflow, max_stack is "+strconv.Itoa(int(v.ca.max_stack)))
+		return
+	}
+	v.cur.stack = append(v.cur.stack, t)
+}
+
+func (v *Verifier) pop() VType {
+	n := len(v.cur.stack)
+	if n == 0 {
+		v.fail("stack underflow")
+		return vTop
+	}
+	t := v.cur.stack[n-1]
+	v.cur.stack = v.cur.stack[:n-1]
+	return t
+}
+
+//pop a value that can be used as type want
+func (v *Verifier) popType(want VType) VType {
+	t := v.pop()
+	if v.err == nil && !v.h.assignable(t, want) {
+		v.fail("expecting "+want.String()+" on the stack, found "+t.String())
+	}
+	return t
+}
+
+func (v *Verifier) popRef() VType {
+	t := v.pop()
+	if v.err == nil && !t.isRef() {
+		v.fail("expecting a reference on the stack, found "+t.String())
+	}
+	return t
+}
+
+//pop an initialized object, which may be null
+func (v *Verifier) popObject() VType {
+	t := v.popRef()
+	if t.tag == ITEM_Uninitialized || t.tag == ITEM_UninitializedThis {
+		v.fail("the object on the stack hasn't been initialized")
+	}
+	return t
+}
+
+//pop an initialized object that can be used as class cname, like the object of getfield
+func (v *Verifier) popInstance(cname string) VType {
+	t := v.popObject()
+	if v.err == nil && !v.h.assignable(t, objectType(cname)) {
+		v.fail("expecting "+cname+" on the stack, found "+t.String())
+	}
+	return t
+}
+
+func (v *Verifier) popArray() VType {
+	t := v.popRef()
+	if v.err == nil && t.tag != ITEM_Null && !t.isArray() {
+		v.fail("expecting an array on the stack, found "+t.String())
+	}
+	return t
+}
+
+//pop n slots, for the dup, pop and swap instructions which don't care about the types.
+//This returns the entries in stack order.  A long or double can't be split
+func (v *Verifier) popSlots(n int) []VType {
+	out := []VType{}
+	for n > 0 && v.err == nil {
+		t := v.pop()
+		if t.size() > n {
+			v.fail("this would split a "+t.String()+" on the stack")
+		}
+		out = append([]VType{t}, out...)
+		n = n - t.size()
+	}
+	return out
+}
+
+func (v *Verifier) pushAll(ts []VType) {
+	for _, t := range ts {
+		v.push(t)
+	}
+}
+
+func (v *Verifier) loadLocal(n int, want VType) VType {
+	if n + want.size() > len(v.cur.locals) {
+		v.fail("local "+strconv.Itoa(n)+" is past max_locals "+strconv.Itoa(len(v.cur.locals)))
+		return vTop
+	}
+	t := v.cur.locals[n]
+	if want.tag == ITEM_Object {
+		//aload can load any reference, including one that isn't initialized yet
+		if !t.isRef() {
+			v.fail("expecting a reference in "+v.localName(n)+", found "+t.String())
+		}
+		return t
+	}
+	if t != want {
+		v.fail("expecting "+want.String()+" in "+v.localName(n)+", found "+t.String())
+	}
+	return t
+}
+
+func (v *Verifier) storeLocal(n int, t VType) {
+	if n + t.size() > len(v.cur.locals) {
+		v.fail("local "+strconv.Itoa(n)+" is past max_locals "+strconv.Itoa(len(v.cur.locals)))
+		return
+	}
+	//storing into the second half of a long or double breaks it
+	if n > 0 && v.cur.locals[n-1].size() == 2 {
+		v.cur.locals[n-1] = vTop
+	}
+	v.cur.locals[n] = t
+	if t.size() == 2 {
+		v.cur.locals[n+1] = vTop
+	}
+}
+
+//return the constant at index, checking that it has one of the tags
+func (v *Verifier) constant(idx int, tags ...uint8) CP_Info {
+	pool := v.cf.pool
+	if idx < 1 || idx >= pool.size() || pool.constant_pool[idx] == nil {
+		v.fail("bad constant pool index "+strconv.Itoa(idx))
+		return nil
+	}
+	k := pool.constant_pool[idx]
+	for _, t := range tags {
+		if k.ctype() == t {
+			return k
+		}
+	}
+	v.fail("constant "+strconv.Itoa(idx)+" has the wrong tag "+strconv.Itoa(int(k.ctype())))
+	return nil
+}
+
+//the class named by a CONSTANT_Class, as a type
+func (v *Verifier) classType(idx int) VType {
+	k := v.constant(idx, CONSTANT_Class)
+	if k == nil {
+		return vTop
+	}
+	return objectType(k.(*CONSTANT_String_info).cstr)
+}
+
+//after a constructor is called, every copy of the uninitialized object becomes the real class
+func (v *Verifier) initialize(t VType) {
+	var init VType
+	if t.tag == ITEM_UninitializedThis {
+		init = objectType(v.cf.getClassName())
+	} else {
+		init = v.classType(v.insns[t.offset].index)
+	}
+	for i := range v.cur.locals {
+		if v.cur.locals[i] == t {
+			v.cur.locals[i] = init
+		}
+	}
+	for i := range v.cur.stack {
+		if v.cur.stack[i] == t {
+			v.cur.stack[i] = init
+		}
+	}
+}
+
+//the types used by the typed instructions, in the order the JVM numbers them
+var (
+	loadStoreTypes = []VType{vInt, vLong, vFloat, vDouble, vObject}
+	arrayElemTypes = []VType{vInt, vLong, vFloat, vDouble, vObject, vInt, vInt, vInt}
+	arrayElemDescs = []string{"I", "J", "F", "D", "", "B", "C", "S"}
+	arithTypes = []VType{vInt, vLong, vFloat, vDouble}
+	newarrayDescs = map[int]string{4: "[Z", 5: "[C", 6: "[F", 7: "[D", 8: "[B", 9: "[S", 10: "[I", 11: "[J"}
+	//i2l, i2f, i2d, l2i, l2f, l2d, f2i, f2l, f2d, d2i, d2l, d2f, i2b, i2c, i2s
+	convertFrom = []VType{vInt, vInt, vInt, vLong, vLong, vLong, vFloat, vFloat, vFloat, vDouble, vDouble, vDouble, vInt, vInt, vInt}
+	convertTo = []VType{vLong, vFloat, vDouble, vInt, vFloat, vDouble, vInt, vLong, vDouble, vInt, vLong, vFloat, vInt, vInt, vInt}
+)
+
+//check the instruction at v.pc against v.cur, and pass the result on to every instruction that can come next
+func (v *Verifier) step() {
+	pc := v.pc
+	ins := v.insns[pc]
+	//after wide, this is the opcode that wide changes, with a 2 byte index
+	op := int(ins.opcode)
+
+	//an exception can happen anywhere in a try block, and the handler starts with just the exception
+	for _, x := range v.ca.exception_table {
+		if pc >= int(x.start_pc) && pc < int(x.end_pc) {
+			ex := objectType("java/lang/Throwable")
+			if x.catch_type != 0 {
+				ex = v.classType(int(x.catch_type))
+			}
+			v.flow(int(x.handler_pc), &vstate{locals: v.cur.locals, stack: []VType{ex}}, true)
+		}
+	}
+
+	next := ins.next()
+	fallsThrough := true
+	switch {
+		case op == 0x00:	//nop
+		case op == 0x01:	//aconst_null
+			v.push(vNull)
+		case op >= 0x02 && op <= 0x08, op == 0x10, op == 0x11:	//iconst, bipush, sipush
+			v.push(vInt)
+		case op == 0x09, op == 0x0A:
+			v.push(vLong)
+		case op >= 0x0B && op <= 0x0D:
+			v.push(vFloat)
+		case op == 0x0E, op == 0x0F:
+			v.push(vDouble)
+		case op == 0x12, op == 0x13:	//ldc, ldc_w
+			k := v.constant(ins.index, CONSTANT_Integer, CONSTANT_Float, CONSTANT_String, CONSTANT_Class,
+				CONSTANT_MethodType, CONSTANT_MethodHandle, CONSTANT_Dynamic)
+			if k == nil {
+				return
+			}
+			t := v.constantType(k)
+			if t.size() != 1 {
+				v.fail("ldc can't load a long or double")
+			}
+			v.push(t)
+		case op == 0x14:	//ldc2_w
+			k := v.constant(ins.index, CONSTANT_Long, CONSTANT_Double, CONSTANT_Dynamic)
+			if k == nil {
+				return
+			}
+			t := v.constantType(k)
+			if t.size() != 2 {
+				v.fail("ldc2_w can only load a long or double")
+			}
+			v.push(t)
+		case op >= 0x15 && op <= 0x19:	//iload..aload
+			v.push(v.loadLocal(ins.index, loadStoreTypes[op-0x15]))
+		case op >= 0x1A && op <= 0x2D:	//iload_0..aload_3
+			v.push(v.loadLocal((op-0x1A)%4, loadStoreTypes[(op-0x1A)/4]))
+		case op >= 0x2E && op <= 0x35:	//iaload..saload
+			v.popType(vInt)
+			a := v.popArray()
+			v.checkElement(a, op-0x2E)
+			t := arrayElemTypes[op-0x2E]
+			if op == 0x32 {
+				//aaload gives the element type of the array
+				t = vNull
+				if a.isArray() {
+					t = descriptorVType(a.name[1:])
+					if !t.isRef() {
+						v.fail("aaload from "+a.name)
+					}
+				}
+			}
+			v.push(t)
+		case op >= 0x36 && op <= 0x3A:	//istore..astore
+			v.storeLocal(ins.index, v.storeValue(loadStoreTypes[op-0x36]))
+		case op >= 0x3B && op <= 0x4E:	//istore_0..astore_3
+			v.storeLocal((op-0x3B)%4, v.storeValue(loadStoreTypes[(op-0x3B)/4]))
+		case op >= 0x4F && op <= 0x56:	//iastore..sastore
+			v.popType(arrayElemTypes[op-0x4F])
+			v.popType(vInt)
+			v.checkElement(v.popArray(), op-0x4F)
+		case op == 0x57:	//pop
+			v.popSlots(1)
+		case op == 0x58:	//pop2
+			v.popSlots(2)
+		case op == 0x59:	//dup
+			a := v.popSlots(1)
+			v.pushAll(a)
+			v.pushAll(a)
+		case op == 0x5A, op == 0x5B:	//dup_x1, dup_x2
+			a := v.popSlots(1)
+			b := v.popSlots(op-0x59)
+			v.pushAll(a)
+			v.pushAll(b)
+			v.pushAll(a)
+		case op == 0x5C:	//dup2
+			a := v.popSlots(2)
+			v.pushAll(a)
+			v.pushAll(a)
+		case op == 0x5D, op == 0x5E:	//dup2_x1, dup2_x2
+			a := v.popSlots(2)
+			b := v.popSlots(op-0x5C)
+			v.pushAll(a)
+			v.pushAll(b)
+			v.pushAll(a)
+		case op == 0x5F:	//swap
+			a := v.popSlots(1)
+			b := v.popSlots(1)
+			v.pushAll(a)
+			v.pushAll(b)
+		case op >= 0x60 && op <= 0x73:	//add, sub, mul, div, rem
+			t := arithTypes[(op-0x60)%4]
+			v.popType(t)
+			v.popType(t)
+			v.push(t)
+		case op >= 0x74 && op <= 0x77:	//neg
+			t := arithTypes[op-0x74]
+			v.popType(t)
+			v.push(t)
+		case op >= 0x78 && op <= 0x7D:	//shifts.  The shift count is always an int
+			t := arithTypes[(op-0x78)%2]
+			v.popType(vInt)
+			v.popType(t)
+			v.push(t)
+		case op >= 0x7E && op <= 0x83:	//and, or, xor
+			t := arithTypes[(op-0x7E)%2]
+			v.popType(t)
+			v.popType(t)
+			v.push(t)
+		case op == 0x84:	//iinc
+			v.loadLocal(ins.index, vInt)
+		case op >= 0x85 && op <= 0x93:	//conversions
+			v.popType(convertFrom[op-0x85])
+			v.push(convertTo[op-0x85])
+		case op >= 0x94 && op <= 0x98:	//lcmp, fcmpl, fcmpg, dcmpl, dcmpg
+			t := []VType{vLong, vFloat, vFloat, vDouble, vDouble}[op-0x94]
+			v.popType(t)
+			v.popType(t)
+			v.push(vInt)
+		case op >= 0x99 && op <= 0x9E:	//if<cond>
+			v.popType(vInt)
+			v.flow(ins.target, v.cur, true)
+		case op >= 0x9F && op <= 0xA4:	//if_icmp<cond>
+			v.popType(vInt)
+			v.popType(vInt)
+			v.flow(ins.target, v.cur, true)
+		case op == 0xA5, op == 0xA6, op == 0xC6, op == 0xC7:	//if_acmp<cond>, ifnull, ifnonnull
+			v.popRef()
+			if op < 0xC6 {
+				v.popRef()
+			}
+			v.flow(ins.target, v.cur, true)
+		case op == 0xA7:	//goto
+			v.flow(ins.target, v.cur, true)
+			fallsThrough = false
+		case op == 0xC8:	//goto_w
+			v.flow(ins.target, v.cur, true)
+			fallsThrough = false
+		case op == 0xA8, op == 0xA9, op == 0xC9:	//jsr, ret, jsr_w
+			v.fail("subroutines (jsr and ret) aren't supported")
+		case op == 0xAA, op == 0xAB:	//tableswitch, lookupswitch
+			v.popType(vInt)
+			v.flow(ins.target, v.cur, true)
+			for _, t := range ins.targets {
+				v.flow(t, v.cur, true)
+			}
+			fallsThrough = false
+		case op >= 0xAC && op <= 0xB0:	//ireturn..areturn
+			want := loadStoreTypes[op-0xAC]
+			if v.ret == nil || v.ret.tag != want.tag {
+				v.fail(mnemonics[op]+" doesn't match the return type of "+v.m.sig())
+				return
+			}
+			v.popType(*v.ret)
+			fallsThrough = false
+		case op == 0xB1:	//return
+			if v.ret != nil {
+				v.fail("return doesn't match the return type of "+v.m.sig())
+			}
+			if len(v.cur.locals) > 0 && v.cur.locals[0].tag == ITEM_UninitializedThis {
+				v.fail("the constructor returns before calling super()")
+			}
+			fallsThrough = false
+		case op >= 0xB2 && op <= 0xB5:	//getstatic, putstatic, getfield, putfield
+			k := v.constant(ins.index, CONSTANT_Fieldref)
+			if k == nil {
+				return
+			}
+			r := k.(*CONSTANT_ref_info)
+			t := descriptorVType(r.descriptor)
+			switch (op) {
+				case 0xB2:
+					v.push(t)
+				case 0xB3:
+					v.popType(t)
+				case 0xB4:
+					v.popInstance(r.cname)
+					v.push(t)
+				case 0xB5:
+					v.popType(t)
+					o := v.popRef()
+					//a constructor can set its own fields before calling super()
+					if o.tag == ITEM_Uninitialized || o.tag == ITEM_UninitializedThis && r.cname != v.cf.getClassName() {
+						v.fail("putfield on an object that hasn't been initialized")
+					} else if v.err == nil && o.tag != ITEM_UninitializedThis && !v.h.assignable(o, objectType(r.cname)) {
+						v.fail("expecting "+r.cname+" on the stack, found "+o.String())
+					}
+			}
+		case op >= 0xB6 && op <= 0xBA:	//invokevirtual, invokespecial, invokestatic, invokeinterface, invokedynamic
+			v.invoke(op, ins.index)
+		case op == 0xBB:	//new
+			c := v.classType(ins.index)
+			if c.isArray() {
+				v.fail("new can't create an array")
+			}
+			v.push(VType{tag: ITEM_Uninitialized, offset: pc})
+		case op == 0xBC:	//newarray
+			desc := newarrayDescs[ins.value]
+			v.popType(vInt)
+			v.push(objectType(desc))
+		case op == 0xBD:	//anewarray
+			c := v.classType(ins.index)
+			v.popType(vInt)
+			if c.isArray() {
+				v.push(objectType("["+c.name))
+			} else {
+				v.push(objectType("[L"+c.name+";"))
+			}
+		case op == 0xBE:	//arraylength
+			v.popArray()
+			v.push(vInt)
+		case op == 0xBF:	//athrow
+			v.popInstance("java/lang/Throwable")
+			fallsThrough = false
+		case op == 0xC0:	//checkcast
+			c := v.classType(ins.index)
+			v.popObject()
+			v.push(c)
+		case op == 0xC1:	//instanceof
+			v.classType(ins.index)
+			v.popObject()
+			v.push(vInt)
+		case op == 0xC2, op == 0xC3:	//monitorenter, monitorexit
+			v.popObject()
+		case op == 0xC5:	//multianewarray
+			c := v.classType(ins.index)
+			dims := ins.value
+			if !strings.HasPrefix(c.name, strings.Repeat("[", dims)) {
+				v.fail("multianewarray has the wrong number of dimensions for "+c.name)
+				return
+			}
+			for i := 0; i < dims; i++ {
+				v.popType(vInt)
+			}
+			v.push(c)
+		default:
+			v.fail("bad opcode "+strconv.Itoa(op))
+	}
+	if fallsThrough {
+		if next >= len(v.code) {
+			v.fail("the code falls off the end of the method")
+			return
+		}
+		v.flow(next, v.cur, false)
+	}
+}
+
+//check that an array load or store matches the array.  baload and bastore are used for
+//both byte and boolean arrays
+func (v *Verifier) checkElement(a VType, n int) {
+	if !a.isArray() {
+		return
+	}
+	e := a.name[1:]
+	ok := e == arrayElemDescs[n]
+	if n == 4 {
+		ok = strings.HasPrefix(e, "L") || strings.HasPrefix(e, "[")
+	} else if n == 5 {
+		ok = e == "B" || e == "Z"
+	}
+	if !ok {
+		v.fail("the array is "+a.name+", which doesn't match the instruction")
+	}
+}
+
+//the value for a store instruction.  astore can also store an uninitialized object
+func (v *Verifier) storeValue(want VType) VType {
+	if want.tag == ITEM_Object {
+		return v.popRef()
+	}
+	return v.popType(want)
+}
+
+//the type that ldc pushes
+func (v *Verifier) constantType(k CP_Info) VType {
+	switch (k.ctype()) {
+		case CONSTANT_Integer: return vInt
+		case CONSTANT_Float: return vFloat
+		case CONSTANT_Long: return vLong
+		case CONSTANT_Double: return vDouble
+		case CONSTANT_String: return objectType("java/lang/String")
+		case CONSTANT_Class: return objectType("java/lang/Class")
+		case CONSTANT_MethodType: return objectType("java/lang/invoke/MethodType")
+		case CONSTANT_MethodHandle: return objectType("java/lang/invoke/MethodHandle")
+		case CONSTANT_Dynamic: return descriptorVType(k.(*CONSTANT_Dynamic_info).descriptor)
+	}
+	return vTop
+}
+
+func (v *Verifier) invoke(op int, idx int) {
+	var cname, name, desc string
+	if op == 0xBA {
+		k := v.constant(idx, CONSTANT_InvokeDynamic)
+		if k == nil {
+			return
+		}
+		name = k.(*CONSTANT_Dynamic_info).name
+		desc = k.(*CONSTANT_Dynamic_info).descriptor
+	} else {
+		var k CP_Info
+		if op == 0xB9 {
+			k = v.constant(idx, CONSTANT_InterfaceMethodref)
+		} else {
+			k = v.constant(idx, CONSTANT_Methodref, CONSTANT_InterfaceMethodref)
+		}
+		if k == nil {
+			return
+		}
+		cname = k.(*CONSTANT_ref_info).cname
+		name = k.(*CONSTANT_ref_info).name
+		desc = k.(*CONSTANT_ref_info).descriptor
+	}
+	if strings.HasPrefix(name, "<") && (op != 0xB7 || name != "<init>") {
+		v.fail("can't call "+name+" with "+mnemonics[op])
+		return
+	}
+	params, ret, ok := methodVTypes(desc)
+	if !ok {
+		v.fail("bad method descriptor "+desc)
+		return
+	}
+	for i := len(params)-1; i >= 0; i-- {
+		v.popType(params[i])
+	}
+	if op != 0xB8 && op != 0xBA {
+		if name == "<init>" {
+			o := v.popRef()
+			if o.tag != ITEM_Uninitialized && o.tag != ITEM_UninitializedThis {
+				v.fail("<init> called on an object that is already initialized")
+				return
+			}
+			if v.err == nil {
+				v.initialize(o)
+			}
+		} else {
+			v.popInstance(cname)
+		}
+	}
+	if ret != nil {
+		v.push(*ret)
+	}
+}
+
+//==============================================
+/** Compiler.  This reads in the Class file and converts it to the format that I want in memory.
+*/
+
+//returns classfile table ref, or an error if two fields or methods have the same key
+func run_compiler(cf *ClassFile) (Ref, error) {
+
+	cref, err := createClassTable(cf);
+	if (err != nil) {
+		return Ref(NIL), err;
+	}
+	err = loadConstants(cf, cref)	
+	if (err != nil) {
+		return Ref(NIL), err;
+	}
+	err = loadFields(cf, cref);
+	if (err != nil) {
+		return Ref(NIL), err;
+	}
+	err = loadMethods(cf, cref);
+	if (err != nil) {
+		return Ref(NIL), err;
+	}
+	//everything up to here is the program, the rest is for running it
+	readOnlyMark = ptr;
+	return cref, nil;
+}
+
+//the table has a row for the class name, every constant that loadConstants stores, every static field and every method
+func createClassTable(cf *ClassFile) (Ref, error) {
+	pool := cf.pool;
+	plen := pool.size();
+	if (plen > SYMBOL_BASE) {
+		return Ref(NIL), errors.New("the constant pool has "+strconv.Itoa(plen)+" entries, but only "+strconv.Itoa(SYMBOL_BASE)+" are supported");
+	}
+
+	tlen := 1 + len(cf.fields) + len(cf.methods);
+	for i := 1; i < plen; i++ {
+		k := pool.getConstant(i);
+		if (k == nil) {
+			continue;
+		}
+		switch (k.ctype()) {
+			case CONSTANT_String, CONSTANT_Class, CONSTANT_Integer, CONSTANT_Float, CONSTANT_Long, CONSTANT_Double,
+				CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+				tlen++;
+		}
+	}
+	if debug {
+		fmt.Fprintln(os.Stderr, "creating class table with "+strconv.Itoa(tlen)+" rows");
+	}
+	//create a table to store the constant pool
+	cref := newTable( Ident(CLAS),tlen);
+	if (cref == Ref(NIL)) {
+		return cref, errors.New("there is no room for a class table with "+strconv.Itoa(tlen)+" rows");
+	}
+	//objects point to this, so they know their class
+	err := put(cref, Ident(CNAM), newString(toCharArray(cf.getClassName())));
+	return cref, err;
+}
+
+func loadConstants(cf *ClassFile, cref Ref) error {
+	cpool := cf.pool;
+	for i := 1;i<cpool.size();i++ {
+		k := cpool.constant_pool[i]
+		if k == nil {
+			//this is the unused slot after a long or double
+			continue
+		}
+		t := cpool.tag(i);
+		if t==CONSTANT_String {
+			cs := k.(*CONSTANT_String_info);
+			str := cs.cstr
+			//store string in memory.  The chars come straight from the class file, so even
+			//a lone surrogate survives
+			chars := cpool.constant_pool[cs.name_index].(*CONSTANT_Utf8_info).chars
+			sref := newString(chars)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants] saved string '"+str+"' in memory as "+strconv.Itoa(int(sref))) 
+			}
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants] storing key "+strconv.Itoa(int(idk))+", value "+strconv.Itoa(int(sref)))
+			}
+			if err := put(cref,idk,sref); err != nil {
+				return err;
+			}
+		} else if t==CONSTANT_Class {	//almost identical to Constant_String
+			cs := k.(*CONSTANT_String_info);
+			str := cs.cstr
+			//store string in memory
+			chars := toCharArray(str)
+			sref := newClass(chars)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants] saved class '"+str+"' in memory as "+strconv.Itoa(int(sref))) 
+			}
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants] storing key "+strconv.Itoa(int(idk))+", value "+strconv.Itoa(int(sref)))
+			}
+			if err := put(cref,idk,sref); err != nil {
+				return err;
+			}
+		} else if t==CONSTANT_Integer {
+			ci := k.(*CONSTANT_Integer_info)
+			ival := ci.ival;		
+			n := IntToNum48(ival)
+			//store the int in memory. This takes up 4 chars!
+			iref := newInt(n);		
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants], storing Integer into "+strconv.Itoa(int(idk)));
+			}
+			if (iref == Ref(NIL)) {
+				return errors.New("there is no room for constant #"+strconv.Itoa(i));
+			}
+			if err := put(cref,idk,iref); err != nil {
+				return err;
+			}
+		} else if t==CONSTANT_Float {
+			cf := k.(*CONSTANT_Float_info)
+			fval := cf.fval;		
+			n := FloatToNum48(fval)
+			//store the float in memory. This takes up 4 chars!
+			fref := newFloat(n);		
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants], storing Float into "+strconv.Itoa(int(idk)));
+			}
+			if (fref == Ref(NIL)) {
+				return errors.New("there is no room for constant #"+strconv.Itoa(i));
+			}
+			if err := put(cref,idk,fref); err != nil {
+				return err;
+			}
+		} else if t==CONSTANT_Long {
+			//a long keeps all 64 bits, so it takes 6 chars
+			lref := newLong(k.(*CONSTANT_Long_info).lval);
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants], storing Long into "+strconv.Itoa(int(idk)));
+			}
+			if (lref == Ref(NIL)) {
+				return errors.New("there is no room for constant #"+strconv.Itoa(i));
+			}
+			if err := put(cref,idk,lref); err != nil {
+				return err;
+			}
+		} else if t==CONSTANT_Double {
+			dref := newDouble(k.(*CONSTANT_Double_info).dval);
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants], storing Double into "+strconv.Itoa(int(idk)));
+			}
+			if (dref == Ref(NIL)) {
+				return errors.New("there is no room for constant #"+strconv.Itoa(i));
+			}
+			if err := put(cref,idk,dref); err != nil {
+				return err;
+			}
+		} else if t==CONSTANT_Fieldref || t==CONSTANT_Methodref || t==CONSTANT_InterfaceMethodref {
+			cr := k.(*CONSTANT_ref_info);
+			if (nativeMember(cr.cname, cr.name, cr.descriptor) != NONE) {
+				//lookupConstant uses the built-in one instead
+				continue;
+			}
+			rref := newMemberRef(cr);
+			idk := poolKey(i)
+			if debug {
+				fmt.Fprintln(os.Stderr, "[loadConstants], storing reference to "+cr.cname+"."+cr.name+" into "+strconv.Itoa(int(idk)));
+			}
+			if err := put(cref,idk,rref); err != nil {
+				return err;
+			}
+		}
+		//these are the only constants we care about, although there could be debugging here
+	}
+	return nil;
+}
+
+//a field or method of some class, which is looked up when it is used.  This is an FREF or MREF array with
+//the key of the class name, the symbol of the field or method, and the number of slots that the field
+//or the params take.  The slots don't count "this".  The last one is the built-in method to use if the
+//class gets the method from a built-in super class, like getMessage from java/lang/Exception
+func newMemberRef(cr *CONSTANT_ref_info) Ref {
+	classKey := poolKey(int(cr.class_index));
+	typ := Ident(MREF);
+	slots := 0;
+	key := methodSymbol(cr.name, cr.descriptor);
+	if (cr.tag == CONSTANT_Fieldref) {
+		typ = Ident(FREF);
+		key = fieldSymbol(cr.name, cr.descriptor);
+		ft, err := parseFieldDescriptor(cr.descriptor);
+		if (err == nil) {
+			slots = ft.slots();
+		}
+	} else {
+		ms, err := parseMethodDescriptor(cr.descriptor);
+		if (err == nil) {
+			slots = ms.paramSlots();
+		}
+	}
+	inherited := nativeMember("java/lang/Throwable", cr.name, cr.descriptor);
+	return newArray(typ, []uint16{uint16(classKey), uint16(key), uint16(slots), inherited});
+}
+
+//this only stores static fields because non-static fields are stored
+//in the object.  The name of every field is its key, so two fields can't have the same name.
+//Number fields always get their own INTG, FLOT, LONG or DUBL, so that PUTSTATIC can update them in place
+func loadFields(cf *ClassFile, cref Ref) error {
+	fa := cf.fields
+	seen := make(map[string]bool);
+	for i := 0;i<len(fa);i++ {
+		f := fa[i];
+		if (seen[f.name()+":"+f.sig()]) {
+			return errors.New("there are two fields named "+f.name()+" with descriptor "+f.sig());
+		}
+		seen[f.name()+":"+f.sig()] = true;
+		if (f.isStatic()) {
+			fname := f.name();
+			idf := fieldSymbol(fname, f.sig());
+			if (idf == Ident(NONE)) {
+				return errors.New("no symbol for field "+fname);
+			}
+			cvx := f.getConstantValueIndex()
+			v := defaultValue(f.sig());
+			if cvx != 0 {
+				//get the constant from the constant pool
+				v = get(cref,poolKey(int(cvx)))
+				//v could be nil, which would be an error
+				if v != Ref(NIL) && (getType(v) == Ident(INTG) || getType(v) == Ident(FLOT)) {
+					v = newNum(getType(v), readInt(v))
+				} else if isWide(v) {
+					v = newWide(getType(v), readWide(v))
+				}
+			}	
+			//only a reference can be NIL
+			if (v == Ref(NIL) && !strings.HasPrefix(f.sig(), "L") && !strings.HasPrefix(f.sig(), "[")) {
+				return errors.New("there is no room for the static field "+fname);
+			}
+			if err := put(cref,idf,v); err != nil {
+				return err;
+			}
+
+		}
+	}
+	return nil;
+}
+
+//the starting value of a field with the given descriptor
+func defaultValue(desc string) Ref {
+	switch (desc) {
+		case "I", "S", "B", "C", "Z":
+			return newInt(IntToNum48(0));
+		case "F":
+			return newFloat(FloatToNum48(0.0));
+		case "J":
+			return newLong(0);
+		case "D":
+			return newDouble(0.0);
+	}
+	return Ref(NIL);
+}
+
+//=================================
+/**
+* Translate the java byte code to my format.  The only change is the constant pool lookup
+* The translated code is 2 more than the input because:
+*	it has the method name and it has the number of params
+*/
+
+//The method name is its symbol
+func translateCode(cf *ClassFile, mname Ident, params int, code []byte) ([]uint16, error) {
+	cpool := cf.pool;
+	list, err := decodeCode(code);
+	if (err != nil) {
+		return nil, err;
+	}
+	out := make([]uint16, len(code)+2);
+	out[0]=uint16(mname);
+	out[1]=uint16(params);
+
+	for _, ins := range list {
+		i := ins.pc;
+		bytecode := uint16(code[i]);
+		out[i+2]=bytecode;
+		switch (uint16(ins.opcode)) {
+			case JSR, RET, JSR_W, INVOKEDYNAMIC:
+				return nil, codeError(i, ins.mnemonic()+" is not supported");
+		}
+
+		//only change the code that uses the constant pool
+		//which is:
+		//	anewarray
+		//	checkcast
+		//	getfield
+		//	getstatic
+		//	instanceof
+		//	invokeinterface
+		//	invokespecial
+		//	invokestatic
+		//	invokevirtual
+		//	ldc
+		//	ldc_w
+		//	ldc2_w
+		//	multianewarray
+		//	newobj
+		//	putfield
+		//	putstatic
+		switch (bytecode) {
+			case LDC:
+				//LDC takes one argument, which is the index
+				out[i+3]=lookupConstant(cpool,ins.index);
+			case ANEWARRAY, CHECKCAST, GETFIELD, GETSTATIC, INSTANCEOF, INVOKESPECIAL,
+				INVOKESTATIC, INVOKEVIRTUAL, LDC_W, LDC2_W, NEWOBJ, PUTFIELD, PUTSTATIC:
+				out[i+3]=lookupConstant(cpool,ins.index);
+				out[i+4]=NOP;	//0
+			case MULTIANEWARRAY:
+				//this also has the number of dimensions, which is copied
+				out[i+3]=lookupConstant(cpool,ins.index);
+				out[i+4]=NOP;
+				out[i+5]=uint16(code[i+3]);
+			case INVOKEINTERFACE:
+				//this also has the count and a 0, which are copied
+				out[i+3]=lookupConstant(cpool,ins.index);
+				out[i+4]=NOP;
+				out[i+5]=uint16(code[i+3]);
+				out[i+6]=uint16(code[i+4]);
+			default:
+				//copy the operands as they are, so they aren't mistaken for opcodes
+				for j := 1; j < ins.length; j++ {
+					out[i+2+j]=uint16(code[i+j]);
+				}
+		}	//end switch
+	} //end for
+	return out, nil;
+} //end translate code
+
+//translate each method and store it in the class table as a METH array, with the symbol of its
+//name and descriptor as the key.  Methods without code (abstract or native) are skipped
+func loadMethods(cf *ClassFile, cref Ref) error {
+	for _, m := range cf.methods {
+		ca := m.getCode();
+		if (ca == nil) {
+			continue;
+		}
+		ms, err := parseMethodDescriptor(m.sig());
+		if (err != nil) {
+			return errors.New(m.name()+": "+err.Error());
+		}
+		//a long or double param takes 2 slots, as it does on the stack, so this is the slots and not the count.
+		//Instance methods also get "this" as a parameter
+		params := ms.paramSlots();
+		if (!m.isStatic()) {
+			params++;
+		}
+		mname := methodSymbol(m.name(), m.sig());
+		if (mname == Ident(NONE)) {
+			return errors.New("no symbol for method "+m.name()+m.sig());
+		}
+		if (get(cref, mname) != Ref(NIL)) {
+			return errors.New("method "+m.name()+m.sig()+" is defined twice");
+		}
+		out, err := translateCode(cf, mname, params, ca.code);
+		if (err != nil) {
+			return errors.New(m.name()+": "+err.Error());
+		}
+		mref := newArray(Ident(METH), out);
+		if debug {
+			fmt.Fprintln(os.Stderr, "[loadMethods] saved method "+m.name()+" in memory as "+strconv.Itoa(int(mref)));
+		}
+		if err := put(cref, mname, mref); err != nil {
+			return err;
+		}
+	}
+	return nil;
+}
+
+//===================================================
+/** Symbols.  Fields and methods are keyed in the class and object tables by a symbol, which is a number
+* for the name and descriptor of a field or a method, like count:I or count:()I.  An Ident only
+* keeps the first 4 characters, so count and countAll would get the same one, and so would overloads.
+* Every class uses the same symbols, so a method has the same key as the methods that override it.
+* Symbols start at SYMBOL_BASE, so they can't be mistaken for the key of a constant
+*/
+
+const SYMBOL_BASE = 0xA000;
+
+var symbols = map[string]Ident{};
+var symbolNames = map[Ident]string{};
+var nextSymbol = SYMBOL_BASE;
+
+//the symbol for a name, which is added the first time it is used.  Returns NONE if there are no
+//symbols left
+func symbolFor(name string) Ident {
+	id, ok := symbols[name];
+	if (ok) {
+		return id;
+	}
+	//the VM uses these keys itself
+	for nextSymbol == int(CNAM) || nextSymbol == int(VALU) {
+		nextSymbol++;
+	}
+	if (nextSymbol >= NATIVE_BASE) {
+		fmt.Fprintln(os.Stderr, "[symbolFor] ERROR: there are no symbols left for "+name);
+		return Ident(NONE);
+	}
+	id = Ident(nextSymbol);
+	nextSymbol++;
+	symbols[name] = id;
+	symbolNames[id] = name;
+	return id;
+}
+
+//the name that a symbol stands for, for messages
+func symbolName(id Ident) string {
+	name, ok := symbolNames[id];
+	if (!ok) {
+		return to_hex(id);
+	}
+	return name;
+}
+
+//the symbol of a method.  Overloads have different descriptors, so they get their own
+func methodSymbol(mname string, msig string) Ident {
+	return symbolFor(mname+":"+msig);
+}
+
+//the symbol of a field.  A class can have 2 fields with the same name and different types, so the
+//descriptor is part of it.  A method descriptor starts with (, so this can't match a method
+func fieldSymbol(fname string, fsig string) Ident {
+	return symbolFor(fname+":"+fsig);
+}
+
+//===================================================
+//the native that stands in for a member of a built-in class, or NONE if there isn't one.
+//A built-in class also gets the natives of its super classes, like getMessage and toString
+func nativeMember(cname string, mname string, msig string) uint16 {
+	if (!isBuiltinClass(cname)) {
+		return NONE;
+	}
+	for c := cname; c != ""; c = builtinSupers[c] {
+		id, ok := nativeKeys[c+"."+mname+":"+msig];
+		if (ok) {
+			return id;
+		}
+	}
+	id, ok := nativeKeys["java/lang/Object."+mname+":"+msig];
+	if (ok) {
+		return id;
+	}
+	return NONE;
+}
+
+	/**
+	* We are helping a bytecode that is referring to something in the constant pool.
+	* What we do is lookup the constant pool, and then translate it to our numbering system.
+	* We return the u16 that has the special name of a built-in, or else the poolKey of the index.
+	* Fields and methods are looked up when they are used, because they could be in another class
+	*/
+func lookupConstant(cpool *ConstantPool, index int) uint16 {
+	t := cpool.tag(index);
+	k := cpool.getConstant(index);
+	//this is the return value
+	name := uint16(0);
+	
+	if t==CONSTANT_Class {	//almost identical to Constant_String
+		//NEWOBJ only works on loaded classes and the built-in classes with a native <init>,
+		//but the others, like ANEWARRAY, work on any class
+		name = uint16(poolKey(index));
+	} else if t==CONSTANT_Fieldref || t==CONSTANT_Methodref || t==CONSTANT_InterfaceMethodref {
+		cr := k.(*CONSTANT_ref_info);
+		name = nativeMember(cr.cname, cr.name, cr.descriptor);
+		if (name == NONE) {
+			if (isBuiltinClass(cr.cname)) {
+				//not found - this is bad
+				fmt.Fprintln(os.Stderr, "[lookupConstant] ERROR: unable to lookup "+cr.cname+"."+cr.name+"; sig is "+cr.descriptor);
+			}
+			//loadConstants stored an FREF or MREF here
+			name = uint16(poolKey(index));
+		}
+	} else if t==CONSTANT_String  {
+		//this is easy, just lookup the k value
+		name = uint16(poolKey(index));
+	} else if t==CONSTANT_Integer || t==CONSTANT_Float || t==CONSTANT_Long || t==CONSTANT_Double {
+		name = uint16(poolKey(index));
+	} else {
+		//this is certainly unexpected
+		fmt.Fprintln(os.Stderr, "[lookupConstant] ERROR: Constant is tag "+strconv.Itoa(t));
+	}
+	return name;
+}
+
+//the key of a constant in the class table, which is its index in the constant pool.  createClassTable
+//checks that the pool is smaller than SYMBOL_BASE, so this can't be mistaken for a field or method
+func poolKey(index int) Ident {
+	return Ident(index);
+}
+
+//===================================================
+/** Classes.  A program can use any number of classes.  They are loaded from the class path
+* the first time they are used, and each one is compiled into its own class table.
+* Classes in java/ are never loaded, because they are either built in or not supported
+*/
+
+type Class struct {
+	name string;
+	cf *ClassFile;
+	cref Ref;		//the class table
+	super *Class;		//nil when the super class is built in
+	interfaces []*Class;	//the interfaces that it implements directly, except the built-in ones
+	builtin string;		//the built-in class at the top, which is usually java/lang/Object
+	fields []*MemberInfo;	//the instance fields, including the ones in the super classes
+	methods map[Ref]*MemberInfo;	//the method for each METH array, for the exception table and line numbers
+}
+
+//the classes that the VM doesn't load
+func isBuiltinClass(name string) bool {
+	return strings.HasPrefix(name, "java/");
+}
+
+//the super class of each built-in exception.  The program can throw, catch and extend these
+var builtinSupers = map[string]string {
+	"java/lang/Throwable": "java/lang/Object",
+	"java/lang/Exception": "java/lang/Throwable",
+	"java/lang/Error": "java/lang/Throwable",
+	"java/lang/RuntimeException": "java/lang/Exception",
+	"java/lang/ArithmeticException": "java/lang/RuntimeException",
+	"java/lang/ArrayStoreException": "java/lang/RuntimeException",
+	"java/lang/ClassCastException": "java/lang/RuntimeException",
+	"java/lang/IllegalArgumentException": "java/lang/RuntimeException",
+	"java/lang/IllegalStateException": "java/lang/RuntimeException",
+	"java/lang/IndexOutOfBoundsException": "java/lang/RuntimeException",
+	"java/lang/ArrayIndexOutOfBoundsException": "java/lang/IndexOutOfBoundsException",
+	"java/lang/StringIndexOutOfBoundsException": "java/lang/IndexOutOfBoundsException",
+	"java/lang/NegativeArraySizeException": "java/lang/RuntimeException",
+	"java/lang/NullPointerException": "java/lang/RuntimeException",
+	"java/lang/NumberFormatException": "java/lang/IllegalArgumentException",
+	"java/lang/UnsupportedOperationException": "java/lang/RuntimeException",
+	"java/lang/VirtualMachineError": "java/lang/Error",
+	"java/lang/OutOfMemoryError": "java/lang/VirtualMachineError",
+	"java/lang/StackOverflowError": "java/lang/VirtualMachineError",
+	"java/io/IOException": "java/lang/Exception",
+	"java/util/NoSuchElementException": "java/lang/RuntimeException",
+	"java/util/InputMismatchException": "java/util/NoSuchElementException",
+}
+
+//is a built-in class the named class, or a subclass of it?
+func builtinSubclassOf(cname string, name string) bool {
+	for cname != "" {
+		if (cname == name) {
+			return true;
+		}
+		cname = builtinSupers[cname];
+	}
+	return false;
+}
+
+func isThrowableClass(cname string) bool {
+	return builtinSubclassOf(cname, "java/lang/Throwable");
+}
+
+//create an object of a built-in class.  It is a table with the class name, and room for one more thing,
+//like the message of an exception or the string in a StringBuilder.  Its <init> sets that.
+//Returns NIL if there isn't room
+func newBuiltinObject(cname string) Ref {
+	oref := newTable(Ident(OBJT), 2);
+	if (oref == Ref(NIL)) {
+		return oref;
+	}
+	nref := newString(toCharArray(cname));
+	if (nref == Ref(NIL)) {
+		return nref;
+	}
+	put(oref, Ident(CNAM), nref);
+	return oref;
+}
+
+//create one of the built-in exceptions.  The message can be NIL.  Returns NIL if there isn't room
+func newThrowable(cname string, msg Ref) Ref {
+	oref := newBuiltinObject(cname);
+	if (oref != Ref(NIL)) {
+		put(oref, Ident(MESG), msg);
+	}
+	return oref;
+}
+
+//a class name the way Java prints it, like java.lang.String
+func javaName(cname string) string {
+	return strings.ReplaceAll(cname, "/", ".");
+}
+
+//find the METH array of a method in this class or one of its super classes, and the class that has it.
+//If none of them have it, look for a default method in their interfaces (JVMS 5.4.3.3).
+//Returns nil and NIL if it isn't there
+func (c *Class) findMethod(key Ident) (*Class, Ref) {
+	for k := c; k != nil; k = k.super {
+		m := get(k.cref, key);
+		if (m != Ref(NIL) && getType(m) == Ident(METH)) {
+			return k, m;
+		}
+	}
+	for k := c; k != nil; k = k.super {
+		for _, ic := range k.interfaces {
+			mc, m := ic.findDefault(key);
+			if (m != Ref(NIL)) {
+				return mc, m;
+			}
+		}
+	}
+	return nil, Ref(NIL);
+}
+
+//find a default method in an interface or the interfaces that it extends.  Abstract methods have no
+//METH array, and static and private methods aren't inherited
+func (c *Class) findDefault(key Ident) (*Class, Ref) {
+	m := get(c.cref, key);
+	if (m != Ref(NIL) && getType(m) == Ident(METH)) {
+		mi := c.methods[m];
+		if (mi != nil && !mi.isStatic() && !mi.methodFlags().has(ACC_PRIVATE)) {
+			return c, m;
+		}
+	}
+	for _, ic := range c.interfaces {
+		mc, m := ic.findDefault(key);
+		if (m != Ref(NIL)) {
+			return mc, m;
+		}
+	}
+	return nil, Ref(NIL);
+}
+
+//find the class that has a static field, which could be a super class.
+//If none of them have it, it is in this class
+func (c *Class) findStatic(key Ident) *Class {
+	for k := c; k != nil; k = k.super {
+		for _, fi := range k.cf.fields {
+			if (fi.isStatic() && fieldSymbol(fi.name(), fi.sig()) == key) {
+				return k;
+			}
+		}
+	}
+	return c;
+}
+
+//create an object.  This is a table with the instance fields and CNAM, which is the name of the class.
+//Number fields get their own INTG, FLOT, LONG or DUBL, the same as static fields
+func newObject(c *Class) Ref {
+	rows := len(c.fields) + 1;
+	if (isThrowableClass(c.builtin)) {
+		//room for the message
+		rows++;
+	}
+	if (rows < 2) {
+		rows = 2;
+	}
+	oref := newTable(Ident(OBJT), rows);
+	if (oref == Ref(NIL)) {
+		return oref;
+	}
+	put(oref, Ident(CNAM), get(c.cref, Ident(CNAM)));
+	for _, fi := range c.fields {
+		put(oref, fieldSymbol(fi.name(), fi.sig()), defaultValue(fi.sig()));
+	}
+	return oref;
+}
+
+//the class name of anything on the heap
+func objectClassName(r Ref) string {
+	switch (getType(r)) {
+		case Ident(OBJT):
+			return refToString(get(r, Ident(CNAM)));
+		case Ident(STRG):
+			return "java/lang/String";
+		case Ident(ARRY):
+			return "["+elementDescriptor(arrayElementType(r));
+	}
+	return "java/lang/Object";
+}
+
+//the Ident of the elements of an array type, given the descriptor after the [
+func descriptorElement(desc string) Ident {
+	switch (desc[0]) {
+		case 'Z':
+			return Ident(BOOL);
+		case 'B':
+			return Ident(BYTE);
+		case 'C':
+			return Ident(CHAR);
+		case 'S':
+			return Ident(SHRT);
+		case 'I':
+			return Ident(INTG);
+		case 'F':
+			return Ident(FLOT);
+		case 'J':
+			return Ident(LONG);
+		case 'D':
+			return Ident(DUBL);
+		case '[':
+			return Ident(ARRY);
+	}
+	if (desc == "Ljava/lang/String;") {
+		return Ident(STRG);
+	}
+	return Ident(OBJT);
+}
+
+//the opposite of descriptorElement.  An array doesn't keep the class of its objects, or the type
+//of the arrays in it, so those are just Object
+func elementDescriptor(elem Ident) string {
+	switch (elem) {
+		case Ident(BOOL):
+			return "Z";
+		case Ident(BYTE):
+			return "B";
+		case Ident(CHAR):
+			return "C";
+		case Ident(SHRT):
+			return "S";
+		case Ident(INTG):
+			return "I";
+		case Ident(FLOT):
+			return "F";
+		case Ident(LONG):
+			return "J";
+		case Ident(DUBL):
+			return "D";
+		case Ident(STRG):
+			return "Ljava/lang/String;";
+		case Ident(ARRY):
+			return "[Ljava/lang/Object;";
+	}
+	return "Ljava/lang/Object;";
+}
+
+//the element type for the atype of NEWARRAY (JVMS 6.5)
+func atypeElement(atype int) Ident {
+	switch (atype) {
+		case 4:
+			return Ident(BOOL);
+		case 5:
+			return Ident(CHAR);
+		case 6:
+			return Ident(FLOT);
+		case 7:
+			return Ident(DUBL);
+		case 8:
+			return Ident(BYTE);
+		case 9:
+			return Ident(SHRT);
+		case 10:
+			return Ident(INTG);
+		case 11:
+			return Ident(LONG);
+	}
+	return Ident(NONE);
+}
+
+//create a multi dimensional array.  desc is the type, like [[I, and there is a count for each
+//dimension that was given.  The arrays in the dimensions after that are null
+func newMultiArray(desc string, counts []int) Ref {
+	aref := newJavaArray(descriptorElement(desc[1:]), counts[0]);
+	if (aref == Ref(NIL) || len(counts) == 1) {
+		return aref;
+	}
+	for i := 0; i < counts[0]; i++ {
+		sub := newMultiArray(desc[1:], counts[1:]);
+		if (sub == Ref(NIL)) {
+			return sub;
+		}
+		storeElement(aref, i, Num48(sub));
+	}
+	return aref;
+}
+
+//===================================================
+/** Processor.  This runs the program.
+* Every method call gets a Frame with its own local variables and operand stack.
+* Numbers are kept as Num48 values.  Everything else (strings, arrays, objects and the special
+* objects like System.out) is kept as a Ref, which is widened to a Num48 so it fits in the same slot.
+* A long or double takes 2 slots, as in the JVM.  The first has all 64 bits, which fit because
+* a Num48 is really a uint64, and the second is 0.  So POP2, DUP2 and the locals work on any 2 slots
+*/
+
+type Frame struct {
+	cls *Class;			//the class of the method, which has the constants the code uses
+	method *MemberInfo;	//for the exception table and the line numbers.  This can be nil
+	ca *Code_attribute;
+	name string;		//the method name, used in error messages
+	code []uint16;
+	//where the first instruction is.  This is needed to turn the pc back into a bytecode offset
+	start int;
+	pc int;
+	locals []Num48;
+	stack []Num48;
+}
+
+func NewFrame(cls *Class, name string, code []uint16, start int) *Frame {
+	return &Frame {
+		cls: cls,
+		name: name,
+		code: code,
+		start: start,
+		pc: start,
+	}
+}
+
+func (f *Frame) push(v Num48) {
+	f.stack = append(f.stack, v);
+}
+
+func (f *Frame) pushRef(r Ref) {
+	f.push(Num48(r));
+}
+
+func (f *Frame) pop() Num48 {
+	n := len(f.stack);
+	if (n == 0) {
+		//this is a bug in the code we are running, not in the VM
+		fmt.Fprintln(os.Stderr, "[pop] ERROR: stack underflow in "+f.name);
+		return Num48(NIL);
+	}
+	v := f.stack[n-1];
+	f.stack = f.stack[:n-1];
+	return v;
+}
+
+func (f *Frame) popRef() Ref {
+	return Ref(f.pop());
+}
+
+//look at a value in the stack without popping it.  0 is the top
+func (f *Frame) peek(n int) Num48 {
+	i := len(f.stack) - 1 - n;
+	if (i < 0) {
+		fmt.Fprintln(os.Stderr, "[peek] ERROR: stack underflow in "+f.name);
+		return Num48(NIL);
+	}
+	return f.stack[i];
+}
+
+//push the 64 bits of a long or double, which take 2 slots
+func (f *Frame) pushWide(bits uint64) {
+	f.push(Num48(bits));
+	f.push(Num48(0));
+}
+
+func (f *Frame) popWide() uint64 {
+	f.pop();
+	return uint64(f.pop());
+}
+
+func (f *Frame) pushLong(lv int64) {
+	f.pushWide(uint64(lv));
+}
+
+func (f *Frame) popLong() int64 {
+	return int64(f.popWide());
+}
+
+func (f *Frame) pushDouble(dv float64) {
+	f.pushWide(math.Float64bits(dv));
+}
+
+func (f *Frame) popDouble() float64 {
+	return math.Float64frombits(f.popWide());
+}
+
+//locals are created as they are used, so we don't need max_locals
+func (f *Frame) load(n int) Num48 {
+	if (n >= len(f.locals)) {
+		return Num48(0);
+	}
+	return f.locals[n];
+}
+
+func (f *Frame) store(n int, v Num48) {
+	for n >= len(f.locals) {
+		f.locals = append(f.locals, Num48(0));
+	}
+	f.locals[n] = v;
+}
+
+//a long or double is in locals n and n+1
+func (f *Frame) loadWide(n int) {
+	f.push(f.load(n));
+	f.push(f.load(n+1));
+}
+
+func (f *Frame) storeWide(n int) {
+	low := f.pop();
+	f.store(n, f.pop());
+	f.store(n+1, low);
+}
+
+//push the value of a field in a class or object table
+func (f *Frame) pushField(tref Ref, key Ident) {
+	r := get(tref, key);
+	if (r != Ref(NIL) && (getType(r) == Ident(INTG) || getType(r) == Ident(FLOT))) {
+		f.push(readInt(r));
+	} else if (isWide(r)) {
+		f.pushWide(readWide(r));
+	} else {
+		f.pushRef(r);
+	}
+}
+
+//pop a value into a field in a class or object table
+func (f *Frame) popField(tref Ref, key Ident) {
+	r := get(tref, key);
+	if (isWide(r)) {
+		//a long or double field always has its own LONG or DUBL
+		updateWide(r, f.popWide());
+		return;
+	}
+	v := f.pop();
+	//numbers are updated in place, other values replace the ref
+	if (r == Ref(NIL) || !updateNum(r, v)) {
+		put(tref, key, Ref(v));
+	}
+}
+
+//the line in a stack trace for the instruction at here, like
+//	at Hello.main(Hello.java:5)
+func (f *Frame) traceLine(here int) string {
+	where := "Unknown Source";
+	src := f.cls.cf.sourceFileName();
+	if (src != "") {
+		where = src;
+		if (f.ca != nil && f.ca.lineNumber(here - f.start) > 0) {
+			where = where + ":"+strconv.Itoa(f.ca.lineNumber(here - f.start));
+		}
+	}
+	return "\tat "+javaName(f.cls.name)+"."+f.name+"("+where+")";
+}
+
+//read the next operand byte from the code
+func (f *Frame) next() int {
+	if (f.pc >= len(f.code)) {
+		return 0;
+	}
+	v := f.code[f.pc];
+	f.pc++;
+	return int(v);
+}
+
+//read a signed 16-bit operand, which is how branch offsets are stored
+func (f *Frame) nextShort() int {
+	hi := f.next();
+	lo := f.next();
+	return int(int16(hi<<8 | lo));
+}
+
+//read a signed 32-bit operand, which is used by goto_w and the switches
+func (f *Frame) nextInt() int {
+	hi := f.nextShort() & 0xFFFF;
+	lo := f.nextShort() & 0xFFFF;
+	return int(int32(hi<<16 | lo));
+}
+
+//the switches are padded so their operands start at a multiple of 4 from the start of the bytecode
+func (f *Frame) skipPadding() {
+	for (f.pc - f.start) % 4 != 0 {
+		f.pc++;
+	}
+}
+
+type Processor struct {
+	cp *ClassPath;
+	classes map[string]*Class;
+	halted bool;
+	//the exception that is being thrown, or NIL.  While it is set, each frame looks for a handler,
+	//and if it doesn't have one it returns and adds itself to the trace
+	thrown Ref;
+	trace []string;
+	//System.out, System.err and System.in, and the reader for System.in
+	out Ref;
+	err Ref;
+	in Ref;
+	stdin *bufio.Reader;
+	//thrown when there isn't room for the exception that should have been thrown
+	oom Ref;
+}
+
+//this creates System.out and the others, so the memory must be ready
+func NewProcessor(cp *ClassPath) *Processor {
+	return &Processor {
+		cp: cp,
+		classes: make(map[string]*Class),
+		thrown: Ref(NIL),
+		out: newBuiltinObject("java/io/PrintStream"),
+		err: newBuiltinObject("java/io/PrintStream"),
+		in: newBuiltinObject("java/io/InputStream"),
+		stdin: bufio.NewReader(os.Stdin),
+		oom: newThrowable("java/lang/OutOfMemoryError", Ref(NIL)),
+	}
+}
+
+//stop the program.  The VM can't recover from these
+func (p *Processor) halt(f *Frame, msg string) {
+	fmt.Fprintln(os.Stderr, "[Processor] ERROR: "+msg+" in "+f.name+" at pc "+strconv.Itoa(f.pc-f.start));
+	p.halted = true;
+}
+
+//throw one of the exceptions that the VM makes itself, like java/lang/NullPointerException.
+//The message can be "".  If there isn't room for the exception, this throws the OutOfMemoryError
+//that NewProcessor made
+func (p *Processor) throw(f *Frame, cname string, msg string) {
+	mref := Ref(NIL);
+	if (msg != "") {
+		mref = newString(toCharArray(msg));
+	}
+	exc := newThrowable(cname, mref);
+	if (exc == Ref(NIL)) {
+		exc = p.oom;
+	}
+	p.throwObject(exc);
+}
+
+func (p *Processor) throwObject(exc Ref) {
+	p.thrown = exc;
+	p.trace = nil;
+}
+
+//look for a handler for the exception that the instruction at here threw.  If there is one,
+//the frame goes on from there with just the exception on the stack (JVMS 2.10)
+func (p *Processor) catch(f *Frame, here int) bool {
+	if (f.ca == nil) {
+		return false;
+	}
+	pc := here - f.start;
+	for _, e := range f.ca.exception_table {
+		if (pc < int(e.start_pc) || pc >= int(e.end_pc)) {
+			continue;
+		}
+		//0 catches everything, and is used for finally
+		if (e.catch_type != 0 && !p.instanceOf(p.thrown, f.cls.cf.pool.getName(int(e.catch_type)))) {
+			continue;
+		}
+		f.stack = f.stack[:0];
+		f.pushRef(p.thrown);
+		p.thrown = Ref(NIL);
+		p.trace = nil;
+		f.pc = f.start + int(e.handler_pc);
+		return true;
+	}
+	return false;
+}
+
+//print an exception that nothing caught, the same way that java does, and stop
+func (p *Processor) uncaught() {
+	fmt.Fprintln(os.Stderr, "Exception in thread \"main\" "+p.defaultString(p.thrown));
+	for _, t := range p.trace {
+		fmt.Fprintln(os.Stderr, t);
+	}
+	p.halted = true;
+}
+
+//check an array and an index before using them
+func (p *Processor) checkIndex(f *Frame, aref Ref, i int) bool {
+	if (aref == Ref(NIL)) {
+		p.throw(f, "java/lang/NullPointerException", "");
+		return false;
+	} else if (i < 0 || i >= arrayLength(aref)) {
+		p.throw(f, "java/lang/ArrayIndexOutOfBoundsException",
+			"Index "+strconv.Itoa(i)+" out of bounds for length "+strconv.Itoa(arrayLength(aref)));
+		return false;
+	}
+	return true;
+}
+
+//create an array, or throw an exception if the length is bad or there isn't room
+func (p *Processor) newArray(f *Frame, elem Ident, alen int) Ref {
+	if (alen < 0) {
+		p.throw(f, "java/lang/NegativeArraySizeException", strconv.Itoa(alen));
+		return Ref(NIL);
+	}
+	aref := newJavaArray(elem, alen);
+	if (aref == Ref(NIL)) {
+		p.throw(f, "java/lang/OutOfMemoryError", "no room for an array of length "+strconv.Itoa(alen));
+	}
+	return aref;
+}
+
+//return a class, loading it from the class path if this is the first time it is used.
+//Built-in classes return nil
+func (p *Processor) loadClass(name string) (*Class, error) {
+	if (isBuiltinClass(name)) {
+		return nil, nil;
+	}
+	c, ok := p.classes[name];
+	if (ok) {
+		return c, nil;
+	}
+	cf, err := p.cp.LoadClass(name);
+	if (err != nil) {
+		return nil, err;
+	}
+	err = verifyClass(cf, p.cp);
+	if (err != nil) {
+		return nil, err;
+	}
+	return p.defineClass(cf);
+}
+
+//compile a class that has been read and checked.  This loads the super class first, and
+//then runs the static initializer, if there is one
+func (p *Processor) defineClass(cf *ClassFile) (*Class, error) {
+	c := &Class{name: cf.getClassName(), cf: cf, builtin: "java/lang/Object", methods: make(map[Ref]*MemberInfo)};
+	//add it now, so a class that uses itself doesn't load it again
+	p.classes[c.name] = c;
+	sname := cf.getSuperClassName();
+	if (sname != "") {
+		super, err := p.loadClass(sname);
+		if (err != nil) {
+			delete(p.classes, c.name);
+			return nil, err;
+		}
+		c.super = super;
+		if (super == nil) {
+			c.builtin = sname;
+		}
+	}
+	if (c.super != nil) {
+		c.builtin = c.super.builtin;
+		c.fields = append(c.fields, c.super.fields...);
+	}
+	//the interfaces are loaded now too, for their default methods
+	for _, iname := range cf.getInterfaceNames() {
+		ic, err := p.loadClass(iname);
+		if (err != nil) {
+			delete(p.classes, c.name);
+			return nil, err;
+		}
+		if (ic != nil) {
+			c.interfaces = append(c.interfaces, ic);
+		}
+	}
+	for _, fi := range cf.fields {
+		if (fi.isStatic()) {
+			continue;
+		}
+		//fields are keyed by name and descriptor, so a field can't hide one of the same type in a super class
+		for _, sf := range c.fields {
+			if (sf.name() == fi.name() && sf.sig() == fi.sig()) {
+				delete(p.classes, c.name);
+				return nil, errors.New("field "+fi.name()+" in "+c.name+" hides a field of a super class, which is not supported");
+			}
+		}
+		c.fields = append(c.fields, fi);
+	}
+	cref, err := run_compiler(cf);
+	if (err != nil) {
+		delete(p.classes, c.name);
+		return nil, err;
+	}
+	c.cref = cref;
+	for _, m := range cf.methods {
+		mref := get(c.cref, methodSymbol(m.name(), m.sig()));
+		if (mref != Ref(NIL) && getType(mref) == Ident(METH)) {
+			c.methods[mref] = m;
+		}
+	}
+	clinit := get(c.cref, methodSymbol("<clinit>", "()V"));
+	if (clinit != Ref(NIL) && getType(clinit) == Ident(METH) && !p.halted) {
+		p.invoke(c, clinit, nil);
+	}
+	return c, nil;
+}
+
+//load a class that an instruction uses.  This halts and returns nil if it can't, or returns nil if
+//its static initializer throws an exception
+func (p *Processor) classNamed(f *Frame, name string) *Class {
+	c, err := p.loadClass(name);
+	if (err != nil) {
+		p.halt(f, "can't load class "+name+": "+err.Error());
+		return nil;
+	}
+	if (c == nil) {
+		p.halt(f, "class "+name+" is not supported");
+	} else if (p.thrown != Ref(NIL)) {
+		//the static initializer threw an exception
+		return nil;
+	}
+	return c;
+}
+
+//is the object an instance of the named class, or of a class that extends or implements it?
+func (p *Processor) instanceOf(obj Ref, name string) bool {
+	oname := objectClassName(obj);
+	if (oname == name || name == "java/lang/Object") {
+		return true;
+	}
+	if (getType(obj) == Ident(ARRY)) {
+		if (!strings.HasPrefix(name, "[")) {
+			return name == "java/lang/Cloneable" || name == "java/io/Serializable";
+		}
+		//an array of objects can be cast to any array of objects, because it doesn't know their class
+		want := descriptorElement(name[1:]);
+		have := arrayElementType(obj);
+		return want == have || isRefElement(want) && isRefElement(have);
+	}
+	if (getType(obj) != Ident(OBJT)) {
+		return false;
+	}
+	c, err := p.loadClass(oname);
+	if (err == nil && c == nil) {
+		//one of the built-in exceptions
+		return builtinSubclassOf(oname, name);
+	}
+	return err == nil && p.subclassOf(c, name);
+}
+
+func (p *Processor) subclassOf(c *Class, name string) bool {
+	for k := c; k != nil; k = k.super {
+		if (k.name == name) {
+			return true;
+		}
+		for _, iname := range k.cf.getInterfaceNames() {
+			if (iname == name) {
+				return true;
+			}
+			ic, err := p.loadClass(iname);
+			if (err == nil && ic != nil && p.subclassOf(ic, name)) {
+				return true;
+			}
+		}
+	}
+	return builtinSubclassOf(c.builtin, name);
+}
+
+//run main.  This returns the exit status, which is 1 if the program halted or an exception
+//wasn't caught, the same as java
+func (p *Processor) start(c *Class, args []string) int {
+	if (p.thrown != Ref(NIL)) {
+		//from the static initializer
+		p.uncaught();
+	}
+	if (p.halted) {
+		//the static initializer failed
+		return 1;
+	}
+	mc, m := c.findMethod(methodSymbol("main", "([Ljava/lang/String;)V"));
+	if (m == Ref(NIL)) {
+		fmt.Fprintln(os.Stderr, "[start] ERROR: "+c.name+" has no main method");
+		return 1;
+	}
+	//main gets the args as an array of strings
+	aref := newJavaArray(Ident(STRG), len(args));
+	for i := 0; i < len(args); i++ {
+		storeElement(aref, i, Num48(newString(toCharArray(args[i]))));
+	}
+	p.invoke(mc, m, []Num48{Num48(aref)});
+	if (p.thrown != Ref(NIL)) {
+		p.uncaught();
+	}
+	if (p.halted) {
+		return 1;
+	}
+	return 0;
+}
+
+//call the method in the METH array.  The args are the slots of the params, so a long or double
+//is 2 of them.  This returns the result, which is nothing for a void method, or 2 slots for a
+//long or double
+func (p *Processor) invoke(c *Class, mref Ref, args []Num48) []Num48 {
+	//run the code right where it is in memory.  The array has the name and the
+	//number of params before the code, so the code starts at 2
+	addr := int(mref) - MEMBASE + 2;
+	code := memory[addr : addr+arrayLength(mref)];
+	f := NewFrame(c, symbolName(Ident(code[0])), code, 2);
+	f.method = c.methods[mref];
+	if (f.method != nil) {
+		f.name = f.method.name();
+		f.ca = f.method.getCode();
+	}
+	for i := 0; i < len(args); i++ {
+		f.store(i, args[i]);
+	}
+	return p.execute(f);
+}
+
+//pop the args of a method and call it, then push the result
+func (p *Processor) call(f *Frame, c *Class, mref Ref) {
+	//the arguments come off the stack in reverse order
+	n := int(loadFromArray(mref, 1));
+	args := make([]Num48, n);
+	for i := n-1; i >= 0; i-- {
+		args[i] = f.pop();
+	}
+	for _, v := range p.invoke(c, mref, args) {
+		f.push(v);
+	}
+}
+
+//read the FREF or MREF that loadConstants stored for a field or method.  This returns the name of the
+//class, the Ident of the member and the number of slots.  It halts and returns false if it isn't there
+func (p *Processor) memberRef(f *Frame, key uint16, typ Ident) (string, Ident, int, bool) {
+	r := get(f.cls.cref, Ident(key));
+	if (r == Ref(NIL) || getType(r) != typ) {
+		p.halt(f, "member "+to_hex(Ident(key))+" is not in the class table");
+		return "", Ident(0), 0, false;
+	}
+	cname := refToString(get(f.cls.cref, Ident(loadFromArray(r, 0))));
+	return cname, Ident(loadFromArray(r, 1)), int(loadFromArray(r, 2)), true;
+}
+
+//the built-in method in an MREF, for a method that a class gets from a built-in super class.
+//Returns NONE if there isn't one
+func (p *Processor) inheritedNative(f *Frame, key uint16) uint16 {
+	return loadFromArray(get(f.cls.cref, Ident(key)), 3);
+}
+
+//the object for GETFIELD, PUTFIELD or INVOKEVIRTUAL.  This throws NullPointerException if it is null,
+//and halts if it is one of the built-in objects
+func (p *Processor) checkObject(f *Frame, obj Ref, member Ident) bool {
+	if (obj == Ref(NIL)) {
+		p.throw(f, "java/lang/NullPointerException", "");
+		return false;
+	} else if (getType(obj) != Ident(OBJT)) {
+		p.halt(f, "can't use "+symbolName(member)+" on a "+objectClassName(obj));
+		return false;
+	}
+	return true;
+}
+
+//read the key that translateCode put in place of the constant pool index
+func (p *Processor) constantKey(f *Frame, op uint16) uint16 {
+	key := f.next();
+	if (op != LDC) {
+		//skip the NOP
+		f.next();
+	}
+	return uint16(key);
+}
+
+func (p *Processor) execute(f *Frame) []Num48 {
+	for !p.halted {
+		if (f.pc >= len(f.code)) {
+			p.halt(f, "ran past the end of the code");
+			break;
+		}
+		//branch offsets are from the address of the opcode
+		here := f.pc;
+		op := uint16(f.next());
+		switch (op) {
+			case NOP:
+			case ACONST_NULL:
+				f.pushRef(Ref(NIL));
+			case ICONST_M1, ICONST_0, ICONST_1, ICONST_2, ICONST_3, ICONST_4, ICONST_5:
+				f.push(IntToNum48(int(op) - int(ICONST_0)));
+			case FCONST_0, FCONST_1, FCONST_2:
+				f.push(FloatToNum48(float32(op - FCONST_0)));
+			case LCONST_0, LCONST_1:
+				f.pushLong(int64(op - LCONST_0));
+			case DCONST_0, DCONST_1:
+				f.pushDouble(float64(op - DCONST_0));
+			case BIPUSH:
+				f.push(IntToNum48(int(int8(f.next()))));
+			case SIPUSH:
+				f.push(IntToNum48(f.nextShort()));
+			case LDC, LDC_W:
+				key := p.constantKey(f, op);
+				r := get(f.cls.cref, Ident(key));
+				if (r == Ref(NIL)) {
+					p.halt(f, "constant "+to_hex(Ident(key))+" is not in the class table");
+				} else if (getType(r) == Ident(INTG) || getType(r) == Ident(FLOT)) {
+					f.push(readInt(r));
+				} else {
+					f.pushRef(r);
+				}
+			case LDC2_W:
+				key := p.constantKey(f, op);
+				r := get(f.cls.cref, Ident(key));
+				if (!isWide(r)) {
+					p.halt(f, "constant "+to_hex(Ident(key))+" is not a long or double");
+				} else {
+					f.pushWide(readWide(r));
+				}
+
+			//locals
+			case ILOAD, FLOAD, ALOAD:
+				f.push(f.load(f.next()));
+			case ILOAD_0, ILOAD_1, ILOAD_2, ILOAD_3:
+				f.push(f.load(int(op - ILOAD_0)));
+			case FLOAD_0, FLOAD_1, FLOAD_2, FLOAD_3:
+				f.push(f.load(int(op - FLOAD_0)));
+			case ALOAD_0, ALOAD_1, ALOAD_2, ALOAD_3:
+				f.push(f.load(int(op - ALOAD_0)));
+			case ISTORE, FSTORE, ASTORE:
+				f.store(f.next(), f.pop());
+			case ISTORE_0, ISTORE_1, ISTORE_2, ISTORE_3:
+				f.store(int(op - ISTORE_0), f.pop());
+			case FSTORE_0, FSTORE_1, FSTORE_2, FSTORE_3:
+				f.store(int(op - FSTORE_0), f.pop());
+			case ASTORE_0, ASTORE_1, ASTORE_2, ASTORE_3:
+				f.store(int(op - ASTORE_0), f.pop());
+			case LLOAD, DLOAD:
+				f.loadWide(f.next());
+			case LLOAD_0, LLOAD_1, LLOAD_2, LLOAD_3:
+				f.loadWide(int(op - LLOAD_0));
+			case DLOAD_0, DLOAD_1, DLOAD_2, DLOAD_3:
+				f.loadWide(int(op - DLOAD_0));
+			case LSTORE, DSTORE:
+				f.storeWide(f.next());
+			case WIDE:
+				//the same load, store or iinc, with a 2-byte index
+				wop := uint16(f.next());
+				n := f.nextShort() & 0xFFFF;
+				switch (wop) {
+					case ILOAD, FLOAD, ALOAD:
+						f.push(f.load(n));
+					case LLOAD, DLOAD:
+						f.loadWide(n);
+					case ISTORE, FSTORE, ASTORE:
+						f.store(n, f.pop());
+					case LSTORE, DSTORE:
+						f.storeWide(n);
+					case IINC:
+						c := int32(f.nextShort());
+						f.store(n, IntToNum48(int(Num48ToInt(f.load(n)) + c)));
+					default:
+						p.halt(f, "unsupported opcode "+strconv.Itoa(int(wop))+" after wide");
+				}
+			case LSTORE_0, LSTORE_1, LSTORE_2, LSTORE_3:
+				f.storeWide(int(op - LSTORE_0));
+			case DSTORE_0, DSTORE_1, DSTORE_2, DSTORE_3:
+				f.storeWide(int(op - DSTORE_0));
+			case IINC:
+				n := f.next();
+				c := int(int8(f.next()));
+				f.store(n, IntToNum48(int(Num48ToInt(f.load(n)) + int32(c))));
+
+			//stack
+			case DUP:
+				v := f.pop();
+				f.push(v);
+				f.push(v);
+			case POP:
+				f.pop();
+			case POP2:
+				f.pop();
+				f.pop();
+			case DUP2:
+				b := f.pop();
+				a := f.pop();
+				f.push(a);
+				f.push(b);
+				f.push(a);
+				f.push(b);
+			case DUP_X1:
+				v1 := f.pop();
+				v2 := f.pop();
+				f.push(v1);
+				f.push(v2);
+				f.push(v1);
+			case DUP_X2:
+				v1 := f.pop();
+				v2 := f.pop();
+				v3 := f.pop();
+				f.push(v1);
+				f.push(v3);
+				f.push(v2);
+				f.push(v1);
+			case DUP2_X1:
+				v1 := f.pop();
+				v2 := f.pop();
+				v3 := f.pop();
+				f.push(v2);
+				f.push(v1);
+				f.push(v3);
+				f.push(v2);
+				f.push(v1);
+			case DUP2_X2:
+				v1 := f.pop();
+				v2 := f.pop();
+				v3 := f.pop();
+				v4 := f.pop();
+				f.push(v2);
+				f.push(v1);
+				f.push(v4);
+				f.push(v3);
+				f.push(v2);
+				f.push(v1);
+			case SWAP:
+				v1 := f.pop();
+				v2 := f.pop();
+				f.push(v1);
+				f.push(v2);
+
+			//math.  Ints and floats are both Num48, but ints are done in int32
+			case IADD, ISUB, IMUL, IDIV, IREM, ISHL, ISHR, IUSHR, IAND, IOR, IXOR:
+				b := Num48ToInt(f.pop());
+				a := Num48ToInt(f.pop());
+				c, ok := intMath(op, a, b);
+				if (!ok) {
+					p.throw(f, "java/lang/ArithmeticException", "/ by zero");
+				} else {
+					f.push(IntToNum48(int(c)));
+				}
+			case FADD:
+				b := f.pop();
+				f.push(ADD(f.pop(), b));
+			case FSUB:
+				b := f.pop();
+				f.push(SUB(f.pop(), b));
+			case FMUL:
+				b := f.pop();
+				f.push(MUL(f.pop(), b));
+			case FDIV:
+				b := f.pop();
+				f.push(NUM48_FDIV(f.pop(), b));
+			case FREM:
+				b := Num48ToFloat(f.pop());
+				a := Num48ToFloat(f.pop());
+				if (b == 0) {
+					//Num48 has no NaN, so this is 0 like NUM48_FDIV
+					f.push(Num48(0));
+				} else {
+					f.push(FloatToNum48(float32(math.Mod(float64(a), float64(b)))));
+				}
+			case INEG:
+				f.push(IntToNum48(int(-Num48ToInt(f.pop()))));
+			case FNEG:
+				f.push(NEG(f.pop()));
+			case I2F:
+				f.push(FloatToNum48(float32(Num48ToInt(f.pop()))));
+			case F2I:
+				f.push(IntToNum48(int(doubleToInt(float64(Num48ToFloat(f.pop()))))));
+			case I2B:
+				f.push(IntToNum48(int(int8(Num48ToInt(f.pop())))));
+			case I2C:
+				f.push(IntToNum48(int(uint16(Num48ToInt(f.pop())))));
+			case I2S:
+				f.push(IntToNum48(int(int16(Num48ToInt(f.pop())))));
+			case FCMPL, FCMPG:
+				//Num48 has no NaN, so these are the same
+				b := f.pop();
+				f.push(IntToNum48(CMP(f.pop(), b)));
+
+			//long math
+			case LADD:
+				b := f.popLong();
+				f.pushLong(f.popLong() + b);
+			case LSUB:
+				b := f.popLong();
+				f.pushLong(f.popLong() - b);
+			case LMUL:
+				b := f.popLong();
+				f.pushLong(f.popLong() * b);
+			case LDIV, LREM:
+				b := f.popLong();
+				a := f.popLong();
+				if (b == 0) {
+					p.throw(f, "java/lang/ArithmeticException", "/ by zero");
+				} else if (op == LDIV) {
+					f.pushLong(a / b);
+				} else {
+					f.pushLong(a % b);
+				}
+			case LNEG:
+				f.pushLong(-f.popLong());
+			case LSHL, LSHR, LUSHR:
+				//only the low 6 bits of the shift count are used
+				n := uint(Num48ToInt(f.pop()) & 63);
+				a := f.popLong();
+				if (op == LSHL) {
+					f.pushLong(a << n);
+				} else if (op == LSHR) {
+					f.pushLong(a >> n);
+				} else {
+					f.pushLong(int64(uint64(a) >> n));
+				}
+			case LAND:
+				b := f.popLong();
+				f.pushLong(f.popLong() & b);
+			case LOR:
+				b := f.popLong();
+				f.pushLong(f.popLong() | b);
+			case LXOR:
+				b := f.popLong();
+				f.pushLong(f.popLong() ^ b);
+			case LCMP:
+				b := f.popLong();
+				a := f.popLong();
+				c := 0;
+				if (a < b) {
+					c = -1;
+				} else if (a > b) {
+					c = 1;
+				}
+				f.push(IntToNum48(c));
+
+			//double math.  These are real doubles, unlike floats
+			case DADD:
+				b := f.popDouble();
+				f.pushDouble(f.popDouble() + b);
+			case DSUB:
+				b := f.popDouble();
+				f.pushDouble(f.popDouble() - b);
+			case DMUL:
+				b := f.popDouble();
+				f.pushDouble(f.popDouble() * b);
+			case DDIV:
+				b := f.popDouble();
+				f.pushDouble(f.popDouble() / b);
+			case DREM:
+				b := f.popDouble();
+				f.pushDouble(math.Mod(f.popDouble(), b));
+			case DNEG:
+				f.pushDouble(-f.popDouble());
+			case DCMPL, DCMPG:
+				//they only differ when one is NaN
+				b := f.popDouble();
+				a := f.popDouble();
+				c := 0;
+				if (a < b) {
+					c = -1;
+				} else if (a > b) {
+					c = 1;
+				} else if (a != b) {
+					c = -1;
+					if (op == DCMPG) {
+						c = 1;
+					}
+				}
+				f.push(IntToNum48(c));
+
+			//conversions
+			case I2L:
+				f.pushLong(int64(Num48ToInt(f.pop())));
+			case I2D:
+				f.pushDouble(float64(Num48ToInt(f.pop())));
+			case L2I:
+				f.push(IntToNum48(int(int32(f.popLong()))));
+			case L2F:
+				f.push(FloatToNum48(float32(f.popLong())));
+			case L2D:
+				f.pushDouble(float64(f.popLong()));
+			case F2L:
+				f.pushLong(doubleToLong(float64(Num48ToFloat(f.pop()))));
+			case F2D:
+				f.pushDouble(float64(Num48ToFloat(f.pop())));
+			case D2I:
+				f.push(IntToNum48(int(doubleToInt(f.popDouble()))));
+			case D2L:
+				f.pushLong(doubleToLong(f.popDouble()));
+			case D2F:
+				f.push(FloatToNum48(float32(f.popDouble())));
+
+			//branches
+			case JMP:
+				f.pc = here + f.nextShort();
+			case GOTO_W:
+				f.pc = here + f.nextInt();
+			case TABLESWITCH:
+				f.skipPadding();
+				def := f.nextInt();
+				low := f.nextInt();
+				high := f.nextInt();
+				key := int(Num48ToInt(f.pop()));
+				if (key < low || key > high) {
+					f.pc = here + def;
+				} else {
+					//each offset takes 4 chars
+					f.pc = f.pc + (key - low) * 4;
+					f.pc = here + f.nextInt();
+				}
+			case LOOKUPSWITCH:
+				f.skipPadding();
+				def := f.nextInt();
+				npairs := f.nextInt();
+				key := int(Num48ToInt(f.pop()));
+				target := here + def;
+				for i := 0; i < npairs; i++ {
+					match := f.nextInt();
+					offset := f.nextInt();
+					if (match == key) {
+						target = here + offset;
+						break;
+					}
+				}
+				f.pc = target;
+			case IFEQ, IFNE, IFLT, IFGE, IFGT, IFLE:
+				offset := f.nextShort();
+				if (compare(op, CMP(f.pop(), Num48(0)))) {
+					f.pc = here + offset;
+				}
+			case IF_ICMPEQ, IF_ICMPNE, IF_ICMPLT, IF_ICMPGE, IF_ICMPGT, IF_ICMPLE:
+				offset := f.nextShort();
+				b := f.pop();
+				if (compare(op, CMP(f.pop(), b))) {
+					f.pc = here + offset;
+				}
+			case IF_ACMPEQ, IF_ACMPNE:
+				offset := f.nextShort();
+				b := f.pop();
+				same := f.pop() == b;
+				if (same == (op == IF_ACMPEQ)) {
+					f.pc = here + offset;
+				}
+			case IFNULL, IFNONNULL:
+				offset := f.nextShort();
+				isnull := f.popRef() == Ref(NIL);
+				if (isnull == (op == IFNULL)) {
+					f.pc = here + offset;
+				}
+
+			//fields
+			case GETSTATIC:
+				key := p.constantKey(f, op);
+				if (p.callNative(f, key)) {
+					break;
+				}
+				cname, fid, _, ok := p.memberRef(f, key, Ident(FREF));
+				if (!ok) {
+					break;
+				}
+				c := p.classNamed(f, cname);
+				if (c != nil) {
+					f.pushField(c.findStatic(fid).cref, fid);
+				}
+			case PUTSTATIC:
+				key := p.constantKey(f, op);
+				cname, fid, _, ok := p.memberRef(f, key, Ident(FREF));
+				if (!ok) {
+					break;
+				}
+				c := p.classNamed(f, cname);
+				if (c != nil) {
+					f.popField(c.findStatic(fid).cref, fid);
+				}
+			case GETFIELD:
+				key := p.constantKey(f, op);
+				_, fid, _, ok := p.memberRef(f, key, Ident(FREF));
+				if (!ok) {
+					break;
+				}
+				obj := f.popRef();
+				if (p.checkObject(f, obj, fid)) {
+					f.pushField(obj, fid);
+				}
+			case PUTFIELD:
+				key := p.constantKey(f, op);
+				_, fid, slots, ok := p.memberRef(f, key, Ident(FREF));
+				if (!ok) {
+					break;
+				}
+				//the object is under the value
+				obj := Ref(f.peek(slots));
+				if (p.checkObject(f, obj, fid)) {
+					f.popField(obj, fid);
+					f.pop();
+				}
+
+			//methods
+			case INVOKESTATIC, INVOKESPECIAL:
+				//these call the method in the class that is named, or the first super class that has it
+				key := p.constantKey(f, op);
+				if (p.callNative(f, key)) {
+					break;
+				}
+				cname, mid, _, ok := p.memberRef(f, key, Ident(MREF));
+				if (!ok) {
+					break;
+				}
+				c := p.classNamed(f, cname);
+				if (c == nil) {
+					break;
+				}
+				mc, m := c.findMethod(mid);
+				if (m == Ref(NIL) && p.callNative(f, p.inheritedNative(f, key))) {
+					break;
+				}
+				if (m == Ref(NIL)) {
+					p.halt(f, "method "+symbolName(mid)+" not found in "+cname);
+					break;
+				}
+				p.call(f, mc, m);
+			case INVOKEVIRTUAL, INVOKEINTERFACE:
+				key := p.constantKey(f, op);
+				if (op == INVOKEINTERFACE) {
+					//skip the count and the 0
+					f.next();
+					f.next();
+				}
+				if (p.callOverride(f, key) || p.callNative(f, key)) {
+					break;
+				}
+				_, mid, slots, ok := p.memberRef(f, key, Ident(MREF));
+				if (!ok) {
+					break;
+				}
+				//the object is under the args, and its class decides which method runs
+				obj := Ref(f.peek(slots));
+				if (!p.checkObject(f, obj, mid)) {
+					break;
+				}
+				c := p.classNamed(f, objectClassName(obj));
+				if (c == nil) {
+					break;
+				}
+				mc, m := c.findMethod(mid);
+				if (m == Ref(NIL) && p.callNative(f, p.inheritedNative(f, key))) {
+					break;
+				}
+				if (m == Ref(NIL)) {
+					p.halt(f, "method "+symbolName(mid)+" not found in "+c.name);
+					break;
+				}
+				p.call(f, mc, m);
+
+			//objects
+			case NEWOBJ:
+				key := p.constantKey(f, op);
+				cname := p.className(f, key);
+				if (isThrowableClass(cname) || nativeClasses[cname]) {
+					//the native <init> sets it up
+					obj := newBuiltinObject(cname);
+					if (obj == Ref(NIL)) {
+						p.throw(f, "java/lang/OutOfMemoryError", "no room for a new "+javaName(cname));
+						break;
+					}
+					f.pushRef(obj);
+					break;
+				}
+				c := p.classNamed(f, cname);
+				if (c == nil) {
+					break;
+				}
+				obj := newObject(c);
+				if (obj == Ref(NIL)) {
+					p.halt(f, "no room for a new "+c.name);
+					break;
+				}
+				f.pushRef(obj);
+			case CHECKCAST, INSTANCEOF:
+				key := p.constantKey(f, op);
+				obj := f.popRef();
+				cname := p.className(f, key);
+				is := obj != Ref(NIL) && p.instanceOf(obj, cname);
+				if (op == INSTANCEOF) {
+					if (is) {
+						f.push(IntToNum48(1));
+					} else {
+						f.push(IntToNum48(0));
+					}
+				} else if (obj == Ref(NIL) || is) {
+					//null can be cast to anything
+					f.pushRef(obj);
+				} else {
+					p.throw(f, "java/lang/ClassCastException", "class "+javaName(objectClassName(obj))+
+						" cannot be cast to class "+javaName(cname));
+				}
+			case RETURNV:
+				return nil;
+			case IRETURN, FRETURN, ARETURN:
+				return []Num48{f.pop()};
+			case LRETURN, DRETURN:
+				low := f.pop();
+				return []Num48{f.pop(), low};
+			case MONITORENTER, MONITOREXIT:
+				//there is only one thread, so there is nothing to lock
+				if (f.popRef() == Ref(NIL)) {
+					p.throw(f, "java/lang/NullPointerException", "");
+				}
+			case ATHROW:
+				exc := f.popRef();
+				if (exc == Ref(NIL)) {
+					p.throw(f, "java/lang/NullPointerException", "");
+				} else {
+					p.throwObject(exc);
+				}
+
+			//arrays
+			case NEWARRAY:
+				elem := atypeElement(f.next());
+				aref := p.newArray(f, elem, int(Num48ToInt(f.pop())));
+				f.pushRef(aref);
+			case ANEWARRAY:
+				key := p.constantKey(f, op);
+				//the class is the type of the elements, which could be another array
+				cname := p.className(f, key);
+				elem := Ident(ARRY);
+				if (!strings.HasPrefix(cname, "[")) {
+					elem = descriptorElement("L"+cname+";");
+				}
+				aref := p.newArray(f, elem, int(Num48ToInt(f.pop())));
+				f.pushRef(aref);
+			case MULTIANEWARRAY:
+				key := p.constantKey(f, op);
+				dims := f.next();
+				//the counts are on the stack, with the last one on top
+				counts := make([]int, dims);
+				for i := dims-1; i >= 0; i-- {
+					counts[i] = int(Num48ToInt(f.pop()));
+				}
+				for _, n := range counts {
+					if (n < 0) {
+						p.throw(f, "java/lang/NegativeArraySizeException", strconv.Itoa(n));
+						break;
+					}
+				}
+				if (p.halted) {
+					break;
+				}
+				desc := p.className(f, key);
+				aref := newMultiArray(desc, counts);
+				if (aref == Ref(NIL)) {
+					p.throw(f, "java/lang/OutOfMemoryError", "no room for a new "+desc);
+				}
+				f.pushRef(aref);
+			case ARRAYLENGTH:
+				aref := f.popRef();
+				if (aref == Ref(NIL)) {
+					p.throw(f, "java/lang/NullPointerException", "");
+				} else {
+					f.push(IntToNum48(arrayLength(aref)));
+				}
+			case IALOAD, FALOAD, AALOAD, BALOAD, CALOAD, SALOAD:
+				i := int(Num48ToInt(f.pop()));
+				aref := f.popRef();
+				if (p.checkIndex(f, aref, i)) {
+					f.push(loadElement(aref, i));
+				}
+			case LALOAD, DALOAD:
+				i := int(Num48ToInt(f.pop()));
+				aref := f.popRef();
+				if (p.checkIndex(f, aref, i)) {
+					f.pushWide(loadWideElement(aref, i));
+				}
+			case IASTORE, FASTORE, AASTORE, BASTORE, CASTORE, SASTORE:
+				v := f.pop();
+				i := int(Num48ToInt(f.pop()));
+				aref := f.popRef();
+				if (p.checkIndex(f, aref, i)) {
+					storeElement(aref, i, v);
+				}
+			case LASTORE, DASTORE:
+				bits := f.popWide();
+				i := int(Num48ToInt(f.pop()));
+				aref := f.popRef();
+				if (p.checkIndex(f, aref, i)) {
+					storeWideElement(aref, i, bits);
+				}
+			default:
+				p.halt(f, "unsupported opcode "+strconv.Itoa(int(op)));
+		}
+		if (p.thrown != Ref(NIL) && !p.catch(f, here)) {
+			//let the caller look for a handler
+			p.trace = append(p.trace, f.traceLine(here));
+			return nil;
+		}
+	}
+	return nil;
+}
+
+//the name of the class in a CONSTANT_Class
+func (p *Processor) className(f *Frame, key uint16) string {
+	return refToString(get(f.cls.cref, Ident(key)));
+}
+
+//decide a branch, given the opcode and the result of CMP
+func compare(op uint16, c int) bool {
+	switch (op) {
+		case IFEQ, IF_ICMPEQ:
+			return c == 0;
+		case IFNE, IF_ICMPNE:
+			return c != 0;
+		case IFLT, IF_ICMPLT:
+			return c < 0;
+		case IFGE, IF_ICMPGE:
+			return c >= 0;
+		case IFGT, IF_ICMPGT:
+			return c > 0;
+		case IFLE, IF_ICMPLE:
+			return c <= 0;
+	}
+	return false;
+}
+
+//read a string from memory.  Java prints a null string as "null"
+func refToString(r Ref) string {
+	if (r == Ref(NIL)) {
+		return "null";
+	}
+	return fromCharArray(readString(r));
+}
+
+//format a float the way Java's Float.toString does
+func floatToString(fv float32) string {
+	return javaNumber(float64(fv), 32);
+}
+
+//format a double the way Java's Double.toString does
+func doubleToString(dv float64) string {
+	return javaNumber(dv, 64);
+}
+
+//Java writes a number from 10^-3 up to 10^7 as a decimal, and any other number in E notation,
+//like 1.0E10 or 1.5E-5.  Both use the fewest digits that read back as the same float or double,
+//and always have a decimal point
+func javaNumber(v float64, bits int) string {
+	if (v != v) {
+		return "NaN";
+	} else if (math.IsInf(v, 1)) {
+		return "Infinity";
+	} else if (math.IsInf(v, -1)) {
+		return "-Infinity";
+	}
+	a := math.Abs(v);
+	if (a == 0 || a >= 1e-3 && a < 1e7) {
+		s := strconv.FormatFloat(v, 'f', -1, bits);
+		if (!strings.Contains(s, ".")) {
+			s = s + ".0";
+		}
+		return s;
+	}
+	//Go writes 1e+10, and Java writes 1.0E10.  When one digit is enough, Java picks the closest
+	//number with 2 digits, which is only different for the smallest numbers, like 4.9E-324
+	mant, exp, _ := strings.Cut(strconv.FormatFloat(v, 'e', -1, bits), "e");
+	if (!strings.Contains(mant, ".")) {
+		mant, exp, _ = strings.Cut(strconv.FormatFloat(v, 'e', 1, bits), "e");
+	}
+	e, _ := strconv.Atoi(exp);
+	return mant + "E" + strconv.Itoa(e);
+}
+
+//d2i and d2l in Java give 0 for NaN, and the smallest or biggest value when the double is out of range.
+//Go doesn't say what happens then, so check first
+func doubleToInt(dv float64) int32 {
+	if (dv != dv) {
+		return 0;
+	} else if (dv <= math.MinInt32) {
+		return math.MinInt32;
+	} else if (dv >= math.MaxInt32) {
+		return math.MaxInt32;
+	}
+	return int32(dv);
+}
+
+func doubleToLong(dv float64) int64 {
+	if (dv != dv) {
+		return 0;
+	} else if (dv <= math.MinInt64) {
+		return math.MinInt64;
+	} else if (dv >= math.MaxInt64) {
+		return math.MaxInt64;
+	}
+	return int64(dv);
+}
+
+//===================================================
+/**
+* Natives.  These are the methods of the built-in classes, like java/lang/String and java/io/PrintStream,
+* written in Go.  Each one is bound to a key with the class, the method and the descriptor, like
+*	java/lang/Math.abs:(I)I
+* and gets an Ident from NATIVE_BASE up.  lookupConstant puts that Ident in the code in place of the
+* constant pool index, so the VM calls the Go function instead of looking for the method.
+*
+* A native finds its args on the frame's stack, the same as the bytecode left them, with the object under
+* them for an instance method.  It pops them and pushes the result, and can throw an exception with p.throw.
+* A static field, like java/lang/System.out, can also be a native.  It just pushes the value.
+*/
+
+//NativeMethod is a built-in method written in Go
+type NativeMethod func(p *Processor, f *Frame)
+
+const NATIVE_BASE = 0xFE01;
+
+type native struct {
+	key string;
+	fn NativeMethod;
+	//the method and the number of slots of its params, not counting the object.  These are used to
+	//look for a method in a user class that overrides this one, like toString
+	mid Ident;
+	slots int;
+}
+
+//the natives, in the order that they were registered, and their Idents by key
+var natives []*native;
+var nativeKeys = map[string]uint16{};
+
+//the built-in classes that NEWOBJ can create, because they have a native <init>
+var nativeClasses = map[string]bool{};
+
+//the built-in classes that have natives.  The verifier knows that these are classes
+var nativeOwners = map[string]bool{};
+
+//RegisterNative binds a Go function to a method or a static field of a built-in class.  The key is
+//class.method:descriptor.  This replaces the native if the key is already registered
+func RegisterNative(key string, fn NativeMethod) {
+	colon := strings.Index(key, ":");
+	dot := -1;
+	if (colon > 0) {
+		dot = strings.LastIndex(key[:colon], ".");
+	}
+	if (dot < 0) {
+		fmt.Fprintln(os.Stderr, "[RegisterNative] ERROR: "+key+" is not class.method:descriptor");
+		return;
+	}
+	cname := key[:dot];
+	mname := key[dot+1:colon];
+	n := &native{key: key, fn: fn};
+	ms, err := parseMethodDescriptor(key[colon+1:]);
+	if (err == nil) {
+		n.mid = methodSymbol(mname, key[colon+1:]);
+		n.slots = ms.paramSlots();
+	}
+	if (mname == "<init>") {
+		nativeClasses[cname] = true;
+	}
+	nativeOwners[cname] = true;
+	id, ok := nativeKeys[key];
+	if (ok) {
+		natives[int(id)-NATIVE_BASE] = n;
+		return;
+	}
+	if (NATIVE_BASE+len(natives) > 65407) {
+		fmt.Fprintln(os.Stderr, "[RegisterNative] ERROR: there is no Ident left for "+key);
+		return;
+	}
+	nativeKeys[key] = uint16(NATIVE_BASE+len(natives));
+	natives = append(natives, n);
+}
+
+//the native with this Ident, or nil
+func nativeAt(key uint16) *native {
+	n := int(key) - NATIVE_BASE;
+	if (n < 0 || n >= len(natives)) {
+		return nil;
+	}
+	return natives[n];
+}
+
+//run one of the natives.  Returns false if key isn't one of them
+func (p *Processor) callNative(f *Frame, key uint16) bool {
+	n := nativeAt(key);
+	if (n == nil) {
+		return false;
+	}
+	n.fn(p, f);
+	return true;
+}
+
+//a user class can override a method of a built-in class, like toString.  If the object that INVOKEVIRTUAL
+//is calling the native on has its own version, call that instead.  Returns false if it doesn't
+func (p *Processor) callOverride(f *Frame, key uint16) bool {
+	n := nativeAt(key);
+	if (n == nil || n.slots >= len(f.stack)) {
+		return false;
+	}
+	obj := Ref(f.peek(n.slots));
+	if (obj == Ref(NIL) || getType(obj) != Ident(OBJT) || isBuiltinClass(objectClassName(obj))) {
+		return false;
+	}
+	c := p.classNamed(f, objectClassName(obj));
+	if (c == nil) {
+		return false;
+	}
+	mc, m := c.findMethod(n.mid);
+	if (m == Ref(NIL)) {
+		return false;
+	}
+	p.call(f, mc, m);
+	return true;
+}
+
+//pop the object of an instance method.  This throws NullPointerException if it is null
+func (p *Processor) popThis(f *Frame) (Ref, bool) {
+	r := f.popRef();
+	if (r == Ref(NIL)) {
+		p.throw(f, "java/lang/NullPointerException", "");
+		return r, false;
+	}
+	return r, true;
+}
+
+//pop a String or other CharSequence, like a StringBuilder.  This throws NullPointerException if it is null
+func (p *Processor) popChars(f *Frame) ([]uint16, bool) {
+	r, ok := p.popThis(f);
+	if (!ok) {
+		return nil, false;
+	}
+	if (getType(r) == Ident(STRG)) {
+		return readString(r), true;
+	}
+	return toCharArray(p.defaultString(r)), true;
+}
+
+//pop a value of the type in the descriptor, and turn it into a string the same way as String.valueOf
+func (p *Processor) popText(f *Frame, desc string) string {
+	switch (desc) {
+		case "I":
+			return strconv.Itoa(int(Num48ToInt(f.pop())));
+		case "J":
+			return strconv.FormatInt(f.popLong(), 10);
+		case "F":
+			return floatToString(Num48ToFloat(f.pop()));
+		case "D":
+			return doubleToString(f.popDouble());
+		case "Z":
+			if (Num48ToInt(f.pop()) != 0) {
+				return "true";
+			}
+			return "false";
+		case "C":
+			return fromCharArray([]uint16{uint16(Num48ToInt(f.pop()))});
+		case "[C":
+			aref := f.popRef();
+			if (aref == Ref(NIL)) {
+				return "null";
+			}
+			ca := make([]uint16, arrayLength(aref));
+			for i := range ca {
+				ca[i] = uint16(Num48ToInt(loadElement(aref, i)));
+			}
+			return fromCharArray(ca);
+	}
+	return p.stringOf(f, f.popRef());
+}
+
+//the string for an object, like String.valueOf.  This calls the toString of a user class if it has one
+func (p *Processor) stringOf(f *Frame, r Ref) string {
+	if (r != Ref(NIL) && getType(r) == Ident(OBJT) && !isBuiltinClass(objectClassName(r))) {
+		c, _ := p.loadClass(objectClassName(r));
+		if (c != nil) {
+			mc, m := c.findMethod(methodSymbol("toString", "()Ljava/lang/String;"));
+			if (m != Ref(NIL)) {
+				res := p.invoke(mc, m, []Num48{Num48(r)});
+				if (len(res) == 0) {
+					//it threw an exception
+					return "";
+				}
+				return refToString(Ref(res[0]));
+			}
+		}
+	}
+	return p.defaultString(r);
+}
+
+//the toString of the built-in classes.  An exception is its class name and message, and other objects
+//are the class name and a hash code, like Dog@1b6d
+func (p *Processor) defaultString(r Ref) string {
+	if (r == Ref(NIL)) {
+		return "null";
+	}
+	switch (getType(r)) {
+		case Ident(STRG):
+			return refToString(r);
+		case Ident(OBJT):
+			if (objectClassName(r) == "java/lang/StringBuilder") {
+				return refToString(get(r, Ident(VALU)));
+			}
+			if (p.instanceOf(r, "java/lang/Throwable")) {
+				s := javaName(objectClassName(r));
+				msg := get(r, Ident(MESG));
+				if (msg != Ref(NIL)) {
+					s = s + ": "+refToString(msg);
+				}
+				return s;
+			}
+	}
+	return javaName(objectClassName(r))+"@"+strconv.FormatInt(int64(r), 16);
+}
+
+func (f *Frame) pushString(s string) {
+	f.pushRef(newString(toCharArray(s)));
+}
+
+func (f *Frame) pushBool(b bool) {
+	if (b) {
+		f.push(IntToNum48(1));
+	} else {
+		f.push(IntToNum48(0));
+	}
+}
+
+//where a PrintStream writes to
+func (p *Processor) writerFor(stream Ref) io.Writer {
+	if (stream == p.err) {
+		return os.Stderr;
+	}
+	return os.Stdout;
+}
+
+//read the next word from System.in, skipping white space.  Returns false at the end of the input
+func (p *Processor) readToken() (string, bool) {
+	var sb strings.Builder;
+	for {
+		r, _, err := p.stdin.ReadRune();
+		if (err != nil) {
+			break;
+		}
+		if (unicode.IsSpace(r)) {
+			if (sb.Len() > 0) {
+				p.stdin.UnreadRune();
+				break;
+			}
+			continue;
+		}
+		sb.WriteRune(r);
+	}
+	return sb.String(), sb.Len() > 0;
+}
+
+//read the rest of the line from System.in, without the line separator
+func (p *Processor) readLine() (string, bool) {
+	line, err := p.stdin.ReadString('\n');
+	if (err != nil && line == "") {
+		return "", false;
+	}
+	return strings.TrimRight(line, "\r\n"), true;
+}
+
+//where a string is in another one, starting from index from, or -1
+func indexOfChars(s []uint16, t []uint16, from int) int {
+	if (from < 0) {
+		from = 0;
+	}
+	for i := from; i+len(t) <= len(s); i++ {
+		j := 0;
+		for j < len(t) && s[i+j] == t[j] {
+			j++;
+		}
+		if (j == len(t)) {
+			return i;
+		}
+	}
+	return -1;
+}
+
+func equalChars(a []uint16, b []uint16) bool {
+	if (len(a) != len(b)) {
+		return false;
+	}
+	for i := range a {
+		if (a[i] != b[i]) {
+			return false;
+		}
+	}
+	return true;
+}
+
+//a Math method that takes a double and returns one
+func mathDouble(fn func(float64) float64) NativeMethod {
+	return func(p *Processor, f *Frame) {
+		f.pushDouble(fn(f.popDouble()));
+	};
+}
+
+//Integer.parseInt, Long.parseLong and Double.parseDouble throw NumberFormatException for a bad string
+func (p *Processor) numberFormat(f *Frame, r Ref) {
+	if (r == Ref(NIL)) {
+		p.throw(f, "java/lang/NumberFormatException", "Cannot parse null string: null");
+		return;
+	}
+	p.throw(f, "java/lang/NumberFormatException", "For input string: \""+refToString(r)+"\"");
+}
+
+//parseInt and parseLong.  A radix of 0 means it is an arg
+func parseIntNative(radix int, bits int) NativeMethod {
+	return func(p *Processor, f *Frame) {
+		base := radix;
+		if (base == 0) {
+			//the radix is an arg
+			base = int(Num48ToInt(f.pop()));
+		}
+		r := f.popRef();
+		n, err := strconv.ParseInt(refToString(r), base, bits);
+		if (r == Ref(NIL) || err != nil) {
+			p.numberFormat(f, r);
+		} else if (bits == 64) {
+			f.pushLong(n);
+		} else {
+			f.push(IntToNum48(int(n)));
+		}
+	};
+}
+
+//String.charAt and substring throw StringIndexOutOfBoundsException
+func (p *Processor) checkRange(f *Frame, begin int, end int, slen int) bool {
+	if (begin < 0 || begin > end || end > slen) {
+		p.throw(f, "java/lang/StringIndexOutOfBoundsException",
+			"begin "+strconv.Itoa(begin)+", end "+strconv.Itoa(end)+", length "+strconv.Itoa(slen));
+		return false;
+	}
+	return true;
+}
+
+//the types that print, println, append and valueOf are overloaded for
+var printTypes = []string{"Ljava/lang/String;", "Ljava/lang/Object;", "I", "J", "F", "D", "Z", "C", "[C"};
+
+//java/lang/Object and java/lang/Throwable.  The methods of Object are also used by the other built-in classes
+func init() {
+	RegisterNative("java/lang/Object.<init>:()V", func(p *Processor, f *Frame) {
+		f.pop();
+	});
+	RegisterNative("java/lang/Object.toString:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		if r, ok := p.popThis(f); ok {
+			f.pushString(p.defaultString(r));
+		}
+	});
+	RegisterNative("java/lang/Object.hashCode:()I", func(p *Processor, f *Frame) {
+		if r, ok := p.popThis(f); ok {
+			f.push(IntToNum48(int(r)));
+		}
+	});
+	RegisterNative("java/lang/Object.equals:(Ljava/lang/Object;)Z", func(p *Processor, f *Frame) {
+		other := f.popRef();
+		if r, ok := p.popThis(f); ok {
+			f.pushBool(r == other);
+		}
+	});
+
+	RegisterNative("java/lang/Throwable.<init>:()V", func(p *Processor, f *Frame) {
+		f.pop();
+	});
+	RegisterNative("java/lang/Throwable.<init>:(Ljava/lang/String;)V", func(p *Processor, f *Frame) {
+		msg := f.popRef();
+		put(f.popRef(), Ident(MESG), msg);
+	});
+	RegisterNative("java/lang/Throwable.getMessage:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		if exc, ok := p.popThis(f); ok {
+			f.pushRef(get(exc, Ident(MESG)));
+		}
+	});
+	//the VM doesn't keep the stack trace in the exception, so this only prints the first line
+	RegisterNative("java/lang/Throwable.printStackTrace:()V", func(p *Processor, f *Frame) {
+		if exc, ok := p.popThis(f); ok {
+			fmt.Fprintln(os.Stderr, p.defaultString(exc));
+		}
+	});
+}
+
+//java/lang/System and java/io/PrintStream
+func init() {
+	RegisterNative("java/lang/System.out:Ljava/io/PrintStream;", func(p *Processor, f *Frame) {
+		f.pushRef(p.out);
+	});
+	RegisterNative("java/lang/System.err:Ljava/io/PrintStream;", func(p *Processor, f *Frame) {
+		f.pushRef(p.err);
+	});
+	RegisterNative("java/lang/System.in:Ljava/io/InputStream;", func(p *Processor, f *Frame) {
+		f.pushRef(p.in);
+	});
+	RegisterNative("java/lang/System.currentTimeMillis:()J", func(p *Processor, f *Frame) {
+		f.pushLong(time.Now().UnixMilli());
+	});
+	RegisterNative("java/lang/System.nanoTime:()J", func(p *Processor, f *Frame) {
+		f.pushLong(time.Now().UnixNano());
+	});
+	RegisterNative("java/lang/System.exit:(I)V", func(p *Processor, f *Frame) {
+		os.Exit(int(Num48ToInt(f.pop())));
+	});
+	RegisterNative("java/lang/System.arraycopy:(Ljava/lang/Object;ILjava/lang/Object;II)V", func(p *Processor, f *Frame) {
+		alen := int(Num48ToInt(f.pop()));
+		destpos := int(Num48ToInt(f.pop()));
+		dest := f.popRef();
+		srcpos := int(Num48ToInt(f.pop()));
+		src := f.popRef();
+		if (src == Ref(NIL) || dest == Ref(NIL)) {
+			p.throw(f, "java/lang/NullPointerException", "");
+			return;
+		}
+		if (getType(src) != Ident(ARRY) || getType(dest) != Ident(ARRY) || arrayElementType(src) != arrayElementType(dest)) {
+			p.throw(f, "java/lang/ArrayStoreException", "arraycopy: type mismatch");
+			return;
+		}
+		if (alen < 0 || srcpos < 0 || destpos < 0 || srcpos+alen > arrayLength(src) || destpos+alen > arrayLength(dest)) {
+			p.throw(f, "java/lang/ArrayIndexOutOfBoundsException", "arraycopy: last source index "+
+				strconv.Itoa(srcpos+alen)+" out of bounds for length "+strconv.Itoa(arrayLength(src)));
+			return;
+		}
+		//copy all the chars of the elements, going backwards if they overlap the wrong way
+		width := elementWidth(arrayElementType(src));
+		from := elementAddr(src, srcpos);
+		to := elementAddr(dest, destpos);
+		if (from < to) {
+			for i := alen*width-1; i >= 0; i-- {
+				memory[to+i] = memory[from+i];
+			}
+		} else {
+			copy(memory[to:to+alen*width], memory[from:from+alen*width]);
+		}
+	});
+
+	for _, t := range printTypes {
+		desc := t;
+		RegisterNative("java/io/PrintStream.print:("+desc+")V", func(p *Processor, f *Frame) {
+			s := p.popText(f, desc);
+			if stream, ok := p.popThis(f); ok {
+				fmt.Fprint(p.writerFor(stream), s);
+			}
+		});
+		RegisterNative("java/io/PrintStream.println:("+desc+")V", func(p *Processor, f *Frame) {
+			s := p.popText(f, desc);
+			if stream, ok := p.popThis(f); ok {
+				fmt.Fprintln(p.writerFor(stream), s);
+			}
+		});
+	}
+	RegisterNative("java/io/PrintStream.println:()V", func(p *Processor, f *Frame) {
+		if stream, ok := p.popThis(f); ok {
+			fmt.Fprintln(p.writerFor(stream));
+		}
+	});
+	//the output isn't buffered
+	RegisterNative("java/io/PrintStream.flush:()V", func(p *Processor, f *Frame) {
+		f.pop();
+	});
+}
+
+//reading System.in, with InputStream.read or a java/util/Scanner
+func init() {
+	RegisterNative("java/io/InputStream.read:()I", func(p *Processor, f *Frame) {
+		if _, ok := p.popThis(f); !ok {
+			return;
+		}
+		b, err := p.stdin.ReadByte();
+		if (err != nil) {
+			f.push(IntToNum48(-1));
+		} else {
+			f.push(IntToNum48(int(b)));
+		}
+	});
+
+	//a Scanner always reads System.in, so it doesn't keep anything
+	RegisterNative("java/util/Scanner.<init>:(Ljava/io/InputStream;)V", func(p *Processor, f *Frame) {
+		f.pop();
+		f.pop();
+	});
+	RegisterNative("java/util/Scanner.close:()V", func(p *Processor, f *Frame) {
+		f.pop();
+	});
+	RegisterNative("java/util/Scanner.hasNext:()Z", func(p *Processor, f *Frame) {
+		f.pop();
+		for {
+			r, _, err := p.stdin.ReadRune();
+			if (err != nil) {
+				f.pushBool(false);
+				return;
+			}
+			if (!unicode.IsSpace(r)) {
+				p.stdin.UnreadRune();
+				f.pushBool(true);
+				return;
+			}
+		}
+	});
+	RegisterNative("java/util/Scanner.hasNextLine:()Z", func(p *Processor, f *Frame) {
+		f.pop();
+		_, err := p.stdin.Peek(1);
+		f.pushBool(err == nil);
+	});
+	RegisterNative("java/util/Scanner.nextLine:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pop();
+		line, ok := p.readLine();
+		if (!ok) {
+			p.throw(f, "java/util/NoSuchElementException", "No line found");
+			return;
+		}
+		f.pushString(line);
+	});
+	RegisterNative("java/util/Scanner.next:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pop();
+		s, ok := p.readToken();
+		if (!ok) {
+			p.throw(f, "java/util/NoSuchElementException", "");
+			return;
+		}
+		f.pushString(s);
+	});
+	RegisterNative("java/util/Scanner.nextInt:()I", func(p *Processor, f *Frame) {
+		f.pop();
+		s, ok := p.readToken();
+		if (!ok) {
+			p.throw(f, "java/util/NoSuchElementException", "");
+			return;
+		}
+		n, err := strconv.ParseInt(s, 10, 32);
+		if (err != nil) {
+			p.throw(f, "java/util/InputMismatchException", "For input string: \""+s+"\"");
+			return;
+		}
+		f.push(IntToNum48(int(n)));
+	});
+	RegisterNative("java/util/Scanner.nextDouble:()D", func(p *Processor, f *Frame) {
+		f.pop();
+		s, ok := p.readToken();
+		if (!ok) {
+			p.throw(f, "java/util/NoSuchElementException", "");
+			return;
+		}
+		dv, err := strconv.ParseFloat(s, 64);
+		if (err != nil) {
+			p.throw(f, "java/util/InputMismatchException", "For input string: \""+s+"\"");
+			return;
+		}
+		f.pushDouble(dv);
+	});
+}
+
+//java/lang/String.  A String is the STRG array of its chars
+func init() {
+	RegisterNative("java/lang/String.length:()I", func(p *Processor, f *Frame) {
+		if s, ok := p.popThis(f); ok {
+			f.push(IntToNum48(arrayLength(s)));
+		}
+	});
+	RegisterNative("java/lang/String.isEmpty:()Z", func(p *Processor, f *Frame) {
+		if s, ok := p.popThis(f); ok {
+			f.pushBool(arrayLength(s) == 0);
+		}
+	});
+	RegisterNative("java/lang/String.charAt:(I)C", func(p *Processor, f *Frame) {
+		i := int(Num48ToInt(f.pop()));
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		ca := readString(s);
+		if (i < 0 || i >= len(ca)) {
+			p.throw(f, "java/lang/StringIndexOutOfBoundsException",
+				"Index "+strconv.Itoa(i)+" out of bounds for length "+strconv.Itoa(len(ca)));
+			return;
+		}
+		f.push(IntToNum48(int(ca[i])));
+	});
+	RegisterNative("java/lang/String.substring:(I)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		begin := int(Num48ToInt(f.pop()));
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		ca := readString(s);
+		if (p.checkRange(f, begin, len(ca), len(ca))) {
+			f.pushRef(newString(ca[begin:]));
+		}
+	});
+	RegisterNative("java/lang/String.substring:(II)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		end := int(Num48ToInt(f.pop()));
+		begin := int(Num48ToInt(f.pop()));
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		ca := readString(s);
+		if (p.checkRange(f, begin, end, len(ca))) {
+			f.pushRef(newString(ca[begin:end]));
+		}
+	});
+	RegisterNative("java/lang/String.equals:(Ljava/lang/Object;)Z", func(p *Processor, f *Frame) {
+		other := f.popRef();
+		s, ok := p.popThis(f);
+		if (ok) {
+			f.pushBool(other != Ref(NIL) && getType(other) == Ident(STRG) && equalChars(readString(s), readString(other)));
+		}
+	});
+	RegisterNative("java/lang/String.equalsIgnoreCase:(Ljava/lang/String;)Z", func(p *Processor, f *Frame) {
+		other := f.popRef();
+		s, ok := p.popThis(f);
+		if (ok) {
+			f.pushBool(other != Ref(NIL) && strings.EqualFold(refToString(s), refToString(other)));
+		}
+	});
+	RegisterNative("java/lang/String.compareTo:(Ljava/lang/String;)I", func(p *Processor, f *Frame) {
+		t, ok := p.popChars(f);
+		if (!ok) {
+			return;
+		}
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		ca := readString(s);
+		for i := 0; i < len(ca) && i < len(t); i++ {
+			if (ca[i] != t[i]) {
+				f.push(IntToNum48(int(ca[i]) - int(t[i])));
+				return;
+			}
+		}
+		f.push(IntToNum48(len(ca) - len(t)));
+	});
+	RegisterNative("java/lang/String.hashCode:()I", func(p *Processor, f *Frame) {
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		h := int32(0);
+		for _, c := range readString(s) {
+			h = 31*h + int32(c);
+		}
+		f.push(IntToNum48(int(h)));
+	});
+	RegisterNative("java/lang/String.indexOf:(I)I", func(p *Processor, f *Frame) {
+		c := uint16(Num48ToInt(f.pop()));
+		if s, ok := p.popThis(f); ok {
+			f.push(IntToNum48(indexOfChars(readString(s), []uint16{c}, 0)));
+		}
+	});
+	RegisterNative("java/lang/String.indexOf:(Ljava/lang/String;)I", func(p *Processor, f *Frame) {
+		t, ok := p.popChars(f);
+		if (!ok) {
+			return;
+		}
+		if s, ok := p.popThis(f); ok {
+			f.push(IntToNum48(indexOfChars(readString(s), t, 0)));
+		}
+	});
+	RegisterNative("java/lang/String.contains:(Ljava/lang/CharSequence;)Z", func(p *Processor, f *Frame) {
+		t, ok := p.popChars(f);
+		if (!ok) {
+			return;
+		}
+		if s, ok := p.popThis(f); ok {
+			f.pushBool(indexOfChars(readString(s), t, 0) >= 0);
+		}
+	});
+	RegisterNative("java/lang/String.startsWith:(Ljava/lang/String;)Z", func(p *Processor, f *Frame) {
+		t, ok := p.popChars(f);
+		if (!ok) {
+			return;
+		}
+		if s, ok := p.popThis(f); ok {
+			ca := readString(s);
+			f.pushBool(len(t) <= len(ca) && equalChars(ca[:len(t)], t));
+		}
+	});
+	RegisterNative("java/lang/String.endsWith:(Ljava/lang/String;)Z", func(p *Processor, f *Frame) {
+		t, ok := p.popChars(f);
+		if (!ok) {
+			return;
+		}
+		if s, ok := p.popThis(f); ok {
+			ca := readString(s);
+			f.pushBool(len(t) <= len(ca) && equalChars(ca[len(ca)-len(t):], t));
+		}
+	});
+	RegisterNative("java/lang/String.concat:(Ljava/lang/String;)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		t, ok := p.popChars(f);
+		if (!ok) {
+			return;
+		}
+		if s, ok := p.popThis(f); ok {
+			f.pushRef(newString(append(readString(s), t...)));
+		}
+	});
+	RegisterNative("java/lang/String.toUpperCase:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		if s, ok := p.popThis(f); ok {
+			f.pushString(strings.ToUpper(refToString(s)));
+		}
+	});
+	RegisterNative("java/lang/String.toLowerCase:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		if s, ok := p.popThis(f); ok {
+			f.pushString(strings.ToLower(refToString(s)));
+		}
+	});
+	//Java trims all the control chars, as well as spaces
+	RegisterNative("java/lang/String.trim:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		ca := readString(s);
+		begin, end := 0, len(ca);
+		for begin < end && ca[begin] <= ' ' {
+			begin++;
+		}
+		for end > begin && ca[end-1] <= ' ' {
+			end--;
+		}
+		f.pushRef(newString(ca[begin:end]));
+	});
+	RegisterNative("java/lang/String.toString:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		if s, ok := p.popThis(f); ok {
+			f.pushRef(s);
+		}
+	});
+	RegisterNative("java/lang/String.toCharArray:()[C", func(p *Processor, f *Frame) {
+		s, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		ca := readString(s);
+		aref := p.newArray(f, Ident(CHAR), len(ca));
+		if (aref == Ref(NIL)) {
+			return;
+		}
+		for i, c := range ca {
+			storeElement(aref, i, IntToNum48(int(c)));
+		}
+		f.pushRef(aref);
+	});
+	for _, t := range printTypes {
+		desc := t;
+		RegisterNative("java/lang/String.valueOf:("+desc+")Ljava/lang/String;", func(p *Processor, f *Frame) {
+			f.pushString(p.popText(f, desc));
+		});
+	}
+}
+
+//java/lang/StringBuilder.  This is an object with the string built so far in VALU.  Strings can't be
+//changed, so append makes a new string each time
+func init() {
+	RegisterNative("java/lang/StringBuilder.<init>:()V", func(p *Processor, f *Frame) {
+		put(f.popRef(), Ident(VALU), newString(nil));
+	});
+	RegisterNative("java/lang/StringBuilder.<init>:(Ljava/lang/String;)V", func(p *Processor, f *Frame) {
+		ca, ok := p.popChars(f);
+		if (ok) {
+			put(f.popRef(), Ident(VALU), newString(ca));
+		}
+	});
+	for _, t := range append(printTypes, "Ljava/lang/CharSequence;") {
+		desc := t;
+		RegisterNative("java/lang/StringBuilder.append:("+desc+")Ljava/lang/StringBuilder;", func(p *Processor, f *Frame) {
+			s := p.popText(f, desc);
+			sb, ok := p.popThis(f);
+			if (!ok) {
+				return;
+			}
+			cur := readString(get(sb, Ident(VALU)));
+			put(sb, Ident(VALU), newString(append(cur, toCharArray(s)...)));
+			f.pushRef(sb);
+		});
+	}
+	RegisterNative("java/lang/StringBuilder.toString:()Ljava/lang/String;", func(p *Processor, f *Frame) {
+		if sb, ok := p.popThis(f); ok {
+			f.pushRef(get(sb, Ident(VALU)));
+		}
+	});
+	RegisterNative("java/lang/StringBuilder.length:()I", func(p *Processor, f *Frame) {
+		if sb, ok := p.popThis(f); ok {
+			f.push(IntToNum48(arrayLength(get(sb, Ident(VALU)))));
+		}
+	});
+	RegisterNative("java/lang/StringBuilder.reverse:()Ljava/lang/StringBuilder;", func(p *Processor, f *Frame) {
+		sb, ok := p.popThis(f);
+		if (!ok) {
+			return;
+		}
+		//reverse the characters, so a pair of surrogates stays in order
+		rs := []rune(refToString(get(sb, Ident(VALU))));
+		for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+			rs[i], rs[j] = rs[j], rs[i];
+		}
+		put(sb, Ident(VALU), newString(toCharArray(string(rs))));
+		f.pushRef(sb);
+	});
+}
+
+//java/lang/Math, java/lang/Integer, java/lang/Long and java/lang/Double
+func init() {
+	RegisterNative("java/lang/Math.abs:(I)I", func(p *Processor, f *Frame) {
+		v := Num48ToInt(f.pop());
+		if (v < 0) {
+			v = -v;
+		}
+		f.push(IntToNum48(int(v)));
+	});
+	RegisterNative("java/lang/Math.abs:(J)J", func(p *Processor, f *Frame) {
+		v := f.popLong();
+		if (v < 0) {
+			v = -v;
+		}
+		f.pushLong(v);
+	});
+	RegisterNative("java/lang/Math.abs:(F)F", func(p *Processor, f *Frame) {
+		f.push(FloatToNum48(float32(math.Abs(float64(Num48ToFloat(f.pop()))))));
+	});
+	RegisterNative("java/lang/Math.abs:(D)D", mathDouble(math.Abs));
+	RegisterNative("java/lang/Math.max:(II)I", func(p *Processor, f *Frame) {
+		b := Num48ToInt(f.pop());
+		a := Num48ToInt(f.pop());
+		if (b > a) {
+			a = b;
+		}
+		f.push(IntToNum48(int(a)));
+	});
+	RegisterNative("java/lang/Math.min:(II)I", func(p *Processor, f *Frame) {
+		b := Num48ToInt(f.pop());
+		a := Num48ToInt(f.pop());
+		if (b < a) {
+			a = b;
+		}
+		f.push(IntToNum48(int(a)));
+	});
+	RegisterNative("java/lang/Math.max:(JJ)J", func(p *Processor, f *Frame) {
+		b := f.popLong();
+		a := f.popLong();
+		if (b > a) {
+			a = b;
+		}
+		f.pushLong(a);
+	});
+	RegisterNative("java/lang/Math.min:(JJ)J", func(p *Processor, f *Frame) {
+		b := f.popLong();
+		a := f.popLong();
+		if (b < a) {
+			a = b;
+		}
+		f.pushLong(a);
+	});
+	RegisterNative("java/lang/Math.max:(DD)D", func(p *Processor, f *Frame) {
+		b := f.popDouble();
+		f.pushDouble(math.Max(f.popDouble(), b));
+	});
+	RegisterNative("java/lang/Math.min:(DD)D", func(p *Processor, f *Frame) {
+		b := f.popDouble();
+		f.pushDouble(math.Min(f.popDouble(), b));
+	});
+	RegisterNative("java/lang/Math.pow:(DD)D", func(p *Processor, f *Frame) {
+		b := f.popDouble();
+		f.pushDouble(math.Pow(f.popDouble(), b));
+	});
+	RegisterNative("java/lang/Math.sqrt:(D)D", mathDouble(math.Sqrt));
+	RegisterNative("java/lang/Math.cbrt:(D)D", mathDouble(math.Cbrt));
+	RegisterNative("java/lang/Math.floor:(D)D", mathDouble(math.Floor));
+	RegisterNative("java/lang/Math.ceil:(D)D", mathDouble(math.Ceil));
+	RegisterNative("java/lang/Math.sin:(D)D", mathDouble(math.Sin));
+	RegisterNative("java/lang/Math.cos:(D)D", mathDouble(math.Cos));
+	RegisterNative("java/lang/Math.tan:(D)D", mathDouble(math.Tan));
+	RegisterNative("java/lang/Math.exp:(D)D", mathDouble(math.Exp));
+	RegisterNative("java/lang/Math.log:(D)D", mathDouble(math.Log));
+	RegisterNative("java/lang/Math.log10:(D)D", mathDouble(math.Log10));
+	//Java rounds a half up, so -2.5 is -2
+	RegisterNative("java/lang/Math.round:(D)J", func(p *Processor, f *Frame) {
+		f.pushLong(doubleToLong(math.Floor(f.popDouble() + 0.5)));
+	});
+	RegisterNative("java/lang/Math.random:()D", func(p *Processor, f *Frame) {
+		f.pushDouble(rand.Float64());
+	});
+
+	RegisterNative("java/lang/Integer.parseInt:(Ljava/lang/String;)I", parseIntNative(10, 32));
+	RegisterNative("java/lang/Integer.parseInt:(Ljava/lang/String;I)I", parseIntNative(0, 32));
+	RegisterNative("java/lang/Integer.toString:(I)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pushString(strconv.Itoa(int(Num48ToInt(f.pop()))));
+	});
+	RegisterNative("java/lang/Integer.toHexString:(I)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pushString(strconv.FormatUint(uint64(uint32(Num48ToInt(f.pop()))), 16));
+	});
+	RegisterNative("java/lang/Integer.toBinaryString:(I)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pushString(strconv.FormatUint(uint64(uint32(Num48ToInt(f.pop()))), 2));
+	});
+	RegisterNative("java/lang/Long.parseLong:(Ljava/lang/String;)J", parseIntNative(10, 64));
+	RegisterNative("java/lang/Long.toString:(J)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pushString(strconv.FormatInt(f.popLong(), 10));
+	});
+	RegisterNative("java/lang/Double.parseDouble:(Ljava/lang/String;)D", func(p *Processor, f *Frame) {
+		r := f.popRef();
+		dv, err := strconv.ParseFloat(strings.TrimSpace(refToString(r)), 64);
+		if (r == Ref(NIL) || err != nil) {
+			p.numberFormat(f, r);
+			return;
+		}
+		f.pushDouble(dv);
+	});
+	RegisterNative("java/lang/Double.toString:(D)Ljava/lang/String;", func(p *Processor, f *Frame) {
+		f.pushString(doubleToString(f.popDouble()));
+	});
+}
+
+//===================================================
+//main
+
+const LAVA_VERSION=6;
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "-verbose" {
+		debug = true
+		args = args[1:]
+	}
+	if debug {
+		fmt.Fprintln(os.Stderr, "Lava version: "+strconv.Itoa(LAVA_VERSION));
+	}
+	//load classfile, and keep the parameters after the class name
+	cf, cp, cfname, args2, err := loadFromArgs(args);
+	if cp != nil {
+		defer cp.Close();
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERR: "+cfname+": "+err.Error());
+		os.Exit(1);
+	}
+
+	//print format number
+	if debug {
+		fmt.Fprintln(os.Stderr, "Classfile major version = "+strconv.Itoa(int(cf.major_version)));
+	}
+
+	//check the code before running it
+	err = verifyClass(cf, cp);
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERR: "+cfname+": "+err.Error());
+		os.Exit(1);
+	}
+	
+	//create memory
+	initialize_memory(MEMSIZE);
+	
+	//compile the program.  The other classes are compiled when they are first used
+	p := NewProcessor(cp);
+	c, err := p.defineClass(cf);
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERR: "+cfname+": "+err.Error());
+		os.Exit(1);
+	}
+
+	//run the program.  Like java, this exits with 1 if it failed
+	if (p.start(c, args2) != 0) {
+		cp.Close();
+		os.Exit(1);
+	}
 }
@@ -0,0 +1,437 @@
+package main
+
+//every .go file here is its own program, so run these with
+//	go test lava6.go lava6_test.go
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//when memory is full, the numbers and objects are NIL, and an exception that the VM throws
+//becomes the OutOfMemoryError that was made at the start
+func TestOutOfMemory(t *testing.T) {
+	initialize_memory(MEMSIZE);
+	ptr = 1;
+	p := NewProcessor(nil);
+	ptr = len(memory) - 4;
+	if (newInt(IntToNum48(1)) != Ref(NIL) || newLong(1) != Ref(NIL) || newDouble(1) != Ref(NIL)) {
+		t.Error("a number was stored past the end of memory");
+	}
+	if (newBuiltinObject("java/lang/StringBuilder") != Ref(NIL)) {
+		t.Error("an object was stored past the end of memory");
+	}
+	p.throw(nil, "java/lang/ArithmeticException", "/ by zero");
+	if (p.thrown != p.oom || objectClassName(p.thrown) != "java/lang/OutOfMemoryError") {
+		t.Errorf("threw %v, want the OutOfMemoryError", p.thrown);
+	}
+}
+
+//compile a class from testdata in a new VM
+func loadTestClass(t *testing.T, name string) (*Processor, *Class) {
+	initialize_memory(MEMSIZE);
+	ptr = 1;
+	cp, err := NewClassPath("testdata");
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	t.Cleanup(cp.Close);
+	cf, err := ParseFile(filepath.Join("testdata", name+".class"));
+	if (err == nil) {
+		err = verifyClass(cf, cp);
+	}
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	p := NewProcessor(cp);
+	c, err := p.defineClass(cf);
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	return p, c;
+}
+
+//call a static method that returns an int
+func callInt(t *testing.T, p *Processor, c *Class, name string, desc string, args ...int32) int32 {
+	mc, m := c.findMethod(methodSymbol(name, desc));
+	if (m == Ref(NIL)) {
+		t.Fatalf("%s%s not found", name, desc);
+	}
+	slots := make([]Num48, len(args));
+	for i, a := range args {
+		slots[i] = IntToNum48(int(a));
+	}
+	res := p.invoke(mc, m, slots);
+	if (p.halted || p.thrown != Ref(NIL) || len(res) != 1) {
+		t.Fatalf("%s%s%v didn't return an int", name, desc, args);
+	}
+	return Num48ToInt(res[0]);
+}
+
+//methods are keyed by name and descriptor, so names with the same first 4 letters and overloads
+//don't get mixed up, and neither does a field with the same name as a method
+func TestMemberKeys(t *testing.T) {
+	p, c := loadTestClass(t, "Coll");
+	if got := callInt(t, p, c, "count", "()I"); got != 1 {
+		t.Errorf("count() = %d, want 1", got);
+	}
+	if got := callInt(t, p, c, "countAll", "()I"); got != 2 {
+		t.Errorf("countAll() = %d, want 2", got);
+	}
+	if got := callInt(t, p, c, "val", "()I"); got != 0 {
+		t.Errorf("val() = %d, want 0", got);
+	}
+	if got := callInt(t, p, c, "f", "(I)I", 5); got != 6 {
+		t.Errorf("f(5) = %d, want 6", got);
+	}
+	if got := callInt(t, p, c, "f", "(II)I", 5, 7); got != 35 {
+		t.Errorf("f(5, 7) = %d, want 35", got);
+	}
+}
+
+//FDup has a static x:I and a static x:J.  CDef implements IDef, which extends IBase, and only
+//overrides the abstract method, so v and b are the default methods from the interfaces
+func TestFieldsAndDefaults(t *testing.T) {
+	p, c := loadTestClass(t, "FDup");
+	if got := callInt(t, p, c, "m", "()I"); got != 12 {
+		t.Errorf("FDup.m() = %d, want 12", got);
+	}
+	p, c = loadTestClass(t, "CDef");
+	tests := []struct {
+		name string;
+		want int32;
+	}{
+		{"callV", 42},
+		{"callIV", 42},
+		{"callB", 7},
+		{"callW", 1},
+	};
+	for _, tt := range tests {
+		if got := callInt(t, p, c, tt.name, "()I"); got != tt.want {
+			t.Errorf("%s() = %d, want %d", tt.name, got, tt.want);
+		}
+	}
+}
+
+//natives are found by class.method:descriptor.  A built-in class also gets the natives of its super
+//classes, and a user class doesn't get any
+func TestNativeKeys(t *testing.T) {
+	getMessage := nativeKeys["java/lang/Throwable.getMessage:()Ljava/lang/String;"];
+	tests := []struct {
+		cname, mname, msig string;
+		want uint16;
+	}{
+		{"java/lang/Throwable", "getMessage", "()Ljava/lang/String;", getMessage},
+		{"java/lang/ArithmeticException", "getMessage", "()Ljava/lang/String;", getMessage},
+		{"java/lang/Throwable", "getMessage", "()I", NONE},
+		{"java/io/PrintStream", "println", "(I)V", nativeKeys["java/io/PrintStream.println:(I)V"]},
+		{"java/io/PrintStream", "println", "(J)V", nativeKeys["java/io/PrintStream.println:(J)V"]},
+		{"java/lang/String", "hashCode", "()I", nativeKeys["java/lang/String.hashCode:()I"]},
+		{"java/lang/System", "gc", "()V", NONE},
+		{"MyErr", "getMessage", "()Ljava/lang/String;", NONE},
+	};
+	for _, tt := range tests {
+		if got := nativeMember(tt.cname, tt.mname, tt.msig); got != tt.want || (got != NONE && nativeAt(got) == nil) {
+			t.Errorf("nativeMember(%s, %s, %s) = %d, want %d", tt.cname, tt.mname, tt.msig, got, tt.want);
+		}
+	}
+	if (getMessage == NONE || nativeKeys["java/io/PrintStream.println:(I)V"] == nativeKeys["java/io/PrintStream.println:(J)V"]) {
+		t.Error("the overloads of println need their own natives");
+	}
+}
+
+//NoNative calls System.gc, which halts until there is a native for it
+func TestMissingNative(t *testing.T) {
+	p, c := loadTestClass(t, "NoNative");
+	_, m := c.findMethod(methodSymbol("m", "()I"));
+	p.invoke(c, m, nil);
+	if (!p.halted) {
+		t.Error("calling a method without a native should halt");
+	}
+	called := false;
+	RegisterNative("java/lang/System.gc:()V", func(p *Processor, f *Frame) {
+		called = true;
+	});
+	p, c = loadTestClass(t, "NoNative");
+	if got := callInt(t, p, c, "m", "()I"); got != 1 || !called {
+		t.Errorf("m() = %d and the native was called %v", got, called);
+	}
+}
+
+//Exc catches most of the exceptions it throws and prints their messages, and then the last one
+//isn't caught.  Like java, its stack trace goes to stderr and the exit status is 1.  main calls
+//os.Exit, so it runs in a copy of the test program
+func TestUncaughtException(t *testing.T) {
+	if (os.Getenv("LAVA6_TEST_MAIN") == "1") {
+		debug = false;
+		os.Args = []string{"lava6", "-cp", "testdata", "Exc"};
+		main();
+		os.Exit(0);
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUncaughtException$");
+	cmd.Env = append(os.Environ(), "LAVA6_TEST_MAIN=1");
+	var stdout, stderr bytes.Buffer;
+	cmd.Stdout = &stdout;
+	cmd.Stderr = &stderr;
+	err := cmd.Run();
+	var ee *exec.ExitError;
+	if (!errors.As(err, &ee) || ee.ExitCode() != 1) {
+		t.Errorf("got %v, want exit status 1", err);
+	}
+	wantOut := "/ by zero\nboom\nnpe\nFor input string: \"x\"\nstate\nfinally\nmine\n"+
+		"class MyErr cannot be cast to class java.lang.String\n";
+	if (stdout.String() != wantOut) {
+		t.Errorf("stdout is\n%s\nwant\n%s", stdout.String(), wantOut);
+	}
+	wantErr := "Exception in thread \"main\" java.lang.ArithmeticException: / by zero\n"+
+		"\tat Exc.div(Exc.java:3)\n\tat Exc.deep(Exc.java:7)\n\tat Exc.main(Exc.java:20)\n";
+	if (stderr.String() != wantErr) {
+		t.Errorf("stderr is\n%s\nwant\n%s", stderr.String(), wantErr);
+	}
+}
+
+//the int opcodes have to give the same answers as Java, including negative numbers and overflow
+func TestIntMath(t *testing.T) {
+	tests := []struct {
+		name string;
+		op uint16;
+		a, b int32;
+		want int32;
+	}{
+		{"add", IADD, 2, 3, 5},
+		{"add negative", IADD, -7, 3, -4},
+		{"add overflow", IADD, 2000000000, 2000000000, -294967296},
+		{"add max", IADD, math.MaxInt32, 1, math.MinInt32},
+		{"sub", ISUB, 3, 10, -7},
+		{"sub overflow", ISUB, math.MinInt32, 1, math.MaxInt32},
+		{"mul", IMUL, -6, 7, -42},
+		{"mul overflow", IMUL, 100000, 100000, 1410065408},
+		{"mul negative overflow", IMUL, -100000, 100000, -1410065408},
+		{"div", IDIV, 7, 2, 3},
+		{"div truncates", IDIV, -7, 2, -3},
+		{"div negative divisor", IDIV, 7, -2, -3},
+		{"div both negative", IDIV, -7, -2, 3},
+		{"div min by -1", IDIV, math.MinInt32, -1, math.MinInt32},
+		{"rem", IREM, 7, 3, 1},
+		{"rem negative dividend", IREM, -7, 3, -1},
+		{"rem negative divisor", IREM, 7, -3, 1},
+		{"rem both negative", IREM, -7, -3, -1},
+		{"rem min by -1", IREM, math.MinInt32, -1, 0},
+		{"shl", ISHL, 1, 4, 16},
+		{"shl uses 5 bits", ISHL, 1, 33, 2},
+		{"shl overflow", ISHL, 1, 31, math.MinInt32},
+		{"shr keeps the sign", ISHR, -16, 2, -4},
+		{"ushr fills with 0", IUSHR, -16, 28, 15},
+		{"ushr uses 5 bits", IUSHR, -1, 32, -1},
+		{"and", IAND, 0xF0, 0x3C, 0x30},
+		{"or", IOR, 0xF0, 0x3C, 0xFC},
+		{"xor", IXOR, 0xF0, 0x3C, 0xCC},
+		{"xor negative", IXOR, -1, 5, -6},
+	};
+	for _, tt := range tests {
+		got, ok := intMath(tt.op, tt.a, tt.b);
+		if (!ok) {
+			t.Errorf("%s: intMath(%d, %d) failed", tt.name, tt.a, tt.b);
+		} else if (got != tt.want) {
+			t.Errorf("%s: intMath(%d, %d) = %d, want %d", tt.name, tt.a, tt.b, got, tt.want);
+		}
+	}
+}
+
+//dividing by zero is an ArithmeticException, so intMath returns false
+func TestIntMathDivideByZero(t *testing.T) {
+	for _, op := range []uint16{IDIV, IREM} {
+		if _, ok := intMath(op, 5, 0); ok {
+			t.Errorf("opcode %d: dividing by zero should fail", op);
+		}
+	}
+}
+
+//every int has to survive being stored in memory, the way ldc loads it from the class table
+func TestIntRoundTrip(t *testing.T) {
+	initialize_memory(MEMSIZE);
+	ptr = 1;
+	for _, v := range []int32{0, 1, -1, 42, -42, 2047999999, 2147483647, -2147483648, -1948516353} {
+		r := newInt(IntToNum48(int(v)));
+		got := Num48ToInt(readInt(r));
+		if (got != v) {
+			t.Errorf("stored %d, read back %d", v, got);
+		}
+	}
+}
+
+//the int cases from above, run as bytecode.  max loads 2147483647 with ldc_w
+func TestIntMathClass(t *testing.T) {
+	p, c := loadTestClass(t, "IntMath");
+	tests := []struct {
+		name string;
+		args []int32;
+		want int32;
+	}{
+		{"add", []int32{2000000000, 2000000000}, -294967296},
+		{"sub", []int32{math.MinInt32, 1}, math.MaxInt32},
+		{"mul", []int32{100000, 100000}, 1410065408},
+		{"div", []int32{-7, 2}, -3},
+		{"div", []int32{math.MinInt32, -1}, math.MinInt32},
+		{"rem", []int32{-7, 3}, -1},
+		{"rem", []int32{7, -3}, 1},
+		{"neg", []int32{math.MinInt32}, math.MinInt32},
+		{"max", nil, math.MaxInt32},
+	};
+	for _, tt := range tests {
+		desc := "(II)I";
+		if (len(tt.args) == 1) {
+			desc = "(I)I";
+		} else if (len(tt.args) == 0) {
+			desc = "()I";
+		}
+		if got := callInt(t, p, c, tt.name, desc, tt.args...); got != tt.want {
+			t.Errorf("%s%v = %d, want %d", tt.name, tt.args, got, tt.want);
+		}
+	}
+}
+
+func TestIntDivideByZero(t *testing.T) {
+	p, c := loadTestClass(t, "IntMath");
+	mc, m := c.findMethod(methodSymbol("div", "(II)I"));
+	p.invoke(mc, m, []Num48{IntToNum48(1), IntToNum48(0)});
+	if (p.thrown == Ref(NIL) || objectClassName(p.thrown) != "java/lang/ArithmeticException") {
+		t.Errorf("1 / 0 didn't throw an ArithmeticException");
+	}
+}
+
+func TestSwitches(t *testing.T) {
+	p, c := loadTestClass(t, "Ops2");
+	for key, want := range map[int32]int32{-1: 10, 0: 10, 1: 11, 2: 12, 3: 13, 4: 10} {
+		if got := callInt(t, p, c, "sw", "(I)I", key); got != want {
+			t.Errorf("tableswitch on %d went to %d, want %d", key, got, want);
+		}
+	}
+	for key, want := range map[int32]int32{-5: 1, 1000: 2, 7: 0} {
+		if got := callInt(t, p, c, "lk", "(I)I", key); got != want {
+			t.Errorf("lookupswitch on %d went to %d, want %d", key, got, want);
+		}
+	}
+}
+
+//read a class from testdata and verify it, with testdata as the class path
+func verifyTestClass(t *testing.T, name string) error {
+	cp, err := NewClassPath("testdata");
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	t.Cleanup(cp.Close);
+	cf, err := ParseFile(filepath.Join("testdata", name+".class"));
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	return verifyClass(cf, cp);
+}
+
+//each of these classes has a method that the verifier has to reject
+func TestVerifyErrors(t *testing.T) {
+	debug = false;
+	tests := []struct {
+		name string;
+		want string;	//part of the detail of the VerifyError
+	}{
+		{"VObjStr", "expecting java/lang/String on the stack, found java/lang/Object"},
+		{"VArg", "expecting java/lang/String on the stack, found java/lang/Object"},
+		{"VField", "expecting VField on the stack, found java/lang/String"},
+		{"VOverflow", "stack overflow, max_stack is 1"},
+		{"VLocals", "local 1 is past max_locals 1"},
+		{"VFrame", "the types don't match the stack map frame at 6"},
+		{"VUninit", "the object on the stack hasn't been initialized"},
+	};
+	for _, tt := range tests {
+		err := verifyTestClass(t, tt.name);
+		var ve *VerifyError;
+		if (!errors.As(err, &ve)) {
+			t.Errorf("%s: got %v, want a VerifyError", tt.name, err);
+		} else if (!strings.Contains(ve.Detail, tt.want)) {
+			t.Errorf("%s: got %q, want %q", tt.name, ve.Detail, tt.want);
+		}
+	}
+}
+
+//a subclass can be used as its super class, including a user class below a built-in one, and
+//any object can be used as an interface
+func TestVerifySubclasses(t *testing.T) {
+	debug = false;
+	if err := verifyTestClass(t, "VOk"); err != nil {
+		t.Error(err);
+	}
+}
+
+//with no CLASSPATH, "lava6 Hello" looks in the current directory
+func TestLoadFromArgsNoClassPath(t *testing.T) {
+	debug = false;
+	t.Setenv("CLASSPATH", "");
+	t.Chdir("testdata");
+	cf, cp, _, _, err := loadFromArgs([]string{"Hello"});
+	if (cp != nil) {
+		defer cp.Close();
+	}
+	if (err != nil) {
+		t.Fatal(err);
+	}
+	if (cf.getClassName() != "Hello") {
+		t.Errorf("loaded %s", cf.getClassName());
+	}
+}
+
+//doubles and floats are printed the same way as Java's Double.toString and Float.toString
+func TestNumberToString(t *testing.T) {
+	doubles := []struct {
+		v float64;
+		want string;
+	}{
+		{0, "0.0"},
+		{math.Copysign(0, -1), "-0.0"},
+		{1, "1.0"},
+		{-2.5, "-2.5"},
+		{0.1, "0.1"},
+		{0.001, "0.001"},
+		{0.0001, "1.0E-4"},
+		{1234567, "1234567.0"},
+		{9999999.5, "9999999.5"},
+		{1e7, "1.0E7"},
+		{1e10, "1.0E10"},
+		{123456789.25, "1.2345678925E8"},
+		{-1.5e-5, "-1.5E-5"},
+		{1.0/3, "0.3333333333333333"},
+		{math.MaxFloat64, "1.7976931348623157E308"},
+		{5e-324, "4.9E-324"},
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "Infinity"},
+		{math.Inf(-1), "-Infinity"},
+	};
+	for _, tt := range doubles {
+		if got := doubleToString(tt.v); got != tt.want {
+			t.Errorf("doubleToString(%v) = %s, want %s", tt.v, got, tt.want);
+		}
+	}
+	floats := []struct {
+		v float32;
+		want string;
+	}{
+		{1.1, "1.1"},
+		{0.001, "0.001"},
+		{1e7, "1.0E7"},
+		{3.4028235e38, "3.4028235E38"},
+		{1.0/3, "0.33333334"},
+		{1.4e-45, "1.4E-45"},
+		{float32(math.Inf(-1)), "-Infinity"},
+	};
+	for _, tt := range floats {
+		if got := floatToString(tt.v); got != tt.want {
+			t.Errorf("floatToString(%v) = %s, want %s", tt.v, got, tt.want);
+		}
+	}
+}